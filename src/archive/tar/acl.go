@@ -0,0 +1,206 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PAX keywords used by star and bsdtar to store POSIX.1e ACLs.
+const (
+	paxSchilyACLAccess  = "SCHILY.acl.access"
+	paxSchilyACLDefault = "SCHILY.acl.default"
+)
+
+// An ACLTag identifies whom an ACLEntry applies to.
+// The values are those of the Linux system.posix_acl_* attributes.
+type ACLTag uint16
+
+// Tags of ACL entries.
+const (
+	ACLUserObj  ACLTag = 0x01 // Owner of the file
+	ACLUser     ACLTag = 0x02 // Named user
+	ACLGroupObj ACLTag = 0x04 // Owning group of the file
+	ACLGroup    ACLTag = 0x08 // Named group
+	ACLMask     ACLTag = 0x10 // Upper bound on the permissions of other groups and named users
+	ACLOther    ACLTag = 0x20 // All other users
+)
+
+// An ACLEntry is a single entry of a POSIX.1e access control list.
+type ACLEntry struct {
+	Tag  ACLTag
+	Name string // User or group name for ACLUser and ACLGroup
+	ID   int    // User or group ID for ACLUser and ACLGroup; -1 if unknown
+	Perm uint8  // Combination of 4 (read), 2 (write) and 1 (execute)
+}
+
+// parseACL parses an ACL in the textual form used by star, where entries
+// are separated by commas or newlines and each entry is of the form:
+//	tag:qualifier:perms[:id]
+//
+// For example: "user::rw-,user:gopher:r--:1000,group::r--,mask::r--,other::---".
+func parseACL(s string) ([]ACLEntry, error) {
+	var acl []ACLEntry
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		parts := strings.Split(f, ":")
+		if len(parts) < 3 || len(parts) > 4 {
+			return nil, ErrHeader
+		}
+		e := ACLEntry{Name: parts[1], ID: -1}
+		switch parts[0] {
+		case "user", "u":
+			e.Tag = ACLUserObj
+			if e.Name != "" {
+				e.Tag = ACLUser
+			}
+		case "group", "g":
+			e.Tag = ACLGroupObj
+			if e.Name != "" {
+				e.Tag = ACLGroup
+			}
+		case "mask", "m":
+			e.Tag = ACLMask
+		case "other", "o":
+			e.Tag = ACLOther
+		default:
+			return nil, ErrHeader
+		}
+		if len(parts) == 4 {
+			id, err := strconv.Atoi(parts[3])
+			if err != nil || id < 0 {
+				return nil, ErrHeader
+			}
+			e.ID = id
+		} else if id, err := strconv.Atoi(e.Name); err == nil && id >= 0 {
+			e.ID = id // Numeric qualifier
+		}
+		if len(parts[2]) != 3 {
+			return nil, ErrHeader
+		}
+		for i := 0; i < 3; i++ {
+			switch c := parts[2][i]; {
+			case c == "rwx"[i]:
+				e.Perm |= 4 >> uint(i)
+			case c != '-':
+				return nil, ErrHeader
+			}
+		}
+		acl = append(acl, e)
+	}
+	return acl, nil
+}
+
+// formatACL formats acl in the textual form used by star and bsdtar,
+// which is parsed by parseACL. Named entries with a known ID record it
+// after the permissions, so that the ACL can be restored on systems where
+// the name is unknown.
+func formatACL(acl []ACLEntry) (string, error) {
+	var b []byte
+	for _, e := range acl {
+		var tag string
+		named := e.Tag == ACLUser || e.Tag == ACLGroup
+		switch e.Tag {
+		case ACLUserObj, ACLUser:
+			tag = "user"
+		case ACLGroupObj, ACLGroup:
+			tag = "group"
+		case ACLMask:
+			tag = "mask"
+		case ACLOther:
+			tag = "other"
+		default:
+			return "", fmt.Errorf("invalid ACL tag %#x", uint16(e.Tag))
+		}
+		name := e.Name
+		switch {
+		case !named:
+			name = ""
+		case strings.ContainsAny(name, ":,\n"):
+			return "", fmt.Errorf("invalid name %q in ACL", name)
+		case name == "" && e.ID < 0:
+			return "", errors.New("named ACL entry without name or ID")
+		case name == "":
+			name = strconv.Itoa(e.ID)
+		}
+		if e.Perm > 7 {
+			return "", fmt.Errorf("invalid ACL permissions %#o", e.Perm)
+		}
+
+		if len(b) > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, tag...)
+		b = append(b, ':')
+		b = append(b, name...)
+		b = append(b, ':')
+		for i := uint(0); i < 3; i++ {
+			if e.Perm&(4>>i) != 0 {
+				b = append(b, "rwx"[i])
+			} else {
+				b = append(b, '-')
+			}
+		}
+		if named && e.Name != "" && e.ID >= 0 && e.Name != strconv.Itoa(e.ID) {
+			b = append(b, ':')
+			b = strconv.AppendInt(b, int64(e.ID), 10)
+		}
+	}
+	return string(b), nil
+}
+
+// encodePOSIXACLXattr encodes acl as the value of a Linux
+// system.posix_acl_access or system.posix_acl_default extended attribute.
+// All named entries must have a known id.
+func encodePOSIXACLXattr(acl []ACLEntry) []byte {
+	const version = 2
+	acl = append([]ACLEntry(nil), acl...)
+	sort.SliceStable(acl, func(i, j int) bool {
+		if acl[i].Tag != acl[j].Tag {
+			return acl[i].Tag < acl[j].Tag
+		}
+		return acl[i].ID < acl[j].ID
+	})
+	b := make([]byte, 4+8*len(acl))
+	binary.LittleEndian.PutUint32(b, version)
+	for i, e := range acl {
+		id := uint32(0xffffffff) // ACL_UNDEFINED_ID
+		if e.Tag == ACLUser || e.Tag == ACLGroup {
+			id = uint32(e.ID)
+		}
+		eb := b[4+8*i:]
+		binary.LittleEndian.PutUint16(eb[0:], uint16(e.Tag))
+		binary.LittleEndian.PutUint16(eb[2:], uint16(e.Perm))
+		binary.LittleEndian.PutUint32(eb[4:], id)
+	}
+	return b
+}
+
+// decodePOSIXACLXattr decodes the value of a Linux system.posix_acl_access
+// or system.posix_acl_default extended attribute, as encoded by
+// encodePOSIXACLXattr. Named entries are given by ID only.
+func decodePOSIXACLXattr(b []byte) ([]ACLEntry, error) {
+	const version = 2
+	if len(b) < 4 || (len(b)-4)%8 != 0 || binary.LittleEndian.Uint32(b) != version {
+		return nil, errors.New("archive/tar: invalid POSIX ACL attribute")
+	}
+	var acl []ACLEntry
+	for b = b[4:]; len(b) > 0; b = b[8:] {
+		e := ACLEntry{
+			Tag:  ACLTag(binary.LittleEndian.Uint16(b[0:])),
+			Perm: uint8(binary.LittleEndian.Uint16(b[2:])),
+			ID:   -1,
+		}
+		if e.Tag == ACLUser || e.Tag == ACLGroup {
+			e.ID = int(binary.LittleEndian.Uint32(b[4:]))
+		}
+		acl = append(acl, e)
+	}
+	return acl, nil
+}
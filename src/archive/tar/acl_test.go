@@ -0,0 +1,171 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	vectors := []struct {
+		in   string
+		want []ACLEntry
+		ok   bool
+	}{{
+		in:   "",
+		want: nil,
+		ok:   true,
+	}, {
+		in: "user::rw-,user:gopher:r--:1000,group::r--,group:42:-w-,mask::rw-,other::---",
+		want: []ACLEntry{
+			{Tag: ACLUserObj, ID: -1, Perm: 6},
+			{Tag: ACLUser, Name: "gopher", ID: 1000, Perm: 4},
+			{Tag: ACLGroupObj, ID: -1, Perm: 4},
+			{Tag: ACLGroup, Name: "42", ID: 42, Perm: 2},
+			{Tag: ACLMask, ID: -1, Perm: 6},
+			{Tag: ACLOther, ID: -1, Perm: 0},
+		},
+		ok: true,
+	}, {
+		in:   "u::rwx\no::r-x",
+		want: []ACLEntry{{Tag: ACLUserObj, ID: -1, Perm: 7}, {Tag: ACLOther, ID: -1, Perm: 5}},
+		ok:   true,
+	}, {
+		in: "user::rw",
+	}, {
+		in: "user::xwr",
+	}, {
+		in: "everyone::rwx",
+	}, {
+		in: "user:gopher:rwx:-1",
+	}}
+
+	for i, v := range vectors {
+		got, err := parseACL(v.in)
+		if ok := err == nil; ok != v.ok {
+			t.Errorf("test %d, parseACL(%q) error: got %v, want ok=%v", i, v.in, err, v.ok)
+			continue
+		}
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("test %d, parseACL(%q):\ngot  %+v\nwant %+v", i, v.in, got, v.want)
+		}
+	}
+}
+
+func TestEncodePOSIXACLXattr(t *testing.T) {
+	acl := []ACLEntry{
+		{Tag: ACLOther, Perm: 4},
+		{Tag: ACLUser, ID: 1000, Perm: 6},
+		{Tag: ACLUserObj, Perm: 7},
+	}
+	want := []byte{
+		2, 0, 0, 0,
+		0x01, 0, 7, 0, 0xff, 0xff, 0xff, 0xff,
+		0x02, 0, 6, 0, 0xe8, 0x03, 0, 0,
+		0x20, 0, 4, 0, 0xff, 0xff, 0xff, 0xff,
+	}
+	if got := encodePOSIXACLXattr(acl); !bytes.Equal(got, want) {
+		t.Errorf("encodePOSIXACLXattr():\ngot  %x\nwant %x", got, want)
+	}
+}
+
+func TestDecodePOSIXACLXattr(t *testing.T) {
+	acl := []ACLEntry{
+		{Tag: ACLUserObj, ID: -1, Perm: 7},
+		{Tag: ACLUser, ID: 1000, Perm: 6},
+		{Tag: ACLGroupObj, ID: -1, Perm: 5},
+		{Tag: ACLMask, ID: -1, Perm: 7},
+		{Tag: ACLOther, ID: -1, Perm: 4},
+	}
+	got, err := decodePOSIXACLXattr(encodePOSIXACLXattr(acl))
+	if err != nil {
+		t.Fatalf("decodePOSIXACLXattr() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, acl) {
+		t.Errorf("decodePOSIXACLXattr():\ngot  %v\nwant %v", got, acl)
+	}
+
+	for i, b := range [][]byte{
+		nil,
+		{2, 0, 0},
+		{1, 0, 0, 0},
+		{2, 0, 0, 0, 0x01, 0, 7, 0},
+	} {
+		if _, err := decodePOSIXACLXattr(b); err == nil {
+			t.Errorf("test %d, decodePOSIXACLXattr(%x) succeeded, want error", i, b)
+		}
+	}
+}
+
+func TestFormatACL(t *testing.T) {
+	vectors := []struct {
+		in   []ACLEntry
+		want string
+		ok   bool
+	}{{
+		in: []ACLEntry{
+			{Tag: ACLUserObj, ID: -1, Perm: 6},
+			{Tag: ACLUser, Name: "gopher", ID: 1000, Perm: 4},
+			{Tag: ACLUser, Name: "nobody", ID: -1, Perm: 1},
+			{Tag: ACLGroup, ID: 42, Perm: 2},
+			{Tag: ACLGroup, Name: "42", ID: 42, Perm: 2},
+			{Tag: ACLMask, Name: "ignored", Perm: 6},
+			{Tag: ACLOther},
+		},
+		want: "user::rw-,user:gopher:r--:1000,user:nobody:--x,group:42:-w-,group:42:-w-,mask::rw-,other::---",
+		ok:   true,
+	}, {
+		in: []ACLEntry{{Tag: 0x40}},
+	}, {
+		in: []ACLEntry{{Tag: ACLUser, ID: -1}},
+	}, {
+		in: []ACLEntry{{Tag: ACLUser, Name: "a:b", ID: -1}},
+	}, {
+		in: []ACLEntry{{Tag: ACLOther, Perm: 8}},
+	}}
+
+	for i, v := range vectors {
+		got, err := formatACL(v.in)
+		if ok := err == nil; ok != v.ok || got != v.want {
+			t.Errorf("test %d, formatACL() = %q, %v; want %q, ok=%v", i, got, err, v.want, v.ok)
+		}
+	}
+}
+
+func TestHeaderACL(t *testing.T) {
+	hdr := &Header{
+		Name:       "dir/",
+		Typeflag:   TypeDir,
+		AccessACL:  []ACLEntry{{Tag: ACLUserObj, ID: -1, Perm: 7}, {Tag: ACLUser, Name: "gopher", ID: 1000, Perm: 5}},
+		DefaultACL: []ACLEntry{{Tag: ACLOther, ID: -1, Perm: 4}},
+	}
+	if f := hdr.FormatReport().Format; f != FormatPAX {
+		t.Errorf("FormatReport().Format = %v, want PAX", f)
+	}
+
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	tw.Close()
+	got, err := NewReader(&b).Next()
+	if err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if !reflect.DeepEqual(got.AccessACL, hdr.AccessACL) || !reflect.DeepEqual(got.DefaultACL, hdr.DefaultACL) {
+		t.Errorf("Next() ACLs = %+v, %+v; want %+v, %+v", got.AccessACL, got.DefaultACL, hdr.AccessACL, hdr.DefaultACL)
+	}
+	if want := "other::r--"; got.PAXRecords[paxSchilyACLDefault] != want {
+		t.Errorf("PAXRecords[%q] = %q, want %q", paxSchilyACLDefault, got.PAXRecords[paxSchilyACLDefault], want)
+	}
+
+	hdr = &Header{Name: "file", AccessACL: []ACLEntry{{Tag: ACLUser, ID: -1}}}
+	if err := NewWriter(&b).WriteHeader(hdr); err == nil {
+		t.Errorf("WriteHeader() with invalid ACL succeeded, want error")
+	}
+}
@@ -0,0 +1,526 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"crypto"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// An AddOption configures the behavior of Writer.AddDir and Writer.AddFile.
+type AddOption func(*adder)
+
+// AddConcurrency returns an AddOption that causes AddDir to prepare files on
+// n goroutines ahead of the entry being written: the contents of small files
+// are read and staged in memory, and the extended attributes and digests
+// requested by AddXattrs and AddDigests are computed. This helps when
+// archiving large trees, where the time spent opening and reading each file
+// dominates, especially on storage that serves many requests in parallel.
+// The archive is identical to the one written without this option.
+//
+// The contents of files larger than 1 MiB are always read sequentially as
+// they are written, and at most 2n files are prepared at any time.
+func AddConcurrency(n int) AddOption {
+	return func(a *adder) { a.concurrency = n }
+}
+
+// AddFilter returns an AddOption that causes AddDir to call fn for each
+// file, with its path on disk and the Header to be written for it, before
+// adding it to the archive. If fn reports false, the file is skipped, along
+// with the contents of a directory. If fn returns an error, AddDir stops
+// and returns that error.
+//
+// The function may modify the Header to rename the entry or rewrite its
+// metadata, such as its ownership or mode, but must not change its Typeflag
+// or Size. Renaming a directory does not rename its contents. Hard links
+// are detected after filtering, so fn sees all of their names as regular
+// files, and the first name that is included holds the data.
+func AddFilter(fn func(path string, hdr *Header) (include bool, err error)) AddOption {
+	return func(a *adder) { a.filter = fn }
+}
+
+// AddXattrs returns an AddOption that causes AddDir and AddFile to record
+// the extended attributes of files in their headers, as if by
+// Header.SetExtendedAttr, except that the security.selinux attribute is
+// recorded in the SELinuxContext field. The system.posix_acl_access and
+// system.posix_acl_default attributes, which hold ACLs, are not recorded.
+//
+// Extended attributes are currently only read on Linux and macOS, where
+// they include the resource fork and Finder information of files, as the
+// com.apple.ResourceFork and com.apple.FinderInfo attributes; elsewhere,
+// none are recorded.
+func AddXattrs() AddOption {
+	return func(a *adder) { a.xattrs = true }
+}
+
+// AddSecurityDescriptors returns an AddOption that causes AddDir and AddFile
+// to record the Windows security descriptors of files in their headers, as
+// FileHeader does, in the WindowsSecurityDescriptor field. Symbolic links
+// have none recorded.
+//
+// Security descriptors are only read on Windows; elsewhere, none are recorded.
+func AddSecurityDescriptors() AddOption {
+	return func(a *adder) { a.secDescs = true }
+}
+
+// AddNames returns an AddOption that causes AddDir and AddFile to fill in
+// the Uname and Gname fields of headers by looking up their Uid and Gid
+// with r, rather than with SystemNames as FileInfoHeader does. The fields
+// are left empty for IDs that r does not know, so that with NumericNames
+// only the numeric IDs are recorded. The names are set before the function
+// given with AddFilter is called.
+func AddNames(r NameResolver) AddOption {
+	return func(a *adder) { a.names = r }
+}
+
+// AddAppleDouble returns an AddOption that causes AddDir and AddFile to
+// record the extended attributes of files, as with AddXattrs, but in
+// AppleDouble companion entries preceding them (see AppleDoublePrefix) rather
+// than in PAX records, as the tar of macOS does. Such archives can be
+// extracted with their attributes by the tar of macOS, and as ordinary
+// files by other implementations. Hard links have no companion entries.
+func AddAppleDouble() AddOption {
+	return func(a *adder) { a.xattrs, a.appleDouble = true, true }
+}
+
+// AddDigests returns an AddOption that causes AddDir and AddFile to record
+// digests of the contents of regular files computed with the given hash
+// functions, as LIBARCHIVE.digest.<algorithm> PAX records like those written
+// with Writer.Digests. Unlike Writer.Digests, AddDigests does not require
+// the archive to be written to an io.WriteSeeker, but reads each file twice:
+// once to compute the digests and once to write its contents.
+func AddDigests(hashes ...crypto.Hash) AddOption {
+	return func(a *adder) { a.digests = hashes }
+}
+
+// maxStagedSize is the size of the largest file that AddDir stages in memory
+// when reading files concurrently.
+const maxStagedSize = 1 << 20
+
+// adder holds the state of a single call to AddDir.
+type adder struct {
+	tw          *Writer
+	concurrency int
+	filter      func(string, *Header) (bool, error)
+	xattrs      bool
+	secDescs    bool
+	appleDouble bool
+	names       NameResolver
+	digests     []crypto.Hash
+}
+
+// AddDir adds the files in the directory tree rooted at dir to the archive,
+// in lexical order, naming them by their slash-separated paths relative
+// to dir. The directory dir itself is not added.
+//
+// Headers are created with FileInfoHeader. Symbolic links are archived as
+// links rather than followed, and device nodes and FIFOs are archived with
+// their device numbers. On Unix systems, a file with several hard links is
+// archived once, and its other names as TypeLink entries, like GNU tar does.
+// This holds across all calls to AddDir and AddFile on the Writer, which
+// remembers the names of such files until it is garbage collected.
+// Sockets cannot be archived and are skipped.
+func (tw *Writer) AddDir(dir string, opts ...AddOption) error {
+	a := newAdder(tw, opts)
+	if a.concurrency <= 1 {
+		return a.walk(dir, a.add)
+	}
+
+	var items []addItem
+	err := a.walk(dir, func(p string, hdr *Header) error {
+		items = append(items, addItem{path: p, hdr: hdr})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return a.addConcurrently(items)
+}
+
+// AddFile adds the file at path on disk to the archive under the given name,
+// in the same way as AddDir adds each file, so that symbolic links are not
+// followed, device nodes keep their device numbers, and further names of
+// a file already added are archived as hard links. For a directory, only
+// the directory itself is added, not its contents; a slash is appended to
+// name if needed. All options but AddConcurrency apply.
+func (tw *Writer) AddFile(name, path string, opts ...AddOption) error {
+	a := newAdder(tw, opts)
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	hdr, err := a.header(path, name, fi)
+	if hdr == nil || err != nil {
+		return err
+	}
+	return a.add(path, hdr)
+}
+
+func newAdder(tw *Writer, opts []AddOption) *adder {
+	a := &adder{tw: tw}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// walk calls fn with the path and header of each file in the tree at dir.
+func (a *adder) walk(dir string, fn func(p string, hdr *Header) error) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir || fi.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := a.header(p, filepath.ToSlash(rel), fi)
+		if err != nil {
+			return err
+		}
+		if hdr == nil {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(p, hdr)
+	})
+}
+
+// header returns the Header for the file at p described by fi, to be added
+// under name, or nil if the file is excluded by the filter.
+func (a *adder) header(p, name string, fi os.FileInfo) (*Header, error) {
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(p); err != nil {
+			return nil, err
+		}
+	}
+	hdr, err := FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, &os.PathError{Op: "add", Path: p, Err: err}
+	}
+	if hdr.CreationTime.IsZero() && sysBtime != nil {
+		hdr.CreationTime = sysBtime(p)
+	}
+	hdr.Name = name
+	if fi.IsDir() && !strings.HasSuffix(name, "/") {
+		hdr.Name += "/"
+	}
+	if a.names != nil {
+		hdr.Uname, _ = a.names.UserName(hdr.Uid)
+		hdr.Gname, _ = a.names.GroupName(hdr.Gid)
+	}
+	if a.filter != nil {
+		include, err := a.filter(p, hdr)
+		if !include || err != nil {
+			return nil, err
+		}
+	}
+	if fi.Mode().IsRegular() && sysFileID != nil {
+		if id, linked := sysFileID(fi); linked {
+			if target, ok := a.tw.links[id]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = TypeLink, target, 0
+			} else {
+				if a.tw.links == nil {
+					a.tw.links = make(map[fileID]string)
+				}
+				a.tw.links[id] = hdr.Name
+			}
+		}
+	}
+	return hdr, nil
+}
+
+// addItem is a file to be added by addConcurrently.
+type addItem struct {
+	path string
+	hdr  *Header
+	done chan stagedFile // Receives the contents if the file is prepared
+}
+
+// stagedFile is the result of preparing a file in the background.
+type stagedFile struct {
+	data []byte // Contents of the file, if staged
+	err  error
+}
+
+// addConcurrently writes the items in order, preparing files ahead
+// on a.concurrency goroutines.
+func (a *adder) addConcurrently(items []addItem) error {
+	sem := make(chan struct{}, 2*a.concurrency) // Limits the prepared files
+	work := make(chan *addItem)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	for i := 0; i < a.concurrency; i++ {
+		go func() {
+			for item := range work {
+				data, err := a.prepare(item.path, item.hdr, isStaged(item.hdr))
+				item.done <- stagedFile{data, err}
+			}
+		}()
+	}
+	for i := range items {
+		if a.needsPrepare(items[i].hdr) {
+			items[i].done = make(chan stagedFile, 1)
+		}
+	}
+	go func() {
+		defer close(work)
+		for i := range items {
+			if items[i].done == nil {
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-quit:
+				return
+			}
+			select {
+			case work <- &items[i]:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	for _, item := range items {
+		if item.done == nil {
+			if err := a.tw.addFile(item.path, item.hdr); err != nil {
+				return err
+			}
+			continue
+		}
+		f := <-item.done
+		<-sem
+		if f.err != nil {
+			return f.err
+		}
+		if err := a.writeAppleDouble(item.hdr); err != nil {
+			return err
+		}
+		if f.data == nil {
+			if err := a.tw.addFile(item.path, item.hdr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := a.tw.WriteHeader(item.hdr); err != nil {
+			return err
+		}
+		if _, err := a.tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isStaged reports whether AddDir reads the file of hdr in the background.
+func isStaged(hdr *Header) bool {
+	return hdr.Typeflag == TypeReg && hdr.Size > 0 && hdr.Size <= maxStagedSize
+}
+
+// needsPrepare reports whether AddDir prepares the file of hdr in the
+// background.
+func (a *adder) needsPrepare(hdr *Header) bool {
+	return isStaged(hdr) || a.xattrs || a.secDescs || (len(a.digests) > 0 && hdr.Typeflag == TypeReg)
+}
+
+// add prepares and writes the file at p.
+func (a *adder) add(p string, hdr *Header) error {
+	if _, err := a.prepare(p, hdr, false); err != nil {
+		return err
+	}
+	if err := a.writeAppleDouble(hdr); err != nil {
+		return err
+	}
+	return a.tw.addFile(p, hdr)
+}
+
+// prepare completes hdr with the extended attributes, security descriptor
+// and digests of the file at p, as configured. If stage is set, it also returns the contents
+// of the file.
+func (a *adder) prepare(p string, hdr *Header, stage bool) (data []byte, err error) {
+	if a.xattrs {
+		if err := addXattrs(p, hdr, false); err != nil {
+			return nil, err
+		}
+	}
+	if a.secDescs && sysSecurityDescriptor != nil && hdr.Typeflag != TypeSymlink {
+		if hdr.WindowsSecurityDescriptor, err = sysSecurityDescriptor(p); err != nil {
+			return nil, err
+		}
+	}
+	if stage {
+		if data, err = readStaged(p, hdr.Size); err != nil {
+			return nil, err
+		}
+	}
+	if len(a.digests) > 0 && hdr.Typeflag == TypeReg {
+		if err := a.addDigests(p, hdr, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// writeAppleDouble moves the extended attributes of hdr to an AppleDouble
+// companion entry, which it writes, if requested with AddAppleDouble.
+func (a *adder) writeAppleDouble(hdr *Header) error {
+	if !a.appleDouble || hdr.Typeflag == TypeLink {
+		return nil
+	}
+	xs := hdr.ExtendedAttrs()
+	if len(xs) == 0 {
+		return nil
+	}
+	data, err := EncodeAppleDouble(xs)
+	if err != nil {
+		return err
+	}
+	for _, xa := range xs {
+		hdr.RemoveExtendedAttr(xa.Name)
+	}
+	dir, file := path.Split(strings.TrimSuffix(hdr.Name, "/"))
+	ad := &Header{
+		Name:     dir + AppleDoublePrefix + file,
+		Typeflag: TypeReg,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		Uid:      hdr.Uid,
+		Gid:      hdr.Gid,
+		Uname:    hdr.Uname,
+		Gname:    hdr.Gname,
+		ModTime:  hdr.ModTime,
+	}
+	if err := a.tw.WriteHeader(ad); err != nil {
+		return err
+	}
+	_, err = a.tw.Write(data)
+	return err
+}
+
+// addXattrs records the extended attributes of the file at p in hdr.
+// If acls is set, the ACLs held in system.posix_acl_* attributes are
+// recorded in the AccessACL and DefaultACL fields; otherwise they are
+// skipped.
+func addXattrs(p string, hdr *Header, acls bool) error {
+	xs, err := listXattrs(p)
+	if err != nil {
+		return err
+	}
+	for _, xa := range xs {
+		switch {
+		case xa.Name == "security.selinux":
+			hdr.SELinuxContext = strings.TrimRight(string(xa.Value), "\x00")
+		case xa.Name == "system.posix_acl_access" && acls:
+			if hdr.AccessACL, err = decodePOSIXACLXattr(xa.Value); err != nil {
+				return &os.PathError{Op: "add", Path: p, Err: err}
+			}
+		case xa.Name == "system.posix_acl_default" && acls:
+			if hdr.DefaultACL, err = decodePOSIXACLXattr(xa.Value); err != nil {
+				return &os.PathError{Op: "add", Path: p, Err: err}
+			}
+		case strings.HasPrefix(xa.Name, "system.posix_acl_"):
+			// ACLs are not extended attributes as far as archives go.
+		default:
+			if err := hdr.SetExtendedAttr(xa.Name, xa.Value); err != nil {
+				return &os.PathError{Op: "add", Path: p, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// addDigests records the digests of the file at p in hdr, reading the file
+// unless its contents are given by data.
+func (a *adder) addDigests(p string, hdr *Header, data []byte) error {
+	names := make([]string, len(a.digests))
+	hashes := make([]hash.Hash, len(a.digests))
+	ws := make([]io.Writer, len(a.digests))
+	for i, h := range a.digests {
+		name, err := digestName(h)
+		if err != nil {
+			return err
+		}
+		names[i], hashes[i] = name, h.New()
+		ws[i] = hashes[i]
+	}
+	w := io.MultiWriter(ws...)
+	if data != nil || hdr.Size == 0 {
+		w.Write(data)
+	} else {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(w, f, hdr.Size)
+		f.Close()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF // The file shrank since it was stat'ed
+			}
+			return &os.PathError{Op: "add", Path: p, Err: err}
+		}
+	}
+	if hdr.PAXRecords == nil {
+		hdr.PAXRecords = make(map[string]string)
+	}
+	for i, name := range names {
+		hdr.PAXRecords[paxLibarchiveDigest+name] = fmt.Sprintf("%x", hashes[i].Sum(nil))
+	}
+	return nil
+}
+
+// readStaged reads the size bytes of the file at p.
+func readStaged(p string, size int64) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &os.PathError{Op: "add", Path: p, Err: err}
+	}
+	return data, nil
+}
+
+// addFile writes hdr followed by the contents of the file at p, if any.
+func (tw *Writer) addFile(p string, hdr *Header) error {
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != TypeReg || hdr.Size == 0 {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF // The file shrank since it was stat'ed
+		}
+		return &os.PathError{Op: "add", Path: p, Err: err}
+	}
+	return nil
+}
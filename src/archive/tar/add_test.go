@@ -0,0 +1,384 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type addedEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	data     string
+}
+
+// readEntries returns the entries of the archive in data.
+func readEntries(t *testing.T, data []byte) []addedEntry {
+	var got []addedEntry
+	tr := NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return got
+		} else if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("ReadAll() error: %v", err)
+		}
+		got = append(got, addedEntry{hdr.Name, hdr.Typeflag, hdr.Linkname, string(b)})
+	}
+}
+
+func TestWriterAddDir(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestWriterAddDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"a", "a/b", "empty"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a/file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a/file", filepath.Join(dir, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "a/file"), filepath.Join(dir, "hardlink")); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	if err := tw.AddDir(dir); err != nil {
+		t.Fatalf("AddDir() error: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	want := []addedEntry{
+		{"a/", TypeDir, "", ""},
+		{"a/b/", TypeDir, "", ""},
+		{"a/file", TypeReg, "", "hello"},
+		{"empty/", TypeDir, "", ""},
+		{"hardlink", TypeLink, "a/file", ""},
+		{"symlink", TypeSymlink, "a/file", ""},
+	}
+	if got := readEntries(t, b.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries mismatch:\ngot  %v\nwant %v", got, want)
+	}
+
+	if err := tw.AddDir(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("AddDir() of missing directory error: got %v, want not exist", err)
+	}
+}
+
+func TestWriterAddDirConcurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWriterAddDirConcurrency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 50; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%d", i%7))
+		os.Mkdir(sub, 0755)
+		data := bytes.Repeat([]byte{byte(i)}, i*100)
+		if i == 25 {
+			data = bytes.Repeat([]byte("big"), maxStagedSize) // Not staged
+		}
+		if err := ioutil.WriteFile(filepath.Join(sub, fmt.Sprintf("file%02d", i)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var want []byte
+	for _, n := range []int{0, 1, 4, 100} {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := tw.AddDir(dir, AddConcurrency(n)); err != nil {
+			t.Fatalf("AddDir(AddConcurrency(%d)) error: %v", n, err)
+		}
+		tw.Close()
+		if want == nil {
+			want = b.Bytes()
+		} else if !bytes.Equal(b.Bytes(), want) {
+			t.Errorf("AddDir(AddConcurrency(%d)) produced a different archive", n)
+		}
+	}
+	if got := len(readEntries(t, want)); got != 57 {
+		t.Errorf("got %d entries, want 57", got)
+	}
+}
+
+func TestWriterAddDirDigests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWriterAddDirDigests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{
+		"empty": nil,
+		"small": []byte("hello"),
+		"big":   bytes.Repeat([]byte("big"), maxStagedSize),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(filepath.Join(dir, "dir"), 0755)
+
+	var want []byte
+	for _, n := range []int{0, 4} {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := tw.AddDir(dir, AddConcurrency(n), AddDigests(crypto.SHA256)); err != nil {
+			t.Fatalf("AddDir(AddConcurrency(%d)) error: %v", n, err)
+		}
+		tw.Close()
+		if want == nil {
+			want = b.Bytes()
+		} else if !bytes.Equal(b.Bytes(), want) {
+			t.Errorf("AddDir(AddConcurrency(%d)) produced a different archive", n)
+		}
+	}
+
+	tr := NewReader(bytes.NewReader(want))
+	tr.VerifyDigests = true
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		if _, err := ioutil.ReadAll(tr); err != nil {
+			t.Errorf("ReadAll(%s) error: %v", hdr.Name, err)
+		}
+		got := hdr.PAXRecords["LIBARCHIVE.digest.sha256"]
+		if data, ok := files[hdr.Name]; ok {
+			if sha := fmt.Sprintf("%x", sha256.Sum256(data)); got != sha {
+				t.Errorf("%s: sha256 record = %q, want %q", hdr.Name, got, sha)
+			}
+		} else if got != "" {
+			t.Errorf("%s: unexpected sha256 record %q", hdr.Name, got)
+		}
+	}
+
+	tw := NewWriter(ioutil.Discard)
+	if err := tw.AddDir(dir, AddDigests(crypto.SHA224)); err == nil {
+		t.Errorf("AddDir(AddDigests(SHA224)) succeeded, want error")
+	}
+}
+
+func TestWriterAddXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestWriterAddXattrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(p, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setXattr(p, "user.gopher", []byte("value")); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	for _, n := range []int{0, 4} {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := tw.AddDir(dir, AddConcurrency(n), AddXattrs()); err != nil {
+			t.Fatalf("AddDir(AddConcurrency(%d)) error: %v", n, err)
+		}
+		tw.Close()
+		hdr, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		found := false
+		for _, xa := range hdr.ExtendedAttrs() {
+			if xa.Name == "user.gopher" && string(xa.Value) == "value" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("AddConcurrency(%d): ExtendedAttrs() = %q, want user.gopher", n, hdr.ExtendedAttrs())
+		}
+	}
+}
+
+func TestWriterAddDirFilter(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestWriterAddDirFilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"keep", "skip"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"a.txt", "b.tmp", "keep/c.txt", "skip/d.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first name of the hard link is excluded, so the second holds the data.
+	if err := os.Link(filepath.Join(dir, "b.tmp"), filepath.Join(dir, "keep/e.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	err = tw.AddDir(dir, AddFilter(func(p string, hdr *Header) (bool, error) {
+		switch {
+		case hdr.Name == "skip/" || filepath.Ext(p) == ".tmp":
+			return false, nil
+		case hdr.Name == "keep/c.txt":
+			hdr.Name = "renamed.txt"
+		}
+		hdr.Uid, hdr.Uname = 1000, "builder"
+		return true, nil
+	}))
+	if err != nil {
+		t.Fatalf("AddDir() error: %v", err)
+	}
+	tw.Close()
+
+	want := []addedEntry{
+		{"a.txt", TypeReg, "", "a.txt"},
+		{"keep/", TypeDir, "", ""},
+		{"renamed.txt", TypeReg, "", "keep/c.txt"},
+		{"keep/e.txt", TypeReg, "", "b.tmp"},
+	}
+	if got := readEntries(t, b.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries mismatch:\ngot  %v\nwant %v", got, want)
+	}
+
+	errStop := errors.New("stop")
+	err = NewWriter(ioutil.Discard).AddDir(dir, AddFilter(func(string, *Header) (bool, error) {
+		return false, errStop
+	}))
+	if err != errStop {
+		t.Errorf("AddDir() error: got %v, want %v", err, errStop)
+	}
+}
+
+func TestWriterAddFileCreationTime(t *testing.T) {
+	if sysBtime == nil {
+		t.Skipf("skipping on %s/%s, where FileInfoHeader records creation times", runtime.GOOS, runtime.GOARCH)
+	}
+	start := time.Now().Add(-time.Minute)
+	f, err := ioutil.TempFile("", "TestWriterAddFileCreationTime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	btime := sysBtime(f.Name())
+	if btime.IsZero() {
+		t.Skip("creation time not recorded by the file system")
+	}
+	if btime.Before(start) || btime.After(time.Now()) {
+		t.Errorf("creation time = %v, want about %v", btime, start.Add(time.Minute))
+	}
+
+	var got time.Time
+	tw := NewWriter(ioutil.Discard)
+	err = tw.AddFile("file", f.Name(), AddFilter(func(_ string, hdr *Header) (bool, error) {
+		got = hdr.CreationTime
+		return false, nil
+	}))
+	if err != nil || !got.Equal(btime) {
+		t.Errorf("AddFile() CreationTime = (%v, %v), want %v", got, err, btime)
+	}
+}
+
+func TestWriterAddFile(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestWriterAddFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "file"), filepath.Join(dir, "hardlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	for _, v := range []struct{ name, path string }{
+		{"a/file.txt", "file"},
+		{"link", "symlink"},
+		{"d", "dir"},
+		{"d2/", "dir"},
+		{"b/copy", "hardlink"},
+	} {
+		if err := tw.AddFile(v.name, filepath.Join(dir, v.path)); err != nil {
+			t.Fatalf("AddFile(%q, %q) error: %v", v.name, v.path, err)
+		}
+	}
+	err = tw.AddFile("skipped", filepath.Join(dir, "file"), AddFilter(func(string, *Header) (bool, error) {
+		return false, nil
+	}))
+	if err != nil {
+		t.Fatalf("AddFile() with filter error: %v", err)
+	}
+	if err := tw.AddFile("missing", filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("AddFile() of missing file error: got %v, want not exist", err)
+	}
+	tw.Close()
+
+	want := []addedEntry{
+		{"a/file.txt", TypeReg, "", "hello"},
+		{"link", TypeSymlink, "file", ""},
+		{"d/", TypeDir, "", ""},
+		{"d2/", TypeDir, "", ""},
+		{"b/copy", TypeLink, "a/file.txt", ""},
+	}
+	if got := readEntries(t, b.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("entries mismatch:\ngot  %v\nwant %v", got, want)
+	}
+	hdr, err := NewReader(bytes.NewReader(b.Bytes())).Next()
+	if err != nil || hdr.Mode&07777 != 0640 || hdr.Size != 5 {
+		t.Errorf("Next() = %+v, %v; want mode 0640 and size 5", hdr, err)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import "io"
+
+// OpenAppend reads the existing archive in rw and returns a Writer that
+// appends entries to it, in the manner of "tar -r". The Writer overwrites the
+// trailer of the archive, and Close writes a new one after the appended
+// entries. If rw is empty, the Writer creates a new archive.
+//
+// OpenAppend only reads the headers of the existing entries, seeking past
+// their data. Any data following the trailer, such as the padding to a
+// multiple of the blocking factor that some implementations write, is left
+// in place after the new trailer, where readers ignore it.
+func OpenAppend(rw io.ReadWriteSeeker) (*Writer, error) {
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cr := &countReader{r: rw}
+	tr := NewReader(&countReadSeeker{cr, rw, 0})
+	tr.TolerateTruncatedTrailer = true
+	for {
+		end := cr.n + tr.curr.PhysicalRemaining() + tr.pad
+		_, err := tr.Next()
+		if err == io.EOF {
+			if _, err := rw.Seek(end, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return NewWriter(rw), nil
+		}
+		if err != nil && err != ErrInsecurePath {
+			return nil, err
+		}
+	}
+}
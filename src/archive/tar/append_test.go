@@ -0,0 +1,73 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestOpenAppend(t *testing.T) {
+	gnu, err := ioutil.ReadFile("testdata/gnu.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := makeArchive(t, testEntry{hdr: Header{Name: "old", Typeflag: TypeReg}, body: "data"})
+
+	vectors := []struct {
+		data  []byte
+		names []string // Names of the entries before appending
+	}{
+		{nil, nil},
+		{gnu, []string{"small.txt", "small2.txt"}},
+		{entry, []string{"old"}},
+		{entry[:len(entry)-blockSize], []string{"old"}},   // Truncated trailer
+		{entry[:len(entry)-2*blockSize], []string{"old"}}, // Missing trailer
+	}
+	for i, v := range vectors {
+		f, err := ioutil.TempFile("", "tar-append")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := f.Write(v.data); err != nil {
+			t.Fatal(err)
+		}
+
+		tw, err := OpenAppend(f)
+		if err != nil {
+			t.Fatalf("test %d, OpenAppend() error: %v", i, err)
+		}
+		for _, name := range []string{"new1", "new2"} {
+			if err := tw.WriteHeader(&Header{Name: name, Typeflag: TypeReg, Size: 3}); err != nil {
+				t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+			}
+			io.WriteString(tw, "abc")
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("test %d, Close() error: %v", i, err)
+		}
+
+		f.Seek(0, io.SeekStart)
+		tr := NewReader(f)
+		var names []string
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+			names = append(names, hdr.Name)
+		}
+		if want := append(v.names, "new1", "new2"); !reflect.DeepEqual(names, want) {
+			t.Errorf("test %d, names = %q, want %q", i, names, want)
+		}
+	}
+}
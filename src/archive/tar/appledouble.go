@@ -0,0 +1,206 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+// AppleDouble companion entries are the entries by which the tar of macOS
+// records the extended attributes of files, including their resource forks
+// and Finder information, for other tar implementations to extract them as
+// ordinary files. The companion of a file is a regular file named after it
+// with AppleDoublePrefix prepended to its base name, which precedes it in
+// the archive, and holds its attributes in the AppleDouble format, as
+// written by the copyfile function of macOS.
+const AppleDoublePrefix = "._"
+
+// Names of the extended attributes of macOS that AppleDouble files hold
+// in entries of their own.
+const (
+	xattrFinderInfo   = "com.apple.FinderInfo"
+	xattrResourceFork = "com.apple.ResourceFork"
+)
+
+// AppleDouble format constants, as used by copyfile.
+const (
+	appleDoubleMagic   = 0x00051607
+	appleDoubleVersion = 0x00020000
+	appleDoubleFiller  = "Mac OS X        "
+
+	adEntryResourceFork = 2
+	adEntryFinderInfo   = 9
+
+	adFinderInfoOffset = 50 // After the header and two entry descriptors
+	adFinderInfoSize   = 32
+	adAttrHeaderOffset = adFinderInfoOffset + adFinderInfoSize + 2
+	adAttrHeaderSize   = 36
+	adAttrMagic        = "ATTR"
+)
+
+var errAppleDouble = errors.New("archive/tar: invalid AppleDouble data")
+
+// ParseAppleDouble reports whether name is the name of an AppleDouble
+// companion entry, and returns the name of the file it belongs to.
+func ParseAppleDouble(name string) (target string, ok bool) {
+	dir, file := path.Split(strings.TrimSuffix(name, "/"))
+	if !strings.HasPrefix(file, AppleDoublePrefix) || len(file) == len(AppleDoublePrefix) {
+		return "", false
+	}
+	return dir + file[len(AppleDoublePrefix):], true
+}
+
+// EncodeAppleDouble returns the AppleDouble data holding the extended
+// attributes xs, to be stored in a companion entry. The com.apple.FinderInfo
+// attribute, if 32 bytes long, and the com.apple.ResourceFork attribute are
+// stored in the entries of the format dedicated to them, and the other
+// attributes in the extended attribute area that macOS adds to the Finder
+// information. Their names must be shorter than 255 bytes.
+func EncodeAppleDouble(xs []Xattr) ([]byte, error) {
+	var finfo, rsrc []byte
+	var attrs []Xattr
+	for _, xa := range xs {
+		switch {
+		case xa.Name == xattrFinderInfo && len(xa.Value) == adFinderInfoSize:
+			finfo = xa.Value
+		case xa.Name == xattrResourceFork:
+			rsrc = xa.Value
+		case len(xa.Name) >= 255:
+			return nil, errors.New("archive/tar: extended attribute name too long for AppleDouble: " + xa.Name)
+		default:
+			attrs = append(attrs, xa)
+		}
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Name < attrs[j].Name })
+
+	// Lay out the attribute entries, followed by their values.
+	dataStart := adAttrHeaderOffset + adAttrHeaderSize
+	for _, xa := range attrs {
+		dataStart += attrEntrySize(len(xa.Name) + 1)
+	}
+	size := dataStart
+	for _, xa := range attrs {
+		size += len(xa.Value)
+	}
+
+	b := make([]byte, size, size+len(rsrc))
+	be := binary.BigEndian
+	be.PutUint32(b[0:], appleDoubleMagic)
+	be.PutUint32(b[4:], appleDoubleVersion)
+	copy(b[8:24], appleDoubleFiller)
+	be.PutUint16(b[24:], 2)
+	be.PutUint32(b[26:], adEntryFinderInfo)
+	be.PutUint32(b[30:], adFinderInfoOffset)
+	be.PutUint32(b[34:], uint32(size-adFinderInfoOffset))
+	be.PutUint32(b[38:], adEntryResourceFork)
+	be.PutUint32(b[42:], uint32(size))
+	be.PutUint32(b[46:], uint32(len(rsrc)))
+	copy(b[adFinderInfoOffset:], finfo)
+
+	h := b[adAttrHeaderOffset:]
+	copy(h, adAttrMagic)
+	be.PutUint32(h[8:], uint32(size))            // Total size
+	be.PutUint32(h[12:], uint32(dataStart))      // Start of the values
+	be.PutUint32(h[16:], uint32(size-dataStart)) // Length of the values
+	be.PutUint16(h[34:], uint16(len(attrs)))     // Number of attributes
+	e, off := adAttrHeaderOffset+adAttrHeaderSize, dataStart
+	for _, xa := range attrs {
+		be.PutUint32(b[e:], uint32(off))
+		be.PutUint32(b[e+4:], uint32(len(xa.Value)))
+		b[e+10] = byte(len(xa.Name) + 1)
+		copy(b[e+11:], xa.Name)
+		copy(b[off:], xa.Value)
+		e += attrEntrySize(len(xa.Name) + 1)
+		off += len(xa.Value)
+	}
+	return append(b, rsrc...), nil
+}
+
+// attrEntrySize returns the size of the entry for an attribute in the
+// extended attribute area, given the length of its NUL-terminated name.
+// Entries are padded to a multiple of 4 bytes.
+func attrEntrySize(namelen int) int {
+	return (11 + namelen + 3) &^ 3
+}
+
+// DecodeAppleDouble returns the extended attributes held in the AppleDouble
+// data b, sorted by name. The Finder information and resource fork are
+// returned as the com.apple.FinderInfo and com.apple.ResourceFork
+// attributes, unless they are empty.
+func DecodeAppleDouble(b []byte) ([]Xattr, error) {
+	be := binary.BigEndian
+	if len(b) < 26 || be.Uint32(b) != appleDoubleMagic || be.Uint32(b[4:]) != appleDoubleVersion {
+		return nil, errAppleDouble
+	}
+	n := int(be.Uint16(b[24:]))
+	if len(b) < 26+12*n {
+		return nil, errAppleDouble
+	}
+	var xs []Xattr
+	for i := 0; i < n; i++ {
+		d := b[26+12*i:]
+		id, off, length := be.Uint32(d), int64(be.Uint32(d[4:])), int64(be.Uint32(d[8:]))
+		if off+length > int64(len(b)) {
+			return nil, errAppleDouble
+		}
+		entry := b[off : off+length]
+		switch id {
+		case adEntryResourceFork:
+			if len(entry) > 0 {
+				xs = append(xs, Xattr{xattrResourceFork, entry})
+			}
+		case adEntryFinderInfo:
+			if len(entry) < adFinderInfoSize {
+				return nil, errAppleDouble
+			}
+			if finfo := entry[:adFinderInfoSize]; !bytes.Equal(finfo, zeroBlock[:adFinderInfoSize]) {
+				xs = append(xs, Xattr{xattrFinderInfo, finfo})
+			}
+			attrs, err := decodeAppleDoubleAttrs(b, entry[adFinderInfoSize:])
+			if err != nil {
+				return nil, err
+			}
+			xs = append(xs, attrs...)
+		}
+	}
+	sort.Slice(xs, func(i, j int) bool { return xs[i].Name < xs[j].Name })
+	return xs, nil
+}
+
+// decodeAppleDoubleAttrs decodes the extended attribute area h, which
+// follows the Finder information in b, if present. The offsets of the
+// values are relative to the start of b.
+func decodeAppleDoubleAttrs(b, h []byte) ([]Xattr, error) {
+	if len(h) < 2+adAttrHeaderSize || string(h[2:6]) != adAttrMagic {
+		return nil, nil // Finder information only
+	}
+	be := binary.BigEndian
+	h = h[2:]
+	n := int(be.Uint16(h[34:]))
+	e := h[adAttrHeaderSize:]
+	var xs []Xattr
+	for i := 0; i < n; i++ {
+		if len(e) < 11 || len(e) < 11+int(e[10]) {
+			return nil, errAppleDouble
+		}
+		off, length := int64(be.Uint32(e)), int64(be.Uint32(e[4:]))
+		name := strings.TrimRight(string(e[11:11+int(e[10])]), "\x00")
+		if name == "" || off+length > int64(len(b)) {
+			return nil, errAppleDouble
+		}
+		xs = append(xs, Xattr{name, b[off : off+length]})
+		if size := attrEntrySize(int(e[10])); size < len(e) {
+			e = e[size:]
+		} else {
+			e = nil
+		}
+	}
+	return xs, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseAppleDouble(t *testing.T) {
+	vectors := []struct {
+		in     string
+		target string
+		ok     bool
+	}{
+		{"._file", "file", true},
+		{"dir/._file", "dir/file", true},
+		{"dir/._sub/", "dir/sub", true},
+		{"._", "", false},
+		{"dir/._", "", false},
+		{"._dir/file", "", false},
+		{"file", "", false},
+		{"_.file", "", false},
+	}
+	for i, v := range vectors {
+		target, ok := ParseAppleDouble(v.in)
+		if target != v.target || ok != v.ok {
+			t.Errorf("test %d, ParseAppleDouble(%q) = (%q, %v), want (%q, %v)", i, v.in, target, ok, v.target, v.ok)
+		}
+	}
+}
+
+func TestAppleDouble(t *testing.T) {
+	finfo := []byte("TEXTttxt" + strings.Repeat("\x00", 24))
+	vectors := [][]Xattr{
+		{},
+		{{"com.apple.FinderInfo", finfo}},
+		{{"com.apple.ResourceFork", []byte("resource fork")}},
+		{{"user.a", []byte("1")}, {"user.bb", []byte{}}, {"user.ccc", []byte("\x00\xff")}},
+		{
+			{"com.apple.FinderInfo", finfo},
+			{"com.apple.ResourceFork", bytes.Repeat([]byte{0xaa}, 1000)},
+			{"com.apple.quarantine", []byte("0081;5a000000;Safari;")},
+		},
+		{{"com.apple.FinderInfo", []byte("short")}},
+	}
+	for i, want := range vectors {
+		b, err := EncodeAppleDouble(want)
+		if err != nil {
+			t.Errorf("test %d, EncodeAppleDouble() error: %v", i, err)
+			continue
+		}
+		if got := binary.BigEndian.Uint32(b[30:]); got != 50 {
+			t.Errorf("test %d, Finder information at offset %d, want 50", i, got)
+		}
+		if got := string(b[84:88]); got != "ATTR" {
+			t.Errorf("test %d, attribute header magic = %q, want %q", i, got, "ATTR")
+		}
+		got, err := DecodeAppleDouble(b)
+		if err != nil {
+			t.Errorf("test %d, DecodeAppleDouble() error: %v", i, err)
+			continue
+		}
+		if len(got) == 0 && len(want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("test %d, DecodeAppleDouble(EncodeAppleDouble()):\ngot  %q\nwant %q", i, got, want)
+		}
+	}
+
+	if _, err := EncodeAppleDouble([]Xattr{{strings.Repeat("n", 255), nil}}); err == nil {
+		t.Errorf("EncodeAppleDouble() with long name succeeded, want error")
+	}
+
+	valid, _ := EncodeAppleDouble([]Xattr{{"user.a", []byte("1")}})
+	for i, b := range [][]byte{
+		nil,
+		[]byte("not AppleDouble data"),
+		valid[:30],
+		valid[:len(valid)-1],
+	} {
+		if _, err := DecodeAppleDouble(b); err == nil {
+			t.Errorf("test %d, DecodeAppleDouble() succeeded, want error", i)
+		}
+	}
+}
+
+func TestExtractAppleDouble(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestExtractAppleDouble")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	empty, err := EncodeAppleDouble(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Companions too large to be held in memory are extracted as files.
+	big, err := EncodeAppleDouble([]Xattr{{"user.big", make([]byte, maxAppleDoubleSize)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := makeArchive(t,
+		testEntry{hdr: Header{Name: "._file", Typeflag: TypeReg, Mode: 0644}, body: string(empty)},
+		testEntry{hdr: Header{Name: "file", Typeflag: TypeReg, Mode: 0644}, body: "data"},
+		testEntry{hdr: Header{Name: "._other", Typeflag: TypeReg, Mode: 0644}, body: "not AppleDouble"},
+		testEntry{hdr: Header{Name: "._big", Typeflag: TypeReg, Mode: 0644}, body: string(big)},
+	)
+	vectors := []struct {
+		opts []ExtractOption
+		want []string
+	}{
+		{nil, []string{"._big", "._file", "._other", "file"}},
+		{[]ExtractOption{ExtractAppleDouble()}, []string{"._big", "._other", "file"}},
+	}
+	for i, v := range vectors {
+		dst := filepath.Join(dir, strconv.Itoa(i))
+		if err := Extract(dst, bytes.NewReader(data), v.opts...); err != nil {
+			t.Fatalf("test %d, Extract() error: %v", i, err)
+		}
+		f, err := os.Open(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, v.want) {
+			t.Errorf("test %d, extracted files = %q, want %q", i, names, v.want)
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(dst, "._other")); err != nil || string(b) != "not AppleDouble" {
+			t.Errorf("test %d, ReadFile(._other) = (%q, %v), want %q", i, b, err, "not AppleDouble")
+		}
+	}
+}
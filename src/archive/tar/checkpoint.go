@@ -0,0 +1,75 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"errors"
+	"io"
+)
+
+// A Checkpoint records the progress of a Writer, from which writing can be
+// resumed with ResumeWriter after the program that created the archive was
+// interrupted, for example by a crash.
+type Checkpoint struct {
+	Offset int64  // Offset in the output of the end of the last entry
+	Name   string // Name of the last entry
+}
+
+// Checkpoint finishes the current entry, like Flush, and returns a
+// Checkpoint recording the end of the archive so far. The underlying
+// io.Writer must be an io.Seeker, such as an *os.File, to report its offset.
+// If it has a Sync method, like *os.File, Checkpoint calls it so that the
+// data up to the checkpoint is stored durably.
+//
+// Checkpoints are not supported when writing in records or volumes.
+func (tw *Writer) Checkpoint() (Checkpoint, error) {
+	if err := tw.Flush(); err != nil {
+		return Checkpoint{}, err
+	}
+	if tw.BlockingFactor > 0 || tw.NextVolume != nil {
+		return Checkpoint{}, errors.New("archive/tar: Checkpoint when writing in records or volumes")
+	}
+	s, ok := tw.dst().(io.Seeker)
+	if !ok {
+		return Checkpoint{}, errors.New("archive/tar: Checkpoint requires an io.Seeker")
+	}
+	off, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if f, ok := tw.dst().(interface{ Sync() error }); ok {
+		if err := f.Sync(); err != nil {
+			return Checkpoint{}, err
+		}
+	}
+	return Checkpoint{Offset: off, Name: tw.last}, nil
+}
+
+// ResumeWriter returns a Writer that continues writing the archive in w from
+// the Checkpoint cp, as obtained from the Writer that wrote it. Anything
+// written after the checkpoint is discarded: ResumeWriter seeks to cp.Offset
+// and, if w has a Truncate method, like *os.File, truncates w there.
+// The next entry to write is the one that followed the entry named cp.Name.
+//
+// Settings of the Writer, such as Deterministic, are not recorded in the
+// checkpoint, and should be set again on the new Writer. Neither are the
+// files added with AddDir or AddFile, so further names of files that were
+// added with several hard links are archived as regular files again.
+func ResumeWriter(w io.WriteSeeker, cp Checkpoint) (*Writer, error) {
+	if cp.Offset < 0 || cp.Offset%blockSize != 0 {
+		return nil, errors.New("archive/tar: invalid Checkpoint offset")
+	}
+	if _, err := w.Seek(cp.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if f, ok := w.(interface{ Truncate(int64) error }); ok {
+		if err := f.Truncate(cp.Offset); err != nil {
+			return nil, err
+		}
+	}
+	tw := NewWriter(w)
+	tw.last = cp.Name
+	return tw, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	f, err := ioutil.TempFile("", "TestCheckpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	write := func(tw *Writer, name, data string) {
+		if err := tw.WriteHeader(&Header{Name: name, Size: int64(len(data))}); err != nil {
+			t.Fatalf("WriteHeader(%s) error: %v", name, err)
+		}
+		if _, err := io.WriteString(tw, data); err != nil {
+			t.Fatalf("Write(%s) error: %v", name, err)
+		}
+	}
+
+	tw := NewWriter(f)
+	if cp, err := tw.Checkpoint(); err != nil || cp != (Checkpoint{}) {
+		t.Errorf("initial Checkpoint() = (%+v, %v), want zero", cp, err)
+	}
+	write(tw, "a", "hello")
+	write(tw, "b", string(bytes.Repeat([]byte("b"), 1000)))
+	cp, err := tw.Checkpoint()
+	if want := (Checkpoint{Offset: 5 * blockSize, Name: "b"}); err != nil || cp != want {
+		t.Fatalf("Checkpoint() = (%+v, %v), want %+v", cp, err, want)
+	}
+	// Simulate a crash in the middle of an entry.
+	if err := tw.WriteHeader(&Header{Name: "c", Size: 100}); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(tw, "partial")
+	if _, err := tw.Checkpoint(); err == nil {
+		t.Errorf("Checkpoint() of partial entry succeeded, want error")
+	}
+
+	tw, err = ResumeWriter(f, cp)
+	if err != nil {
+		t.Fatalf("ResumeWriter() error: %v", err)
+	}
+	write(tw, "c", "world")
+	if cp, err := tw.Checkpoint(); err != nil || cp.Name != "c" {
+		t.Errorf("Checkpoint() after resuming = (%+v, %v), want entry c", cp, err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 9*blockSize {
+		t.Errorf("archive size = %d, want %d", len(data), 9*blockSize)
+	}
+	var names []string
+	for _, e := range readEntries(t, data) {
+		names = append(names, e.name)
+	}
+	if got := fmt.Sprint(names); got != "[a b c]" {
+		t.Errorf("entries = %v, want [a b c]", got)
+	}
+
+	if _, err := ResumeWriter(f, Checkpoint{Offset: 100}); err == nil {
+		t.Errorf("ResumeWriter() with unaligned offset succeeded, want error")
+	}
+	if _, err := NewWriter(&bytes.Buffer{}).Checkpoint(); err == nil {
+		t.Errorf("Checkpoint() without io.Seeker succeeded, want error")
+	}
+}
@@ -11,15 +11,20 @@
 package tar
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"math"
 	"os"
 	"path"
 	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // BUG: Use of the Uid and Gid fields in Header could overflow on 32-bit
@@ -31,19 +36,42 @@ var (
 	ErrWriteTooLong    = errors.New("archive/tar: write too long")
 	ErrFieldTooLong    = errors.New("archive/tar: header field too long")
 	ErrWriteAfterClose = errors.New("archive/tar: write after close")
+	ErrDuplicateName   = errors.New("archive/tar: duplicate entry name")
+	ErrInsecurePath    = errors.New("archive/tar: insecure file path")
 	errMissData        = errors.New("archive/tar: sparse file references non-existent data")
 	errUnrefData       = errors.New("archive/tar: sparse file contains unreferenced data")
 	errWriteHole       = errors.New("archive/tar: write non-NUL byte in sparse hole")
 )
 
-type headerError []string
+// A HeaderError is returned by Writer.WriteHeader when a Header cannot be
+// written, either because some of its fields are invalid, or because no
+// format can encode all of them, and by Header.Validate. It lists each of
+// the offending fields.
+type HeaderError struct {
+	Fields []FieldError
+}
+
+// A FieldError describes why a field of a Header prevents writing it.
+type FieldError struct {
+	Field  string // Name of the Header field, such as "Name" or "Size"
+	Reason string // Explanation, such as "negative size on header-only type"
+
+	// Value is the value of the field in the Header, if it is a field
+	// of Header, such as an int64 for "Size".
+	Value interface{}
+}
+
+// newHeaderError returns a HeaderError for a single field.
+func newHeaderError(field, reason string) *HeaderError {
+	return &HeaderError{[]FieldError{{Field: field, Reason: reason}}}
+}
 
-func (he headerError) Error() string {
+func (he *HeaderError) Error() string {
 	const prefix = "archive/tar: cannot encode header"
 	var ss []string
-	for _, s := range he {
-		if s != "" {
-			ss = append(ss, s)
+	for _, f := range he.Fields {
+		if f.Reason != "" {
+			ss = append(ss, f.Reason)
 		}
 	}
 	if len(ss) == 0 {
@@ -76,8 +104,8 @@ const (
 
 	// Type 'g' is used by the PAX format to store key-value records that
 	// are relevant to all subsequent files.
-	// This package only supports parsing and composing such headers,
-	// but does not currently support persisting the global state across files.
+	// This package supports parsing and composing such headers, and applies
+	// them to subsequent files if Reader.ApplyGlobalPAXRecords is set.
 	TypeXGlobalHeader = 'g'
 
 	// Type 'S' indicates a sparse file in the GNU format.
@@ -88,8 +116,85 @@ const (
 	// This package transparently handles these types.
 	TypeGNULongName = 'L'
 	TypeGNULongLink = 'K'
+
+	// Type 'X' is used by Solaris tar and older versions of star for
+	// extended headers in the same form as TypeXHeader.
+	typeSolarisXHeader = 'X'
+
+	// Type 'M' is used by the GNU format for the continuation of a file
+	// whose data began in the previous volume of a multi-volume archive.
+	typeGNUMultiVolume = 'M'
 )
 
+// typeNames are the names of the known type flags, as returned by TypeName.
+var typeNames = map[byte]string{
+	TypeReg:           "reg",
+	TypeRegA:          "rega",
+	TypeLink:          "link",
+	TypeSymlink:       "symlink",
+	TypeChar:          "char",
+	TypeBlock:         "block",
+	TypeDir:           "dir",
+	TypeFifo:          "fifo",
+	TypeCont:          "cont",
+	TypeXHeader:       "xheader",
+	TypeXGlobalHeader: "xglobalheader",
+	TypeGNUSparse:     "gnusparse",
+	TypeGNULongName:   "gnulongname",
+	TypeGNULongLink:   "gnulonglink",
+}
+
+// TypeName returns a short name for the type flag, such as "reg", "dir" or
+// "symlink", or the flag as a quoted character, such as 'A', if it is not
+// one of the Type constants.
+func TypeName(flag byte) string {
+	if name, ok := typeNames[flag]; ok {
+		return name
+	}
+	return strconv.QuoteRuneToASCII(rune(flag))
+}
+
+// IsRegular reports whether h describes a regular file: an entry of type
+// TypeReg, TypeRegA (unless its name ends with a slash, which old archives
+// use for directories), TypeCont or TypeGNUSparse.
+func (h *Header) IsRegular() bool {
+	switch h.Typeflag {
+	case TypeReg, TypeCont, TypeGNUSparse:
+		return true
+	case TypeRegA:
+		return !strings.HasSuffix(h.Name, "/")
+	}
+	return false
+}
+
+// IsDir reports whether h describes a directory: an entry of type TypeDir,
+// or of type TypeRegA with a name ending with a slash.
+func (h *Header) IsDir() bool {
+	return h.Typeflag == TypeDir || (h.Typeflag == TypeRegA && strings.HasSuffix(h.Name, "/"))
+}
+
+// IsSymlink reports whether h describes a symbolic link (TypeSymlink).
+func (h *Header) IsSymlink() bool {
+	return h.Typeflag == TypeSymlink
+}
+
+// IsHardlink reports whether h describes a hard link (TypeLink)
+// to the file named by h.Linkname.
+func (h *Header) IsHardlink() bool {
+	return h.Typeflag == TypeLink
+}
+
+// IsDevice reports whether h describes a character or block device
+// (TypeChar or TypeBlock).
+func (h *Header) IsDevice() bool {
+	return h.Typeflag == TypeChar || h.Typeflag == TypeBlock
+}
+
+// IsFIFO reports whether h describes a named pipe (TypeFifo).
+func (h *Header) IsFIFO() bool {
+	return h.Typeflag == TypeFifo
+}
+
 // Keywords for PAX extended header records.
 const (
 	paxNone     = "" // Indicates that no PAX key is suitable
@@ -103,10 +208,20 @@ const (
 	paxMtime    = "mtime"
 	paxAtime    = "atime"
 	paxCtime    = "ctime"   // Removed from later revision of PAX spec, but was valid
-	paxCharset  = "charset" // Currently unused
-	paxComment  = "comment" // Currently unused
+	paxCharset  = "charset" // Character set of the file data
+	paxComment  = "comment" // Free-form description of the entry
+
+	paxHdrCharset    = "hdrcharset" // Character set of the header records
+	hdrCharsetBinary = "BINARY"
+
+	paxSchilyXattr     = "SCHILY.xattr."
+	paxLibarchiveXattr = "LIBARCHIVE.xattr." // Base64 values and URL-encoded names
+	paxRHTSELinux      = "RHT.security.selinux"
 
-	paxSchilyXattr = "SCHILY.xattr."
+	// Keywords for Windows metadata, as used by libarchive.
+	paxMSWindowsFileAttr = "MSWINDOWS.fileattr"              // Decimal FILE_ATTRIBUTE_* flags
+	paxMSWindowsRawSD    = "MSWINDOWS.rawsecuritydescriptor" // Base64 security descriptor
+	paxMSWindowsRawSDAlt = "MSWINDOWS.rawsd"                 // Alternative used by some tools
 
 	// Keywords for GNU sparse files in a PAX extended header.
 	paxGNUSparse          = "GNU.sparse."
@@ -119,12 +234,18 @@ const (
 	paxGNUSparseMinor     = "GNU.sparse.minor"
 	paxGNUSparseSize      = "GNU.sparse.size"
 	paxGNUSparseRealSize  = "GNU.sparse.realsize"
+
+	// Keys used by libarchive to store digests of the file data.
+	paxLibarchiveDigest = "LIBARCHIVE.digest."
+
+	// Key used by libarchive to store the creation time of files.
+	paxLibarchiveCreationTime = "LIBARCHIVE.creationtime"
 )
 
 // basicKeys is a set of the PAX keys for which we have built-in support.
-// This does not contain "charset" or "comment", which are both PAX-specific,
-// so adding them as first-class features of Header is unlikely.
-// Users can use the PAXRecords field to set it themselves.
+// This does not contain "charset", "comment" or "hdrcharset", which are
+// PAX-specific. Although they are exposed as fields of Header, records set
+// in PAXRecords for them are still honored when the fields are empty.
 var basicKeys = map[string]bool{
 	paxPath: true, paxLinkpath: true, paxSize: true, paxUid: true, paxGid: true,
 	paxUname: true, paxGname: true, paxMtime: true, paxAtime: true, paxCtime: true,
@@ -153,15 +274,39 @@ type Header struct {
 	// If the Format is unspecified, then Writer.WriteHeader rounds ModTime
 	// to the nearest second and ignores the AccessTime and ChangeTime fields.
 	//
-	// To use AccessTime or ChangeTime, specify the Format as PAX or GNU.
+	// To use AccessTime or ChangeTime, specify the Format as PAX or GNU,
+	// or set Writer.GNUTimes.
 	// To use sub-second resolution, specify the Format as PAX.
 	ModTime    time.Time // Modification time
 	AccessTime time.Time // Access time (requires either PAX or GNU support)
 	ChangeTime time.Time // Change time (requires either PAX or GNU support)
 
+	// CreationTime is the time the file was created, also known as its
+	// birth time, which some file systems record. It is stored in the
+	// "LIBARCHIVE.creationtime" PAX record, and is only written if the
+	// Format is set to PAX. FileInfoHeader fills it in where the stat data
+	// of the system records it, which is not the case on Linux. There,
+	// AddDir and AddFile obtain it with the statx system call instead, on
+	// amd64 and arm64 with Linux 4.11 or later.
+	CreationTime time.Time
+
 	Devmajor int64 // Major device number (valid for TypeChar or TypeBlock)
 	Devminor int64 // Minor device number (valid for TypeChar or TypeBlock)
 
+	// Comment, Charset, and HdrCharset hold the PAX records of the same
+	// names, in lowercase. Setting any of them requires the PAX format,
+	// and they take precedence over the records in PAXRecords.
+	//
+	// Charset names the character set of the file data, such as
+	// "ISO-IR 10646 2000 UTF-8", while HdrCharset is "BINARY" if the
+	// Name, Linkname, Uname, and Gname fields hold raw bytes rather than UTF-8.
+	// If HdrCharset is empty, Writer.WriteHeader sets the "hdrcharset"
+	// record to "BINARY" when it stores any of those fields in a PAX record
+	// and they are not valid UTF-8.
+	Comment    string // Free-form description of the entry
+	Charset    string // Character set of the file data
+	HdrCharset string // Character set of the header fields
+
 	// Xattrs stores extended attributes as PAX records under the
 	// "SCHILY.xattr." namespace.
 	//
@@ -172,9 +317,41 @@ type Header struct {
 	// When Writer.WriteHeader is called, the contents of Xattrs will take
 	// precedence over those in PAXRecords.
 	//
-	// Deprecated: Use PAXRecords instead.
+	// Deprecated: Use ExtendedAttrs and SetExtendedAttr instead,
+	// which support binary values and the records written by libarchive.
 	Xattrs map[string]string
 
+	// AccessACL is the POSIX.1e access control list of the file, and
+	// DefaultACL is the ACL inherited by files created in a directory.
+	// They are stored in the "SCHILY.acl.access" and "SCHILY.acl.default"
+	// PAX records, as done by star and bsdtar, and require the PAX format.
+	// They take precedence over those records in PAXRecords.
+	AccessACL  []ACLEntry
+	DefaultACL []ACLEntry
+
+	// Flags are the BSD file flags of the file, stored in the
+	// "SCHILY.fflags" PAX record, as done by star and bsdtar.
+	// Setting them requires the PAX format.
+	Flags FileFlags
+
+	// SELinuxContext is the SELinux security context of the file, such as
+	// "system_u:object_r:bin_t:s0". It is stored in the
+	// "RHT.security.selinux" PAX record, as done by GNU tar, and requires
+	// the PAX format. When reading archives made by tools that store it
+	// as the "security.selinux" extended attribute instead, it is taken
+	// from that attribute.
+	SELinuxContext string
+
+	// WindowsAttributes holds the Windows file attributes of the file,
+	// a combination of the FILE_ATTRIBUTE_* flags such as hidden (0x2),
+	// system (0x4) and read-only (0x1), and WindowsSecurityDescriptor holds
+	// its security descriptor in self-relative form.
+	// They are stored in the "MSWINDOWS.fileattr" and
+	// "MSWINDOWS.rawsecuritydescriptor" PAX records, and require the
+	// PAX format. They are zero if unknown.
+	WindowsAttributes         uint32
+	WindowsSecurityDescriptor []byte
+
 	// PAXRecords is a map of PAX extended header records.
 	//
 	// User-defined records should have keys of the following form:
@@ -182,9 +359,14 @@ type Header struct {
 	// Where VENDOR is some namespace in all uppercase, and keyword may
 	// not contain the '=' character (e.g., "GOLANG.pkg.version").
 	// The key and value should be non-empty UTF-8 strings.
+	// Records in any such namespace need not be registered: Writer writes
+	// them as they are, and Reader reports them in PAXRecords of a Header
+	// whose Format is PAX.
 	//
 	// When Writer.WriteHeader is called, PAX records derived from the
 	// the other fields in Header take precedence over PAXRecords.
+	// The methods PAXRecord, SetPAXRecord and DeletePAXRecord access
+	// records with validation of their keys.
 	PAXRecords map[string]string
 
 	// Format specifies the format of the tar header.
@@ -325,13 +507,151 @@ type fileState interface {
 // and an error is returned explaining why.
 //
 // As a by-product of checking the fields, this function returns paxHdrs, which
-// contain all fields that could not be directly encoded.
+// contain all fields that could not be directly encoded, or is nil if there
+// are none.
 // A value receiver ensures that this method does not mutate the source Header.
 func (h Header) allowedFormats() (format Format, paxHdrs map[string]string, err error) {
+	return h.checkFormats(nil)
+}
+
+// A FormatConstraint describes the formats that cannot encode a Header
+// because of one of its fields.
+type FormatConstraint struct {
+	Field   string // Name of the Header field, such as "Name" or "Xattrs"
+	Exclude Format // Logical OR of the formats that cannot encode the field
+	Reason  string // Explanation, such as "USTAR cannot encode Uid=2097152"
+}
+
+// A FormatReport explains which formats can encode a Header.
+type FormatReport struct {
+	// Allowed is the logical OR of the formats that can encode the Header.
+	Allowed Format
+
+	// Format is the format chosen by Writer.WriteHeader,
+	// which prefers USTAR, then PAX, then GNU, and only uses V7 and STAR
+	// on request.
+	// It is FormatUnknown if the Header cannot be encoded.
+	Format Format
+
+	// Constraints lists the fields that rule out formats,
+	// in the order that they are checked.
+	Constraints []FormatConstraint
+
+	// Err explains why the Header cannot be encoded, if it is invalid or
+	// no format is allowed.
+	Err error
+}
+
+// FormatReport reports which formats can encode h, which of them
+// Writer.WriteHeader would use, and which fields ruled out the others.
+// This allows producers targeting consumers that only support USTAR to
+// find the fields that cause PAX or GNU headers to be written.
+//
+// Like WriteHeader, FormatReport rounds ModTime and ignores AccessTime and
+// ChangeTime unless h.Format is set. The Writer options Deterministic and
+// ClampTime, which may change the header further, are not taken into account.
+func (h *Header) FormatReport() FormatReport {
+	var rep FormatReport
+	h2 := *h
+	h2.defaultTimes()
+	rep.Allowed, _, rep.Err = h2.checkFormats(&rep)
+	switch {
+	case rep.Allowed.has(FormatUSTAR):
+		rep.Format = FormatUSTAR
+	case rep.Allowed.has(FormatPAX):
+		rep.Format = FormatPAX
+	case rep.Allowed.has(FormatGNU):
+		rep.Format = FormatGNU
+	case rep.Allowed.has(FormatV7):
+		rep.Format = FormatV7
+	case rep.Allowed.has(FormatSTAR):
+		rep.Format = FormatSTAR
+	}
+	return rep
+}
+
+// Validate reports whether Writer.WriteHeader can write h in the format f,
+// which must be one of FormatV7, FormatUSTAR, FormatPAX, FormatGNU or
+// FormatSTAR, as if h.Format were set to f. This allows checking headers
+// before streaming an archive begins.
+//
+// If h cannot be written, Validate returns a *HeaderError listing each field
+// that is invalid or that f cannot encode, with its value. Like FormatReport,
+// Validate does not take the options of a Writer into account.
+func (h *Header) Validate(f Format) error {
+	switch f {
+	case FormatV7, FormatUSTAR, FormatPAX, FormatGNU, FormatSTAR:
+	default:
+		return fmt.Errorf("archive/tar: cannot validate header for format %v", f)
+	}
+	h2 := *h
+	h2.Format = f
+	h2.defaultTimes()
+	_, _, err := h2.checkFormats(nil)
+	he, ok := err.(*HeaderError)
+	if !ok {
+		return err
+	}
+
+	// Omit the reason that f was requested, which is implied.
+	fields := he.Fields[:0]
+	for _, fe := range he.Fields {
+		if fe.Field != "Format" || fe.Reason != "Format specifies "+f.String() {
+			fields = append(fields, fe)
+		}
+	}
+	he.Fields = fields
+	return he
+}
+
+// defaultTimes rounds ModTime and clears AccessTime and ChangeTime,
+// unless the format is explicitly chosen, and clears CreationTime
+// unless the format is PAX.
+// This ensures nominal usage of WriteHeader (without specifying the format)
+// does not always result in the PAX format being chosen, which
+// causes a 1KiB increase to every header.
+func (h *Header) defaultTimes() {
+	if h.Format == FormatUnknown {
+		h.ModTime = h.ModTime.Round(time.Second)
+		h.AccessTime = time.Time{}
+		h.ChangeTime = time.Time{}
+	}
+	if !h.Format.has(FormatPAX) {
+		h.CreationTime = time.Time{}
+	}
+}
+
+// checkFormats implements allowedFormats, and records the constraints
+// imposed by each field in rep if it is non-nil.
+func (h Header) checkFormats(rep *FormatReport) (format Format, paxHdrs map[string]string, err error) {
 	format = FormatUSTAR | FormatPAX | FormatGNU
-	paxHdrs = make(map[string]string)
+	if h.Format.has(FormatV7 | FormatSTAR) {
+		format.mayBe(h.Format & (FormatV7 | FormatSTAR)) // Only used if explicitly requested
+	}
+	// setPAX sets a PAX record, allocating paxHdrs only once one is needed.
+	setPAX := func(k, v string) {
+		if paxHdrs == nil {
+			paxHdrs = make(map[string]string)
+		}
+		paxHdrs[k] = v
+	}
 
-	var whyNoUSTAR, whyNoPAX, whyNoGNU string
+	// exclude removes the formats in f from the allowed formats,
+	// on account of the named field.
+	// Reasons specific to V7 or STAR are only formatted if those formats
+	// are requested, to avoid needless allocations.
+	all := format
+	exclude := func(f Format, field, why string) {
+		if f &= all; f == FormatUnknown {
+			return
+		}
+		format.mustNotBe(f)
+		if rep != nil {
+			rep.Constraints = append(rep.Constraints, FormatConstraint{field, f, why})
+		}
+	}
+
+	var whyNoV7, whyNoUSTAR, whyNoPAX, whyNoGNU, whyNoSTAR string
 	var preferPAX bool // Prefer PAX over USTAR
 	verifyString := func(s string, size int, name, paxKey string) {
 		// NUL-terminator is optional for path and linkpath.
@@ -339,44 +659,62 @@ func (h Header) allowedFormats() (format Format, paxHdrs map[string]string, err
 		// but neither GNU nor BSD tar checks for it.
 		tooLong := len(s) > size
 		allowLongGNU := paxKey == paxPath || paxKey == paxLinkpath
+		// V7 has no prefix field, nor fields for the user and group names.
+		inV7 := paxKey == paxPath || paxKey == paxLinkpath
+		if all.has(FormatV7) && ((inV7 && (hasNUL(s) || !isASCII(s) || tooLong)) || (!inV7 && s != "")) {
+			whyNoV7 = fmt.Sprintf("V7 cannot encode %s=%q", name, s)
+			exclude(FormatV7, name, whyNoV7)
+		}
 		if hasNUL(s) || (tooLong && !allowLongGNU) {
 			whyNoGNU = fmt.Sprintf("GNU cannot encode %s=%q", name, s)
-			format.mustNotBe(FormatGNU)
+			exclude(FormatGNU, name, whyNoGNU)
 		}
 		if !isASCII(s) || tooLong {
 			canSplitUSTAR := paxKey == paxPath
 			if _, _, ok := splitUSTARPath(s); !canSplitUSTAR || !ok {
 				whyNoUSTAR = fmt.Sprintf("USTAR cannot encode %s=%q", name, s)
-				format.mustNotBe(FormatUSTAR)
+				exclude(FormatUSTAR, name, whyNoUSTAR)
+			}
+			if _, _, ok := splitPrefixPath(s, starPrefixSize); all.has(FormatSTAR) && (!canSplitUSTAR || !ok) {
+				whyNoSTAR = fmt.Sprintf("STAR cannot encode %s=%q", name, s)
+				exclude(FormatSTAR, name, whyNoSTAR)
 			}
 			if paxKey == paxNone {
 				whyNoPAX = fmt.Sprintf("PAX cannot encode %s=%q", name, s)
-				format.mustNotBe(FormatPAX)
+				exclude(FormatPAX, name, whyNoPAX)
 			} else {
-				paxHdrs[paxKey] = s
+				setPAX(paxKey, s)
 			}
 		}
 		if v, ok := h.PAXRecords[paxKey]; ok && v == s {
-			paxHdrs[paxKey] = v
+			setPAX(paxKey, v)
 		}
 	}
 	verifyNumeric := func(n int64, size int, name, paxKey string) {
 		if !fitsInBase256(size, n) {
 			whyNoGNU = fmt.Sprintf("GNU cannot encode %s=%d", name, n)
-			format.mustNotBe(FormatGNU)
+			exclude(FormatGNU, name, whyNoGNU)
 		}
 		if !fitsInOctal(size, n) {
+			if all.has(FormatV7) {
+				whyNoV7 = fmt.Sprintf("V7 cannot encode %s=%d", name, n)
+				exclude(FormatV7, name, whyNoV7)
+			}
 			whyNoUSTAR = fmt.Sprintf("USTAR cannot encode %s=%d", name, n)
-			format.mustNotBe(FormatUSTAR)
+			exclude(FormatUSTAR, name, whyNoUSTAR)
+			if all.has(FormatSTAR) {
+				whyNoSTAR = fmt.Sprintf("STAR cannot encode %s=%d", name, n)
+				exclude(FormatSTAR, name, whyNoSTAR)
+			}
 			if paxKey == paxNone {
 				whyNoPAX = fmt.Sprintf("PAX cannot encode %s=%d", name, n)
-				format.mustNotBe(FormatPAX)
+				exclude(FormatPAX, name, whyNoPAX)
 			} else {
-				paxHdrs[paxKey] = strconv.FormatInt(n, 10)
+				setPAX(paxKey, strconv.FormatInt(n, 10))
 			}
 		}
 		if v, ok := h.PAXRecords[paxKey]; ok && v == strconv.FormatInt(n, 10) {
-			paxHdrs[paxKey] = v
+			setPAX(paxKey, v)
 		}
 	}
 	verifyTime := func(ts time.Time, size int, name, paxKey string) {
@@ -385,26 +723,40 @@ func (h Header) allowedFormats() (format Format, paxHdrs map[string]string, err
 		}
 		if !fitsInBase256(size, ts.Unix()) {
 			whyNoGNU = fmt.Sprintf("GNU cannot encode %s=%v", name, ts)
-			format.mustNotBe(FormatGNU)
+			exclude(FormatGNU, name, whyNoGNU)
 		}
 		isMtime := paxKey == paxMtime
 		fitsOctal := fitsInOctal(size, ts.Unix())
 		if (isMtime && !fitsOctal) || !isMtime {
+			if all.has(FormatV7) {
+				whyNoV7 = fmt.Sprintf("V7 cannot encode %s=%v", name, ts)
+				exclude(FormatV7, name, whyNoV7)
+			}
 			whyNoUSTAR = fmt.Sprintf("USTAR cannot encode %s=%v", name, ts)
-			format.mustNotBe(FormatUSTAR)
+			exclude(FormatUSTAR, name, whyNoUSTAR)
+		}
+		if !fitsOctal && all.has(FormatSTAR) {
+			whyNoSTAR = fmt.Sprintf("STAR cannot encode %s=%v", name, ts)
+			exclude(FormatSTAR, name, whyNoSTAR)
 		}
+		// Format the time without allocating, and reuse the string of an
+		// equal record in PAXRecords if there is one.
+		var buf [32]byte
+		b := appendPAXTime(buf[:0], ts)
+		v, reuse := h.PAXRecords[paxKey]
+		reuse = reuse && v == string(b)
 		needsNano := ts.Nanosecond() != 0
 		if !isMtime || !fitsOctal || needsNano {
 			preferPAX = true // USTAR may truncate sub-second measurements
 			if paxKey == paxNone {
 				whyNoPAX = fmt.Sprintf("PAX cannot encode %s=%v", name, ts)
-				format.mustNotBe(FormatPAX)
-			} else {
-				paxHdrs[paxKey] = formatPAXTime(ts)
+				exclude(FormatPAX, name, whyNoPAX)
+			} else if !reuse {
+				setPAX(paxKey, string(b))
 			}
 		}
-		if v, ok := h.PAXRecords[paxKey]; ok && v == formatPAXTime(ts) {
-			paxHdrs[paxKey] = v
+		if reuse {
+			setPAX(paxKey, v)
 		}
 	}
 
@@ -417,6 +769,16 @@ func (h Header) allowedFormats() (format Format, paxHdrs map[string]string, err
 	verifyString(h.Linkname, len(v7.LinkName()), "Linkname", paxLinkpath)
 	verifyString(h.Uname, len(ustar.UserName()), "Uname", paxUname)
 	verifyString(h.Gname, len(ustar.GroupName()), "Gname", paxGname)
+	if h.HdrCharset == "" {
+		// PAX records are UTF-8 unless stated otherwise, so mark names
+		// holding raw bytes, such as from legacy file systems, as binary.
+		for _, k := range [...]string{paxPath, paxLinkpath, paxUname, paxGname} {
+			if v, ok := paxHdrs[k]; ok && !utf8.ValidString(v) {
+				paxHdrs[paxHdrCharset] = hdrCharsetBinary
+				break
+			}
+		}
+	}
 	verifyNumeric(h.Mode, len(v7.Mode()), "Mode", paxNone)
 	verifyNumeric(int64(h.Uid), len(v7.UID()), "Uid", paxUid)
 	verifyNumeric(int64(h.Gid), len(v7.GID()), "Gid", paxGid)
@@ -426,36 +788,116 @@ func (h Header) allowedFormats() (format Format, paxHdrs map[string]string, err
 	verifyTime(h.ModTime, len(v7.ModTime()), "ModTime", paxMtime)
 	verifyTime(h.AccessTime, len(gnu.AccessTime()), "AccessTime", paxAtime)
 	verifyTime(h.ChangeTime, len(gnu.ChangeTime()), "ChangeTime", paxCtime)
+	if all.has(FormatV7) && (h.Devmajor != 0 || h.Devminor != 0) {
+		whyNoV7 = fmt.Sprintf("V7 cannot encode Devmajor=%d, Devminor=%d", h.Devmajor, h.Devminor)
+		exclude(FormatV7, "Devmajor", whyNoV7)
+	}
+	switch h.Typeflag {
+	case TypeReg, TypeRegA, TypeLink, TypeSymlink:
+	case TypeDir:
+		if !strings.HasSuffix(h.Name, "/") {
+			whyNoV7 = "V7 requires a trailing slash in the names of directories"
+			exclude(FormatV7, "Name", whyNoV7)
+		}
+	default:
+		if all.has(FormatV7) {
+			whyNoV7 = fmt.Sprintf("V7 cannot encode Typeflag=%q", h.Typeflag)
+			exclude(FormatV7, "Typeflag", whyNoV7)
+		}
+	}
 
 	// Check for header-only types.
+	// Invalid fields are collected in problems, to report all of them.
+	var problems []FieldError
+	invalid := func(field, why string) {
+		problems = append(problems, FieldError{Field: field, Reason: why})
+	}
 	var whyOnlyPAX, whyOnlyGNU string
 	switch h.Typeflag {
 	case TypeReg, TypeChar, TypeBlock, TypeFifo, TypeGNUSparse:
 		// Exclude TypeLink and TypeSymlink, since they may reference directories.
 		if strings.HasSuffix(h.Name, "/") {
-			return FormatUnknown, nil, headerError{"filename may not have trailing slash"}
+			invalid("Name", "filename may not have trailing slash")
 		}
 	case TypeXHeader, TypeGNULongName, TypeGNULongLink:
-		return FormatUnknown, nil, headerError{"cannot manually encode TypeXHeader, TypeGNULongName, or TypeGNULongLink headers"}
+		invalid("Typeflag", "cannot manually encode TypeXHeader, TypeGNULongName, or TypeGNULongLink headers")
 	case TypeXGlobalHeader:
 		h2 := Header{Name: h.Name, Typeflag: h.Typeflag, Xattrs: h.Xattrs, PAXRecords: h.PAXRecords, Format: h.Format}
 		if !reflect.DeepEqual(h, h2) {
-			return FormatUnknown, nil, headerError{"only PAXRecords should be set for TypeXGlobalHeader"}
+			invalid("Typeflag", "only PAXRecords should be set for TypeXGlobalHeader")
 		}
 		whyOnlyPAX = "only PAX supports TypeXGlobalHeader"
-		format.mayOnlyBe(FormatPAX)
+		exclude(all&^FormatPAX, "Typeflag", whyOnlyPAX)
 	}
 	if !isHeaderOnlyType(h.Typeflag) && h.Size < 0 {
-		return FormatUnknown, nil, headerError{"negative size on header-only type"}
+		invalid("Size", "negative size on header-only type")
 	}
 
 	// Check PAX records.
+	verifyPAXOnly := func(s, name, paxKey string) {
+		if s != "" {
+			setPAX(paxKey, s)
+			whyOnlyPAX = "only PAX supports " + name
+			exclude(all&^FormatPAX, name, whyOnlyPAX)
+		}
+	}
+	verifyPAXOnly(h.Comment, "Comment", paxComment)
+	verifyPAXOnly(h.Charset, "Charset", paxCharset)
+	verifyPAXOnly(h.HdrCharset, "HdrCharset", paxHdrCharset)
 	if len(h.Xattrs) > 0 {
 		for k, v := range h.Xattrs {
-			paxHdrs[paxSchilyXattr+k] = v
+			setPAX(paxSchilyXattr+k, v)
 		}
 		whyOnlyPAX = "only PAX supports Xattrs"
-		format.mayOnlyBe(FormatPAX)
+		exclude(all&^FormatPAX, "Xattrs", whyOnlyPAX)
+	}
+	verifyACL := func(acl []ACLEntry, name, paxKey string) {
+		if len(acl) == 0 {
+			return
+		}
+		s, err := formatACL(acl)
+		if err != nil {
+			invalid(name, fmt.Sprintf("invalid %s: %v", name, err))
+			return
+		}
+		setPAX(paxKey, s)
+		whyOnlyPAX = "only PAX supports " + name
+		exclude(all&^FormatPAX, name, whyOnlyPAX)
+	}
+	verifyACL(h.AccessACL, "AccessACL", paxSchilyACLAccess)
+	verifyACL(h.DefaultACL, "DefaultACL", paxSchilyACLDefault)
+	if !h.CreationTime.IsZero() {
+		setPAX(paxLibarchiveCreationTime, formatPAXTime(h.CreationTime))
+		whyOnlyPAX = "only PAX supports CreationTime"
+		exclude(all&^FormatPAX, "CreationTime", whyOnlyPAX)
+	}
+	if h.SELinuxContext != "" {
+		setPAX(paxRHTSELinux, h.SELinuxContext)
+		whyOnlyPAX = "only PAX supports SELinuxContext"
+		exclude(all&^FormatPAX, "SELinuxContext", whyOnlyPAX)
+	}
+	if h.WindowsAttributes != 0 {
+		setPAX(paxMSWindowsFileAttr, strconv.FormatUint(uint64(h.WindowsAttributes), 10))
+		whyOnlyPAX = "only PAX supports WindowsAttributes"
+		exclude(all&^FormatPAX, "WindowsAttributes", whyOnlyPAX)
+	}
+	if len(h.WindowsSecurityDescriptor) > 0 {
+		setPAX(paxMSWindowsRawSD, base64.StdEncoding.EncodeToString(h.WindowsSecurityDescriptor))
+		whyOnlyPAX = "only PAX supports WindowsSecurityDescriptor"
+		exclude(all&^FormatPAX, "WindowsSecurityDescriptor", whyOnlyPAX)
+	}
+	if h.Flags != 0 {
+		// Keep a record that names flags unknown to FileFlags, if it
+		// otherwise matches, so that they are preserved.
+		s := h.Flags.String()
+		if v, ok := h.PAXRecords[paxSchilyFflags]; ok {
+			if f, _ := ParseFileFlags(v); f == h.Flags {
+				s = v
+			}
+		}
+		setPAX(paxSchilyFflags, s)
+		whyOnlyPAX = "only PAX supports Flags"
+		exclude(all&^FormatPAX, "Flags", whyOnlyPAX)
 	}
 	if len(h.PAXRecords) > 0 {
 		for k, v := range h.PAXRecords {
@@ -463,19 +905,24 @@ func (h Header) allowedFormats() (format Format, paxHdrs map[string]string, err
 			case exists:
 				continue // Do not overwrite existing records
 			case h.Typeflag == TypeXGlobalHeader:
-				paxHdrs[k] = v // Copy all records
-			case !basicKeys[k] && !strings.HasPrefix(k, paxGNUSparse):
-				paxHdrs[k] = v // Ignore local records that may conflict
+				setPAX(k, v) // Copy all records
+			case !isReservedPAXKey(k):
+				setPAX(k, v) // Ignore local records that may conflict
 			}
 		}
 		whyOnlyPAX = "only PAX supports PAXRecords"
-		format.mayOnlyBe(FormatPAX)
+		exclude(all&^FormatPAX, "PAXRecords", whyOnlyPAX)
 	}
+	var badKeys []string
 	for k, v := range paxHdrs {
 		if !validPAXRecord(k, v) {
-			return FormatUnknown, nil, headerError{fmt.Sprintf("invalid PAX record: %q", k+" = "+v)}
+			badKeys = append(badKeys, k)
 		}
 	}
+	sort.Strings(badKeys)
+	for _, k := range badKeys {
+		invalid(paxKeyField(k), fmt.Sprintf("invalid PAX record: %q", k+" = "+paxHdrs[k]))
+	}
 
 	// TODO(dsnet): Re-enable this when adding sparse support.
 	// See https://golang.org/issue/22735
@@ -483,10 +930,10 @@ func (h Header) allowedFormats() (format Format, paxHdrs map[string]string, err
 		// Check sparse files.
 		if len(h.SparseHoles) > 0 || h.Typeflag == TypeGNUSparse {
 			if isHeaderOnlyType(h.Typeflag) {
-				return FormatUnknown, nil, headerError{"header-only type cannot be sparse"}
+				invalid("SparseHoles", "header-only type cannot be sparse")
 			}
 			if !validateSparseEntries(h.SparseHoles, h.Size) {
-				return FormatUnknown, nil, headerError{"invalid sparse holes"}
+				invalid("SparseHoles", "invalid sparse holes")
 			}
 			if h.Typeflag == TypeGNUSparse {
 				whyOnlyGNU = "only GNU supports TypeGNUSparse"
@@ -505,21 +952,78 @@ func (h Header) allowedFormats() (format Format, paxHdrs map[string]string, err
 		if wantFormat.has(FormatPAX) && !preferPAX {
 			wantFormat.mayBe(FormatUSTAR) // PAX implies USTAR allowed too
 		}
+		if rep != nil { // Otherwise, mayOnlyBe suffices
+			exclude(all&^wantFormat, "Format", "Format specifies "+h.Format.String())
+		}
 		format.mayOnlyBe(wantFormat) // Set union of formats allowed and format wanted
 	}
 	if format == FormatUnknown {
+		var whys []string
 		switch h.Format {
+		case FormatV7:
+			whys = []string{"Format specifies V7", whyNoV7, whyOnlyPAX, whyOnlyGNU}
+		case FormatSTAR:
+			whys = []string{"Format specifies STAR", whyNoSTAR, whyOnlyPAX, whyOnlyGNU}
 		case FormatUSTAR:
-			err = headerError{"Format specifies USTAR", whyNoUSTAR, whyOnlyPAX, whyOnlyGNU}
+			whys = []string{"Format specifies USTAR", whyNoUSTAR, whyOnlyPAX, whyOnlyGNU}
 		case FormatPAX:
-			err = headerError{"Format specifies PAX", whyNoPAX, whyOnlyGNU}
+			whys = []string{"Format specifies PAX", whyNoPAX, whyOnlyGNU}
 		case FormatGNU:
-			err = headerError{"Format specifies GNU", whyNoGNU, whyOnlyPAX}
+			whys = []string{"Format specifies GNU", whyNoGNU, whyOnlyPAX}
 		default:
-			err = headerError{whyNoUSTAR, whyNoPAX, whyNoGNU, whyOnlyPAX, whyOnlyGNU}
+			whys = []string{whyNoUSTAR, whyNoPAX, whyNoGNU, whyOnlyPAX, whyOnlyGNU}
+		}
+
+		// Find the fields behind the reasons, which are only
+		// recorded when producing a report.
+		if rep == nil {
+			rep = new(FormatReport)
+			h.checkFormats(rep)
+		}
+		for _, why := range whys {
+			if why == "" {
+				continue
+			}
+			field := "Format"
+			for _, c := range rep.Constraints {
+				if c.Reason == why {
+					field = c.Field
+					break
+				}
+			}
+			problems = append(problems, FieldError{Field: field, Reason: why})
+		}
+	}
+	if len(problems) > 0 {
+		hv := reflect.ValueOf(h)
+		for i := range problems {
+			if v := hv.FieldByName(problems[i].Field); v.IsValid() {
+				problems[i].Value = v.Interface()
+			}
 		}
+		return FormatUnknown, nil, &HeaderError{problems}
+	}
+	return format, paxHdrs, nil
+}
+
+// paxKeyField returns the name of the Header field that the PAX record
+// with key k comes from.
+func paxKeyField(k string) string {
+	switch {
+	case k == paxPath:
+		return "Name"
+	case k == paxLinkpath:
+		return "Linkname"
+	case k == paxUname:
+		return "Uname"
+	case k == paxGname:
+		return "Gname"
+	case k == paxComment:
+		return "Comment"
+	case strings.HasPrefix(k, paxSchilyXattr):
+		return "Xattrs"
 	}
-	return format, paxHdrs, err
+	return "PAXRecords"
 }
 
 // FileInfo returns an os.FileInfo for the Header.
@@ -598,6 +1102,17 @@ func (fi headerFileInfo) Mode() (mode os.FileMode) {
 // sysStat, if non-nil, populates h from system-dependent fields of fi.
 var sysStat func(fi os.FileInfo, h *Header) error
 
+// sysBtime, if non-nil, returns the creation time of the file at p, without
+// following symbolic links, on systems whose stat data does not record it.
+var sysBtime func(p string) time.Time
+
+// sysFileID, if non-nil, returns the identity of the file described by fi,
+// which is shared by all of its hard links, and whether it has several.
+var sysFileID func(fi os.FileInfo) (id fileID, linked bool)
+
+// fileID identifies a file by its device and inode numbers.
+type fileID struct{ dev, ino uint64 }
+
 const (
 	// Mode constants from the USTAR spec:
 	// See http://pubs.opengroup.org/onlinepubs/9699919799/utilities/pax.html#tag_20_92_13_06
@@ -676,6 +1191,7 @@ func FileInfoHeader(fi os.FileInfo, link string) (*Header, error) {
 		h.Gname = sys.Gname
 		h.AccessTime = sys.AccessTime
 		h.ChangeTime = sys.ChangeTime
+		h.CreationTime = sys.CreationTime
 		if sys.Xattrs != nil {
 			h.Xattrs = make(map[string]string)
 			for k, v := range sys.Xattrs {
@@ -701,6 +1217,100 @@ func FileInfoHeader(fi os.FileInfo, link string) (*Header, error) {
 	return h, nil
 }
 
+// isLocalName reports whether name, a slash-separated path from an archive,
+// refers to a location within the directory the archive is extracted into.
+// Empty names, absolute names, and names that escape using ".." are not local.
+// On Windows, names containing backslashes or volume names are also rejected.
+func isLocalName(name string) bool {
+	if name == "" || name[0] == '/' {
+		return false
+	}
+	if runtime.GOOS == "windows" && strings.ContainsAny(name, `\:`) {
+		return false
+	}
+	name = path.Clean(name)
+	return name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// hasInsecurePath reports whether the Name or Linkname of h may refer to
+// a location outside the directory the archive is extracted into.
+//
+// The Linkname of a hard link is relative to the root of the archive,
+// while the Linkname of a symbolic link is relative to the directory
+// containing the link.
+func (h *Header) hasInsecurePath() bool {
+	if !isLocalName(h.Name) {
+		return true
+	}
+	switch h.Typeflag {
+	case TypeLink:
+		return !isLocalName(h.Linkname)
+	case TypeSymlink:
+		if path.IsAbs(h.Linkname) {
+			return true
+		}
+		return !isLocalName(path.Join(path.Dir(h.Name), h.Linkname))
+	}
+	return false
+}
+
+// clone returns a copy of h that shares no maps or slices with it,
+// since Reader.NextInto reuses them.
+func (h *Header) clone() *Header {
+	h2 := *h
+	copyMap := func(m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+		m2 := make(map[string]string, len(m))
+		for k, v := range m {
+			m2[k] = v
+		}
+		return m2
+	}
+	h2.Xattrs = copyMap(h.Xattrs)
+	h2.PAXRecords = copyMap(h.PAXRecords)
+	h2.AccessACL = append([]ACLEntry(nil), h.AccessACL...)
+	h2.DefaultACL = append([]ACLEntry(nil), h.DefaultACL...)
+	h2.WindowsSecurityDescriptor = append([]byte(nil), h.WindowsSecurityDescriptor...)
+	return &h2
+}
+
+var (
+	godebugOnce sync.Once
+	godebugEnv  string
+)
+
+// goDebugString returns the value of the named GODEBUG key.
+// GODEBUG is of the form "key=val,key2=val2".
+func goDebugString(key string) string {
+	godebugOnce.Do(func() { godebugEnv = os.Getenv("GODEBUG") })
+	for s := godebugEnv; s != ""; {
+		kv := s
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			kv, s = s[:i], s[i+1:]
+		} else {
+			s = ""
+		}
+		if i := strings.IndexByte(kv, '='); i >= 0 && kv[:i] == key {
+			return kv[i+1:]
+		}
+	}
+	return ""
+}
+
+// isKnownType reports whether flag is one of the Type constants.
+func isKnownType(flag byte) bool {
+	switch flag {
+	case TypeReg, TypeRegA, TypeLink, TypeSymlink, TypeChar, TypeBlock, TypeDir,
+		TypeFifo, TypeCont, TypeXHeader, TypeXGlobalHeader, TypeGNUSparse,
+		TypeGNULongName, TypeGNULongLink:
+		return true
+	default:
+		return false
+	}
+}
+
 // isHeaderOnlyType checks if the given type flag is of the type that has no
 // data section even if a size is specified.
 func isHeaderOnlyType(flag byte) bool {
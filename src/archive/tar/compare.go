@@ -0,0 +1,131 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"crypto"
+	"reflect"
+	"time"
+)
+
+// A FieldDiff describes a field of Header whose values differ between
+// the two Headers passed to CompareHeaders.
+type FieldDiff struct {
+	Field string      // Name of the Header field, such as "Mode"
+	A, B  interface{} // Values of the field in each Header
+}
+
+// A CompareOption configures the behavior of CompareHeaders.
+type CompareOption func(*comparer)
+
+// CompareTimePrecision returns a CompareOption that causes CompareHeaders to
+// consider times equal if they differ by less than d, such as when the file
+// system or the format of an archive records times with a lower precision.
+func CompareTimePrecision(d time.Duration) CompareOption {
+	return func(c *comparer) { c.precision = d }
+}
+
+// CompareFormat returns a CompareOption that causes CompareHeaders to only
+// compare what the format f can store, to match a Header against one read
+// back from an archive of that format: fields that f does not store are
+// ignored, times are compared in whole seconds unless f is FormatPAX, and
+// the Format field itself is ignored. For example, only PAX stores the
+// Xattrs, and V7 does not store the Uname and Gname.
+func CompareFormat(f Format) CompareOption {
+	return func(c *comparer) { c.format = f }
+}
+
+// CompareIgnore returns a CompareOption that causes CompareHeaders to ignore
+// the named fields of Header, such as "PAXRecords" or "Uname".
+func CompareIgnore(fields ...string) CompareOption {
+	return func(c *comparer) {
+		if c.ignore == nil {
+			c.ignore = make(map[string]bool)
+		}
+		for _, f := range fields {
+			c.ignore[f] = true
+		}
+	}
+}
+
+// CompareHeaders compares the fields of a and b, and reports those that
+// differ, in the order of the fields of Header. It returns nil if a and b
+// are equal. Times are compared with time.Time.Equal, and empty maps
+// and slices are equal to nil ones.
+//
+// This is meant for tests, and for tools that verify extracted files or
+// a rewritten archive against the original archive. The CompareOptions
+// allow tolerating the differences that are expected in those cases.
+func CompareHeaders(a, b *Header, opts ...CompareOption) []FieldDiff {
+	var c comparer
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c.compare(a, b)
+}
+
+// compare implements CompareHeaders.
+func (c *comparer) compare(a, b *Header) []FieldDiff {
+	var diffs []FieldDiff
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		name := va.Type().Field(i).Name
+		if c.ignore[name] || (c.format != FormatUnknown && !c.stores(name)) {
+			continue
+		}
+		fa, fb := va.Field(i).Interface(), vb.Field(i).Interface()
+		if !c.equal(fa, fb) {
+			diffs = append(diffs, FieldDiff{name, fa, fb})
+		}
+	}
+	return diffs
+}
+
+// comparer holds the options of CompareHeaders and Diff.
+type comparer struct {
+	precision time.Duration
+	format    Format
+	ignore    map[string]bool
+	digest    crypto.Hash // Only used by Diff
+}
+
+// stores reports whether c.format stores the named field of Header.
+func (c *comparer) stores(field string) bool {
+	if c.format.has(FormatPAX) {
+		return field != "Format"
+	}
+	switch field {
+	case "Typeflag", "Name", "Linkname", "Size", "Mode", "Uid", "Gid", "ModTime":
+		return true
+	case "Uname", "Gname", "Devmajor", "Devminor":
+		return c.format.has(FormatUSTAR | FormatGNU | FormatSTAR)
+	case "AccessTime", "ChangeTime":
+		return c.format.has(FormatGNU | FormatSTAR)
+	}
+	return false
+}
+
+// equal reports whether the values x and y of a field of Header are equal.
+func (c *comparer) equal(x, y interface{}) bool {
+	if tx, ok := x.(time.Time); ok {
+		ty := y.(time.Time)
+		if c.format != FormatUnknown && !c.format.has(FormatPAX) {
+			tx, ty = tx.Truncate(time.Second), ty.Truncate(time.Second)
+		}
+		d := tx.Sub(ty)
+		if d < 0 {
+			d = -d
+		}
+		return tx.Equal(ty) || (c.precision > 0 && d < c.precision)
+	}
+	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
+	switch vx.Kind() {
+	case reflect.Map, reflect.Slice:
+		if vx.Len() == 0 && vy.Len() == 0 {
+			return true
+		}
+	}
+	return reflect.DeepEqual(x, y)
+}
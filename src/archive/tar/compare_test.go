@@ -0,0 +1,118 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareHeaders(t *testing.T) {
+	mtime := time.Unix(1500000000, 5e8)
+	vectors := []struct {
+		a, b   *Header
+		opts   []CompareOption
+		fields []string
+	}{{
+		a: &Header{Name: "file", Mode: 0644, ModTime: mtime},
+		b: &Header{Name: "file", Mode: 0644, ModTime: mtime.UTC()},
+	}, {
+		a:      &Header{Name: "file", Mode: 0644, Uid: 1},
+		b:      &Header{Name: "file", Mode: 0600, Uid: 2},
+		fields: []string{"Mode", "Uid"},
+	}, {
+		a: &Header{Name: "file", Xattrs: map[string]string{}, AccessACL: []ACLEntry{}},
+		b: &Header{Name: "file"},
+	}, {
+		a:      &Header{Name: "file", ModTime: mtime},
+		b:      &Header{Name: "file", ModTime: mtime.Add(time.Millisecond)},
+		fields: []string{"ModTime"},
+	}, {
+		a:    &Header{Name: "file", ModTime: mtime},
+		b:    &Header{Name: "file", ModTime: mtime.Add(time.Millisecond)},
+		opts: []CompareOption{CompareTimePrecision(time.Second)},
+	}, {
+		a:      &Header{Name: "file", ModTime: mtime},
+		b:      &Header{Name: "file", ModTime: mtime.Add(time.Second)},
+		opts:   []CompareOption{CompareTimePrecision(time.Second)},
+		fields: []string{"ModTime"},
+	}, {
+		a:      &Header{Name: "file", Uname: "gopher", Format: FormatPAX},
+		b:      &Header{Name: "file", Uname: "nobody"},
+		opts:   []CompareOption{CompareIgnore("Format")},
+		fields: []string{"Uname"},
+	}, {
+		a:    &Header{Name: "file", Uname: "gopher", Format: FormatPAX},
+		b:    &Header{Name: "file", Uname: "nobody"},
+		opts: []CompareOption{CompareIgnore("Format", "Uname")},
+	}, {
+		a:    &Header{Name: "file", Uname: "gopher", ModTime: mtime, AccessTime: mtime},
+		b:    &Header{Name: "file", ModTime: mtime.Truncate(time.Second), Format: FormatV7},
+		opts: []CompareOption{CompareFormat(FormatV7)},
+	}, {
+		a:      &Header{Name: "file", Uname: "gopher", Xattrs: map[string]string{"user.a": "b"}},
+		b:      &Header{Name: "file", Format: FormatUSTAR},
+		opts:   []CompareOption{CompareFormat(FormatUSTAR)},
+		fields: []string{"Uname"},
+	}, {
+		a:      &Header{Name: "file", ModTime: mtime, Xattrs: map[string]string{"user.a": "b"}},
+		b:      &Header{Name: "file", ModTime: mtime.Truncate(time.Second), Format: FormatPAX},
+		opts:   []CompareOption{CompareFormat(FormatPAX)},
+		fields: []string{"ModTime", "Xattrs"},
+	}}
+
+	for i, v := range vectors {
+		diffs := CompareHeaders(v.a, v.b, v.opts...)
+		var got []string
+		for _, d := range diffs {
+			got = append(got, d.Field)
+			fa := reflect.ValueOf(v.a).Elem().FieldByName(d.Field).Interface()
+			fb := reflect.ValueOf(v.b).Elem().FieldByName(d.Field).Interface()
+			if !reflect.DeepEqual(d.A, fa) || !reflect.DeepEqual(d.B, fb) {
+				t.Errorf("test %d, diff of %s = %v, %v; want %v, %v", i, d.Field, d.A, d.B, fa, fb)
+			}
+		}
+		if !reflect.DeepEqual(got, v.fields) {
+			t.Errorf("test %d, CompareHeaders() fields = %v, want %v", i, got, v.fields)
+		}
+	}
+}
+
+func TestCompareHeadersRoundTrip(t *testing.T) {
+	hdr := &Header{
+		Typeflag:   TypeReg,
+		Name:       "file",
+		Mode:       0644,
+		Uname:      "gopher",
+		ModTime:    time.Unix(1500000000, 5e8),
+		AccessTime: time.Unix(1500000001, 5e8),
+	}
+	for _, format := range []Format{FormatUSTAR, FormatGNU, FormatPAX} {
+		h := *hdr
+		h.Format = format
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if format != FormatPAX {
+			// Times with sub-second precision require PAX.
+			h.ModTime, h.AccessTime = h.ModTime.Truncate(time.Second), h.AccessTime.Truncate(time.Second)
+			if format == FormatUSTAR {
+				h.AccessTime = time.Time{}
+			}
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatalf("%v: WriteHeader() error: %v", format, err)
+		}
+		tw.Close()
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("%v: Next() error: %v", format, err)
+		}
+		if diffs := CompareHeaders(hdr, got, CompareFormat(format), CompareIgnore("PAXRecords")); diffs != nil {
+			t.Errorf("%v: CompareHeaders() = %v, want none", format, diffs)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import "io"
+
+// Concat writes to w a single archive holding the entries of all of the
+// archives read from rs, in order, in the manner of "tar -A". The trailers of
+// the input archives are dropped and a single trailer is written at the end.
+//
+// The entries are copied with Writer.CopyFrom, so they are not decoded and
+// encoded again, and the inputs are read sequentially, so they may be streams
+// such as pipes or network connections.
+func Concat(w io.Writer, rs ...io.Reader) error {
+	tw := NewWriter(w)
+	for _, r := range rs {
+		tr := NewReader(r)
+		for {
+			_, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil && err != ErrInsecurePath {
+				return err
+			}
+			if _, err := tw.CopyFrom(tr); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestConcat(t *testing.T) {
+	files := []string{
+		"testdata/gnu.tar",
+		"testdata/pax-global-records.tar",
+		"testdata/sparse-formats.tar",
+	}
+	var want []string
+	var rs []io.Reader
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		toc, err := List(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range toc {
+			want = append(want, e.Header.Name)
+		}
+		f.Seek(0, io.SeekStart)
+		rs = append(rs, iotest.HalfReader(f)) // Hide io.Seeker
+	}
+
+	var b bytes.Buffer
+	if err := Concat(&b, rs...); err != nil {
+		t.Fatalf("Concat() error: %v", err)
+	}
+	toc, err := List(&b)
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	var got []string
+	for _, e := range toc {
+		got = append(got, e.Header.Name)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries of concatenated archive = %q, want %q", got, want)
+	}
+}
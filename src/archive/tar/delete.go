@@ -0,0 +1,117 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import "io"
+
+// Delete removes the entries for which match returns true from the archive
+// in rw, in place, in the manner of "tar --delete". It returns the new size
+// of the archive, including its trailer.
+//
+// Delete first reads the headers of all of the entries, seeking past their
+// data, so that the archive is left unmodified if it cannot be read. The
+// entries following the first deleted one are then moved towards the start
+// of rw over the deleted ones, and a new trailer is written after the last
+// entry kept. If no entry matches, rw is left unmodified, and its size is
+// returned. When only entries at the end of the archive are deleted,
+// nothing is moved, and only the trailer is written. Entries are moved
+// as they are stored, without being decoded and encoded again.
+//
+// If rw has a Truncate method, like *os.File, it is truncated to the new
+// size of the archive. Otherwise, the rest of the old archive remains after
+// the new trailer, where readers ignore it. If Delete fails while moving
+// entries, the archive is left corrupt, as tar --delete leaves it.
+func Delete(rw io.ReadWriteSeeker, match func(*Header) bool) (int64, error) {
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	// Find the extents of the entries to keep, after the first deleted one.
+	var keep [][2]int64
+	deleted := int64(-1) // Offset of the first deleted entry
+	cr := &countReader{r: rw}
+	tr := NewReader(&countReadSeeker{cr, rw, 0})
+	tr.TolerateTruncatedTrailer = true
+	var end int64
+	for {
+		start := cr.n + tr.curr.PhysicalRemaining() + tr.pad
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			end = start
+			break
+		}
+		if err != nil && err != ErrInsecurePath {
+			return 0, err
+		}
+		stop := cr.n + tr.curr.PhysicalRemaining() + tr.pad
+		switch {
+		case match(hdr):
+			if deleted < 0 {
+				deleted = start
+			}
+		case deleted < 0:
+			// Entries before the first deleted one stay in place.
+		case len(keep) > 0 && keep[len(keep)-1][1] == start:
+			keep[len(keep)-1][1] = stop
+		default:
+			keep = append(keep, [2]int64{start, stop})
+		}
+	}
+	size, err := rw.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if size < end {
+		return 0, io.ErrUnexpectedEOF // Data of the last entry is truncated
+	}
+	if deleted < 0 {
+		return size, nil
+	}
+
+	// Move the entries to keep, and write the trailer after them.
+	pos := deleted
+	buf := make([]byte, 32<<10)
+	for _, k := range keep {
+		for off := k[0]; off < k[1]; {
+			b := buf
+			if rem := k[1] - off; rem < int64(len(b)) {
+				b = b[:rem]
+			}
+			if _, err := rw.Seek(off, io.SeekStart); err != nil {
+				return 0, err
+			}
+			if _, err := io.ReadFull(rw, b); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return 0, err
+			}
+			if _, err := rw.Seek(pos, io.SeekStart); err != nil {
+				return 0, err
+			}
+			if _, err := rw.Write(b); err != nil {
+				return 0, err
+			}
+			off += int64(len(b))
+			pos += int64(len(b))
+		}
+	}
+	if _, err := rw.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := rw.Write(zeroBlock[:]); err != nil {
+		return 0, err
+	}
+	if _, err := rw.Write(zeroBlock[:]); err != nil {
+		return 0, err
+	}
+	size = pos + 2*blockSize
+	if f, ok := rw.(interface{ Truncate(int64) error }); ok {
+		if err := f.Truncate(size); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
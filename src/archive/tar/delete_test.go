@@ -0,0 +1,136 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	reg := func(name, body string) testEntry {
+		return testEntry{hdr: Header{Name: name, Typeflag: TypeReg, Mode: 0644}, body: body}
+	}
+	src := makeArchive(t,
+		reg("a", "a"),
+		reg("b", strings.Repeat("b", 100000)),
+		reg(strings.Repeat("c", 200), "c"), // PAX header
+		reg("d", strings.Repeat("d", 1000)),
+		reg("e", "e"),
+	)
+	all := []string{"a", "b", strings.Repeat("c", 200), "d", "e"}
+
+	vectors := []struct {
+		del        []string
+		noTruncate bool
+	}{
+		{del: nil},
+		{del: []string{"a"}},
+		{del: []string{"b"}},
+		{del: []string{"b", "d"}},
+		{del: []string{"a", strings.Repeat("c", 200), "e"}},
+		{del: []string{"d", "e"}},
+		{del: all},
+		{del: []string{"b"}, noTruncate: true},
+	}
+	for i, v := range vectors {
+		f, err := ioutil.TempFile("", "tar-delete")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := f.Write(src); err != nil {
+			t.Fatal(err)
+		}
+
+		del := make(map[string]bool)
+		for _, name := range v.del {
+			del[name] = true
+		}
+		var rw io.ReadWriteSeeker = f
+		if v.noTruncate {
+			rw = struct{ io.ReadWriteSeeker }{f} // Hide Truncate
+		}
+		size, err := Delete(rw, func(hdr *Header) bool { return del[hdr.Name] })
+		if err != nil {
+			t.Fatalf("test %d, Delete() error: %v", i, err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantSize := size
+		if v.noTruncate {
+			wantSize = int64(len(src))
+		}
+		if fi.Size() != wantSize {
+			t.Errorf("test %d, file size = %d, want %d", i, fi.Size(), wantSize)
+		}
+		if len(v.del) == 0 && size != int64(len(src)) {
+			t.Errorf("test %d, Delete() = %d, want %d", i, size, len(src))
+		}
+
+		f.Seek(0, io.SeekStart)
+		var got, want []string
+		for _, name := range all {
+			if !del[name] {
+				want = append(want, name)
+			}
+		}
+		tr := NewReader(io.LimitReader(f, size))
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("test %d, ReadAll() error: %v", i, err)
+			}
+			if int64(len(data)) != hdr.Size || strings.Trim(string(data), hdr.Name[:1]) != "" {
+				t.Errorf("test %d, data of %s is corrupt", i, hdr.Name)
+			}
+			got = append(got, hdr.Name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("test %d, entries after Delete() = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestDeleteTruncated(t *testing.T) {
+	src := makeArchive(t,
+		testEntry{hdr: Header{Name: "a", Typeflag: TypeReg, Mode: 0644}, body: "a"},
+		testEntry{hdr: Header{Name: "b", Typeflag: TypeReg, Mode: 0644}, body: strings.Repeat("b", 10000)},
+	)
+	src = src[:3*blockSize+5000]
+	f, err := ioutil.TempFile("", "tar-delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Delete(f, func(hdr *Header) bool { return hdr.Name == "a" }); err != io.ErrUnexpectedEOF {
+		t.Errorf("Delete() error: %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(src) {
+		t.Errorf("Delete() of truncated archive modified it")
+	}
+}
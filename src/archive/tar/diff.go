@@ -0,0 +1,187 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"crypto"
+	"hash"
+	"io"
+)
+
+// An EntryDiff describes an entry that differs between the two archives
+// passed to Diff, or between the archive and the directory passed to DiffDir.
+type EntryDiff struct {
+	Index int     // Index of the entry in the archives; see DiffDir for -1
+	A, B  *Header // Headers of the entry in each archive; nil if missing
+
+	// Fields and DataDiffers report how the headers and data of the
+	// entries differ, and are unset if the entry is missing from either.
+	Fields      []FieldDiff
+	DataDiffers bool
+
+	// SumA and SumB hold the digests of the data of the entries in each
+	// archive if CompareDigest was passed to Diff, and nil otherwise.
+	SumA, SumB []byte
+}
+
+// CompareDigest returns a CompareOption that causes Diff to report the
+// digests of the data of entries, computed with h, which must be
+// available. It has no effect on CompareHeaders.
+func CompareDigest(h crypto.Hash) CompareOption {
+	return func(c *comparer) { c.digest = h }
+}
+
+// A Differ reports the differences between two archives, or between an
+// archive and a directory. It is returned by Diff and DiffDir.
+type Differ struct {
+	a, b  *Reader
+	c     comparer
+	index int
+	err   error
+
+	bufA, bufB []byte
+
+	// State of DiffDir, which compares a with dir, and leaves b nil.
+	dir   string
+	seen  map[string]bool // Names of the entries of a and their parents
+	extra []string        // Files only in dir, once the end of a is reached
+}
+
+// Diff returns a Differ that compares the archives read from a and b entry
+// by entry, reading each only once, without holding more than one entry
+// of each in memory. The headers are compared as by CompareHeaders with
+// opts, and the data of regular files byte by byte.
+//
+// Entries are matched by their position in the archives, as is suitable
+// for archives that are expected to be identical, such as those from
+// reproducible builds or an archive and its rewritten copy. An entry
+// inserted in one archive causes those after it to be reported as
+// different.
+func Diff(a, b io.Reader, opts ...CompareOption) *Differ {
+	d := &Differ{a: NewReader(a), b: NewReader(b)}
+	for _, opt := range opts {
+		opt(&d.c)
+	}
+	return d
+}
+
+// Next returns the next entry that differs between the archives, or
+// io.EOF when the end of both archives has been reached. Errors reading
+// either archive are returned as is, and are persistent.
+func (d *Differ) Next() (*EntryDiff, error) {
+	for d.err == nil {
+		var ed *EntryDiff
+		if d.b == nil {
+			ed, d.err = d.nextDir()
+		} else {
+			ed, d.err = d.next()
+		}
+		if ed != nil {
+			return ed, d.err
+		}
+	}
+	return nil, d.err
+}
+
+// next compares the next pair of entries, and returns nil if they are equal.
+func (d *Differ) next() (*EntryDiff, error) {
+	ha, err := d.a.Next()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	hb, err2 := d.b.Next()
+	if err2 != nil && err2 != io.EOF {
+		return nil, err2
+	}
+	if ha == nil && hb == nil {
+		return nil, io.EOF
+	}
+
+	ed := &EntryDiff{Index: d.index, A: ha, B: hb}
+	d.index++
+	if ha != nil && hb != nil {
+		ed.Fields = d.c.compare(ha, hb)
+	}
+	var ra, rb io.Reader
+	if ha != nil {
+		ra = d.a
+	}
+	if hb != nil {
+		rb = d.b
+	}
+	if ed.DataDiffers, err = d.compareData(ed, ra, rb); err != nil {
+		return nil, err
+	}
+	if ha != nil && hb != nil && ed.Fields == nil && !ed.DataDiffers {
+		return nil, nil
+	}
+	return ed, nil
+}
+
+// compareData reports whether the data read from ra and rb differ, where a
+// nil Reader represents a missing entry, and records their digests in ed.
+func (d *Differ) compareData(ed *EntryDiff, ra, rb io.Reader) (bool, error) {
+	var sumA, sumB hash.Hash
+	if d.c.digest != 0 {
+		sumA, sumB = d.c.digest.New(), d.c.digest.New()
+		defer func() {
+			if ra != nil {
+				ed.SumA = sumA.Sum(nil)
+			}
+			if rb != nil {
+				ed.SumB = sumB.Sum(nil)
+			}
+		}()
+	}
+	if ra == nil || rb == nil {
+		var err error
+		switch {
+		case ra != nil && sumA != nil:
+			_, err = io.Copy(sumA, ra)
+		case rb != nil && sumB != nil:
+			_, err = io.Copy(sumB, rb)
+		}
+		return false, err
+	}
+
+	if d.bufA == nil {
+		d.bufA, d.bufB = make([]byte, 32<<10), make([]byte, 32<<10)
+	}
+	differs := false
+	for {
+		na, errA := io.ReadFull(ra, d.bufA)
+		nb, errB := io.ReadFull(rb, d.bufB)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, errA
+		}
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+		if sumA != nil {
+			sumA.Write(d.bufA[:na])
+			sumB.Write(d.bufB[:nb])
+		}
+		if !bytes.Equal(d.bufA[:na], d.bufB[:nb]) {
+			differs = true
+			if sumA == nil {
+				return true, nil // The rest is skipped by Reader.Next
+			}
+		}
+		switch {
+		case errA != nil && errB != nil:
+			return differs, nil
+		case errA != nil:
+			ra = eofReader{}
+		case errB != nil:
+			rb = eofReader{}
+		}
+	}
+}
+
+// eofReader is an io.Reader that is always at EOF.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
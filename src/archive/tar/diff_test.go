@@ -0,0 +1,87 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	big := strings.Repeat("x", 100000)
+	a := makeArchive(t,
+		testEntry{hdr: Header{Name: "same", Typeflag: TypeReg, Mode: 0644}, body: "data"},
+		testEntry{hdr: Header{Name: "mode", Typeflag: TypeReg, Mode: 0644}, body: "data"},
+		testEntry{hdr: Header{Name: "data", Typeflag: TypeReg, Mode: 0644}, body: big + "a"},
+		testEntry{hdr: Header{Name: "short", Typeflag: TypeReg, Mode: 0644}, body: big},
+		testEntry{hdr: Header{Name: "removed", Typeflag: TypeReg, Mode: 0644}, body: "data"},
+	)
+	b := makeArchive(t,
+		testEntry{hdr: Header{Name: "same", Typeflag: TypeReg, Mode: 0644}, body: "data"},
+		testEntry{hdr: Header{Name: "mode", Typeflag: TypeReg, Mode: 0600}, body: "data"},
+		testEntry{hdr: Header{Name: "data", Typeflag: TypeReg, Mode: 0644}, body: big + "b"},
+		testEntry{hdr: Header{Name: "short", Typeflag: TypeReg, Mode: 0644}, body: big[:10]},
+	)
+
+	type result struct {
+		index       int
+		fields      []string
+		dataDiffers bool
+		missingB    bool
+	}
+	want := []result{
+		{index: 1, fields: []string{"Mode"}},
+		{index: 2, dataDiffers: true},
+		{index: 3, fields: []string{"Size"}, dataDiffers: true},
+		{index: 4, missingB: true},
+	}
+	for _, digest := range []bool{false, true} {
+		var opts []CompareOption
+		if digest {
+			opts = append(opts, CompareDigest(crypto.SHA256))
+		}
+		d := Diff(bytes.NewReader(a), bytes.NewReader(b), opts...)
+		for i := 0; ; i++ {
+			ed, err := d.Next()
+			if err == io.EOF {
+				if i != len(want) {
+					t.Errorf("digest %v, got %d differences, want %d", digest, i, len(want))
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("digest %v, Next() error: %v", digest, err)
+			}
+			if i >= len(want) {
+				t.Errorf("digest %v, unexpected difference: %+v", digest, ed)
+				continue
+			}
+			var fields []string
+			for _, f := range ed.Fields {
+				fields = append(fields, f.Field)
+			}
+			got := result{ed.Index, fields, ed.DataDiffers, ed.B == nil}
+			if !reflect.DeepEqual(got, want[i]) {
+				t.Errorf("digest %v, test %d, Next() = %+v, want %+v", digest, i, got, want[i])
+			}
+			if hasSums := ed.SumA != nil; hasSums != digest {
+				t.Errorf("digest %v, test %d, SumA = %x", digest, i, ed.SumA)
+			}
+			if digest && ed.B != nil && bytes.Equal(ed.SumA, ed.SumB) != !ed.DataDiffers {
+				t.Errorf("digest %v, test %d, SumA = %x, SumB = %x", digest, i, ed.SumA, ed.SumB)
+			}
+		}
+	}
+
+	d := Diff(bytes.NewReader(a), strings.NewReader("garbage"))
+	if _, err := d.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next() with invalid archive error: %v, want error", err)
+	}
+}
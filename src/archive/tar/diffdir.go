@@ -0,0 +1,203 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// dirFields lists the fields of Header that DiffDir compares,
+// as tar --diff does.
+var dirFields = map[string]bool{
+	"Typeflag": true,
+	"Linkname": true,
+	"Size":     true,
+	"Mode":     true,
+	"Uid":      true,
+	"Gid":      true,
+	"ModTime":  true,
+	"Devmajor": true,
+	"Devminor": true,
+}
+
+// DiffDir returns a Differ that compares the archive read from r with the
+// directory tree rooted at dir, such as one it was created from or extracted
+// into, in the manner of tar --diff, to validate backups.
+//
+// For each entry of the archive, the EntryDiff holds the Header of the
+// entry as A, and a Header describing the file of the same name on disk
+// as B, obtained with FileHeader, or nil if the file does not exist. Only the
+// type, link target, size, permission bits, ownership, modification time and
+// device numbers are compared, as by CompareHeaders with opts, as well as the
+// data of regular files. The size is only compared for regular files, and
+// the mode and modification time are not compared for symbolic links.
+// A hard link matches a file that is the same file as its target, and
+// nothing else is compared for it.
+//
+// Once the end of the archive is reached, the files and directories within
+// dir that the archive has no entries for are reported in lexical order,
+// with an Index of -1, A set to nil, and the Name of B set to their path
+// relative to dir. The contents of such directories are not reported
+// separately, and the parent directories of the entries of the archive
+// are not reported, even if the archive has no entries for them.
+// Entries with insecure names (see ErrInsecurePath) are ignored.
+func DiffDir(r io.Reader, dir string, opts ...CompareOption) *Differ {
+	d := &Differ{a: NewReader(r), dir: dir, seen: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(&d.c)
+	}
+	if d.c.ignore == nil {
+		d.c.ignore = make(map[string]bool)
+	}
+	t := reflect.TypeOf(Header{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := t.Field(i).Name; !dirFields[name] {
+			d.c.ignore[name] = true
+		}
+	}
+	return d
+}
+
+// nextDir compares the next entry of the archive with the file on disk,
+// and returns nil if they are equal.
+func (d *Differ) nextDir() (*EntryDiff, error) {
+	if d.extra != nil {
+		return d.nextExtra()
+	}
+	hdr, err := d.a.Next()
+	if err == ErrInsecurePath {
+		return nil, nil
+	}
+	if err == io.EOF {
+		if err := d.findExtra(); err != nil {
+			return nil, err
+		}
+		return d.nextExtra()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Typeflag == TypeXGlobalHeader {
+		return nil, nil
+	}
+
+	name := path.Clean(hdr.Name)
+	for n := name; n != "."; n = path.Dir(n) {
+		d.seen[n] = true
+	}
+	ed := &EntryDiff{Index: d.index, A: hdr}
+	d.index++
+	file := filepath.Join(d.dir, filepath.FromSlash(name))
+	disk, err := FileHeader(file)
+	if os.IsNotExist(err) {
+		return ed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	disk.Name = hdr.Name
+	ed.B = disk
+
+	// Compare the headers as tar --diff does.
+	a, b := *hdr, *disk
+	switch a.Typeflag {
+	case TypeRegA, TypeGNUSparse:
+		a.Typeflag = TypeReg
+	case TypeLink:
+		// Only the link itself matters, as the target is compared separately.
+		target := filepath.Join(d.dir, filepath.FromSlash(path.Clean(hdr.Linkname)))
+		if sameFile(file, target) {
+			disk.Typeflag, disk.Linkname = TypeLink, hdr.Linkname
+		}
+		b = a
+		b.Typeflag, b.Linkname = disk.Typeflag, disk.Linkname
+	}
+	a.Mode &= 07777
+	b.Mode &= 07777
+	if a.Typeflag != TypeReg {
+		b.Size = a.Size
+	}
+	if a.Typeflag == TypeSymlink {
+		b.Mode, b.ModTime = a.Mode, a.ModTime
+	}
+	if a.Typeflag != TypeChar && a.Typeflag != TypeBlock {
+		b.Devmajor, b.Devminor = a.Devmajor, a.Devminor
+	}
+	ed.Fields = d.c.compare(&a, &b)
+
+	if a.Typeflag == TypeReg && b.Typeflag == TypeReg {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		ed.DataDiffers, err = d.compareData(ed, d.a, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ed.Fields == nil && !ed.DataDiffers {
+		return nil, nil
+	}
+	return ed, nil
+}
+
+// sameFile reports whether a and b name the same file.
+func sameFile(a, b string) bool {
+	fa, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Lstat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
+
+// findExtra lists the files within d.dir that the archive has no entries for.
+func (d *Differ) findExtra() error {
+	d.extra = []string{}
+	err := filepath.Walk(d.dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(d.dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && !d.seen[rel] {
+			d.extra = append(d.extra, rel)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	sort.Strings(d.extra)
+	return err
+}
+
+// nextExtra returns the next file found only in d.dir, or io.EOF.
+func (d *Differ) nextExtra() (*EntryDiff, error) {
+	if len(d.extra) == 0 {
+		return nil, io.EOF
+	}
+	rel := d.extra[0]
+	d.extra = d.extra[1:]
+	hdr, err := FileHeader(filepath.Join(d.dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = rel
+	if hdr.Typeflag == TypeDir && !strings.HasSuffix(rel, "/") {
+		hdr.Name += "/"
+	}
+	return &EntryDiff{Index: -1, B: hdr}, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"crypto"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDiffDir(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestDiffDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Unix(1500000000, 0)
+	uid, gid := os.Getuid(), os.Getgid()
+	entry := func(name string, flag byte, mode int64, link, body string) testEntry {
+		return testEntry{hdr: Header{Name: name, Typeflag: flag, Mode: mode, Linkname: link, Uid: uid, Gid: gid, ModTime: mtime}, body: body}
+	}
+	data := makeArchive(t,
+		entry("dir/", TypeDir, 0755, "", ""),
+		entry("dir/same", TypeReg, 0644, "", "same"),
+		entry("dir/content", TypeReg, 0644, "", "hello"),
+		entry("dir/mode", TypeReg, 0644, "", "mode"),
+		entry("dir/size", TypeReg, 0644, "", "size"),
+		entry("dir/missing", TypeReg, 0644, "", "missing"),
+		entry("implicit/file", TypeReg, 0600, "", "implicit"),
+		entry("symlink", TypeSymlink, 0777, "dir/same", ""),
+		entry("retargeted", TypeSymlink, 0777, "dir/same", ""),
+		entry("hardlink", TypeLink, 0, "dir/same", ""),
+		entry("unlinked", TypeLink, 0, "dir/same", ""),
+	)
+	if err := Extract(dir, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+
+	diff := func() map[string]*EntryDiff {
+		got := make(map[string]*EntryDiff)
+		d := DiffDir(bytes.NewReader(data), dir, CompareDigest(crypto.SHA256))
+		for {
+			ed, err := d.Next()
+			if err == io.EOF {
+				return got
+			}
+			if err != nil {
+				t.Fatalf("Next() error: %v", err)
+			}
+			var name string
+			if ed.A != nil {
+				name = ed.A.Name
+			} else {
+				name = ed.B.Name
+			}
+			got[name] = ed
+		}
+	}
+	if got := diff(); len(got) != 0 {
+		for name, ed := range got {
+			t.Errorf("%s differs after extraction: %+v", name, ed.Fields)
+		}
+	}
+
+	write := func(name, data string) {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("dir/content", "HELLO")
+	write("dir/size", "larger")
+	if err := os.Chmod(filepath.Join(dir, "dir/mode"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "dir/missing")); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "retargeted"))
+	if err := os.Symlink("dir/content", filepath.Join(dir, "retargeted")); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "unlinked"))
+	write("unlinked", "same")
+	write("dir/extra", "extra")
+	if err := os.MkdirAll(filepath.Join(dir, "extradir/sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "dir"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	got := diff()
+	want := map[string]struct {
+		fields      []string
+		dataDiffers bool
+		missing     bool
+		extra       bool
+	}{
+		"dir/content": {dataDiffers: true},
+		"dir/mode":    {fields: []string{"Mode"}},
+		"dir/size":    {fields: []string{"Size"}, dataDiffers: true},
+		"dir/missing": {missing: true},
+		"retargeted":  {fields: []string{"Linkname"}},
+		"unlinked":    {fields: []string{"Typeflag", "Linkname"}},
+		"dir/extra":   {extra: true},
+		"extradir/":   {extra: true},
+	}
+	for name, v := range want {
+		ed, ok := got[name]
+		if !ok {
+			t.Errorf("%s: no difference reported", name)
+			continue
+		}
+		delete(got, name)
+		var fields []string
+		for _, f := range ed.Fields {
+			fields = append(fields, f.Field)
+		}
+		sort.Strings(fields)
+		sort.Strings(v.fields)
+		if !reflect.DeepEqual(fields, v.fields) || ed.DataDiffers != v.dataDiffers ||
+			(ed.B == nil) != v.missing || (ed.A == nil) != v.extra || (ed.Index == -1) != v.extra {
+			t.Errorf("%s: got fields %v, data differs %v, A %v, B %v, index %d",
+				name, fields, ed.DataDiffers, ed.A != nil, ed.B != nil, ed.Index)
+		}
+		if v.dataDiffers && (ed.SumA == nil || bytes.Equal(ed.SumA, ed.SumB)) {
+			t.Errorf("%s: got digests %x and %x, want different digests", name, ed.SumA, ed.SumB)
+		}
+	}
+	for name, ed := range got {
+		t.Errorf("%s: unexpected difference: %+v", name, ed.Fields)
+	}
+
+	// Ignoring fields.
+	d := DiffDir(bytes.NewReader(data), dir, CompareIgnore("Mode"))
+	for {
+		ed, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ed.A != nil && ed.A.Name == "dir/mode" {
+			t.Errorf("dir/mode differs with CompareIgnore(\"Mode\")")
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// A DigestError is returned by Reader.Read when the data of an entry does not
+// match a digest recorded in its PAX records. It does not indicate that the
+// archive is otherwise corrupt, so reading may continue with Reader.Next.
+// It is also returned by Writer.Flush when the data written for an entry does
+// not match a digest given in advance with Writer.Digests set.
+type DigestError struct {
+	Name      string // Name of the entry
+	Algorithm string // Name of the hash algorithm (e.g., "sha256")
+	Want      string // Digest recorded in the archive, in hexadecimal
+	Got       string // Digest of the data read, in hexadecimal
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("archive/tar: %s digest mismatch for %s: got %s, want %s", e.Algorithm, e.Name, e.Got, e.Want)
+}
+
+func isDigestError(err error) bool {
+	_, ok := err.(*DigestError)
+	return ok
+}
+
+// digestAlgorithms maps the algorithm names used in PAX records to hashes.
+var digestAlgorithms = map[string]crypto.Hash{
+	"md5":    crypto.MD5,
+	"sha1":   crypto.SHA1,
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// digestName returns the name of h used in PAX records, if h is supported.
+func digestName(h crypto.Hash) (string, error) {
+	for k, v := range digestAlgorithms {
+		if v == h && h.Available() {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("archive/tar: unsupported digest algorithm %d", h)
+}
+
+// entryDigest is a digest being computed for the data of an entry.
+type entryDigest struct {
+	algo string
+	want string
+	h    hash.Hash
+}
+
+// digestFileReader is a fileReader that computes the digests of the data
+// read from the underlying fileReader and verifies them at the end.
+type digestFileReader struct {
+	fileReader
+	name    string
+	digests []entryDigest
+	err     error // Result of verification, reported in place of io.EOF
+}
+
+// newDigestFileReader returns fr wrapped to verify the digests in the
+// PAX records of hdr. If there are none, it returns fr unchanged.
+func newDigestFileReader(fr fileReader, hdr *Header) fileReader {
+	var digests []entryDigest
+	for k, v := range hdr.PAXRecords {
+		if !strings.HasPrefix(k, paxLibarchiveDigest) {
+			continue
+		}
+		algo := k[len(paxLibarchiveDigest):]
+		if h, ok := digestAlgorithms[algo]; ok && h.Available() {
+			digests = append(digests, entryDigest{algo, v, h.New()})
+		}
+	}
+	if len(digests) == 0 {
+		return fr
+	}
+	return &digestFileReader{fileReader: fr, name: hdr.Name, digests: digests}
+}
+
+func (fr *digestFileReader) Read(b []byte) (int, error) {
+	n, err := fr.fileReader.Read(b)
+	for _, d := range fr.digests {
+		d.h.Write(b[:n])
+	}
+	if err == io.EOF {
+		err = fr.verify()
+	}
+	return n, err
+}
+
+func (fr *digestFileReader) WriteTo(w io.Writer) (int64, error) {
+	ws := []io.Writer{w}
+	for _, d := range fr.digests {
+		ws = append(ws, d.h)
+	}
+	n, err := fr.fileReader.WriteTo(io.MultiWriter(ws...))
+	if err == nil {
+		if err = fr.verify(); err == io.EOF {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+// verify compares the computed digests with the recorded ones once all of the
+// data has been read. It returns a *DigestError on mismatch and io.EOF otherwise.
+func (fr *digestFileReader) verify() error {
+	if fr.digests != nil {
+		fr.err = io.EOF
+		for _, d := range fr.digests {
+			got := fmt.Sprintf("%x", d.h.Sum(nil))
+			if !strings.EqualFold(got, d.want) {
+				fr.err = &DigestError{Name: fr.name, Algorithm: d.algo, Want: d.want, Got: got}
+				break
+			}
+		}
+		fr.digests = nil
+	}
+	return fr.err
+}
+
+// entryHash is a hash being computed for the data of an entry.
+type entryHash struct {
+	algo crypto.Hash
+	h    hash.Hash
+}
+
+// hashFileReader is a fileReader that feeds the data read from the underlying
+// fileReader to the hashes of a Reader, and marks them as complete at the end.
+type hashFileReader struct {
+	fileReader
+	tr *Reader
+}
+
+// newHashFileReader resets the hashes of tr for a new entry and returns fr
+// wrapped to compute them.
+func (tr *Reader) newHashFileReader(fr fileReader) fileReader {
+	if len(tr.hashes) != len(tr.Hashes) {
+		tr.hashes = make([]entryHash, len(tr.Hashes))
+	}
+	for i, algo := range tr.Hashes {
+		if e := &tr.hashes[i]; e.algo == algo && e.h != nil {
+			e.h.Reset()
+		} else {
+			*e = entryHash{algo, algo.New()}
+		}
+	}
+	tr.hashed = fr.LogicalRemaining() == 0
+	return &hashFileReader{fileReader: fr, tr: tr}
+}
+
+func (fr *hashFileReader) Read(b []byte) (int, error) {
+	n, err := fr.fileReader.Read(b)
+	for _, e := range fr.tr.hashes {
+		e.h.Write(b[:n])
+	}
+	if err == io.EOF {
+		fr.tr.hashed = true
+	}
+	return n, err
+}
+
+func (fr *hashFileReader) WriteTo(w io.Writer) (int64, error) {
+	ws := []io.Writer{w}
+	for _, e := range fr.tr.hashes {
+		ws = append(ws, e.h)
+	}
+	n, err := fr.fileReader.WriteTo(io.MultiWriter(ws...))
+	if err == nil {
+		fr.tr.hashed = true
+	}
+	return n, err
+}
+
+// Sum returns the digest computed with the hash function h of the data of
+// the current entry, which must be listed in the Hashes field. Only once all
+// of the data has been read, with Read returning io.EOF or with WriteTo,
+// is the digest available; until then, or if h is not listed, Sum returns nil.
+// Data skipped by calling Next is not hashed.
+func (tr *Reader) Sum(h crypto.Hash) []byte {
+	if !tr.hashed {
+		return nil
+	}
+	for _, e := range tr.hashes {
+		if e.algo == h {
+			return e.h.Sum(nil)
+		}
+	}
+	return nil
+}
+
+// digestEntry is the state of a regular file being written by a Writer with
+// Digests set. Digests not given in advance in the PAX records of the file
+// are written as placeholders of the same length, and recorded over them
+// once all of the data has been written.
+type digestEntry struct {
+	hdr     Header        // Header with placeholders for the missing digests
+	digests []entryDigest // Digests being computed; want is empty for placeholders
+	ws      io.WriteSeeker
+	offs    []int64 // Offsets in ws of the placeholders, by digest
+}
+
+// newDigestEntry returns a digestEntry for hdr, whose header has
+// placeholder digests of the same length as the final ones.
+func (tw *Writer) newDigestEntry(hdr *Header) (*digestEntry, error) {
+	e := &digestEntry{hdr: *hdr}
+	e.hdr.PAXRecords = make(map[string]string, len(hdr.PAXRecords)+len(tw.Digests))
+	for k, v := range hdr.PAXRecords {
+		e.hdr.PAXRecords[k] = v
+	}
+	for _, h := range tw.Digests {
+		name, err := digestName(h)
+		if err != nil {
+			return nil, err
+		}
+		want := hdr.PAXRecords[paxLibarchiveDigest+name]
+		if want == "" {
+			e.hdr.PAXRecords[paxLibarchiveDigest+name] = strings.Repeat("0", 2*h.Size())
+		}
+		e.digests = append(e.digests, entryDigest{name, want, h.New()})
+	}
+	return e, nil
+}
+
+func (e *digestEntry) Write(b []byte) (int, error) {
+	for _, d := range e.digests {
+		d.h.Write(b)
+	}
+	return len(b), nil
+}
+
+// startDigest writes the header of hdr with its digests or placeholders,
+// and prepares to compute the digests of its data.
+func (tw *Writer) startDigest(hdr *Header) error {
+	e, err := tw.newDigestEntry(hdr)
+	if err != nil {
+		return err
+	}
+	ws, _ := tw.dst().(io.WriteSeeker)
+	for _, d := range e.digests {
+		if d.want == "" && ws == nil {
+			return errors.New("archive/tar: Digests requires an io.WriteSeeker, or digests recorded in advance")
+		}
+	}
+	if err := tw.writeHeader(&e.hdr); err != nil {
+		return err
+	}
+	if ws != nil {
+		if err := e.locate(ws, tw.paxBuf); err != nil {
+			return err
+		}
+	}
+	tw.digest = e
+	return nil
+}
+
+// locate finds the placeholders of e in the PAX records paxBuf, which were
+// written to ws just before the header block that precedes its position.
+func (e *digestEntry) locate(ws io.WriteSeeker, paxBuf []byte) error {
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	off := end - blockSize - blockPadding(int64(len(paxBuf))) - int64(len(paxBuf))
+	e.ws, e.offs = ws, make([]int64, len(e.digests))
+	for s := string(paxBuf); len(s) > 0; {
+		k, v, r, err := parsePAXRecord(s)
+		if err != nil {
+			return err
+		}
+		// The value is followed by a newline and the remaining records.
+		pos := off + int64(len(paxBuf)-len(r)-1-len(v))
+		for i, d := range e.digests {
+			if d.want == "" && k == paxLibarchiveDigest+d.algo {
+				e.offs[i] = pos
+			}
+		}
+		s = r
+	}
+	return nil
+}
+
+// finishDigest records the digests of the data of the current entry over
+// their placeholders, and verifies those given in advance. It returns
+// a *DigestError if one of the latter does not match the data.
+func (tw *Writer) finishDigest() error {
+	e := tw.digest
+	tw.digest = nil
+
+	var end int64
+	for i, d := range e.digests {
+		got := fmt.Sprintf("%x", d.h.Sum(nil))
+		if d.want != "" {
+			if !strings.EqualFold(got, d.want) {
+				return &DigestError{Name: e.hdr.Name, Algorithm: d.algo, Want: d.want, Got: got}
+			}
+			continue
+		}
+		if end == 0 {
+			var err error
+			if end, err = e.ws.Seek(0, io.SeekCurrent); err != nil {
+				return err
+			}
+		}
+		if _, err := e.ws.Seek(e.offs[i], io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(e.ws, got); err != nil {
+			return err
+		}
+		e.hdr.PAXRecords[paxLibarchiveDigest+d.algo] = got // Shared with the TOC
+	}
+	if end != 0 {
+		if _, err := e.ws.Seek(end, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
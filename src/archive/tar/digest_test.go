@@ -0,0 +1,236 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVerifyDigests(t *testing.T) {
+	const data = "Hello, world!\n"
+	sha := fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+	md := fmt.Sprintf("%X", md5.Sum([]byte(data)))
+	bad := fmt.Sprintf("%x", sha256.Sum256([]byte("Goodbye")))
+
+	vectors := []struct {
+		records map[string]string
+		verify  bool
+		wantErr bool
+	}{
+		{nil, true, false},
+		{map[string]string{"LIBARCHIVE.digest.sha256": sha}, true, false},
+		{map[string]string{"LIBARCHIVE.digest.sha256": sha, "LIBARCHIVE.digest.md5": md}, true, false},
+		{map[string]string{"LIBARCHIVE.digest.sha256": bad}, true, true},
+		{map[string]string{"LIBARCHIVE.digest.sha256": bad}, false, false},
+		{map[string]string{"LIBARCHIVE.digest.sha256": sha, "LIBARCHIVE.digest.md5": bad}, true, true},
+		{map[string]string{"LIBARCHIVE.digest.rmd160": bad}, true, false},
+	}
+
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		for _, name := range []string{"file", "next"} {
+			hdr := &Header{Name: name, Typeflag: TypeReg, Size: int64(len(data)), PAXRecords: v.records}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(tw, data)
+		}
+		tw.Close()
+
+		tr := NewReader(&b)
+		tr.VerifyDigests = v.verify
+		if _, err := tr.Next(); err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		got, err := ioutil.ReadAll(tr)
+		if string(got) != data {
+			t.Errorf("test %d, ReadAll() = %q, want %q", i, got, data)
+		}
+		if de, ok := err.(*DigestError); ok != v.wantErr {
+			t.Errorf("test %d, ReadAll() error: got %v, want DigestError %v", i, err, v.wantErr)
+		} else if ok && (de.Name != "file" || de.Want != bad) {
+			t.Errorf("test %d, DigestError = %+v", i, de)
+		}
+
+		// A digest mismatch is not fatal to the rest of the archive.
+		if hdr, err := tr.Next(); err != nil || hdr.Name != "next" {
+			t.Errorf("test %d, second Next() = (%v, %v), want next entry", i, hdr, err)
+		}
+	}
+}
+
+func TestReaderHashes(t *testing.T) {
+	entries := []testEntry{
+		{hdr: Header{Name: "a", Typeflag: TypeReg}, body: "Hello, world!\n"},
+		{hdr: Header{Name: "dir/", Typeflag: TypeDir}},
+		{hdr: Header{Name: "b", Typeflag: TypeReg}, body: "Goodbye"},
+		{hdr: Header{Name: "skipped", Typeflag: TypeReg}, body: "unread"},
+	}
+	data := makeArchive(t, entries...)
+
+	for _, useWriteTo := range []bool{false, true} {
+		tr := NewReader(bytes.NewReader(data))
+		tr.Hashes = []crypto.Hash{crypto.SHA256, crypto.MD5}
+		for i, e := range entries {
+			if _, err := tr.Next(); err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+			if got := tr.Sum(crypto.SHA256); got != nil && len(e.body) > 0 {
+				t.Errorf("test %d, Sum() before reading = %x, want nil", i, got)
+			}
+			if e.hdr.Name == "skipped" {
+				continue
+			}
+			var err error
+			if useWriteTo {
+				_, err = tr.WriteTo(ioutil.Discard)
+			} else {
+				_, err = ioutil.ReadAll(tr)
+			}
+			if err != nil {
+				t.Fatalf("test %d, reading data error: %v", i, err)
+			}
+			sha, md := sha256.Sum256([]byte(e.body)), md5.Sum([]byte(e.body))
+			if got := tr.Sum(crypto.SHA256); !bytes.Equal(got, sha[:]) {
+				t.Errorf("test %d, Sum(SHA256) = %x, want %x", i, got, sha)
+			}
+			if got := tr.Sum(crypto.MD5); !bytes.Equal(got, md[:]) {
+				t.Errorf("test %d, Sum(MD5) = %x, want %x", i, got, md)
+			}
+			if got := tr.Sum(crypto.SHA1); got != nil {
+				t.Errorf("test %d, Sum(SHA1) = %x, want nil", i, got)
+			}
+		}
+		if _, err := tr.Next(); err != io.EOF {
+			t.Errorf("final Next() error: got %v, want %v", err, io.EOF)
+		}
+		if got := tr.Sum(crypto.SHA256); got != nil {
+			t.Errorf("Sum() after end of archive = %x, want nil", got)
+		}
+	}
+}
+
+func TestWriterDigests(t *testing.T) {
+	f, err := ioutil.TempFile("", "tar-digests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	tw := NewWriter(f)
+	tw.Digests = []crypto.Hash{crypto.SHA256, crypto.MD5}
+	tw.BuildTOC = true
+	entries := []struct {
+		hdr  Header
+		body string
+	}{
+		{Header{Name: "a", Typeflag: TypeReg, Size: 14}, "Hello, world!\n"},
+		{Header{Name: "dir/", Typeflag: TypeDir}, ""},
+		{Header{Name: "b", Typeflag: TypeReg, Size: -1}, "Goodbye"},
+		{Header{Name: "empty", Typeflag: TypeReg}, ""},
+		{Header{Name: "c", Typeflag: TypeReg, Size: 3, PAXRecords: map[string]string{"comment": "x"}}, "abc"},
+	}
+	for i, e := range entries {
+		if err := tw.WriteHeader(&e.hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		if i%2 == 0 {
+			_, err = io.WriteString(tw, e.body)
+		} else {
+			_, err = tw.ReadFrom(strings.NewReader(e.body))
+		}
+		if err != nil {
+			t.Fatalf("test %d, Write() error: %v", i, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewReader(f)
+	tr.VerifyDigests = true
+	toc := tw.TOC()
+	for i, e := range entries {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		got, err := ioutil.ReadAll(tr)
+		if err != nil || string(got) != e.body {
+			t.Errorf("test %d, ReadAll() = (%q, %v), want %q", i, got, err, e.body)
+		}
+		sha := fmt.Sprintf("%x", sha256.Sum256([]byte(e.body)))
+		md := fmt.Sprintf("%x", md5.Sum([]byte(e.body)))
+		if e.hdr.Typeflag != TypeReg {
+			sha, md = "", ""
+		}
+		if got := hdr.PAXRecords["LIBARCHIVE.digest.sha256"]; got != sha {
+			t.Errorf("test %d, sha256 record = %q, want %q", i, got, sha)
+		}
+		if got := hdr.PAXRecords["LIBARCHIVE.digest.md5"]; got != md {
+			t.Errorf("test %d, md5 record = %q, want %q", i, got, md)
+		}
+		if got := toc[i].Header.PAXRecords["LIBARCHIVE.digest.sha256"]; got != sha {
+			t.Errorf("test %d, sha256 record in TOC = %q, want %q", i, got, sha)
+		}
+	}
+	if e := entries[4]; len(e.hdr.PAXRecords) != 1 {
+		t.Errorf("WriteHeader() modified PAXRecords: %v", e.hdr.PAXRecords)
+	}
+
+	// Without an io.WriteSeeker, digests must be given in advance, and are
+	// then verified.
+	const data = "Hello, world!\n"
+	sha := fmt.Sprintf("%X", sha256.Sum256([]byte(data)))
+	var b bytes.Buffer
+	tw = NewWriter(&b)
+	tw.Digests = []crypto.Hash{crypto.SHA256}
+	if err := tw.WriteHeader(&Header{Name: "a", Size: int64(len(data))}); err == nil {
+		t.Errorf("WriteHeader() to bytes.Buffer without digests succeeded, want error")
+	}
+	recs := map[string]string{"LIBARCHIVE.digest.sha256": sha}
+	for i, body := range []string{data, "Goodbye, world\n"} {
+		if err := tw.WriteHeader(&Header{Name: "a", Size: int64(len(body)), PAXRecords: recs}); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		io.WriteString(tw, body)
+		err := tw.Flush()
+		if got, want := isDigestError(err), i > 0; got != want || (err != nil) != want {
+			t.Errorf("test %d, Flush() error: %v, want DigestError %v", i, err, want)
+		}
+	}
+
+	// Errors in headers are reported by WriteHeader, and missing data by Flush.
+	tw = NewWriter(f)
+	tw.Digests = []crypto.Hash{crypto.SHA256}
+	if err := tw.WriteHeader(&Header{Name: "a", Size: 1, Format: FormatUSTAR}); err == nil {
+		t.Errorf("WriteHeader() with USTAR format succeeded, want error")
+	}
+	if err := tw.WriteHeader(&Header{Name: "a", Size: 1}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	if err := tw.Flush(); err == nil {
+		t.Errorf("Flush() with missing data succeeded, want error")
+	}
+	io.WriteString(tw, "x")
+	tw.Digests = []crypto.Hash{crypto.SHA224}
+	if err := tw.WriteHeader(&Header{Name: "b"}); err == nil || !strings.Contains(err.Error(), "digest") {
+		t.Errorf("WriteHeader() with SHA-224 error: got %v, want unsupported digest", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EditHeaders calls edit with the Header of each entry of the archive in rw,
+// and writes the header blocks of the entries whose Header it modifies back
+// to rw, in place, leaving their data untouched. This allows changing the
+// mode, ownership or times of entries, as chmod, chown and touch would,
+// without rewriting the archive. If edit returns an error, EditHeaders
+// stops and returns it.
+//
+// The modified headers are encoded again by Writer.WriteHeader, with the
+// checksum computed anew, in the Format of the original Header unless edit
+// changes it, and must occupy exactly as many blocks as the original header
+// blocks, including any PAX or GNU extension headers, so that the data of
+// the entry stays where it is. The Size of entries cannot be changed, and
+// sparse files cannot be modified.
+//
+// The archive is read in full before anything is written, seeking past the
+// data of the entries, so that rw is left unmodified if it cannot be read,
+// if edit returns an error, or if a modified header cannot be written
+// in place.
+func EditHeaders(rw io.ReadWriteSeeker, edit func(hdr *Header) error) error {
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	type patch struct {
+		off int64
+		buf []byte
+	}
+	var patches []patch
+	cr := &countReader{r: rw}
+	tr := NewReader(&countReadSeeker{cr, rw, 0})
+	tr.TolerateTruncatedTrailer = true
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != ErrInsecurePath {
+			return err
+		}
+		if hdr.Typeflag == TypeXGlobalHeader {
+			continue
+		}
+		orig := hdr.clone()
+		if err := edit(hdr); err != nil {
+			return err
+		}
+		if CompareHeaders(orig, hdr) == nil {
+			continue
+		}
+		if _, ok := tr.curr.(*sparseFileReader); ok {
+			return errors.New("archive/tar: cannot edit header of sparse file " + orig.Name)
+		}
+		if hdr.Size != orig.Size {
+			return errors.New("archive/tar: cannot change Size of " + orig.Name + " in place")
+		}
+
+		var buf bytes.Buffer
+		if err := NewWriter(&buf).WriteHeader(hdr); err != nil {
+			return err
+		}
+		if n := len(tr.raw.buf); buf.Len() != n {
+			return fmt.Errorf("archive/tar: edited header of %s takes %d blocks instead of %d",
+				orig.Name, buf.Len()/blockSize, n/blockSize)
+		}
+		patches = append(patches, patch{cr.n - int64(buf.Len()), buf.Bytes()})
+	}
+
+	for _, p := range patches {
+		if _, err := rw.Seek(p.off, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := rw.Write(p.buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEditHeaders(t *testing.T) {
+	mtime := time.Unix(1500000000, 0)
+	touched := time.Unix(1600000000, 0)
+	long := strings.Repeat("long/", 30) + "file"
+	src := makeArchive(t,
+		testEntry{hdr: Header{Name: "ustar", Typeflag: TypeReg, Mode: 0644, ModTime: mtime, Format: FormatUSTAR}, body: "ustar"},
+		testEntry{hdr: Header{Name: long, Typeflag: TypeReg, Mode: 0644, ModTime: mtime, Format: FormatPAX}, body: "pax"},
+		testEntry{hdr: Header{Name: "gnu", Typeflag: TypeReg, Mode: 0644, ModTime: mtime, Format: FormatGNU}, body: "gnu"},
+		testEntry{hdr: Header{Name: "dir/", Typeflag: TypeDir, Mode: 0755, ModTime: mtime}},
+		testEntry{hdr: Header{Name: "untouched", Typeflag: TypeReg, Mode: 0600, ModTime: mtime}, body: "untouched"},
+	)
+
+	vectors := []struct {
+		edit    func(hdr *Header) error
+		check   func(hdr *Header) bool
+		wantErr string
+	}{{
+		edit: func(hdr *Header) error {
+			if hdr.Name != "untouched" {
+				hdr.Mode = 0700
+				hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 1000, 100, "user", "users"
+				hdr.ModTime = touched
+			}
+			return nil
+		},
+		check: func(hdr *Header) bool {
+			if hdr.Name == "untouched" {
+				return hdr.Mode == 0600 && hdr.Uid == 0 && hdr.ModTime.Equal(mtime)
+			}
+			return hdr.Mode == 0700 && hdr.Uid == 1000 && hdr.Gid == 100 &&
+				hdr.Uname == "user" && hdr.Gname == "users" && hdr.ModTime.Equal(touched)
+		},
+	}, {
+		edit: func(hdr *Header) error {
+			hdr.Size++
+			return nil
+		},
+		wantErr: "cannot change Size",
+	}, {
+		edit: func(hdr *Header) error {
+			if hdr.Name == "ustar" {
+				hdr.Format = FormatUnknown
+				hdr.Uname = strings.Repeat("u", 100) // Needs a PAX header
+			}
+			return nil
+		},
+		wantErr: "takes 3 blocks instead of 1",
+	}, {
+		edit: func(hdr *Header) error {
+			if hdr.Name == "dir/" {
+				return SkipEntry
+			}
+			hdr.Mode = 0
+			return nil
+		},
+		wantErr: SkipEntry.Error(),
+	}}
+
+	for i, v := range vectors {
+		f, err := ioutil.TempFile("", "tar-edit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := f.Write(src); err != nil {
+			t.Fatal(err)
+		}
+
+		err = EditHeaders(f, v.edit)
+		got, rerr := ioutil.ReadFile(f.Name())
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		if len(got) != len(src) {
+			t.Errorf("test %d, size of archive changed from %d to %d", i, len(src), len(got))
+		}
+		if v.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), v.wantErr) {
+				t.Errorf("test %d, EditHeaders() error: %v, want %q", i, err, v.wantErr)
+			}
+			if !bytes.Equal(got, src) {
+				t.Errorf("test %d, EditHeaders() modified the archive despite failing", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d, EditHeaders() error: %v", i, err)
+			continue
+		}
+
+		tr := NewReader(bytes.NewReader(got))
+		tr0 := NewReader(bytes.NewReader(src))
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+			hdr0, _ := tr0.Next()
+			if hdr.Name != hdr0.Name || hdr.Format != hdr0.Format {
+				t.Errorf("test %d, got %s in format %v, want %s in format %v", i, hdr.Name, hdr.Format, hdr0.Name, hdr0.Format)
+			}
+			if !v.check(hdr) {
+				t.Errorf("test %d, header of %s not edited as expected: %+v", i, hdr.Name, hdr)
+			}
+			data, _ := ioutil.ReadAll(tr)
+			data0, _ := ioutil.ReadAll(tr0)
+			if !bytes.Equal(data, data0) {
+				t.Errorf("test %d, data of %s = %q, want %q", i, hdr.Name, data, data0)
+			}
+		}
+	}
+}
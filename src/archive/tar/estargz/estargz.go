@@ -0,0 +1,145 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package estargz implements the eStargz format, a gzip-compressed tar
+// archive that can be read at random, so that container runtimes can fetch
+// the files of an image layer lazily, as they are accessed.
+//
+// An eStargz archive is a valid tar.gz file, whose entries are compressed
+// as separate gzip members, with the data of large files split into chunks
+// of separate members. A final entry named TOCName holds a table of
+// contents in JSON recording the offset of each member, and a footer at
+// the end of the archive records the offset of that entry.
+//
+// Entries named PrefetchLandmark or NoPrefetchLandmark mark the files
+// likely to be accessed first, which precede the landmark, and whether
+// there are any.
+//
+// See https://github.com/containerd/stargz-snapshotter/blob/master/docs/estargz.md.
+package estargz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TOCName is the name of the entry holding the table of contents.
+	TOCName = "stargz.index.json"
+
+	// FooterSize is the size of the footer of an eStargz archive.
+	FooterSize = 51
+
+	// legacyFooterSize is the size of the footer of the original stargz
+	// format, which Open also accepts.
+	legacyFooterSize = 47
+
+	// DefaultChunkSize is the default maximum size of the chunks into
+	// which the data of regular files is split.
+	DefaultChunkSize = 4 << 20
+)
+
+// Landmark entries, which are regular files holding the single byte
+// landmarkContents.
+const (
+	PrefetchLandmark   = ".prefetch.landmark"
+	NoPrefetchLandmark = ".no.prefetch.landmark"
+
+	landmarkContents = 0xf
+)
+
+// A TOC is the table of contents of an eStargz archive.
+type TOC struct {
+	Version int         `json:"version"`
+	Entries []*TOCEntry `json:"entries"`
+}
+
+// A TOCEntry describes an entry of an eStargz archive, or a chunk of the
+// data of a regular file after the first.
+type TOCEntry struct {
+	// Name is the cleaned name of the entry, without a leading "./"
+	// or a trailing slash.
+	Name string `json:"name"`
+
+	// Type is one of "dir", "reg", "symlink", "hardlink", "char",
+	// "block", "fifo" or "chunk".
+	Type string `json:"type"`
+
+	Size     int64             `json:"size,omitempty"`
+	ModTime  string            `json:"modtime,omitempty"` // In RFC 3339 format
+	LinkName string            `json:"linkName,omitempty"`
+	Mode     int64             `json:"mode,omitempty"`
+	UID      int               `json:"uid,omitempty"`
+	GID      int               `json:"gid,omitempty"`
+	Uname    string            `json:"userName,omitempty"`
+	Gname    string            `json:"groupName,omitempty"`
+	DevMajor int64             `json:"devMajor,omitempty"`
+	DevMinor int64             `json:"devMinor,omitempty"`
+	Xattrs   map[string][]byte `json:"xattrs,omitempty"`
+
+	// Offset is the offset in the archive of the gzip member holding the
+	// header of the entry, and the first chunk of its data, or for a
+	// "chunk", of the member holding that chunk.
+	Offset int64 `json:"offset,omitempty"`
+
+	// Digest is the digest of the data of a regular file,
+	// such as "sha256:" followed by the hexadecimal SHA-256.
+	Digest string `json:"digest,omitempty"`
+
+	// ChunkOffset and ChunkSize locate a chunk within the data of the file,
+	// and ChunkDigest is the digest of the chunk. ChunkSize is zero if
+	// the chunk extends to the end of the file.
+	ChunkOffset int64  `json:"chunkOffset,omitempty"`
+	ChunkSize   int64  `json:"chunkSize,omitempty"`
+	ChunkDigest string `json:"chunkDigest,omitempty"`
+}
+
+// cleanName returns the name of an entry as recorded in a TOC.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// footer returns the footer of an archive whose table of contents is at
+// offset tocOff: an empty gzip member whose header has an extra field
+// holding the offset. The member is built by hand, as its body must be an
+// empty stored block for the footer to have the size of FooterSize.
+func footer(tocOff int64) []byte {
+	subfield := fmt.Sprintf("%016xSTARGZ", tocOff)
+	b := []byte{0x1f, 0x8b, 8, 1 << 2, 0, 0, 0, 0, 0, 0xff} // FEXTRA set
+	b = append(b, 0, 0, 'S', 'G', 0, 0)
+	binary.LittleEndian.PutUint16(b[10:], uint16(4+len(subfield)))
+	binary.LittleEndian.PutUint16(b[14:], uint16(len(subfield)))
+	b = append(b, subfield...)
+	b = append(b, 1, 0, 0, 0xff, 0xff)       // Final empty stored block
+	return append(b, 0, 0, 0, 0, 0, 0, 0, 0) // CRC-32 and size
+}
+
+var errFooter = errors.New("estargz: invalid footer")
+
+// parseFooter returns the offset of the table of contents recorded in the
+// footer b, in either the eStargz or the original stargz format.
+func parseFooter(b []byte) (int64, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return 0, errFooter
+	}
+	extra := zr.Header.Extra
+	if len(extra) == 4+22 && extra[0] == 'S' && extra[1] == 'G' && binary.LittleEndian.Uint16(extra[2:]) == 22 {
+		extra = extra[4:]
+	}
+	if len(extra) != 22 || !bytes.HasSuffix(extra, []byte("STARGZ")) {
+		return 0, errFooter
+	}
+	off, err := strconv.ParseInt(string(extra[:16]), 16, 64)
+	if err != nil {
+		return 0, errFooter
+	}
+	return off, nil
+}
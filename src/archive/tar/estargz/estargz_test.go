@@ -0,0 +1,193 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package estargz
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeTar(t *testing.T) ([]byte, string) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	big := strings.Repeat("0123456789abcdef", 40) + "tail"
+	entries := []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{tar.Header{Typeflag: tar.TypeDir, Name: "./dir/", Mode: 0755}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "dir/big", Mode: 0644, Uname: "user"}, big},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "small", Mode: 0600}, "small"},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "empty", Mode: 0600}, ""},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "sym", Linkname: "dir/big"}, ""},
+		{tar.Header{Typeflag: tar.TypeLink, Name: "link", Linkname: "./small"}, ""},
+	}
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.data))
+		hdr.ModTime = time.Unix(1500000000, 0)
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), big
+}
+
+func TestRoundTrip(t *testing.T) {
+	src, big := makeTar(t)
+	var buf bytes.Buffer
+	zw := NewWriter(&buf)
+	zw.ChunkSize = 100
+	if err := zw.AppendLandmark(PrefetchLandmark); err != nil {
+		t.Fatalf("AppendLandmark() error: %v", err)
+	}
+	if err := zw.AppendTar(bytes.NewReader(src)); err != nil {
+		t.Fatalf("AppendTar() error: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if !strings.HasPrefix(zw.TOCDigest(), "sha256:") {
+		t.Errorf("TOCDigest() = %q, want sha256 digest", zw.TOCDigest())
+	}
+	if err := zw.AppendLandmark(PrefetchLandmark); err == nil {
+		t.Errorf("AppendLandmark() after Close succeeded")
+	}
+	b := buf.Bytes()
+
+	// The archive must be a valid tar.gz file.
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	wantNames := []string{PrefetchLandmark, "./dir/", "dir/big", "small", "empty", "sym", "link", TOCName}
+	if strings.Join(names, ",") != strings.Join(wantNames, ",") {
+		t.Errorf("got entries %q, want %q", names, wantNames)
+	}
+
+	zs, err := Open(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	vectors := []struct {
+		name, typ, link string
+		size            int64
+	}{
+		{PrefetchLandmark, "reg", "", 1},
+		{"dir/", "dir", "", 0},
+		{"./dir/big", "reg", "", int64(len(big))},
+		{"small", "reg", "", 5},
+		{"empty", "reg", "", 0},
+		{"sym", "symlink", "dir/big", 0},
+		{"link", "hardlink", "small", 0},
+	}
+	for i, v := range vectors {
+		e, ok := zs.Lookup(v.name)
+		if !ok {
+			t.Errorf("test %d, Lookup(%q) failed", i, v.name)
+			continue
+		}
+		if e.Type != v.typ || e.LinkName != v.link || e.Size != v.size {
+			t.Errorf("test %d, got type %q, link %q and size %d, want %q, %q and %d",
+				i, e.Type, e.LinkName, e.Size, v.typ, v.link, v.size)
+		}
+	}
+	if _, ok := zs.Lookup(TOCName); ok {
+		t.Errorf("Lookup(%q) succeeded", TOCName)
+	}
+	if e, _ := zs.Lookup("dir/big"); e.Uname != "user" || e.ModTime != "2017-07-14T02:40:00Z" || !strings.HasPrefix(e.Digest, "sha256:") {
+		t.Errorf("got %+v, want user name, modification time and digest", e)
+	}
+	var chunks int
+	for _, e := range zs.TOC().Entries {
+		if e.Type == "chunk" {
+			chunks++
+		}
+	}
+	if want := (len(big) + 99) / 100; chunks != want-1 {
+		t.Errorf("got %d chunks, want %d", chunks, want-1)
+	}
+
+	sr, err := zs.OpenFile("dir/big")
+	if err != nil {
+		t.Fatalf("OpenFile() error: %v", err)
+	}
+	for i, v := range []struct{ off, n int }{
+		{0, 10}, {95, 10}, {100, 100}, {150, 300}, {0, len(big)}, {len(big) - 4, 4},
+	} {
+		p := make([]byte, v.n)
+		if _, err := sr.ReadAt(p, int64(v.off)); err != nil {
+			t.Errorf("test %d, ReadAt() error: %v", i, err)
+		}
+		if want := big[v.off : v.off+v.n]; string(p) != want {
+			t.Errorf("test %d, ReadAt() = %q, want %q", i, p, want)
+		}
+	}
+	if _, err := sr.ReadAt(make([]byte, 10), int64(len(big)-5)); err != io.EOF {
+		t.Errorf("ReadAt() past end error: %v, want io.EOF", err)
+	}
+	if data, err := ioutil.ReadAll(sr); err != nil || string(data) != big {
+		t.Errorf("ReadAll() = %d bytes, %v, want %d bytes", len(data), err, len(big))
+	}
+	for _, name := range []string{"small", "empty"} {
+		sr, err := zs.OpenFile(name)
+		if err != nil {
+			t.Fatalf("OpenFile(%q) error: %v", name, err)
+		}
+		data, err := ioutil.ReadAll(sr)
+		if err != nil || (name == "small" && string(data) != "small") || (name == "empty" && len(data) != 0) {
+			t.Errorf("ReadAll() of %q = %q, %v", name, data, err)
+		}
+	}
+	if _, err := zs.OpenFile("sym"); err == nil {
+		t.Errorf("OpenFile() of symlink succeeded")
+	}
+	if _, err := zs.OpenFile("missing"); err == nil {
+		t.Errorf("OpenFile() of missing file succeeded")
+	}
+}
+
+func TestFooter(t *testing.T) {
+	for i, off := range []int64{0, 1, 12345, 1<<40 + 7} {
+		b := footer(off)
+		if len(b) != FooterSize {
+			t.Errorf("test %d, footer size %d, want %d", i, len(b), FooterSize)
+		}
+		got, err := parseFooter(b)
+		if err != nil || got != off {
+			t.Errorf("test %d, parseFooter() = %d, %v, want %d", i, got, err, off)
+		}
+	}
+	if _, err := parseFooter(make([]byte, FooterSize)); err == nil {
+		t.Errorf("parseFooter() of invalid footer succeeded")
+	}
+	if _, err := Open(bytes.NewReader([]byte("not an estargz archive, but long enough for a footer")), 52); err == nil {
+		t.Errorf("Open() of invalid archive succeeded")
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package estargz
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// A Reader provides random access to the files of an eStargz archive,
+// reading only the parts of the archive that hold the data being read.
+// It is safe for concurrent use.
+type Reader struct {
+	r    io.ReaderAt
+	size int64
+	toc  *TOC
+
+	entries map[string]*TOCEntry   // Entries other than chunks, by name
+	chunks  map[string][]*TOCEntry // Chunks of regular files, by name
+}
+
+// Open reads the table of contents of the eStargz archive of the given
+// size stored in r. It also accepts archives in the original stargz format.
+func Open(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < legacyFooterSize {
+		return nil, errFooter
+	}
+	var b [FooterSize]byte
+	n := int64(FooterSize)
+	if size < n {
+		n = legacyFooterSize
+	}
+	if _, err := r.ReadAt(b[FooterSize-n:], size-n); err != nil {
+		return nil, err
+	}
+	tocOff, err := parseFooter(b[FooterSize-n:])
+	if err != nil {
+		tocOff, err = parseFooter(b[FooterSize-legacyFooterSize:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tocOff < 0 || tocOff > size {
+		return nil, errFooter
+	}
+
+	zr, err := gzip.NewReader(io.NewSectionReader(r, tocOff, size-tocOff))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != TOCName {
+		return nil, errors.New("estargz: missing table of contents")
+	}
+	toc := new(TOC)
+	if err := json.NewDecoder(tr).Decode(toc); err != nil {
+		return nil, err
+	}
+
+	zs := &Reader{
+		r:       r,
+		size:    size,
+		toc:     toc,
+		entries: make(map[string]*TOCEntry),
+		chunks:  make(map[string][]*TOCEntry),
+	}
+	for _, e := range toc.Entries {
+		name := cleanName(e.Name)
+		if e.Type != "chunk" {
+			zs.entries[name] = e
+		}
+		if e.Type == "reg" || e.Type == "chunk" {
+			zs.chunks[name] = append(zs.chunks[name], e)
+		}
+	}
+	return zs, nil
+}
+
+// TOC returns the table of contents of the archive.
+func (zs *Reader) TOC() *TOC { return zs.toc }
+
+// Lookup returns the entry of the table of contents for the named file,
+// which is cleaned first, so that "./dir/" refers to "dir".
+func (zs *Reader) Lookup(name string) (*TOCEntry, bool) {
+	e, ok := zs.entries[cleanName(name)]
+	return e, ok
+}
+
+// OpenFile returns a reader for the data of the named regular file.
+// Only the chunks of the archive holding the data being read are read and
+// decompressed. Hard links are not resolved.
+func (zs *Reader) OpenFile(name string) (*io.SectionReader, error) {
+	e, ok := zs.Lookup(name)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if e.Type != "reg" {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("not a regular file")}
+	}
+	fr := &fileReader{zs: zs, size: e.Size, chunks: zs.chunks[cleanName(name)]}
+	return io.NewSectionReader(fr, 0, e.Size), nil
+}
+
+// fileReader is an io.ReaderAt for the data of a regular file,
+// where chunks are the file's chunks in order.
+type fileReader struct {
+	zs     *Reader
+	size   int64
+	chunks []*TOCEntry
+}
+
+func (fr *fileReader) ReadAt(b []byte, off int64) (n int, err error) {
+	for n < len(b) {
+		pos := off + int64(n)
+		if pos >= fr.size {
+			return n, io.EOF
+		}
+		i := sort.Search(len(fr.chunks), func(i int) bool { return fr.chunks[i].ChunkOffset > pos }) - 1
+		if i < 0 {
+			return n, errors.New("estargz: missing chunk in table of contents")
+		}
+		c := fr.chunks[i]
+		end := fr.size
+		if c.ChunkSize > 0 {
+			end = c.ChunkOffset + c.ChunkSize
+		}
+		r, err := fr.zs.openChunk(c)
+		if err != nil {
+			return n, err
+		}
+		if _, err := io.CopyN(ioutil.Discard, r, pos-c.ChunkOffset); err != nil {
+			return n, chunkError(err)
+		}
+		m := int64(len(b) - n)
+		if m > end-pos {
+			m = end - pos
+		}
+		nn, err := io.ReadFull(r, b[n:n+int(m)])
+		n += nn
+		if err != nil {
+			return n, chunkError(err)
+		}
+	}
+	return n, nil
+}
+
+// openChunk returns a reader for the data of the chunk c.
+func (zs *Reader) openChunk(c *TOCEntry) (io.Reader, error) {
+	if c.Offset < 0 || c.Offset > zs.size {
+		return nil, errors.New("estargz: invalid chunk offset")
+	}
+	zr, err := gzip.NewReader(io.NewSectionReader(zs.r, c.Offset, zs.size-c.Offset))
+	if err != nil {
+		return nil, err
+	}
+	zr.Multistream(false)
+	if c.Type == "chunk" {
+		return zr, nil
+	}
+	// The first chunk follows the header of the entry.
+	tr := tar.NewReader(zr)
+	if _, err := tr.Next(); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// chunkError converts an unexpected end of the data of a chunk to an error.
+func chunkError(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,265 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package estargz
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// A Writer writes an eStargz archive.
+type Writer struct {
+	// ChunkSize is the maximum size of the chunks into which the data
+	// of regular files is split. If zero, DefaultChunkSize is used.
+	ChunkSize int64
+
+	cw  *countWriter
+	gz  *gzip.Writer
+	tw  *tar.Writer
+	toc TOC
+	err error
+
+	tocDigest string
+}
+
+// NewWriter returns a Writer writing an eStargz archive to w.
+func NewWriter(w io.Writer) *Writer {
+	zw := &Writer{cw: &countWriter{w: w}, toc: TOC{Version: 1}}
+	zw.tw = tar.NewWriter(gzipSink{zw})
+	return zw
+}
+
+// gzipSink writes to the current gzip member of a Writer.
+type gzipSink struct{ w *Writer }
+
+func (s gzipSink) Write(b []byte) (int, error) { return s.w.gz.Write(b) }
+
+// newMember ends the current gzip member, if any, and starts a new one.
+// The padding of the current tar entry is written first unless mid is set,
+// which indicates that the data of the entry continues in the new member.
+func (w *Writer) newMember(mid bool) error {
+	if w.gz != nil {
+		if !mid {
+			if err := w.tw.Flush(); err != nil {
+				return err
+			}
+		}
+		if err := w.gz.Close(); err != nil {
+			return err
+		}
+		w.gz.Reset(w.cw)
+		return nil
+	}
+	w.gz = gzip.NewWriter(w.cw)
+	return nil
+}
+
+// AppendTar appends the entries of the tar archive read from r.
+// GNU sparse files are appended as regular files, and entries named
+// TOCName are dropped.
+func (w *Writer) AppendTar(r io.Reader) error {
+	if w.err != nil {
+		return w.err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != tar.ErrInsecurePath {
+			return err
+		}
+		if cleanName(hdr.Name) == TOCName {
+			continue
+		}
+		if err := w.appendEntry(hdr, tr); err != nil {
+			w.err = err
+			return err
+		}
+	}
+}
+
+// AppendLandmark appends a landmark entry named name, which is either
+// PrefetchLandmark, after the entries likely to be accessed first,
+// or NoPrefetchLandmark, as the first entry if there are none.
+func (w *Writer) AppendLandmark(name string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if name != PrefetchLandmark && name != NoPrefetchLandmark {
+		return errors.New("estargz: invalid landmark name " + name)
+	}
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: 1, Mode: 0644, ModTime: time.Unix(0, 0)}
+	w.err = w.appendEntry(hdr, bytes.NewReader([]byte{landmarkContents}))
+	return w.err
+}
+
+// appendEntry writes an entry with the data read from r in a new member,
+// and adds it to the table of contents.
+func (w *Writer) appendEntry(hdr *tar.Header, r io.Reader) error {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		hdr.Typeflag = tar.TypeReg
+		hdr.Format = tar.FormatUnknown
+	}
+	if err := w.newMember(false); err != nil {
+		return err
+	}
+	e := &TOCEntry{
+		Name:     cleanName(hdr.Name),
+		Type:     tocType(hdr.Typeflag),
+		LinkName: hdr.Linkname,
+		Mode:     hdr.Mode,
+		UID:      hdr.Uid,
+		GID:      hdr.Gid,
+		Uname:    hdr.Uname,
+		Gname:    hdr.Gname,
+		DevMajor: hdr.Devmajor,
+		DevMinor: hdr.Devminor,
+		Offset:   w.cw.n,
+	}
+	if !hdr.ModTime.IsZero() {
+		e.ModTime = hdr.ModTime.UTC().Format(time.RFC3339)
+	}
+	for _, xa := range hdr.ExtendedAttrs() {
+		if e.Xattrs == nil {
+			e.Xattrs = make(map[string][]byte)
+		}
+		e.Xattrs[xa.Name] = xa.Value
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if e.Type == "" {
+		_, err := io.Copy(w.tw, r) // Not in the table of contents
+		return err
+	}
+	if e.Type == "hardlink" {
+		e.LinkName = cleanName(hdr.Linkname)
+	}
+	w.toc.Entries = append(w.toc.Entries, e)
+	if e.Type != "reg" {
+		return nil
+	}
+
+	e.Size = hdr.Size
+	chunkSize := w.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	fileHash := sha256.New()
+	chunk := e
+	for off := int64(0); off < hdr.Size; off += chunkSize {
+		if off > 0 {
+			if err := w.newMember(true); err != nil {
+				return err
+			}
+			chunk = &TOCEntry{Name: e.Name, Type: "chunk", Offset: w.cw.n, ChunkOffset: off}
+			w.toc.Entries = append(w.toc.Entries, chunk)
+		}
+		n := hdr.Size - off
+		if n > chunkSize {
+			n = chunkSize
+		}
+		if n < hdr.Size {
+			chunk.ChunkSize = n
+		}
+		chunkHash := sha256.New()
+		if _, err := io.CopyN(io.MultiWriter(w.tw, fileHash, chunkHash), r, n); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		chunk.ChunkDigest = digest(chunkHash.Sum(nil))
+	}
+	e.Digest = digest(fileHash.Sum(nil))
+	return nil
+}
+
+// tocType returns the type of a TOCEntry for an entry of type flag,
+// or "" if such entries are not in the table of contents.
+func tocType(flag byte) string {
+	switch flag {
+	case tar.TypeReg, tar.TypeRegA:
+		return "reg"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return ""
+}
+
+func digest(sum []byte) string {
+	return "sha256:" + hex.EncodeToString(sum)
+}
+
+// Close writes the table of contents and the footer.
+// It does not close the underlying io.Writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errors.New("estargz: write after close")
+	b, err := json.Marshal(&w.toc)
+	if err != nil {
+		return err
+	}
+	if err := w.newMember(false); err != nil {
+		return err
+	}
+	tocOff := w.cw.n
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: TOCName, Size: int64(len(b)), Mode: 0644, ModTime: time.Unix(0, 0)}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(b); err != nil {
+		return err
+	}
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if err := w.gz.Close(); err != nil {
+		return err
+	}
+	if _, err := w.cw.Write(footer(tocOff)); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	w.tocDigest = digest(sum[:])
+	return nil
+}
+
+// TOCDigest returns the digest of the table of contents, once Close has
+// been called, for use in the annotations of the layer of an image.
+func (w *Writer) TOCDigest() string { return w.tocDigest }
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
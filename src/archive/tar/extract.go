@@ -0,0 +1,592 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// An ExtractOption configures the behavior of Extract.
+type ExtractOption func(*extractor)
+
+// ExtractNoOverwrite returns an ExtractOption that causes Extract to fail,
+// rather than replace the existing file, when an entry would be extracted
+// to a path that already exists. Existing directories are always reused.
+func ExtractNoOverwrite() ExtractOption {
+	return func(x *extractor) { x.noOverwrite = true }
+}
+
+// ExtractTouch returns an ExtractOption that causes Extract to leave the
+// modification and access times of extracted files at the time of extraction
+// instead of restoring them from the archive.
+func ExtractTouch() ExtractOption {
+	return func(x *extractor) { x.touch = true }
+}
+
+// ExtractOwner returns an ExtractOption that causes Extract to restore the
+// owner and group of extracted files. The Uname and Gname fields take
+// precedence over Uid and Gid when they name a user or group known to
+// the system, or to the NameResolver given with ExtractNames.
+//
+// Changing ownership usually requires privileges. If the process is not
+// running as root, failures to change ownership are ignored.
+func ExtractOwner() ExtractOption {
+	return func(x *extractor) { x.owner = true }
+}
+
+// ExtractNames returns an ExtractOption that causes Extract to look up the
+// users and groups named by the Uname and Gname fields of entries, and by
+// ACL entries, with r instead of SystemNames. With NumericNames, ownership
+// is restored from the Uid and Gid fields alone.
+func ExtractNames(r NameResolver) ExtractOption {
+	return func(x *extractor) { x.names = r }
+}
+
+// ExtractXattrs returns an ExtractOption that causes Extract to restore
+// the extended attributes of entries (see Header.ExtendedAttrs), including
+// their SELinux contexts.
+// Extended attributes are not set on symbolic links.
+//
+// Extended attributes are currently only supported on Linux and macOS,
+// where they include the resource fork and Finder information of files, as
+// the com.apple.ResourceFork and com.apple.FinderInfo attributes; on other
+// systems Extract fails if an entry has any.
+func ExtractXattrs() ExtractOption {
+	return func(x *extractor) { x.xattrs = true }
+}
+
+// ExtractACLs returns an ExtractOption that causes Extract to restore
+// the POSIX.1e access control lists of entries, from the AccessACL and
+// DefaultACL fields of their headers.
+//
+// ACLs are currently only supported on Linux;
+// on other systems Extract fails if an entry has any.
+func ExtractACLs() ExtractOption {
+	return func(x *extractor) { x.acls = true }
+}
+
+// ExtractJunctions returns an ExtractOption that causes Extract, on Windows,
+// to create symbolic links to directories as directory junctions, which
+// unlike symbolic links can be created without the SeCreateSymbolicLinkPrivilege
+// privilege. A junction is only created if the target of the link is an
+// existing directory beneath dst when the link is extracted, since it must
+// refer to an absolute path; other links remain symbolic links.
+// Elsewhere, this option has no effect.
+func ExtractJunctions() ExtractOption {
+	return func(x *extractor) { x.junctions = true }
+}
+
+// ExtractWindowsAttributes returns an ExtractOption that causes Extract to
+// set the Windows file attributes of extracted files, such as hidden and
+// system, from the WindowsAttributes field of their headers. Only the
+// attributes that SetFileAttributes accepts are set, and entries without
+// attributes are left as created, with the read-only attribute determined
+// by their permission bits.
+//
+// Windows attributes are only set on Windows; elsewhere, they are ignored.
+func ExtractWindowsAttributes() ExtractOption {
+	return func(x *extractor) { x.winAttrs = true }
+}
+
+// ExtractSecurityDescriptors returns an ExtractOption that causes Extract
+// to set the Windows security descriptors of extracted files, holding their
+// owner, primary group, DACL and SACL, from the WindowsSecurityDescriptor
+// field of their headers. Setting the SACL, or an owner other than the
+// current user, requires privileges; if the process lacks them, only the
+// group and DACL are set.
+//
+// Security descriptors are only set on Windows; elsewhere, they are ignored.
+func ExtractSecurityDescriptors() ExtractOption {
+	return func(x *extractor) { x.winSD = true }
+}
+
+// ExtractAppleDouble returns an ExtractOption that causes Extract to restore
+// the extended attributes held in AppleDouble companion entries (see
+// AppleDoublePrefix) on the files that they belong to, as with ExtractXattrs,
+// instead of extracting them as files, as the tar of macOS does. Companion
+// entries that do not hold valid AppleDouble data are extracted as files.
+//
+// Companion entries are held in memory until their file is extracted, so
+// those larger than 4 MiB, or that would bring the total held past 64 MiB
+// because their files are missing or come late in the archive, are also
+// extracted as files.
+//
+// The attributes of macOS, such as the resource fork and Finder information,
+// can only be restored on macOS.
+func ExtractAppleDouble() ExtractOption {
+	return func(x *extractor) { x.appleDouble = true }
+}
+
+// ExtractLayer returns an ExtractOption that causes Extract to apply the
+// archive as a layer of a Docker or OCI container image on top of the
+// existing contents of the directory, as described for ApplyLayer.
+func ExtractLayer() ExtractOption {
+	return func(x *extractor) { x.layer = true }
+}
+
+// Extract reads the tar archive from r and extracts its contents into the
+// directory dst, which is created if it does not already exist.
+//
+// Extract creates regular files, directories, symbolic links, hard links,
+// FIFOs and device nodes (where supported by the operating system),
+// and sets their permission bits and modification times. The holes of
+// sparse files are left unwritten, so that extracting a sparse disk image
+// only allocates space for its data on file systems that support it.
+// Permissions and times of directories are set after all entries have
+// been extracted, so that creating their contents does not alter them.
+// Global PAX headers are skipped.
+//
+// Extract refuses to create any entry whose Name or Linkname would refer to
+// a location outside of dst, reporting an *os.PathError whose Err is
+// ErrInsecurePath. Entries are also never written through a symbolic link
+// that resolves to a location outside of dst. On Linux, paths are resolved
+// using openat2 with RESOLVE_BENEATH where available, so this holds even if
+// the contents of dst are concurrently modified by another process.
+// Elsewhere, each symbolic link is resolved by Extract itself, which does
+// not protect against such races. On Windows, files are created using
+// extended-length paths, so that their paths may exceed MAX_PATH.
+func Extract(dst string, r io.Reader, opts ...ExtractOption) error {
+	x := &extractor{dst: dst, names: systemNames}
+	for _, opt := range opts {
+		opt(x)
+	}
+	return x.extract(NewReader(r))
+}
+
+// ApplyLayer applies the layer of a Docker or OCI container image read from
+// r to the directory dst, which holds the layers below it, with the same
+// semantics and options as Extract, as well as the following.
+//
+// Whiteouts (see WhiteoutPrefix) delete the files and directories that they
+// name, and opaque whiteouts delete the contents of their directory that
+// are not part of the layer, instead of being extracted. An existing
+// directory that an entry replaces with another type of file is deleted
+// along with its contents. Since the metadata of directories is set once
+// all entries have been extracted, the modification times of directories
+// in the layer are those that it records, regardless of the deletions.
+//
+// ApplyLayer is equivalent to Extract with ExtractLayer.
+func ApplyLayer(dst string, r io.Reader, opts ...ExtractOption) error {
+	return Extract(dst, r, append(opts, ExtractLayer())...)
+}
+
+// extractor holds the state of a single call to Extract.
+type extractor struct {
+	dst string    // Destination directory
+	fs  extractFS // Destination directory as a file system
+
+	noOverwrite bool
+	touch       bool
+	owner       bool
+	xattrs      bool
+	acls        bool
+	layer       bool
+	junctions   bool
+	winAttrs    bool
+	winSD       bool
+	appleDouble bool
+	names       NameResolver
+
+	layerNames      map[string]bool        // Names of the entries of a layer and their parents
+	appleDoubles    map[string]appleDouble // Attributes from AppleDouble companions, by name
+	appleDoubleSize int64                  // Total size of the companions in appleDoubles
+
+	dirs []*Header // Directories whose metadata is set once done
+}
+
+// Limits on the AppleDouble companion entries held in memory by Extract.
+const (
+	maxAppleDoubleSize    = 4 << 20  // Size of a single companion
+	maxAppleDoublePending = 64 << 20 // Total size of the companions held
+)
+
+// appleDouble holds the attributes decoded from an AppleDouble companion
+// entry of size bytes, which they refer to.
+type appleDouble struct {
+	xs   []Xattr
+	size int64
+}
+
+func (x *extractor) extract(tr *Reader) (err error) {
+	if err := os.MkdirAll(x.dst, 0755); err != nil {
+		return err
+	}
+	if x.fs, err = newExtractFS(x.dst); err != nil {
+		return err
+	}
+	defer func() {
+		if err1 := x.fs.Close(); err == nil {
+			err = err1
+		}
+	}()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := x.extractEntry(tr, hdr); err != nil {
+			return err
+		}
+	}
+
+	// Companion entries usually precede their file, which takes their
+	// attributes. Those that follow it apply to the file once extracted.
+	for name, ad := range x.appleDoubles {
+		fi, err := x.fs.Lstat(name)
+		if err != nil || fi.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		x.deleteAppleDouble(name)
+		for _, xa := range ad.xs {
+			if err := x.fs.Setxattr(name, xa.Name, xa.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Set directory metadata in reverse order so that parents are
+	// handled after their children.
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		hdr := x.dirs[i]
+		if err := x.setMetadata(path.Clean(hdr.Name), hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeExisting makes way for a new file at name, unless the existing
+// file is a directory and keepDir is set.
+func (x *extractor) removeExisting(name string, keepDir bool) error {
+	fi, err := x.fs.Lstat(name)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	case keepDir && fi.IsDir():
+		return nil
+	case x.noOverwrite:
+		return &os.PathError{Op: "extract", Path: name, Err: os.ErrExist}
+	case x.layer && fi.IsDir():
+		return x.removeAll(name)
+	}
+	return x.fs.Remove(name)
+}
+
+// removeAll removes name and, if it is a directory, its contents.
+// It returns nil if name does not exist.
+func (x *extractor) removeAll(name string) error {
+	fi, err := x.fs.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		names, err := x.fs.Readdirnames(name)
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			if err := x.removeAll(path.Join(name, n)); err != nil {
+				return err
+			}
+		}
+	}
+	return x.fs.Remove(name)
+}
+
+// whiteout applies the whiteout of a layer for target, which is either a
+// file to remove or, if opaque is set, a directory to make opaque.
+func (x *extractor) whiteout(target string, opaque bool) error {
+	if !opaque {
+		if base := path.Base(target); base == "." || base == ".." {
+			return &os.PathError{Op: "extract", Path: target, Err: ErrInsecurePath}
+		}
+		return x.removeAll(target)
+	}
+	if err := x.fs.MkdirAll(target); err != nil {
+		return err
+	}
+	x.addLayerName(target)
+	return x.removeLower(target)
+}
+
+// removeLower removes the contents of the directory dir that are not
+// part of the layer being applied.
+func (x *extractor) removeLower(dir string) error {
+	names, err := x.fs.Readdirnames(dir)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		name := path.Join(dir, n)
+		if !x.layerNames[name] {
+			if err := x.removeAll(name); err != nil {
+				return err
+			}
+			continue
+		}
+		fi, err := x.fs.Lstat(name)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if err := x.removeLower(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteAppleDouble forgets the attributes of the companion of name, if any.
+func (x *extractor) deleteAppleDouble(name string) {
+	if ad, ok := x.appleDoubles[name]; ok {
+		delete(x.appleDoubles, name)
+		x.appleDoubleSize -= ad.size
+	}
+}
+
+// addLayerName records that name and its parents are part of the layer.
+func (x *extractor) addLayerName(name string) {
+	if x.layerNames == nil {
+		x.layerNames = make(map[string]bool)
+	}
+	for ; name != "." && !x.layerNames[name]; name = path.Dir(name) {
+		x.layerNames[name] = true
+	}
+}
+
+func (x *extractor) extractEntry(tr *Reader, hdr *Header) error {
+	if hdr.Typeflag == TypeXGlobalHeader {
+		return nil
+	}
+	name := path.Clean(hdr.Name)
+	if hdr.hasInsecurePath() || (name == "." && hdr.Typeflag != TypeDir) {
+		return &os.PathError{Op: "extract", Path: hdr.Name, Err: ErrInsecurePath}
+	}
+	if x.layer {
+		if target, opaque, ok := ParseWhiteout(name); ok {
+			return x.whiteout(target, opaque)
+		}
+		x.addLayerName(name)
+	}
+	var r io.Reader = tr
+	if target, ok := ParseAppleDouble(name); ok && x.appleDouble && hdr.Typeflag == TypeReg &&
+		hdr.Size <= maxAppleDoubleSize && x.appleDoubleSize+hdr.Size <= maxAppleDoublePending {
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if xs, err := DecodeAppleDouble(data); err == nil {
+			if x.appleDoubles == nil {
+				x.appleDoubles = make(map[string]appleDouble)
+			}
+			x.deleteAppleDouble(target)
+			x.appleDoubles[target] = appleDouble{xs, int64(len(data))}
+			x.appleDoubleSize += int64(len(data))
+			return nil
+		}
+		r = bytes.NewReader(data) // Not AppleDouble; extract it as a file
+	}
+	if err := x.fs.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	if err := x.removeExisting(name, hdr.Typeflag == TypeDir); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case TypeDir:
+		if err := x.fs.Mkdir(name, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+		x.dirs = append(x.dirs, hdr)
+		return nil
+	case TypeSymlink:
+		if err := x.symlink(hdr.Linkname, name); err != nil {
+			return err
+		}
+		if x.owner {
+			return x.chown(name, hdr)
+		}
+		return nil
+	case TypeLink:
+		return x.fs.Link(path.Clean(hdr.Linkname), name)
+	case TypeChar, TypeBlock, TypeFifo:
+		if err := x.fs.Mknod(name, hdr); err != nil {
+			return err
+		}
+	default:
+		// Regular files, as well as any unknown types, per POSIX.
+		if err := x.writeFile(name, r); err != nil {
+			return err
+		}
+	}
+	return x.setMetadata(name, hdr)
+}
+
+// symlink creates a symbolic link to oldname at newname, or a junction if
+// requested with ExtractJunctions and possible.
+func (x *extractor) symlink(oldname, newname string) error {
+	if fs, ok := x.fs.(junctionFS); ok && x.junctions {
+		if ok, err := fs.Junction(oldname, newname); ok || err != nil {
+			return err
+		}
+	}
+	return x.fs.Symlink(oldname, newname)
+}
+
+// writeFile creates the file name with the content read from r, which is
+// usually the Reader positioned at the entry. The holes of sparse files are
+// skipped with Seek rather than written, so
+// that they take up no space on file systems that support sparse files.
+func (x *extractor) writeFile(name string, r io.Reader) error {
+	f, err := x.fs.Create(name)
+	if err != nil {
+		return err
+	}
+	if tr, ok := r.(*Reader); ok && isSparseReader(tr.curr) {
+		_, err = tr.writeTo(f)
+	} else {
+		_, err = io.Copy(f, r)
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// setMetadata sets the ownership, permission bits, extended attributes,
+// ACLs, Windows security descriptor and attributes, and times of name from
+// hdr, as configured.
+func (x *extractor) setMetadata(name string, hdr *Header) error {
+	// Change the owner first, since doing so may clear the setuid bit.
+	if x.owner {
+		if err := x.chown(name, hdr); err != nil {
+			return err
+		}
+	}
+	const modeMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	if err := x.fs.Chmod(name, hdr.FileInfo().Mode()&modeMask); err != nil {
+		return err
+	}
+	if x.xattrs {
+		xattrs := hdr.ExtendedAttrs()
+		if hdr.SELinuxContext != "" {
+			xattrs = append(xattrs, Xattr{"security.selinux", []byte(hdr.SELinuxContext)})
+		}
+		for _, xa := range xattrs {
+			if err := x.fs.Setxattr(name, xa.Name, xa.Value); err != nil {
+				return err
+			}
+		}
+	}
+	if ad, ok := x.appleDoubles[name]; ok {
+		x.deleteAppleDouble(name)
+		for _, xa := range ad.xs {
+			if err := x.fs.Setxattr(name, xa.Name, xa.Value); err != nil {
+				return err
+			}
+		}
+	}
+	if x.acls {
+		if err := x.setACLs(name, hdr); err != nil {
+			return err
+		}
+	}
+	if x.winSD && len(hdr.WindowsSecurityDescriptor) > 0 {
+		if err := x.fs.SetSecurityDescriptor(name, hdr.WindowsSecurityDescriptor); err != nil {
+			return err
+		}
+	}
+	if x.winAttrs && hdr.WindowsAttributes != 0 {
+		if err := x.fs.SetWindowsAttributes(name, hdr.WindowsAttributes); err != nil {
+			return err
+		}
+	}
+	if x.touch {
+		return nil
+	}
+	mtime, atime := hdr.ModTime, hdr.AccessTime
+	if mtime.IsZero() {
+		mtime = time.Unix(0, 0)
+	}
+	if atime.IsZero() {
+		atime = mtime
+	}
+	return x.fs.Chtimes(name, atime, mtime)
+}
+
+// chown sets the owner and group of name (without following symbolic links).
+func (x *extractor) chown(name string, hdr *Header) error {
+	uid, gid := hdr.Uid, hdr.Gid
+	if id, ok := x.lookupID(hdr.Uname, false); ok {
+		uid = id
+	}
+	if id, ok := x.lookupID(hdr.Gname, true); ok {
+		gid = id
+	}
+	err := x.fs.Lchown(name, uid, gid)
+	if err != nil && os.Geteuid() != 0 {
+		return nil // Not privileged; keep the current owner
+	}
+	return err
+}
+
+// lookupID returns the ID of the named user or group, if it exists.
+func (x *extractor) lookupID(name string, group bool) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+	if group {
+		return x.names.GroupID(name)
+	}
+	return x.names.UserID(name)
+}
+
+// setACLs applies the access and default ACLs of hdr to name.
+func (x *extractor) setACLs(name string, hdr *Header) error {
+	for _, key := range []string{paxSchilyACLAccess, paxSchilyACLDefault} {
+		acl := hdr.AccessACL
+		if key == paxSchilyACLDefault {
+			acl = hdr.DefaultACL
+		}
+		if len(acl) == 0 {
+			continue
+		}
+		acl = append([]ACLEntry(nil), acl...)
+		for i, e := range acl {
+			if (e.Tag == ACLUser || e.Tag == ACLGroup) && e.ID < 0 {
+				id, ok := x.lookupID(e.Name, e.Tag == ACLGroup)
+				if !ok {
+					return fmt.Errorf("archive/tar: unknown name %q in ACL of %s", e.Name, hdr.Name)
+				}
+				acl[i].ID = id
+			}
+		}
+		attr := "system.posix_acl_access"
+		if key == paxSchilyACLDefault {
+			attr = "system.posix_acl_default"
+		}
+		if err := x.fs.Setxattr(name, attr, encodePOSIXACLXattr(acl)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
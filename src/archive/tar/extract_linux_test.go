@@ -0,0 +1,170 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExtractMetadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Check whether the file system supports user extended attributes.
+	probe := filepath.Join(dir, "probe")
+	if err := ioutil.WriteFile(probe, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(probe, "user.probe", []byte("1"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	data := makeArchive(t, testEntry{hdr: Header{
+		Name:     "file",
+		Typeflag: TypeReg,
+		Mode:     0644,
+		Uid:      1234,
+		Gid:      5678,
+		PAXRecords: map[string]string{
+			"SCHILY.xattr.user.key": "value",
+		},
+	}, body: "data"})
+
+	// Without options, no metadata beyond the mode is restored.
+	if err := Extract(filepath.Join(dir, "plain"), bytes.NewReader(data)); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	buf := make([]byte, 64)
+	if _, err := syscall.Getxattr(filepath.Join(dir, "plain", "file"), "user.key", buf); err == nil {
+		t.Errorf("extended attribute restored without ExtractXattrs")
+	}
+
+	if err := Extract(filepath.Join(dir, "full"), bytes.NewReader(data), ExtractXattrs(), ExtractOwner()); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	p := filepath.Join(dir, "full", "file")
+	n, err := syscall.Getxattr(p, "user.key", buf)
+	if err != nil || string(buf[:n]) != "value" {
+		t.Errorf("Getxattr() = %q, %v; want %q", buf[:n], err, "value")
+	}
+	if os.Geteuid() == 0 {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != 1234 || st.Gid != 5678 {
+			t.Errorf("owner: got %d:%d, want 1234:5678", st.Uid, st.Gid)
+		}
+	}
+}
+
+func TestExtractSparseHoles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExtractSparseHoles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The archive holds a 60 GB file with 3 KiB of data.
+	const file = "testdata/gnu-sparse-big.tar"
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Extract(dir, bytes.NewReader(data)); err != nil {
+		if pe, ok := err.(*os.PathError); ok && (pe.Err == syscall.EFBIG || pe.Err == syscall.ENOSPC) {
+			t.Skipf("file system does not support large files: %v", err)
+		}
+		t.Fatalf("Extract(%s) error: %v", file, err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(filepath.Join(dir, "gnu-sparse"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Size != 60000000000 {
+		t.Errorf("size of extracted file = %d, want %d", st.Size, int64(60000000000))
+	}
+	if used := st.Blocks * 512; used > 1<<20 {
+		t.Errorf("extracted file uses %d bytes of storage, want holes to be left unallocated", used)
+	}
+}
+
+func TestAppleDoubleXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestAppleDoubleXattrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(src, "user.key", []byte("value"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	// The attribute is moved to a companion entry preceding the file.
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	if err := tw.AddFile("file", src, AddAppleDouble()); err != nil {
+		t.Fatalf("AddFile() error: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewReader(bytes.NewReader(b.Bytes()))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if xs := hdr.ExtendedAttrs(); len(xs) > 0 {
+			t.Errorf("entry %s has extended attributes %q, want none", hdr.Name, xs)
+		}
+		if hdr.Name == "._file" {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			xs, err := DecodeAppleDouble(data)
+			if err != nil || len(xs) != 1 || xs[0].Name != "user.key" || string(xs[0].Value) != "value" {
+				t.Errorf("DecodeAppleDouble() of companion = (%q, %v), want user.key=value", xs, err)
+			}
+		}
+	}
+	if want := []string{"._file", "file"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("entries = %q, want %q", names, want)
+	}
+
+	// Extracting the archive restores the attribute on the file.
+	dst := filepath.Join(dir, "dst")
+	if err := Extract(dst, bytes.NewReader(b.Bytes()), ExtractAppleDouble()); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := syscall.Getxattr(filepath.Join(dst, "file"), "user.key", buf)
+	if err != nil || string(buf[:n]) != "value" {
+		t.Errorf("Getxattr() = %q, %v; want %q", buf[:n], err, "value")
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "._file")); !os.IsNotExist(err) {
+		t.Errorf("companion entry extracted as a file: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!dragonfly,!freebsd,!openbsd,!netbsd,!solaris
+
+package tar
+
+import (
+	"errors"
+	"os"
+)
+
+// mknod creates the FIFO or device node described by hdr at p.
+func mknod(p string, hdr *Header) error {
+	return &os.PathError{Op: "mknod", Path: p, Err: errors.New("not supported on this platform")}
+}
@@ -0,0 +1,318 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	hdr  Header
+	body string
+}
+
+// makeArchive returns an archive containing the given entries.
+func makeArchive(t *testing.T, entries ...testEntry) []byte {
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	for _, e := range entries {
+		hdr := e.hdr
+		if hdr.Size == 0 && !isHeaderOnlyType(hdr.Typeflag) {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("WriteHeader(%q) error: %v", hdr.Name, err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatalf("Write(%q) error: %v", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	return b.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestExtract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Unix(1500000000, 0)
+	data := makeArchive(t,
+		testEntry{hdr: Header{Name: "dir/", Typeflag: TypeDir, Mode: 0750, ModTime: mtime}},
+		testEntry{hdr: Header{Name: "dir/file.txt", Typeflag: TypeReg, Mode: 0640, ModTime: mtime}, body: "hello"},
+		testEntry{hdr: Header{Name: "implicit/file.txt", Typeflag: TypeReg, Mode: 0600, ModTime: mtime}, body: "world"},
+		testEntry{hdr: Header{Name: "symlink", Typeflag: TypeSymlink, Linkname: "dir/file.txt"}},
+		testEntry{hdr: Header{Name: "hardlink", Typeflag: TypeLink, Linkname: "dir/file.txt"}},
+		testEntry{hdr: Header{Name: "fifo", Typeflag: TypeFifo, Mode: 0600}},
+	)
+	if err := Extract(dir, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() || fi.Mode().Perm() != 0750 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("dir: got mode %v, mtime %v; want %v, %v", fi.Mode(), fi.ModTime(), os.ModeDir|0750, mtime)
+	}
+	for _, v := range []struct{ name, want string }{
+		{"dir/file.txt", "hello"},
+		{"implicit/file.txt", "world"},
+		{"symlink", "hello"},
+		{"hardlink", "hello"},
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, v.name))
+		if err != nil || string(got) != v.want {
+			t.Errorf("ReadFile(%q) = %q, %v; want %q", v.name, got, err, v.want)
+		}
+	}
+	fi, err = os.Stat(filepath.Join(dir, "dir/file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("file: got mode %v, mtime %v; want %v, %v", fi.Mode(), fi.ModTime(), os.FileMode(0640), mtime)
+	}
+	if fi, err := os.Lstat(filepath.Join(dir, "symlink")); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink: got %v, %v; want symbolic link", fi, err)
+	}
+	if fi, err := os.Lstat(filepath.Join(dir, "fifo")); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("fifo: got %v, %v; want named pipe", fi, err)
+	}
+
+	// Extracting again replaces existing files, unless disallowed.
+	if err := Extract(dir, bytes.NewReader(data)); err != nil {
+		t.Errorf("Extract() again error: %v", err)
+	}
+	err = Extract(dir, bytes.NewReader(data), ExtractNoOverwrite())
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != os.ErrExist {
+		t.Errorf("Extract(ExtractNoOverwrite()) error: got %v, want %v", err, os.ErrExist)
+	}
+}
+
+func TestExtractSparse(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestExtractSparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const file = "testdata/sparse-formats.tar"
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Extract(dir, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Extract(%s) error: %v", file, err)
+	}
+	tr := NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		want, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("ReadAll() error: %v", err)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(dir, hdr.Name))
+		if err != nil {
+			t.Errorf("ReadFile(%s) error: %v", hdr.Name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("extracted %s = %q, want %q", hdr.Name, got, want)
+		}
+	}
+}
+
+func TestApplyLayer(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestApplyLayer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mtime := time.Unix(1500000000, 0)
+	lower := makeArchive(t,
+		testEntry{hdr: Header{Name: "a/old", Typeflag: TypeReg}, body: "old"},
+		testEntry{hdr: Header{Name: "a/keep", Typeflag: TypeReg}, body: "keep"},
+		testEntry{hdr: Header{Name: "b/x", Typeflag: TypeReg}, body: "x"},
+		testEntry{hdr: Header{Name: "b/sub/y", Typeflag: TypeReg}, body: "y"},
+		testEntry{hdr: Header{Name: "c/z", Typeflag: TypeReg}, body: "z"},
+	)
+	layer := makeArchive(t,
+		testEntry{hdr: Header{Name: "a/", Typeflag: TypeDir, Mode: 0755, ModTime: mtime}},
+		testEntry{hdr: Header{Name: "a/.wh.old", Typeflag: TypeReg}},
+		testEntry{hdr: Header{Name: "b/sub/new", Typeflag: TypeReg}, body: "new"},
+		testEntry{hdr: Header{Name: "b/.wh..wh..opq", Typeflag: TypeReg}},
+		testEntry{hdr: Header{Name: "c", Typeflag: TypeReg}, body: "file"},
+		testEntry{hdr: Header{Name: "e/.wh.missing", Typeflag: TypeReg}},
+	)
+	if err := Extract(dir, bytes.NewReader(lower)); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	if err := ApplyLayer(dir, bytes.NewReader(layer)); err != nil {
+		t.Fatalf("ApplyLayer() error: %v", err)
+	}
+
+	var got []string
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if want := []string{"a/keep", "b/sub/new", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("files after ApplyLayer() = %q, want %q", got, want)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "e")); !os.IsNotExist(err) {
+		t.Errorf("Lstat(e) error: %v, want not exist", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "a")); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("Stat(a) = %v, %v; want mtime %v", fi, err, mtime)
+	}
+
+	bad := makeArchive(t, testEntry{hdr: Header{Name: "a/.wh..", Typeflag: TypeReg}})
+	if err := ApplyLayer(dir, bytes.NewReader(bad)); err == nil {
+		t.Errorf("ApplyLayer() of whiteout for parent succeeded")
+	}
+}
+
+func TestExtractInsecure(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	vectors := [][]testEntry{{
+		{hdr: Header{Name: "../escape", Typeflag: TypeReg}},
+	}, {
+		{hdr: Header{Name: "/abs", Typeflag: TypeReg}},
+	}, {
+		{hdr: Header{Name: "link", Typeflag: TypeSymlink, Linkname: "/"}},
+	}, {
+		{hdr: Header{Name: "link", Typeflag: TypeLink, Linkname: "../outside"}},
+	}, {
+		// Each link is lexically local, but together they resolve to
+		// the parent of the destination directory.
+		{hdr: Header{Name: "self", Typeflag: TypeSymlink, Linkname: "."}},
+		{hdr: Header{Name: "parent", Typeflag: TypeSymlink, Linkname: "self/.."}},
+		{hdr: Header{Name: "parent/escape", Typeflag: TypeReg}, body: "evil"},
+	}}
+
+	for i, v := range vectors {
+		dir, err := ioutil.TempDir("", "TestExtractInsecure")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(dir, "dst")
+		err = Extract(dst, bytes.NewReader(makeArchive(t, v...)))
+		if pe, ok := err.(*os.PathError); !ok || pe.Err != ErrInsecurePath {
+			t.Errorf("test %d, Extract() error: got %v, want %v", i, err, ErrInsecurePath)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "escape")); err == nil {
+			t.Errorf("test %d, file was created outside of destination", i)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestExtractFSEscape(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	tmp, err := ioutil.TempDir("", "tar-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "root")
+	outside := filepath.Join(tmp, "outside")
+	for _, d := range []string{root, outside, filepath.Join(root, "dir")} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Links planted in the destination before extraction begins.
+	links := map[string]string{
+		"abs":     outside,
+		"up":      "../outside",
+		"dir/up":  "../../outside",
+		"dir/in":  "..",
+		"chain":   "dir/up",
+		"dirlink": "dir",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	fsys, err := newExtractFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	for _, fs := range []extractFS{fsys, pathFS{root}} {
+		vectors := []struct {
+			name string
+			ok   bool
+		}{
+			{"abs/file", false},
+			{"up/file", false},
+			{"dir/up/file", false},
+			{"chain/file", false},
+			{"dir/in/file", true},
+			{"dirlink/file", true},
+			{"file", true},
+		}
+		for i, v := range vectors {
+			f, err := fs.Create(v.name)
+			if f != nil {
+				f.Close()
+			}
+			if got := err == nil; got != v.ok {
+				t.Errorf("%T, test %d, Create(%q) = %v, want ok %v", fs, i, v.name, err, v.ok)
+			}
+			if err == nil {
+				fs.Remove(v.name)
+			}
+		}
+		if err := fs.MkdirAll("up/newdir"); err == nil {
+			t.Errorf("%T, MkdirAll through escaping link succeeded", fs)
+		}
+		if _, err := fs.Readdirnames("up"); err == nil {
+			t.Errorf("%T, Readdirnames through escaping link succeeded", fs)
+		}
+	}
+	if fis, err := ioutil.ReadDir(outside); err != nil || len(fis) != 0 {
+		t.Errorf("files created outside of destination: %v, %v", fis, err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux darwin dragonfly freebsd openbsd netbsd solaris
+
+package tar
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// mknod creates the FIFO or device node described by hdr at p.
+func mknod(p string, hdr *Header) error {
+	dev := mkdev(hdr.Devmajor, hdr.Devminor)
+	if err := syscall.Mknod(p, mknodMode(hdr), int(dev)); err != nil {
+		return &os.PathError{Op: "mknod", Path: p, Err: err}
+	}
+	return nil
+}
+
+// mknodMode returns the mode argument to mknod for hdr.
+func mknodMode(hdr *Header) uint32 {
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case TypeChar:
+		mode |= syscall.S_IFCHR
+	case TypeBlock:
+		mode |= syscall.S_IFBLK
+	case TypeFifo:
+		mode |= syscall.S_IFIFO
+	}
+	return mode
+}
+
+// mkdev returns the device number for the major and minor numbers.
+// It is the inverse of the decoding performed by statUnix.
+func mkdev(major, minor int64) uint64 {
+	ma, mi := uint64(major), uint64(minor)
+	switch runtime.GOOS {
+	case "linux":
+		// Copied from golang.org/x/sys/unix/dev_linux.go.
+		dev := (ma & 0x00000fff) << 8
+		dev |= (ma & 0xfffff000) << 32
+		dev |= (mi & 0x000000ff) << 0
+		dev |= (mi & 0xffffff00) << 12
+		return dev
+	case "darwin":
+		return (ma << 24) | mi
+	case "dragonfly", "freebsd":
+		return (ma << 8) | mi
+	case "netbsd":
+		dev := (ma << 8) & 0x000fff00
+		dev |= (mi << 12) & 0xfff00000
+		dev |= (mi << 0) & 0x000000ff
+		return dev
+	case "openbsd":
+		dev := (ma << 8) & 0x0000ff00
+		dev |= (mi << 8) & 0xffff0000
+		dev |= (mi << 0) & 0x000000ff
+		return dev
+	default:
+		// TODO: Implement solaris (see https://golang.org/issue/8106)
+		return 0
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestExtractWindows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExtractWindows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	long := strings.Repeat("d", 200) + "/" + strings.Repeat("f", 200)
+	archive := makeArchive(t,
+		testEntry{hdr: Header{Name: long, Typeflag: TypeReg, Mode: 0644}, body: "long"},
+		testEntry{hdr: Header{Name: "dir/", Typeflag: TypeDir, Mode: 0755}},
+		testEntry{hdr: Header{Name: "dir/file", Typeflag: TypeReg, Mode: 0644}, body: "data"},
+		testEntry{hdr: Header{Name: "junction", Typeflag: TypeSymlink, Linkname: "dir"}},
+		testEntry{hdr: Header{Name: "hidden", Typeflag: TypeReg, Mode: 0644, WindowsAttributes: 0x2 | 0x20}},
+	)
+	if err := Extract(dir, bytes.NewReader(archive), ExtractJunctions(), ExtractWindowsAttributes()); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+
+	p, err := longPath(filepath.Join(dir, filepath.FromSlash(long)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(p); err != nil || string(data) != "long" {
+		t.Errorf("ReadFile(%q) = (%q, %v), want %q", p, data, err, "long")
+	}
+
+	// The junction is followed like a symbolic link, and resolved by
+	// secureJoin within the directory.
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "junction", "file")); err != nil || string(data) != "data" {
+		t.Errorf("ReadFile(junction/file) = (%q, %v), want %q", data, err, "data")
+	}
+	if _, err := secureJoin(dir, "junction/file"); err != nil {
+		t.Errorf("secureJoin(junction/file) error: %v", err)
+	}
+
+	pp, err := syscall.UTF16PtrFromString(filepath.Join(dir, "hidden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := syscall.GetFileAttributes(pp)
+	if err != nil {
+		t.Fatalf("GetFileAttributes() error: %v", err)
+	}
+	if attrs&0x2 == 0 {
+		t.Errorf("attributes of hidden = %#x, want hidden (0x2) set", attrs)
+	}
+}
+
+func TestLocalLink(t *testing.T) {
+	vectors := []struct {
+		root, target string
+		want         string
+		ok           bool
+	}{
+		{`\\?\C:\dst`, `C:\dst\dir`, "dir", true},
+		{`\\?\C:\dst`, `c:\DST\dir\sub`, "dir/sub", true},
+		{`\\?\C:\dst`, `C:\dst`, "", true},
+		{`\\?\C:\`, `C:\dir`, "dir", true},
+		{`\\?\C:\dst`, `C:\dst2\dir`, "", false},
+		{`\\?\C:\dst`, `C:\other`, "", false},
+		{`\\?\C:\dst`, `dir`, "", false},
+	}
+	for i, v := range vectors {
+		got, ok := localLink(v.root, v.target)
+		if got != v.want || ok != v.ok {
+			t.Errorf("test %d, localLink(%q, %q) = (%q, %v), want (%q, %v)", i, v.root, v.target, got, ok, v.want, v.ok)
+		}
+	}
+}
+
+func TestExtractSecurityDescriptors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExtractSecurityDescriptors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := FileHeader(src)
+	if err != nil {
+		t.Fatalf("FileHeader() error: %v", err)
+	}
+	info, err := securityInformation(hdr.WindowsSecurityDescriptor)
+	if err != nil {
+		t.Fatalf("security descriptor of FileHeader(): %v", err)
+	}
+	if want := uint32(ownerSecurityInformation | groupSecurityInformation | daclSecurityInformation); info&want != want {
+		t.Errorf("security descriptor of FileHeader() holds %#x, want at least %#x", info, want)
+	}
+
+	hdr.Name = "file"
+	archive := makeArchive(t, testEntry{hdr: *hdr, body: "data"})
+	dst := filepath.Join(dir, "dst")
+	if err := Extract(dst, bytes.NewReader(archive), ExtractSecurityDescriptors()); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	sd, err := fileSecurityDescriptor(filepath.Join(dst, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := securityInformation(sd); err != nil || info&daclSecurityInformation == 0 {
+		t.Errorf("security descriptor of extracted file holds %#x (%v), want a DACL", info, err)
+	}
+}
+
+func TestSecurityInformation(t *testing.T) {
+	sd := func(control uint16, owner, group uint32) []byte {
+		b := make([]byte, 20)
+		b[0] = 1
+		b[2], b[3] = byte(control), byte(control>>8)
+		b[4], b[8] = byte(owner), byte(group)
+		return b
+	}
+	vectors := []struct {
+		sd   []byte
+		want uint32
+		ok   bool
+	}{
+		{sd(seSelfRelative|seDACLPresent, 20, 40), ownerSecurityInformation | groupSecurityInformation | daclSecurityInformation, true},
+		{sd(seSelfRelative|seDACLPresent|seSACLPresent, 0, 0), daclSecurityInformation | saclSecurityInformation, true},
+		{sd(seSelfRelative, 20, 0), ownerSecurityInformation, true},
+		{sd(seDACLPresent, 20, 40), 0, false}, // Not self-relative
+		{sd(seSelfRelative, 20, 40)[:19], 0, false},
+		{nil, 0, false},
+	}
+	for i, v := range vectors {
+		got, err := securityInformation(v.sd)
+		if got != v.want || (err == nil) != v.ok {
+			t.Errorf("test %d, securityInformation() = (%#x, %v), want (%#x, ok=%v)", i, got, err, v.want, v.ok)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// extractFS creates and modifies files beneath the directory that an archive
+// is extracted into. Names are slash-separated paths relative to that
+// directory, which have been cleaned and checked with isLocalName.
+//
+// Symbolic links are followed in all but the last element of a name,
+// but an implementation must never follow a link that leads outside
+// of the directory; it reports ErrInsecurePath instead.
+type extractFS interface {
+	MkdirAll(name string) error
+	Lstat(name string) (os.FileInfo, error)
+	Readdirnames(name string) ([]string, error)
+	Remove(name string) error
+	Mkdir(name string, perm os.FileMode) error
+	Create(name string) (*os.File, error)
+	Symlink(oldname, newname string) error
+	Link(oldname, newname string) error
+	Mknod(name string, hdr *Header) error
+	Lchown(name string, uid, gid int) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Setxattr(name, attr string, data []byte) error
+	SetWindowsAttributes(name string, attrs uint32) error
+	SetSecurityDescriptor(name string, sd []byte) error
+	Close() error
+}
+
+// A junctionFS is an extractFS that can create directory junctions.
+// Junction reports false, without creating anything, if oldname does not
+// resolve to a directory beneath the root that a junction can refer to.
+type junctionFS interface {
+	Junction(oldname, newname string) (bool, error)
+}
+
+// These functions are set by the Windows implementation.
+var (
+	// sysSetFileAttributes sets the Windows file attributes of p.
+	sysSetFileAttributes func(p string, attrs uint32) error
+
+	// sysSetSecurityDescriptor sets the Windows security descriptor of p.
+	sysSetSecurityDescriptor func(p string, sd []byte) error
+
+	// sysLocalLink returns the slash-separated path relative to root of
+	// the absolute target of a junction, if it is beneath root.
+	sysLocalLink func(root, target string) (string, bool)
+)
+
+// pathFS is an extractFS that resolves symbolic links itself before
+// operating on host paths. It is not safe against concurrent modification
+// of the directory tree by other processes.
+type pathFS struct {
+	root string
+}
+
+// resolve returns the host path for name, where all but the last element
+// of name have been resolved by secureJoin.
+func (fs pathFS) resolve(name string) (string, error) {
+	dir, file := path.Split(name)
+	p, err := secureJoin(fs.root, dir)
+	if err != nil {
+		return "", &os.PathError{Op: "extract", Path: name, Err: err}
+	}
+	return filepath.Join(p, file), nil
+}
+
+func (fs pathFS) MkdirAll(name string) error {
+	p, err := secureJoin(fs.root, name)
+	if err != nil {
+		return &os.PathError{Op: "extract", Path: name, Err: err}
+	}
+	return os.MkdirAll(p, 0755)
+}
+
+func (fs pathFS) Lstat(name string) (os.FileInfo, error) {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(p)
+}
+
+func (fs pathFS) Readdirnames(name string) ([]string, error) {
+	p, err := secureJoin(fs.root, name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+func (fs pathFS) Remove(name string) error {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (fs pathFS) Mkdir(name string, perm os.FileMode) error {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(p, perm)
+}
+
+func (fs pathFS) Create(name string) (*os.File, error) {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+func (fs pathFS) Symlink(oldname, newname string) error {
+	p, err := fs.resolve(newname)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(oldname, p)
+}
+
+func (fs pathFS) Link(oldname, newname string) error {
+	oldp, err := fs.resolve(oldname)
+	if err != nil {
+		return err
+	}
+	newp, err := fs.resolve(newname)
+	if err != nil {
+		return err
+	}
+	return os.Link(oldp, newp)
+}
+
+func (fs pathFS) Mknod(name string, hdr *Header) error {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return mknod(p, hdr)
+}
+
+func (fs pathFS) Lchown(name string, uid, gid int) error {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Lchown(p, uid, gid)
+}
+
+func (fs pathFS) Chmod(name string, mode os.FileMode) error {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(p, mode)
+}
+
+func (fs pathFS) Chtimes(name string, atime, mtime time.Time) error {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, atime, mtime)
+}
+
+func (fs pathFS) Setxattr(name, attr string, data []byte) error {
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return setXattr(p, attr, data)
+}
+
+func (fs pathFS) SetWindowsAttributes(name string, attrs uint32) error {
+	if sysSetFileAttributes == nil {
+		return nil // Not supported; ignored
+	}
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return sysSetFileAttributes(p, attrs)
+}
+
+func (fs pathFS) SetSecurityDescriptor(name string, sd []byte) error {
+	if sysSetSecurityDescriptor == nil {
+		return nil // Not supported; ignored
+	}
+	p, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	return sysSetSecurityDescriptor(p, sd)
+}
+
+func (fs pathFS) Close() error { return nil }
+
+// maxSymlinks limits the number of symbolic links followed by secureJoin.
+const maxSymlinks = 255
+
+var errTooManyLinks = errors.New("too many levels of symbolic links")
+
+// secureJoin joins the slash-separated path name to root, resolving any
+// symbolic links in name such that the result is beneath root.
+// It reports ErrInsecurePath if name or any link it contains refers
+// to a location outside of root.
+func secureJoin(root, name string) (string, error) {
+	var resolved []string // Path elements beneath root
+	pending := strings.Split(name, "/")
+	for links := 0; len(pending) > 0; {
+		elem := pending[0]
+		pending = pending[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", ErrInsecurePath
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		p := filepath.Join(root, filepath.Join(resolved...), elem)
+		fi, err := os.Lstat(p)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = append(resolved, elem)
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", errTooManyLinks
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if sysLocalLink != nil {
+			if rel, ok := sysLocalLink(root, target); ok {
+				resolved = nil
+				pending = append(strings.Split(rel, "/"), pending...)
+				continue
+			}
+		}
+		target = filepath.ToSlash(target)
+		if path.IsAbs(target) || filepath.VolumeName(target) != "" {
+			return "", ErrInsecurePath
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return filepath.Join(root, filepath.Join(resolved...)), nil
+}
@@ -0,0 +1,365 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Constants for openat2 and related calls, which package syscall lacks.
+const (
+	resolveNoMagiclinks = 0x02 // RESOLVE_NO_MAGICLINKS
+	resolveBeneath      = 0x08 // RESOLVE_BENEATH
+
+	oPath             = 0x200000 // O_PATH
+	atSymlinkNofollow = 0x100    // AT_SYMLINK_NOFOLLOW
+	atRemovedir       = 0x200    // AT_REMOVEDIR
+)
+
+// sysOpenat2 is the number of the openat2 system call (added in Linux 5.6),
+// which is the same on all architectures except for the MIPS ABI offsets.
+var sysOpenat2 = func() uintptr {
+	switch runtime.GOARCH {
+	case "mips", "mipsle":
+		return 4437
+	case "mips64", "mips64le":
+		return 5437
+	default:
+		return 437
+	}
+}()
+
+// openHow is struct open_how from <linux/openat2.h>.
+type openHow struct {
+	flags   uint64
+	mode    uint64
+	resolve uint64
+}
+
+func openat2(dirfd int, name string, how *openHow) (int, error) {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return -1, err
+	}
+	for {
+		fd, _, errno := syscall.Syscall6(sysOpenat2, uintptr(dirfd), uintptr(unsafe.Pointer(p)),
+			uintptr(unsafe.Pointer(how)), unsafe.Sizeof(*how), 0, 0)
+		switch errno {
+		case 0:
+			return int(fd), nil
+		case syscall.EINTR, syscall.EAGAIN:
+			continue // EAGAIN indicates a concurrent rename; try again
+		default:
+			return -1, errno
+		}
+	}
+}
+
+func unlinkat(dirfd int, name string, flags int) error {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_UNLINKAT, uintptr(dirfd), uintptr(unsafe.Pointer(p)), uintptr(flags))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func symlinkat(oldname string, newdirfd int, newname string) error {
+	p1, err := syscall.BytePtrFromString(oldname)
+	if err != nil {
+		return err
+	}
+	p2, err := syscall.BytePtrFromString(newname)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_SYMLINKAT, uintptr(unsafe.Pointer(p1)), uintptr(newdirfd), uintptr(unsafe.Pointer(p2)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func linkat(olddirfd int, oldname string, newdirfd int, newname string) error {
+	p1, err := syscall.BytePtrFromString(oldname)
+	if err != nil {
+		return err
+	}
+	p2, err := syscall.BytePtrFromString(newname)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_LINKAT, uintptr(olddirfd), uintptr(unsafe.Pointer(p1)),
+		uintptr(newdirfd), uintptr(unsafe.Pointer(p2)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func utimensat(dirfd int, name string, ts *[2]syscall.Timespec, flags int) error {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(dirfd), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(ts)), uintptr(flags), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// newExtractFS returns an extractFS for the directory root.
+// It uses openat2 if the kernel supports it, and pathFS otherwise.
+func newExtractFS(root string) (extractFS, error) {
+	f, err := os.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	fs := &beneathFS{root: f}
+	fd, err := fs.openDir(".")
+	switch err {
+	case nil:
+		syscall.Close(fd)
+	case syscall.ENOSYS, syscall.EPERM:
+		// The kernel predates openat2, or a seccomp filter disallows it.
+		f.Close()
+		return pathFS{root}, nil
+	default:
+		f.Close()
+		return nil, &os.PathError{Op: "openat2", Path: root, Err: err}
+	}
+	if _, err := os.Stat("/proc/self/fd"); err == nil {
+		fs.proc = true
+	}
+	return fs, nil
+}
+
+// beneathFS is an extractFS that resolves names relative to a descriptor for
+// the root directory using openat2 with RESOLVE_BENEATH, so that the kernel
+// guarantees that resolution never escapes the root, even when racing with
+// other processes that modify the tree.
+type beneathFS struct {
+	root *os.File
+	proc bool // Whether /proc/self/fd is available
+}
+
+// openDir opens the directory name beneath the root as an O_PATH descriptor.
+func (fs *beneathFS) openDir(name string) (int, error) {
+	how := openHow{
+		flags:   oPath | syscall.O_DIRECTORY | syscall.O_CLOEXEC,
+		resolve: resolveBeneath | resolveNoMagiclinks,
+	}
+	fd, err := openat2(int(fs.root.Fd()), name, &how)
+	if err == syscall.EXDEV {
+		return -1, ErrInsecurePath
+	}
+	return fd, err
+}
+
+// at calls fn with a descriptor for the parent directory of name
+// and the last element of name.
+func (fs *beneathFS) at(op, name string, fn func(dirfd int, base string) error) error {
+	dir, base := path.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	dirfd, err := fs.openDir(dir)
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	defer syscall.Close(dirfd)
+	if err := fn(dirfd, base); err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			return pe
+		}
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// withFile opens base in dirfd without following a final symbolic link and
+// calls fn with a path in /proc that refers to exactly that file.
+// If /proc is not available, withFile fails with ENOTSUP rather than
+// calling fn with a path that another process could replace with a link.
+func (fs *beneathFS) withFile(dirfd int, base string, fn func(name string) error) error {
+	if !fs.proc {
+		return syscall.ENOTSUP
+	}
+	fd, err := syscall.Openat(dirfd, base, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	return fn("/proc/self/fd/" + strconv.Itoa(fd))
+}
+
+func (fs *beneathFS) MkdirAll(name string) error {
+	fd, err := fs.openDir(name)
+	if err == nil {
+		syscall.Close(fd)
+		return nil
+	}
+	if err != syscall.ENOENT || name == "." {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if err := fs.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	err = fs.Mkdir(name, 0755)
+	if os.IsExist(err) {
+		return nil // Lost a race with another process
+	}
+	return err
+}
+
+func (fs *beneathFS) Lstat(name string) (fi os.FileInfo, err error) {
+	err = fs.at("lstat", name, func(dirfd int, base string) error {
+		fd, err := syscall.Openat(dirfd, base, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		f := os.NewFile(uintptr(fd), name)
+		defer f.Close()
+		fi, err = f.Stat()
+		return err
+	})
+	return fi, err
+}
+
+func (fs *beneathFS) Readdirnames(name string) ([]string, error) {
+	how := openHow{
+		flags:   syscall.O_RDONLY | syscall.O_DIRECTORY | syscall.O_CLOEXEC,
+		resolve: resolveBeneath | resolveNoMagiclinks,
+	}
+	fd, err := openat2(int(fs.root.Fd()), name, &how)
+	if err == syscall.EXDEV {
+		err = ErrInsecurePath
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+func (fs *beneathFS) Remove(name string) error {
+	return fs.at("remove", name, func(dirfd int, base string) error {
+		err := unlinkat(dirfd, base, 0)
+		if err == syscall.EISDIR {
+			err = unlinkat(dirfd, base, atRemovedir)
+		}
+		return err
+	})
+}
+
+func (fs *beneathFS) Mkdir(name string, perm os.FileMode) error {
+	return fs.at("mkdir", name, func(dirfd int, base string) error {
+		return syscall.Mkdirat(dirfd, base, uint32(perm.Perm()))
+	})
+}
+
+func (fs *beneathFS) Create(name string) (f *os.File, err error) {
+	err = fs.at("open", name, func(dirfd int, base string) error {
+		const flags = syscall.O_WRONLY | syscall.O_CREAT | syscall.O_EXCL | syscall.O_NOFOLLOW | syscall.O_CLOEXEC
+		fd, err := syscall.Openat(dirfd, base, flags, 0600)
+		if err != nil {
+			return err
+		}
+		f = os.NewFile(uintptr(fd), name)
+		return nil
+	})
+	return f, err
+}
+
+func (fs *beneathFS) Symlink(oldname, newname string) error {
+	return fs.at("symlink", newname, func(dirfd int, base string) error {
+		return symlinkat(oldname, dirfd, base)
+	})
+}
+
+func (fs *beneathFS) Link(oldname, newname string) error {
+	return fs.at("link", oldname, func(olddirfd int, oldbase string) error {
+		return fs.at("link", newname, func(newdirfd int, newbase string) error {
+			return linkat(olddirfd, oldbase, newdirfd, newbase)
+		})
+	})
+}
+
+func (fs *beneathFS) Mknod(name string, hdr *Header) error {
+	return fs.at("mknod", name, func(dirfd int, base string) error {
+		return syscall.Mknodat(dirfd, base, mknodMode(hdr), int(mkdev(hdr.Devmajor, hdr.Devminor)))
+	})
+}
+
+func (fs *beneathFS) Lchown(name string, uid, gid int) error {
+	return fs.at("lchown", name, func(dirfd int, base string) error {
+		return syscall.Fchownat(dirfd, base, uid, gid, atSymlinkNofollow)
+	})
+}
+
+func (fs *beneathFS) Chmod(name string, mode os.FileMode) error {
+	return fs.at("chmod", name, func(dirfd int, base string) error {
+		return fs.withFile(dirfd, base, func(name string) error {
+			return syscall.Chmod(name, syscallMode(mode))
+		})
+	})
+}
+
+func (fs *beneathFS) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.at("chtimes", name, func(dirfd int, base string) error {
+		ts := [2]syscall.Timespec{
+			syscall.NsecToTimespec(atime.UnixNano()),
+			syscall.NsecToTimespec(mtime.UnixNano()),
+		}
+		return utimensat(dirfd, base, &ts, atSymlinkNofollow)
+	})
+}
+
+func (fs *beneathFS) Setxattr(name, attr string, data []byte) error {
+	return fs.at("setxattr", name, func(dirfd int, base string) error {
+		return fs.withFile(dirfd, base, func(name string) error {
+			return syscall.Setxattr(name, attr, data, 0)
+		})
+	})
+}
+
+func (fs *beneathFS) SetWindowsAttributes(name string, attrs uint32) error {
+	return nil // Not supported; ignored
+}
+
+func (fs *beneathFS) SetSecurityDescriptor(name string, sd []byte) error {
+	return nil // Not supported; ignored
+}
+
+func (fs *beneathFS) Close() error {
+	return fs.root.Close()
+}
+
+// syscallMode returns the Unix mode bits for the permission bits of mode.
+func syscallMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= syscall.S_ISVTX
+	}
+	return m
+}
@@ -0,0 +1,12 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux,!windows
+
+package tar
+
+// newExtractFS returns an extractFS for the directory root.
+func newExtractFS(root string) (extractFS, error) {
+	return pathFS{root}, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	sysSetFileAttributes = setFileAttributes
+	sysLocalLink = localLink
+}
+
+// newExtractFS returns an extractFS for the directory root.
+// The root is made an extended-length path, with the \\?\ prefix, so that
+// files can be extracted to paths longer than MAX_PATH (260 characters).
+func newExtractFS(root string) (extractFS, error) {
+	p, err := longPath(root)
+	if err != nil {
+		return nil, err
+	}
+	return windowsFS{pathFS{p}}, nil
+}
+
+// longPath returns the extended-length form of the path p.
+func longPath(p string) (string, error) {
+	if strings.HasPrefix(p, `\\?\`) {
+		return p, nil
+	}
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(p, `\\`) {
+		return `\\?\UNC\` + p[2:], nil // \\server\share\dir
+	}
+	return `\\?\` + p, nil
+}
+
+// windowsFS is a pathFS that can also create directory junctions.
+type windowsFS struct {
+	pathFS
+}
+
+// Reparse point constants, from the Windows SDK.
+const (
+	fsctlSetReparsePoint   = 0x900A4
+	ioReparseTagMountPoint = 0xA0000003
+)
+
+func (fs windowsFS) Junction(oldname, newname string) (bool, error) {
+	if strings.HasPrefix(fs.root, `\\?\UNC\`) {
+		return false, nil // Junctions cannot refer to network shares
+	}
+	target, err := secureJoin(fs.root, path.Join(path.Dir(newname), filepath.ToSlash(oldname)))
+	if err != nil {
+		return false, nil
+	}
+	if fi, err := os.Stat(target); err != nil || !fi.IsDir() {
+		return false, nil
+	}
+	p, err := fs.resolve(newname)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Mkdir(p, 0700); err != nil {
+		return false, err
+	}
+	if err := setJunction(p, target[len(`\\?\`):]); err != nil {
+		os.Remove(p)
+		return false, &os.PathError{Op: "junction", Path: p, Err: err}
+	}
+	return true, nil
+}
+
+// setJunction makes the empty directory p a junction to the absolute
+// path target.
+func setJunction(p, target string) error {
+	subst, err := syscall.UTF16FromString(`\??\` + target)
+	if err != nil {
+		return err
+	}
+	printName, err := syscall.UTF16FromString(target)
+	if err != nil {
+		return err
+	}
+
+	// Build a REPARSE_DATA_BUFFER holding a MountPointReparseBuffer,
+	// whose names are both NUL-terminated.
+	names := append(subst, printName...)
+	b := make([]byte, 16+2*len(names))
+	le := func(off int, v uint32, n int) {
+		for i := 0; i < n; i++ {
+			b[off+i] = byte(v >> (8 * uint(i)))
+		}
+	}
+	le(0, ioReparseTagMountPoint, 4)        // ReparseTag
+	le(4, uint32(8+2*len(names)), 2)        // ReparseDataLength
+	le(8, 0, 2)                             // SubstituteNameOffset
+	le(10, uint32(2*(len(subst)-1)), 2)     // SubstituteNameLength
+	le(12, uint32(2*len(subst)), 2)         // PrintNameOffset
+	le(14, uint32(2*(len(printName)-1)), 2) // PrintNameLength
+	for i, c := range names {
+		le(16+2*i, uint32(c), 2)
+	}
+
+	pp, err := syscall.UTF16PtrFromString(p)
+	if err != nil {
+		return err
+	}
+	h, err := syscall.CreateFile(pp, syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING,
+		syscall.FILE_FLAG_OPEN_REPARSE_POINT|syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(h)
+	var n uint32
+	return syscall.DeviceIoControl(h, fsctlSetReparsePoint, &b[0], uint32(len(b)), nil, 0, &n, nil)
+}
+
+// localLink returns the path relative to root of target, the absolute
+// path of a junction as returned by os.Readlink, if it is beneath root.
+func localLink(root, target string) (string, bool) {
+	if !filepath.IsAbs(target) {
+		return "", false
+	}
+	root = strings.TrimSuffix(strings.TrimPrefix(root, `\\?\`), `\`)
+	target = filepath.Clean(strings.TrimPrefix(target, `\\?\`))
+	if !strings.EqualFold(target, root) {
+		if len(target) <= len(root) || target[len(root)] != '\\' || !strings.EqualFold(target[:len(root)], root) {
+			return "", false
+		}
+	}
+	return filepath.ToSlash(strings.TrimPrefix(target[len(root):], `\`)), true
+}
+
+// settableAttributes are the FILE_ATTRIBUTE_* flags that SetFileAttributes
+// can set: read-only, hidden, system, archive, normal, temporary, offline
+// and not content indexed.
+const settableAttributes = 0x1 | 0x2 | 0x4 | 0x20 | 0x80 | 0x100 | 0x1000 | 0x2000
+
+// setFileAttributes sets the file attributes of p to attrs.
+func setFileAttributes(p string, attrs uint32) error {
+	pp, err := syscall.UTF16PtrFromString(p)
+	if err != nil {
+		return err
+	}
+	if attrs &= settableAttributes; attrs == 0 {
+		attrs = syscall.FILE_ATTRIBUTE_NORMAL
+	}
+	if err := syscall.SetFileAttributes(pp, attrs); err != nil {
+		return &os.PathError{Op: "setfileattributes", Path: p, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// paxSchilyFflags is the PAX keyword used by star and bsdtar to store
+// file flags, as a comma-separated list of flag names.
+const paxSchilyFflags = "SCHILY.fflags"
+
+// FileFlags is a set of the file flags of BSD systems and macOS, which are
+// set with chflags(2). The values of the flags are those of FreeBSD.
+type FileFlags uint32
+
+// File flags that may be set by the owner of a file (UF_*),
+// and by the super-user only (SF_*).
+const (
+	FlagNodump          FileFlags = 0x00000001 // Do not dump the file
+	FlagUserImmutable   FileFlags = 0x00000002 // The file may not be changed
+	FlagUserAppend      FileFlags = 0x00000004 // Writes to the file may only append
+	FlagOpaque          FileFlags = 0x00000008 // The directory is opaque in union mounts
+	FlagUserNounlink    FileFlags = 0x00000010 // The file may not be removed or renamed
+	FlagHidden          FileFlags = 0x00008000 // The file is hidden from GUIs
+	FlagArchived        FileFlags = 0x00010000 // The file is archived
+	FlagSystemImmutable FileFlags = 0x00020000 // The file may not be changed
+	FlagSystemAppend    FileFlags = 0x00040000 // Writes to the file may only append
+	FlagSystemNounlink  FileFlags = 0x00100000 // The file may not be removed or renamed
+)
+
+// fileFlagNames lists the names of the flags, as used by chflags(1),
+// in the order that they are formatted.
+var fileFlagNames = []struct {
+	flag  FileFlags
+	names []string // Canonical name, followed by aliases
+}{
+	{FlagSystemAppend, []string{"sappnd", "sappend"}},
+	{FlagArchived, []string{"arch", "archived"}},
+	{FlagSystemImmutable, []string{"schg", "schange", "simmutable"}},
+	{FlagSystemNounlink, []string{"sunlnk", "sunlink"}},
+	{FlagUserAppend, []string{"uappnd", "uappend"}},
+	{FlagUserImmutable, []string{"uchg", "uchange", "uimmutable"}},
+	{FlagUserNounlink, []string{"uunlnk", "uunlink"}},
+	{FlagOpaque, []string{"opaque"}},
+	{FlagNodump, []string{"nodump"}},
+	{FlagHidden, []string{"hidden"}},
+}
+
+// String returns the names of the flags in f, separated by commas,
+// as stored in archives and printed by ls -lo. Flags without a name are
+// formatted as hexadecimal numbers.
+func (f FileFlags) String() string {
+	var ss []string
+	for _, fn := range fileFlagNames {
+		if f&fn.flag != 0 {
+			ss = append(ss, fn.names[0])
+			f &^= fn.flag
+		}
+	}
+	for bit := FileFlags(1); f != 0; bit <<= 1 {
+		if f&bit != 0 {
+			ss = append(ss, "0x"+strconv.FormatUint(uint64(bit), 16))
+			f &^= bit
+		}
+	}
+	return strings.Join(ss, ",")
+}
+
+// ParseFileFlags parses a comma-separated list of flag names, as returned
+// by FileFlags.String and written by star and bsdtar.
+//
+// Flags may also be given as hexadecimal numbers prefixed by "0x".
+// Unknown names, such as flags of other systems, result in an error;
+// the flags that were recognized are still returned.
+func ParseFileFlags(s string) (FileFlags, error) {
+	var f FileFlags
+	var unknown []string
+loop:
+	for _, name := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		for _, fn := range fileFlagNames {
+			for _, n := range fn.names {
+				if name == n {
+					f |= fn.flag
+					continue loop
+				}
+			}
+		}
+		if strings.HasPrefix(name, "0x") {
+			if n, err := strconv.ParseUint(name[2:], 16, 32); err == nil {
+				f |= FileFlags(n)
+				continue
+			}
+		}
+		unknown = append(unknown, name)
+	}
+	if len(unknown) > 0 {
+		return f, errors.New("archive/tar: unknown file flags: " + strings.Join(unknown, ","))
+	}
+	return f, nil
+}
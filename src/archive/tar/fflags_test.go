@@ -0,0 +1,68 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFileFlags(t *testing.T) {
+	vectors := []struct {
+		in    string
+		flags FileFlags
+		str   string
+		ok    bool
+	}{
+		{"", 0, "", true},
+		{"nodump", FlagNodump, "nodump", true},
+		{"uchg,schg", FlagUserImmutable | FlagSystemImmutable, "schg,uchg", true},
+		{"uimmutable, sappend,hidden", FlagUserImmutable | FlagSystemAppend | FlagHidden, "sappnd,uchg,hidden", true},
+		{"arch,0x80000000", FlagArchived | 0x80000000, "arch,0x80000000", true},
+		{"nodump,compressed", FlagNodump, "nodump", false},
+	}
+	for i, v := range vectors {
+		got, err := ParseFileFlags(v.in)
+		if got != v.flags || (err == nil) != v.ok {
+			t.Errorf("test %d, ParseFileFlags(%q) = %#x, %v; want %#x, ok=%v", i, v.in, got, err, v.flags, v.ok)
+		}
+		if s := v.flags.String(); s != v.str {
+			t.Errorf("test %d, String() = %q, want %q", i, s, v.str)
+		}
+	}
+}
+
+func TestHeaderFlags(t *testing.T) {
+	vectors := []struct {
+		hdr  Header
+		want string // Value of the SCHILY.fflags record
+	}{{
+		hdr:  Header{Flags: FlagNodump | FlagUserAppend},
+		want: "uappnd,nodump",
+	}, {
+		hdr:  Header{Flags: FlagNodump, PAXRecords: map[string]string{paxSchilyFflags: "nodump,compressed"}},
+		want: "nodump,compressed",
+	}, {
+		hdr:  Header{Flags: FlagHidden, PAXRecords: map[string]string{paxSchilyFflags: "nodump"}},
+		want: "hidden",
+	}}
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		v.hdr.Name, v.hdr.Typeflag = "file", TypeReg
+		if err := tw.WriteHeader(&v.hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if got.Flags != v.hdr.Flags || got.PAXRecords[paxSchilyFflags] != v.want {
+			t.Errorf("test %d, Next() = %v with record %q, want %v with %q",
+				i, got.Flags, got.PAXRecords[paxSchilyFflags], v.hdr.Flags, v.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"os"
+)
+
+// sysStatExtended, if non-nil, populates h with the system-dependent
+// metadata of fi that only FileHeader records.
+var sysStatExtended func(fi os.FileInfo, h *Header)
+
+// sysSecurityDescriptor, if non-nil, returns the Windows security
+// descriptor of the file at path.
+var sysSecurityDescriptor func(path string) ([]byte, error)
+
+// FileHeader returns a Header describing the file at path, without
+// following symbolic links. It is like FileInfoHeader, except that it also
+// records the metadata of the file that FileInfoHeader cannot obtain from
+// an os.FileInfo, where the operating system provides it:
+//
+//	- the extended attributes (Linux and macOS) and SELinux context (Linux),
+//	- the access and default ACLs (Linux),
+//	- the file flags (BSD systems and macOS),
+//	- the device number, inode number and link count of the file, as the
+//	  SCHILY.dev, SCHILY.ino and SCHILY.nlink PAX records (Unix),
+//	- the file attributes and security descriptor (Windows).
+//
+// Named entries of the ACLs are identified by ID only. The security
+// descriptor holds the owner, primary group and DACL of the file, as well as
+// its SACL if the process has the SeSecurityPrivilege privilege enabled.
+// As with FileInfoHeader, the Name is the base name of the file; callers
+// should set it to the full path name of the entry.
+func FileHeader(path string) (*Header, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return nil, err
+		}
+	}
+	hdr, err := FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	if err := addXattrs(path, hdr, true); err != nil {
+		return nil, err
+	}
+	if sysStatExtended != nil {
+		sysStatExtended(fi, hdr)
+	}
+	if sysSecurityDescriptor != nil && fi.Mode()&os.ModeSymlink == 0 {
+		if hdr.WindowsSecurityDescriptor, err = sysSecurityDescriptor(path); err != nil {
+			return nil, err
+		}
+	}
+	return hdr, nil
+}
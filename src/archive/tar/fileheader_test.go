@@ -0,0 +1,66 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestFileHeader(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestFileHeader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(file, filepath.Join(dir, "hardlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+
+	hdr, err := FileHeader(file)
+	if err != nil {
+		t.Fatalf("FileHeader() error: %v", err)
+	}
+	if hdr.Name != "file" || hdr.Typeflag != TypeReg || hdr.Size != 5 || hdr.Mode != 0640 {
+		t.Errorf("FileHeader() = {Name: %q, Typeflag: %q, Size: %d, Mode: %o}, want {\"file\", '0', 5, 640}",
+			hdr.Name, hdr.Typeflag, hdr.Size, hdr.Mode)
+	}
+	if got := hdr.PAXRecords[PAXKeySchilyNlink]; got != "2" {
+		t.Errorf("PAXRecords[%q] = %q, want \"2\"", PAXKeySchilyNlink, got)
+	}
+	fi, _ := os.Stat(file)
+	id, _ := sysFileID(fi)
+	for k, want := range map[string]uint64{PAXKeySchilyDev: id.dev, PAXKeySchilyIno: id.ino} {
+		if got, want := hdr.PAXRecords[k], strconv.FormatUint(want, 10); got != want {
+			t.Errorf("PAXRecords[%q] = %q, want %q", k, got, want)
+		}
+	}
+
+	hdr, err = FileHeader(filepath.Join(dir, "symlink"))
+	if err != nil {
+		t.Fatalf("FileHeader() of symlink error: %v", err)
+	}
+	if hdr.Typeflag != TypeSymlink || hdr.Linkname != "file" {
+		t.Errorf("FileHeader() of symlink = {Typeflag: %q, Linkname: %q}, want {'2', \"file\"}", hdr.Typeflag, hdr.Linkname)
+	}
+
+	if _, err := FileHeader(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("FileHeader() of missing file error: got %v, want not exist", err)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import "io"
+
+// Filter copies the entries of src for which match returns true to dst,
+// until the end of src is reached. The entries are copied with
+// Writer.CopyFrom, without being encoded again, and the data of the others
+// is skipped without being read, seeking if src reads from an io.Seeker.
+// Entries for which src.Next reports ErrInsecurePath are passed to match
+// like the others.
+//
+// Filter does not close dst, so that entries may be added before and after
+// those copied. For example, to remove documentation from an archive:
+//
+//	tw := tar.NewWriter(w)
+//	err := tar.Filter(tw, tar.NewReader(r), func(hdr *tar.Header) bool {
+//		return !strings.HasPrefix(hdr.Name, "usr/share/doc/")
+//	})
+//	if err == nil {
+//		err = tw.Close()
+//	}
+func Filter(dst *Writer, src *Reader, match func(*Header) bool) error {
+	for {
+		hdr, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != ErrInsecurePath {
+			return err
+		}
+		if !match(hdr) {
+			continue
+		}
+		if _, err := dst.CopyFrom(src); err != nil {
+			return err
+		}
+	}
+}
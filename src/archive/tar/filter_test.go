@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFilter(t *testing.T) {
+	reg := func(name, body string) testEntry {
+		return testEntry{hdr: Header{Name: name, Typeflag: TypeReg, Mode: 0644}, body: body}
+	}
+	src := makeArchive(t,
+		testEntry{hdr: Header{Name: "usr/", Typeflag: TypeDir, Mode: 0755}},
+		reg("usr/bin/tool", "binary"),
+		reg("usr/share/doc/tool/README", strings.Repeat("docs", 1000)),
+		reg("usr/share/doc/tool/NEWS", "news"),
+		reg("etc/tool.conf", "conf"),
+	)
+	noDocs := func(hdr *Header) bool {
+		return !strings.HasPrefix(hdr.Name, "usr/share/doc/")
+	}
+	want := []string{"usr/", "usr/bin/tool:binary", "etc/tool.conf:conf"}
+
+	for i, r := range []io.Reader{
+		bytes.NewReader(src),
+		iotest.HalfReader(bytes.NewReader(src)), // Hide io.Seeker
+	} {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := Filter(tw, NewReader(r), noDocs); err != nil {
+			t.Errorf("test %d, Filter() error: %v", i, err)
+			continue
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		tr := NewReader(&b)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+			entry := hdr.Name
+			if hdr.Typeflag == TypeReg {
+				body, err := ioutil.ReadAll(tr)
+				if err != nil {
+					t.Fatalf("test %d, ReadAll() error: %v", i, err)
+				}
+				entry += ":" + string(body)
+			}
+			got = append(got, entry)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("test %d, filtered entries = %q, want %q", i, got, want)
+		}
+	}
+
+	// Errors reading the source are returned.
+	tw := NewWriter(ioutil.Discard)
+	err := Filter(tw, NewReader(bytes.NewReader(src[:1000])), noDocs)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Filter() of truncated archive: got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+)
+
+// magicOffset is the offset of the magic field within a header block.
+const magicOffset = 257
+
+// FindArchive scans r for the start of a tar archive that is preceded by
+// other data, as in self-extracting shell archives and firmware images,
+// and returns a Reader for the archive together with its offset in r.
+//
+// Unlike Reader.Resync, FindArchive does not assume that the archive starts
+// on a block boundary. It looks for the first block holding a USTAR, PAX or
+// GNU header with a valid checksum; archives in the original Unix V7 format
+// have no magic value to search for, and are not found.
+//
+// FindArchive returns io.EOF if no header was found before the end of r.
+func FindArchive(r io.Reader) (*Reader, int64, error) {
+	buf := make([]byte, 32<<10)
+	var off int64 // Offset in r of buf[0]
+	var n int     // Number of valid bytes in buf
+	var eof bool
+	var blk block
+
+	// fill moves buf[i:n] to the front of buf and reads more data after it.
+	fill := func(i int) error {
+		n = copy(buf, buf[i:n])
+		off += int64(i)
+		m, err := io.ReadFull(r, buf[n:])
+		n += m
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			eof = true
+		default:
+			return err
+		}
+		return nil
+	}
+
+	if err := fill(0); err != nil {
+		return nil, 0, err
+	}
+	for i := 0; ; {
+		// Look for the magic value of a header starting at or after i.
+		j := -1
+		if i+magicOffset < n {
+			j = bytes.Index(buf[i+magicOffset:n], []byte("ustar"))
+		}
+		if j < 0 {
+			if eof {
+				return nil, 0, io.EOF
+			}
+			// Keep any partial magic value at the end of buf.
+			i = n - magicOffset - len("ustar") + 1
+			if i < 0 {
+				i = 0
+			}
+			if err := fill(i); err != nil {
+				return nil, 0, err
+			}
+			i = 0
+			continue
+		}
+		i += j
+		if i+blockSize > n {
+			if eof {
+				return nil, 0, io.EOF
+			}
+			if err := fill(i); err != nil {
+				return nil, 0, err
+			}
+			i = 0
+			continue
+		}
+
+		copy(blk[:], buf[i:])
+		if blk.GetFormat() != FormatUnknown {
+			rest := io.MultiReader(bytes.NewReader(buf[i:n]), r)
+			return NewReader(rest), off + int64(i), nil
+		}
+		i++
+	}
+}
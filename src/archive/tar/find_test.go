@@ -0,0 +1,62 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestFindArchive(t *testing.T) {
+	data := makeArchive(t,
+		testEntry{hdr: Header{Name: "file", Typeflag: TypeReg}, body: "hello"},
+	)
+	script := "#!/bin/sh\n# Extract with: tail -c +N $0 | tar x  # ustar\nexit 0\n"
+
+	vectors := []struct {
+		prefix  string
+		oneByte bool
+	}{
+		{prefix: ""},
+		{prefix: script},
+		{prefix: script, oneByte: true},
+		{prefix: strings.Repeat("ustar", 20)},
+		{prefix: strings.Repeat("x", 40000) + script},
+		{prefix: strings.Repeat("x", 32<<10-magicOffset-2)},
+	}
+	for i, v := range vectors {
+		var r io.Reader = strings.NewReader(v.prefix + string(data))
+		if v.oneByte {
+			r = iotest.OneByteReader(r)
+		}
+		tr, off, err := FindArchive(r)
+		if err != nil {
+			t.Errorf("test %d, FindArchive() error: %v", i, err)
+			continue
+		}
+		if off != int64(len(v.prefix)) {
+			t.Errorf("test %d, FindArchive() offset = %d, want %d", i, off, len(v.prefix))
+		}
+		hdr, err := tr.Next()
+		if err != nil || hdr.Name != "file" {
+			t.Errorf("test %d, Next() = (%v, %v), want file", i, hdr, err)
+			continue
+		}
+		if got, err := ioutil.ReadAll(tr); err != nil || string(got) != "hello" {
+			t.Errorf("test %d, ReadAll() = %q, %v; want %q", i, got, err, "hello")
+		}
+	}
+
+	// Input without a valid header.
+	for i, s := range []string{"", "no archive here", strings.Repeat("ustar", 1000)} {
+		if _, _, err := FindArchive(bytes.NewReader([]byte(s))); err != io.EOF {
+			t.Errorf("test %d, FindArchive() error: got %v, want %v", i, err, io.EOF)
+		}
+	}
+}
@@ -4,7 +4,11 @@
 
 package tar
 
-import "strings"
+import (
+	"encoding/binary"
+	"math/bits"
+	"strings"
+)
 
 // Format represents the tar archive format.
 //
@@ -53,8 +57,19 @@ const (
 	// FormatUnknown indicates that the format is unknown.
 	FormatUnknown
 
-	// The format of the original Unix V7 tar tool prior to standardization.
-	formatV7
+	// FormatV7 represents the format of the original Unix V7 tar tool
+	// prior to standardization.
+	//
+	// It only supports regular files, hard links, symbolic links and
+	// directories, with ASCII names of at most 100 bytes, and has no fields
+	// for user and group names or device numbers. Directories are identified
+	// by a trailing slash in their name.
+	//
+	// Since V7 headers have no magic value, they are never chosen unless
+	// Header.Format is set to FormatV7, and the Reader reports such headers
+	// with FormatUnknown. Use it only for consumers that reject the magic
+	// values of other formats.
+	FormatV7
 
 	// FormatUSTAR represents the USTAR header format defined in POSIX.1-1988.
 	//
@@ -90,6 +105,11 @@ const (
 	// arbitrary file sizes, filenames of arbitrary encoding and length,
 	// sparse files, and other features.
 	//
+	// Names and link names longer than 100 bytes are stored in separate
+	// LongName ('L') and LongLink ('K') entries preceding the header.
+	// Setting Header.Format to FormatGNU ensures that these are used
+	// instead of PAX records, for readers that do not support PAX.
+	//
 	// It is recommended that PAX be chosen over GNU unless the target
 	// application can only parse GNU formatted archives.
 	//
@@ -97,10 +117,19 @@ const (
 	//	http://www.gnu.org/software/tar/manual/html_node/Standard.html
 	FormatGNU
 
-	// Schily's tar format, which is incompatible with USTAR.
+	// FormatSTAR represents the format of Schily's star tool, which is
+	// incompatible with USTAR.
 	// This does not cover STAR extensions to the PAX format; these fall under
 	// the PAX format.
-	formatSTAR
+	//
+	// STAR shortens the USTAR prefix field to 130 bytes, to make room for the
+	// access and change times and a trailer identifying the format.
+	// Like USTAR, it is limited to ASCII strings and octal numbers.
+	//
+	// The STAR format is never chosen unless Header.Format is set to
+	// FormatSTAR, and the Reader reports such headers with FormatUnknown.
+	// It is intended for Solaris-heritage tools that prefer it over PAX.
+	FormatSTAR
 
 	formatMax
 )
@@ -111,7 +140,7 @@ func (f *Format) mayOnlyBe(f2 Format) { *f &= f2 }
 func (f *Format) mustNotBe(f2 Format) { *f &^= f2 }
 
 var formatNames = map[Format]string{
-	formatV7: "V7", FormatUSTAR: "USTAR", FormatPAX: "PAX", FormatGNU: "GNU", formatSTAR: "STAR",
+	FormatV7: "V7", FormatUSTAR: "USTAR", FormatPAX: "PAX", FormatGNU: "GNU", FormatSTAR: "STAR",
 }
 
 func (f Format) String() string {
@@ -143,6 +172,8 @@ const (
 	blockSize  = 512 // Size of each block in a tar stream
 	nameSize   = 100 // Max length of the name field in USTAR format
 	prefixSize = 155 // Max length of the prefix field in USTAR format
+
+	starPrefixSize = 130 // Max length of the prefix field in STAR format
 )
 
 // blockPadding computes the number of bytes needed to pad offset up to the
@@ -173,20 +204,41 @@ func (b *block) GetFormat() Format {
 	if p.err != nil || (value != chksum1 && value != chksum2) {
 		return FormatUnknown
 	}
+	return b.magicFormat()
+}
+
+// getFormatQuirks is like GetFormat, but also accepts checksums with garbage
+// after the digits, and checksums that were computed with the checksum field
+// filled with NULs instead of spaces, as done by some non-conforming producers.
+func (b *block) getFormatQuirks() Format {
+	p := parser{quirks: true}
+	value := p.parseOctal(b.V7().Chksum())
+	chksum1, chksum2 := b.ComputeChecksum()
+	const spaces = 8 * ' ' // Contribution of the checksum field to ComputeChecksum
+	switch {
+	case p.err != nil:
+		return FormatUnknown
+	case value == chksum1, value == chksum2, value == chksum1-spaces, value == chksum2-spaces:
+		return b.magicFormat()
+	default:
+		return FormatUnknown
+	}
+}
 
-	// Guess the magic values.
+// magicFormat guesses the format of the block based on its magic values.
+func (b *block) magicFormat() Format {
 	magic := string(b.USTAR().Magic())
 	version := string(b.USTAR().Version())
 	trailer := string(b.STAR().Trailer())
 	switch {
 	case magic == magicUSTAR && trailer == trailerSTAR:
-		return formatSTAR
+		return FormatSTAR
 	case magic == magicUSTAR:
 		return FormatUSTAR | FormatPAX
 	case magic == magicGNU && version == versionGNU:
 		return FormatGNU
 	default:
-		return formatV7
+		return FormatV7
 	}
 }
 
@@ -195,12 +247,12 @@ func (b *block) GetFormat() Format {
 func (b *block) SetFormat(format Format) {
 	// Set the magic values.
 	switch {
-	case format.has(formatV7):
+	case format.has(FormatV7):
 		// Do nothing.
 	case format.has(FormatGNU):
 		copy(b.GNU().Magic(), magicGNU)
 		copy(b.GNU().Version(), versionGNU)
-	case format.has(formatSTAR):
+	case format.has(FormatSTAR):
 		copy(b.STAR().Magic(), magicUSTAR)
 		copy(b.STAR().Version(), versionUSTAR)
 		copy(b.STAR().Trailer(), trailerSTAR)
@@ -211,7 +263,11 @@ func (b *block) SetFormat(format Format) {
 		panic("invalid format")
 	}
 
-	// Update checksum.
+	b.setChecksum()
+}
+
+// setChecksum updates the checksum field of the block.
+func (b *block) setChecksum() {
 	// This field is special in that it is terminated by a NULL then space.
 	var f formatter
 	field := b.V7().Chksum()
@@ -224,13 +280,28 @@ func (b *block) SetFormat(format Format) {
 // POSIX specifies a sum of the unsigned byte values, but the Sun tar used
 // signed byte values.
 // We compute and return both.
+//
+// The block is summed eight bytes at a time: pairs of bytes are added into
+// 16-bit lanes, which cannot overflow over the 64 words of a block, and
+// the signed sum is derived from the unsigned one by subtracting 256 for
+// each byte with its high bit set.
 func (b *block) ComputeChecksum() (unsigned, signed int64) {
-	for i, c := range b {
-		if 148 <= i && i < 156 {
-			c = ' ' // Treat the checksum field itself as all spaces.
-		}
-		unsigned += int64(c)
-		signed += int64(int8(c))
+	const lo = 0x00ff00ff00ff00ff
+	var lanes uint64 // Sums of pairs of bytes, in four 16-bit lanes
+	var high int     // Number of bytes with the high bit set
+	for i := 0; i < blockSize; i += 8 {
+		w := binary.LittleEndian.Uint64(b[i:])
+		lanes += w&lo + (w>>8)&lo
+		high += bits.OnesCount64(w & 0x8080808080808080)
+	}
+	lanes = lanes&0x0000ffff0000ffff + (lanes>>16)&0x0000ffff0000ffff
+	unsigned = int64(lanes&0xffffffff + lanes>>32)
+	signed = unsigned - 256*int64(high)
+
+	// Treat the checksum field itself as all spaces.
+	for _, c := range b.V7().Chksum() {
+		unsigned += ' ' - int64(c)
+		signed += ' ' - int64(int8(c))
 	}
 	return unsigned, signed
 }
@@ -263,6 +334,7 @@ func (h *headerGNU) DevMajor() []byte    { return h[329:][:8] }
 func (h *headerGNU) DevMinor() []byte    { return h[337:][:8] }
 func (h *headerGNU) AccessTime() []byte  { return h[345:][:12] }
 func (h *headerGNU) ChangeTime() []byte  { return h[357:][:12] }
+func (h *headerGNU) Offset() []byte      { return h[369:][:12] }
 func (h *headerGNU) Sparse() sparseArray { return (sparseArray)(h[386:][:24*4+1]) }
 func (h *headerGNU) RealSize() []byte    { return h[483:][:12] }
 
@@ -280,6 +352,26 @@ func (h *headerSTAR) AccessTime() []byte { return h[476:][:12] }
 func (h *headerSTAR) ChangeTime() []byte { return h[488:][:12] }
 func (h *headerSTAR) Trailer() []byte    { return h[508:][:4] }
 
+// isXUSTAR reports whether the USTAR header h was written in the xustar or
+// exustar format of star, which stores the access and change times at the
+// end of the prefix field, like the STAR format, but without its trailer.
+func (h *headerSTAR) isXUSTAR() bool {
+	if h.Prefix()[130] != 0 {
+		return false
+	}
+	for _, b := range [][]byte{h.AccessTime(), h.ChangeTime()} {
+		for _, c := range b[:len(b)-1] {
+			if c < '0' || c > '7' {
+				return false
+			}
+		}
+		if c := b[len(b)-1]; c != ' ' && c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 type headerUSTAR [blockSize]byte
 
 func (h *headerUSTAR) V7() *headerV7     { return (*headerV7)(h) }
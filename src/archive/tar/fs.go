@@ -0,0 +1,378 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// An FS provides read-only access to the files of an archive stored in an
+// io.ReaderAt, in the manner of a file system. It is safe for concurrent use.
+//
+// Names are slash-separated paths relative to the root of the archive,
+// such as "dir/file.txt", where "." names the root directory.
+// When an archive contains several entries with the same name, the last one
+// is used. Entries with insecure names (see ErrInsecurePath) are ignored.
+//
+// Many archives do not contain entries for all of the directories holding
+// their files. FS synthesizes such directories, so that every file can be
+// reached with ReadDir and Walk. IsSynthetic reports whether a directory was
+// synthesized, and the Sys method of its os.FileInfo returns nil rather than
+// a *Header.
+//
+// Symbolic links are not followed. Hard links are resolved to the data of
+// the file that they link to.
+type FS struct {
+	r     io.ReaderAt
+	size  int64
+	nodes map[string]*fsNode
+}
+
+// fsNode is a file or directory in an FS.
+type fsNode struct {
+	name     string   // Cleaned name; "." for the root
+	entry    TOCEntry // Zero if synthetic
+	data     TOCEntry // Entry holding the data, which differs for hard links
+	children map[string]*fsNode
+}
+
+func (n *fsNode) synthetic() bool { return n.entry.Header == nil }
+
+func (n *fsNode) isDir() bool {
+	return n.synthetic() || n.entry.Header.Typeflag == TypeDir
+}
+
+// NewFS returns an FS for the archive of the given size stored in r.
+// It reads the headers of all entries, but none of their data.
+func NewFS(r io.ReaderAt, size int64) (*FS, error) {
+	toc, err := List(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	fsys := &FS{r: r, size: size, nodes: make(map[string]*fsNode)}
+	fsys.nodes["."] = &fsNode{name: ".", children: make(map[string]*fsNode)}
+	for _, e := range toc {
+		fsys.add(e)
+	}
+	return fsys, nil
+}
+
+// add adds the entry e to fsys.
+func (fsys *FS) add(e TOCEntry) {
+	hdr := e.Header
+	if hdr.Typeflag == TypeXGlobalHeader || !isLocalName(hdr.Name) {
+		return
+	}
+	name := path.Clean(hdr.Name)
+	n := &fsNode{name: name, entry: e, data: e}
+	switch hdr.Typeflag {
+	case TypeDir:
+		n.children = make(map[string]*fsNode)
+	case TypeLink:
+		target, ok := fsys.nodes[path.Clean(hdr.Linkname)]
+		if !ok || target.isDir() || target.entry.Header.Typeflag == TypeSymlink {
+			return // The target must be an earlier file in the archive
+		}
+		n.data = target.data
+	}
+
+	if name == "." && !n.isDir() {
+		return // The root must be a directory
+	}
+	if old, ok := fsys.nodes[name]; ok {
+		if old.isDir() && n.isDir() {
+			n.children = old.children // Keep the contents of the directory
+		} else {
+			fsys.remove(old)
+		}
+	}
+	fsys.nodes[name] = n
+	if name == "." {
+		return
+	}
+	fsys.mkdirAll(path.Dir(name)).children[path.Base(name)] = n
+}
+
+// mkdirAll returns the directory node for name,
+// synthesizing it and its parents if they do not exist.
+func (fsys *FS) mkdirAll(name string) *fsNode {
+	if n, ok := fsys.nodes[name]; ok && n.isDir() {
+		return n
+	} else if ok {
+		fsys.remove(n) // A file is replaced by an implied directory
+	}
+	n := &fsNode{name: name, children: make(map[string]*fsNode)}
+	fsys.nodes[name] = n
+	fsys.mkdirAll(path.Dir(name)).children[path.Base(name)] = n
+	return n
+}
+
+// remove removes n and its contents from the index.
+func (fsys *FS) remove(n *fsNode) {
+	for _, c := range n.children {
+		fsys.remove(c)
+	}
+	delete(fsys.nodes, n.name)
+	if parent, ok := fsys.nodes[path.Dir(n.name)]; ok && n.name != "." {
+		delete(parent.children, path.Base(n.name))
+	}
+}
+
+// lookup returns the node for name.
+func (fsys *FS) lookup(op, name string) (*fsNode, error) {
+	if n, ok := fsys.nodes[name]; ok && validFSName(name) {
+		return n, nil
+	}
+	return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// validFSName reports whether name is in the canonical form used by FS.
+func validFSName(name string) bool {
+	return name == "." || (isLocalName(name) && path.Clean(name) == name)
+}
+
+// IsSynthetic reports whether name is a directory that does not have an
+// entry in the archive, but is implied by the names of other entries.
+func (fsys *FS) IsSynthetic(name string) bool {
+	n, err := fsys.lookup("stat", name)
+	return err == nil && n.synthetic()
+}
+
+// Stat returns an os.FileInfo describing the named file.
+// The Sys method of the os.FileInfo returns the *Header of the entry.
+func (fsys *FS) Stat(name string) (os.FileInfo, error) {
+	n, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.stat(), nil
+}
+
+func (n *fsNode) stat() os.FileInfo {
+	if n.synthetic() {
+		hdr := &Header{Name: n.name + "/", Typeflag: TypeDir, Mode: 0755}
+		return fsFileInfo{hdr.FileInfo(), path.Base(n.name), nil}
+	}
+	fi := n.entry.Header.FileInfo()
+	if n.data.Header != n.entry.Header {
+		fi = n.data.Header.FileInfo() // Use the size and mode of the target
+	}
+	return fsFileInfo{fi, path.Base(n.name), n.entry.Header}
+}
+
+// fsFileInfo is an os.FileInfo with a different name and Sys value.
+type fsFileInfo struct {
+	os.FileInfo
+	name string
+	sys  interface{}
+}
+
+func (fi fsFileInfo) Name() string     { return fi.name }
+func (fi fsFileInfo) Sys() interface{} { return fi.sys }
+
+// ReadDir returns the contents of the named directory, sorted by name.
+func (fsys *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	n, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return n.readDir(), nil
+}
+
+func (n *fsNode) readDir() []os.FileInfo {
+	fis := make([]os.FileInfo, 0, len(n.children))
+	for _, c := range n.children {
+		fis = append(fis, c.stat())
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis
+}
+
+// maxSparseReadFile is the size of the largest sparse file that ReadFile
+// reads into memory. Unlike that of other files, the size of a sparse file
+// is not bounded by the size of the archive, since its holes are not stored.
+const maxSparseReadFile = 1 << 30
+
+// ReadFile returns the contents of the named file.
+// Sparse files larger than 1 GiB are not read; they can be read with Open.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	size := f.size
+	if int64(int(size)) != size || (f.tr != nil && size > maxSparseReadFile) {
+		return nil, &os.PathError{Op: "read", Path: name, Err: errors.New("file too large")}
+	}
+	if f.tr == nil && size > fsys.size {
+		// All the data of a file that is not sparse is stored in the
+		// archive, so its header is wrong; let reading fail below.
+		size = fsys.size
+	}
+	b := make([]byte, size)
+	n, err := io.ReadFull(f, b)
+	if err == nil && int64(n) < f.size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+	return b, nil
+}
+
+// Walk walks the file tree rooted at root in lexical order, calling fn for
+// each file or directory in the tree, including root, with the same
+// semantics as filepath.Walk, except that names are slash-separated.
+func (fsys *FS) Walk(root string, fn filepath.WalkFunc) error {
+	n, err := fsys.lookup("walk", root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fsys.walk(root, n, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (fsys *FS) walk(name string, n *fsNode, fn filepath.WalkFunc) error {
+	fi := n.stat()
+	if err := fn(name, fi, nil); err != nil {
+		if err == filepath.SkipDir && fi.IsDir() {
+			return nil
+		}
+		return err
+	}
+	if !n.isDir() {
+		return nil
+	}
+	for _, cfi := range n.readDir() {
+		err := fsys.walk(path.Join(name, cfi.Name()), n.children[cfi.Name()], fn)
+		if err != nil {
+			if err == filepath.SkipDir && !cfi.IsDir() {
+				return nil // Skip the rest of this directory
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Open opens the named file or directory for reading.
+//
+// The data of regular files that are not sparse can be read at random,
+// with Seek and ReadAt. Sparse files can only be read sequentially.
+func (fsys *FS) Open(name string) (*FSFile, error) {
+	n, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f := &FSFile{name: name, fsys: fsys, node: n}
+	if n.isDir() {
+		return f, nil
+	}
+	hdr := n.data.Header
+	switch {
+	case isHeaderOnlyType(hdr.Typeflag):
+		f.sr = io.NewSectionReader(fsys.r, 0, 0)
+	case isSparseHeader(hdr):
+		f.size = hdr.Size
+		tr := NewReader(io.NewSectionReader(fsys.r, n.data.HeaderOffset, fsys.size-n.data.HeaderOffset))
+		if _, err := tr.Next(); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		f.tr = tr
+	default:
+		f.size = hdr.Size
+		f.sr = io.NewSectionReader(fsys.r, n.data.DataOffset, hdr.Size)
+	}
+	return f, nil
+}
+
+// An FSFile is a file or directory opened with FS.Open.
+type FSFile struct {
+	name string
+	fsys *FS
+	node *fsNode
+	size int64
+
+	sr *io.SectionReader // Data of a regular file
+	tr *Reader           // Data of a sparse file
+
+	dirs   []os.FileInfo // Remaining entries for Readdir
+	dirsOK bool          // Whether dirs has been initialized
+}
+
+// Name returns the name of the file as passed to Open.
+func (f *FSFile) Name() string { return f.name }
+
+// Stat returns an os.FileInfo describing the file.
+func (f *FSFile) Stat() (os.FileInfo, error) { return f.node.stat(), nil }
+
+// Read reads up to len(b) bytes of the data of the file.
+func (f *FSFile) Read(b []byte) (int, error) {
+	switch {
+	case f.sr != nil:
+		return f.sr.Read(b)
+	case f.tr != nil:
+		return f.tr.Read(b)
+	default:
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+}
+
+// ReadAt reads len(b) bytes of the data of the file starting at off.
+func (f *FSFile) ReadAt(b []byte, off int64) (int, error) {
+	if f.sr == nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errors.New("random access not supported")}
+	}
+	return f.sr.ReadAt(b, off)
+}
+
+// Seek sets the offset for the next Read.
+func (f *FSFile) Seek(offset int64, whence int) (int64, error) {
+	if f.sr == nil {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errors.New("random access not supported")}
+	}
+	return f.sr.Seek(offset, whence)
+}
+
+// Readdir reads the contents of the directory, sorted by name,
+// with the same semantics as os.File.Readdir.
+func (f *FSFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.node.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+	}
+	if !f.dirsOK {
+		f.dirs, f.dirsOK = f.node.readDir(), true
+	}
+	if count <= 0 {
+		fis := f.dirs
+		f.dirs = nil
+		return fis, nil
+	}
+	if len(f.dirs) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.dirs) {
+		count = len(f.dirs)
+	}
+	fis := f.dirs[:count:count]
+	f.dirs = f.dirs[count:]
+	return fis, nil
+}
+
+// Close closes the file.
+func (f *FSFile) Close() error { return nil }
@@ -0,0 +1,188 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFS(t *testing.T) {
+	data := makeArchive(t,
+		testEntry{hdr: Header{Name: "a/b/file", Typeflag: TypeReg, Mode: 0644}, body: "hello"},
+		testEntry{hdr: Header{Name: "dir/", Typeflag: TypeDir, Mode: 0700}},
+		testEntry{hdr: Header{Name: "dir/link", Typeflag: TypeLink, Linkname: "a/b/file"}},
+		testEntry{hdr: Header{Name: "dup", Typeflag: TypeReg}, body: "old"},
+		testEntry{hdr: Header{Name: "dup", Typeflag: TypeReg}, body: "new"},
+		testEntry{hdr: Header{Name: "../escape", Typeflag: TypeReg}, body: "evil"},
+		testEntry{hdr: Header{Name: "sym", Typeflag: TypeSymlink, Linkname: "a"}},
+	)
+	fsys, err := NewFS(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewFS() error: %v", err)
+	}
+
+	dirs := []struct {
+		name  string
+		names []string
+		synth bool
+	}{
+		{".", []string{"a", "dir", "dup", "sym"}, true},
+		{"a", []string{"b"}, true},
+		{"a/b", []string{"file"}, true},
+		{"dir", []string{"link"}, false},
+	}
+	for i, v := range dirs {
+		fis, err := fsys.ReadDir(v.name)
+		if err != nil {
+			t.Errorf("test %d, ReadDir(%q) error: %v", i, v.name, err)
+			continue
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if !reflect.DeepEqual(names, v.names) {
+			t.Errorf("test %d, ReadDir(%q) = %q, want %q", i, v.name, names, v.names)
+		}
+		if got := fsys.IsSynthetic(v.name); got != v.synth {
+			t.Errorf("test %d, IsSynthetic(%q) = %v, want %v", i, v.name, got, v.synth)
+		}
+		fi, err := fsys.Stat(v.name)
+		if err != nil || !fi.IsDir() || (fi.Sys() == nil) != v.synth {
+			t.Errorf("test %d, Stat(%q) = %v, %v; want directory", i, v.name, fi, err)
+		}
+	}
+
+	files := []struct {
+		name, want string
+	}{
+		{"a/b/file", "hello"},
+		{"dir/link", "hello"},
+		{"dup", "new"},
+		{"sym", ""},
+	}
+	for i, v := range files {
+		got, err := fsys.ReadFile(v.name)
+		if err != nil || string(got) != v.want {
+			t.Errorf("test %d, ReadFile(%q) = %q, %v; want %q", i, v.name, got, err, v.want)
+		}
+	}
+
+	for i, name := range []string{"escape", "../escape", "a/", "./a", "a//b", "/a", "missing"} {
+		if _, err := fsys.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("test %d, Stat(%q) error: got %v, want not exist", i, name, err)
+		}
+	}
+
+	var walked []string
+	err = fsys.Walk(".", func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, name)
+		if name == "a/b" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	wantWalked := []string{".", "a", "a/b", "dir", "dir/link", "dup", "sym"}
+	if err != nil || !reflect.DeepEqual(walked, wantWalked) {
+		t.Errorf("Walk() = %q, %v; want %q", walked, err, wantWalked)
+	}
+
+	f, err := fsys.Open("a/b/file")
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if _, err := f.Seek(1, io.SeekStart); err != nil {
+		t.Errorf("Seek() error: %v", err)
+	}
+	if got, err := ioutil.ReadAll(f); err != nil || string(got) != "ello" {
+		t.Errorf("ReadAll() = %q, %v; want %q", got, err, "ello")
+	}
+	b := make([]byte, 2)
+	if n, err := f.ReadAt(b, 3); err != nil || string(b[:n]) != "lo" {
+		t.Errorf("ReadAt() = %q, %v; want %q", b[:n], err, "lo")
+	}
+	f.Close()
+
+	d, err := fsys.Open(".")
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	var names []string
+	for {
+		fis, err := d.Readdir(3)
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Readdir() error: %v", err)
+		}
+	}
+	if want := dirs[0].names; !reflect.DeepEqual(names, want) {
+		t.Errorf("Readdir() = %q, want %q", names, want)
+	}
+}
+
+func TestFSSparse(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/sparse-formats.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := NewFS(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewFS() error: %v", err)
+	}
+
+	tr := NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		want, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("ReadAll() error: %v", err)
+		}
+		got, err := fsys.ReadFile(hdr.Name)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("ReadFile(%q) mismatch: %v", hdr.Name, err)
+		}
+	}
+}
+
+func TestFSReadFileHuge(t *testing.T) {
+	// The archive holds a sparse file of 60 GB with little data.
+	data, err := ioutil.ReadFile("testdata/pax-sparse-big.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := NewFS(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewFS() error: %v", err)
+	}
+	if _, err := fsys.ReadFile("pax-sparse"); err == nil {
+		t.Errorf("ReadFile() succeeded, want error")
+	}
+	f, err := fsys.Open("pax-sparse")
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.Size() != 60000000000 {
+		t.Errorf("Stat() = (%v, %v), want size %d", fi, err, int64(60000000000))
+	}
+}
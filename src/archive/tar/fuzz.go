@@ -0,0 +1,159 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build gofuzz
+
+// Fuzz functions for use with github.com/dvyukov/go-fuzz. For example:
+//
+//	go-fuzz-build -func FuzzReader archive/tar
+//	mkdir -p workdir/corpus && cp testdata/*.tar workdir/corpus
+//	go-fuzz -bin tar-fuzz.zip -workdir workdir
+//
+// The archives in testdata, many of which exercise unusual or malformed
+// headers, make good seeds for all three functions. The functions panic
+// when an invariant does not hold.
+
+package tar
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"time"
+)
+
+// fuzzMaxData is the maximum amount of data read from each entry,
+// so that sparse files with huge sizes do not slow down fuzzing.
+const fuzzMaxData = 1 << 20
+
+// FuzzReader reads the archive in data. It must not panic, whether or
+// not the archive is valid.
+func FuzzReader(data []byte) int {
+	tr := NewReader(bytes.NewReader(data))
+	n := 0
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		hdr.FileInfo().Mode()
+		if _, err := io.CopyN(ioutil.Discard, tr, fuzzMaxData); err != nil && err != io.EOF {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return 1
+}
+
+// FuzzWriterRoundTrip reads the archive in data and writes its entries
+// to a new archive, which must read back with the same headers and data,
+// except where the Writer is documented to change them: the Format is
+// chosen anew, the ModTime is rounded to the nearest second, the
+// AccessTime, ChangeTime and CreationTime are discarded, and the
+// HdrCharset may be set to "BINARY". Entries of types other than files,
+// directories, links and devices are skipped, since the Writer does not
+// write sparse files and may rewrite the other types.
+func FuzzWriterRoundTrip(data []byte) int {
+	type entry struct {
+		hdr  *Header
+		data []byte
+	}
+	var entries []entry
+	tr := NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		switch hdr.Typeflag {
+		case TypeReg, TypeRegA, TypeDir, TypeSymlink, TypeLink, TypeChar, TypeBlock, TypeFifo:
+		default:
+			continue
+		}
+		if hdr.Size > fuzzMaxData {
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			break
+		}
+		entries = append(entries, entry{hdr, b})
+	}
+	if len(entries) == 0 {
+		return 0
+	}
+
+	var buf bytes.Buffer
+	tw := NewWriter(&buf)
+	var written []entry
+	for _, e := range entries {
+		hdr := *e.hdr
+		hdr.Format = FormatUnknown
+		hdr.PAXRecords = nil // Records derived from other fields may conflict
+		if err := tw.WriteHeader(&hdr); err != nil {
+			continue // Not every header that can be read can be written
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			panic(fmt.Sprintf("Write() error: %v", err))
+		}
+		written = append(written, entry{&hdr, e.data})
+	}
+	if err := tw.Close(); err != nil {
+		panic(fmt.Sprintf("Close() error: %v", err))
+	}
+
+	tr = NewReader(&buf)
+	for i, want := range written {
+		got, err := tr.Next()
+		if err != nil {
+			panic(fmt.Sprintf("entry %d, Next() error: %v", i, err))
+		}
+		wantHdr := *want.hdr
+		wantHdr.ModTime = wantHdr.ModTime.Round(time.Second)
+		wantHdr.AccessTime, wantHdr.ChangeTime, wantHdr.CreationTime = time.Time{}, time.Time{}, time.Time{}
+		if wantHdr.HdrCharset == "" && got.HdrCharset == hdrCharsetBinary {
+			wantHdr.HdrCharset = got.HdrCharset
+		}
+		if diffs := CompareHeaders(&wantHdr, got, CompareIgnore("Format", "PAXRecords")); diffs != nil {
+			panic(fmt.Sprintf("entry %d, headers differ: %+v", i, diffs))
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			panic(fmt.Sprintf("entry %d, ReadAll() error: %v", i, err))
+		}
+		if !bytes.Equal(b, want.data) {
+			panic(fmt.Sprintf("entry %d, data differs", i))
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		panic(fmt.Sprintf("Next() at end error: %v, want io.EOF", err))
+	}
+	return 1
+}
+
+// FuzzPAXRecords parses data as the records of a PAX extended header.
+// If it is valid, the records must format and parse back to the same.
+func FuzzPAXRecords(data []byte) int {
+	recs, err := ParsePAXRecords(data)
+	if err != nil {
+		return 0
+	}
+	b, err := FormatPAXRecords(recs)
+	if err != nil {
+		panic(fmt.Sprintf("FormatPAXRecords() error: %v", err))
+	}
+	recs2, err := ParsePAXRecords(b)
+	if err != nil {
+		panic(fmt.Sprintf("ParsePAXRecords() of formatted records error: %v", err))
+	}
+	if !reflect.DeepEqual(recs, recs2) {
+		panic(fmt.Sprintf("records differ:\n%q\n%q", recs, recs2))
+	}
+	return 1
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build gofuzz
+
+package tar
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestFuzzSeeds runs the fuzz functions on the archives in testdata,
+// which are the seeds of the fuzzing corpus. Run it with:
+//
+//	go test -tags gofuzz -run FuzzSeeds archive/tar
+func TestFuzzSeeds(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds := [][]byte{
+		[]byte("30 mtime=1350244992.023960108\n"),
+		[]byte("23 GNU.sparse.offset=0\n25 GNU.sparse.numbytes=5\n"),
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeds = append(seeds, b)
+	}
+	for _, fn := range []struct {
+		name string
+		fuzz func([]byte) int
+	}{
+		{"FuzzReader", FuzzReader},
+		{"FuzzWriterRoundTrip", FuzzWriterRoundTrip},
+		{"FuzzPAXRecords", FuzzPAXRecords},
+	} {
+		for i, seed := range seeds {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s(seed %d) panicked: %v", fn.name, i, r)
+					}
+				}()
+				fn.fuzz(seed)
+			}()
+		}
+	}
+}
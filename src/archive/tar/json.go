@@ -0,0 +1,245 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jsonHeader is the JSON representation of a Header.
+// Its field names are stable, and empty fields are omitted.
+type jsonHeader struct {
+	Typeflag jsonTypeflag `json:"typeflag"`
+	Name     string       `json:"name"`
+	Linkname string       `json:"linkname,omitempty"`
+
+	Size  int64    `json:"size"`
+	Mode  jsonMode `json:"mode"`
+	Uid   int      `json:"uid"`
+	Gid   int      `json:"gid"`
+	Uname string   `json:"uname,omitempty"`
+	Gname string   `json:"gname,omitempty"`
+
+	ModTime      *time.Time `json:"modTime,omitempty"`
+	AccessTime   *time.Time `json:"accessTime,omitempty"`
+	ChangeTime   *time.Time `json:"changeTime,omitempty"`
+	CreationTime *time.Time `json:"creationTime,omitempty"`
+
+	Devmajor int64 `json:"devmajor,omitempty"`
+	Devminor int64 `json:"devminor,omitempty"`
+
+	Comment    string `json:"comment,omitempty"`
+	Charset    string `json:"charset,omitempty"`
+	HdrCharset string `json:"hdrCharset,omitempty"`
+
+	Xattrs                    map[string]string `json:"xattrs,omitempty"`
+	AccessACL                 string            `json:"accessACL,omitempty"`
+	DefaultACL                string            `json:"defaultACL,omitempty"`
+	Flags                     string            `json:"flags,omitempty"`
+	SELinuxContext            string            `json:"selinuxContext,omitempty"`
+	WindowsAttributes         uint32            `json:"windowsAttributes,omitempty"`
+	WindowsSecurityDescriptor []byte            `json:"windowsSecurityDescriptor,omitempty"`
+	PAXRecords                map[string]string `json:"paxRecords,omitempty"`
+
+	Format string `json:"format,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface, so that tools can
+// report the metadata of entries without defining a parallel type.
+//
+// The field names are those of Header in lower camel case, such as "name"
+// and "modTime". The Typeflag is represented by its TypeName, such as
+// "reg", "dir" or "symlink", or by the character itself for types other
+// than the Type constants, and the Mode as an octal string, such as "0644". Times are in RFC 3339 format,
+// and the ACLs, Flags and Format are in the text forms used by their PAX
+// records and String methods. Empty fields other than the Typeflag, Name,
+// Size, Mode, Uid and Gid are omitted.
+//
+// Strings that are not valid UTF-8 are not preserved.
+func (h Header) MarshalJSON() ([]byte, error) {
+	jh := jsonHeader{
+		Typeflag: jsonTypeflag(h.Typeflag),
+		Name:     h.Name,
+		Linkname: h.Linkname,
+
+		Size:  h.Size,
+		Mode:  jsonMode(h.Mode),
+		Uid:   h.Uid,
+		Gid:   h.Gid,
+		Uname: h.Uname,
+		Gname: h.Gname,
+
+		ModTime:      jsonTime(h.ModTime),
+		AccessTime:   jsonTime(h.AccessTime),
+		ChangeTime:   jsonTime(h.ChangeTime),
+		CreationTime: jsonTime(h.CreationTime),
+
+		Devmajor: h.Devmajor,
+		Devminor: h.Devminor,
+
+		Comment:    h.Comment,
+		Charset:    h.Charset,
+		HdrCharset: h.HdrCharset,
+
+		Xattrs:                    h.Xattrs,
+		SELinuxContext:            h.SELinuxContext,
+		WindowsAttributes:         h.WindowsAttributes,
+		WindowsSecurityDescriptor: h.WindowsSecurityDescriptor,
+		PAXRecords:                h.PAXRecords,
+	}
+	var err error
+	if jh.AccessACL, err = formatACL(h.AccessACL); err != nil {
+		return nil, err
+	}
+	if jh.DefaultACL, err = formatACL(h.DefaultACL); err != nil {
+		return nil, err
+	}
+	if h.Flags != 0 {
+		jh.Flags = h.Flags.String()
+	}
+	if h.Format != FormatUnknown {
+		jh.Format = h.Format.String()
+	}
+	return json.Marshal(&jh)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface,
+// accepting the representation produced by MarshalJSON.
+func (h *Header) UnmarshalJSON(b []byte) error {
+	var jh jsonHeader
+	if err := json.Unmarshal(b, &jh); err != nil {
+		return err
+	}
+	h2 := Header{
+		Typeflag: byte(jh.Typeflag),
+		Name:     jh.Name,
+		Linkname: jh.Linkname,
+
+		Size:  jh.Size,
+		Mode:  int64(jh.Mode),
+		Uid:   jh.Uid,
+		Gid:   jh.Gid,
+		Uname: jh.Uname,
+		Gname: jh.Gname,
+
+		Devmajor: jh.Devmajor,
+		Devminor: jh.Devminor,
+
+		Comment:    jh.Comment,
+		Charset:    jh.Charset,
+		HdrCharset: jh.HdrCharset,
+
+		Xattrs:                    jh.Xattrs,
+		SELinuxContext:            jh.SELinuxContext,
+		WindowsAttributes:         jh.WindowsAttributes,
+		WindowsSecurityDescriptor: jh.WindowsSecurityDescriptor,
+		PAXRecords:                jh.PAXRecords,
+	}
+	for _, t := range []struct {
+		dst *time.Time
+		src *time.Time
+	}{
+		{&h2.ModTime, jh.ModTime},
+		{&h2.AccessTime, jh.AccessTime},
+		{&h2.ChangeTime, jh.ChangeTime},
+		{&h2.CreationTime, jh.CreationTime},
+	} {
+		if t.src != nil {
+			*t.dst = *t.src
+		}
+	}
+	var err error
+	if h2.AccessACL, err = parseACL(jh.AccessACL); err != nil {
+		return err
+	}
+	if h2.DefaultACL, err = parseACL(jh.DefaultACL); err != nil {
+		return err
+	}
+	if jh.Flags != "" {
+		if h2.Flags, err = ParseFileFlags(jh.Flags); err != nil {
+			return err
+		}
+	}
+	if h2.Format, err = parseFormat(jh.Format); err != nil {
+		return err
+	}
+	*h = h2
+	return nil
+}
+
+// jsonTime returns a pointer to t, or nil if t is the zero Time.
+func jsonTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// parseFormat parses the result of Format.String.
+func parseFormat(s string) (Format, error) {
+	if s == "" || s == FormatUnknown.String() {
+		return FormatUnknown, nil
+	}
+	var f Format
+next:
+	for _, name := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(s, "("), ")"), " | ") {
+		for f2, name2 := range formatNames {
+			if name == name2 {
+				f |= f2
+				continue next
+			}
+		}
+		return FormatUnknown, errors.New("archive/tar: invalid format " + strconv.Quote(s))
+	}
+	return f, nil
+}
+
+// jsonTypeflag is a Typeflag that is represented by its name in text.
+type jsonTypeflag byte
+
+func (t jsonTypeflag) MarshalText() ([]byte, error) {
+	if name, ok := typeNames[byte(t)]; ok {
+		return []byte(name), nil
+	}
+	return []byte{byte(t)}, nil
+}
+
+func (t *jsonTypeflag) UnmarshalText(b []byte) error {
+	for flag, name := range typeNames {
+		if string(b) == name {
+			*t = jsonTypeflag(flag)
+			return nil
+		}
+	}
+	if len(b) != 1 {
+		return errors.New("archive/tar: invalid typeflag " + strconv.Quote(string(b)))
+	}
+	*t = jsonTypeflag(b[0])
+	return nil
+}
+
+// jsonMode is a Mode that is represented in octal in text.
+type jsonMode int64
+
+func (m jsonMode) MarshalText() ([]byte, error) {
+	s := strconv.FormatInt(int64(m), 8)
+	if m < 0 {
+		return []byte("-0" + s[1:]), nil
+	}
+	return []byte("0" + s), nil
+}
+
+func (m *jsonMode) UnmarshalText(b []byte) error {
+	n, err := strconv.ParseInt(string(b), 8, 64)
+	if err != nil {
+		return errors.New("archive/tar: invalid mode " + strconv.Quote(string(b)))
+	}
+	*m = jsonMode(n)
+	return nil
+}
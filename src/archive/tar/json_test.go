@@ -0,0 +1,86 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeaderJSON(t *testing.T) {
+	vectors := []struct {
+		header *Header
+		json   string // Expected encoding, if not empty
+	}{{
+		header: &Header{Typeflag: TypeReg, Name: "file", Size: 5, Mode: 0644, ModTime: time.Unix(1500000000, 0).UTC()},
+		json:   `{"typeflag":"reg","name":"file","size":5,"mode":"0644","uid":0,"gid":0,"modTime":"2017-07-14T02:40:00Z"}`,
+	}, {
+		header: &Header{Typeflag: TypeSymlink, Name: "link", Linkname: "file", Mode: 0777, Format: FormatPAX},
+		json:   `{"typeflag":"symlink","name":"link","linkname":"file","size":0,"mode":"0777","uid":0,"gid":0,"format":"PAX"}`,
+	}, {
+		header: &Header{Typeflag: 'A', Name: "solaris", Mode: -1, Format: FormatUSTAR | FormatPAX},
+		json:   `{"typeflag":"A","name":"solaris","size":0,"mode":"-01","uid":0,"gid":0,"format":"(USTAR | PAX)"}`,
+	}, {
+		header: &Header{
+			Typeflag:                  TypeDir,
+			Name:                      "dir/",
+			Mode:                      01755,
+			Uid:                       1000,
+			Gid:                       100,
+			Uname:                     "gopher",
+			Gname:                     "users",
+			ModTime:                   time.Unix(1500000000, 123456789).UTC(),
+			AccessTime:                time.Unix(1500000001, 0).UTC(),
+			ChangeTime:                time.Unix(1500000002, 0).UTC(),
+			CreationTime:              time.Unix(1400000000, 0).UTC(),
+			Comment:                   "comment",
+			Charset:                   "ISO-IR 10646 2000 UTF-8",
+			HdrCharset:                "BINARY",
+			Xattrs:                    map[string]string{"user.a": "b"},
+			AccessACL:                 []ACLEntry{{Tag: ACLUserObj, ID: -1, Perm: 7}, {Tag: ACLUser, Name: "gopher", ID: 1000, Perm: 5}},
+			DefaultACL:                []ACLEntry{{Tag: ACLOther, ID: -1, Perm: 4}},
+			Flags:                     FlagUserImmutable | FlagNodump,
+			SELinuxContext:            "system_u:object_r:etc_t:s0",
+			WindowsAttributes:         0x21,
+			WindowsSecurityDescriptor: []byte{1, 0, 4, 0x80},
+			PAXRecords:                map[string]string{"GOLANG.pkg": "tar"},
+			Format:                    FormatPAX,
+		},
+	}, {
+		header: &Header{Typeflag: TypeChar, Name: "dev/null", Devmajor: 1, Devminor: 3},
+	}}
+
+	for i, v := range vectors {
+		b, err := json.Marshal(v.header)
+		if err != nil {
+			t.Fatalf("test %d, Marshal() error: %v", i, err)
+		}
+		if v.json != "" && string(b) != v.json {
+			t.Errorf("test %d, Marshal():\ngot  %s\nwant %s", i, b, v.json)
+		}
+		var got Header
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("test %d, Unmarshal(%s) error: %v", i, b, err)
+		}
+		if !reflect.DeepEqual(&got, v.header) {
+			t.Errorf("test %d, Unmarshal(%s):\ngot  %+v\nwant %+v", i, b, got, *v.header)
+		}
+	}
+
+	for i, s := range []string{
+		`{"typeflag":"regular"}`,
+		`{"mode":"0999"}`,
+		`{"format":"ZIP"}`,
+		`{"accessACL":"everyone::rwx"}`,
+		`{"flags":"bogus"}`,
+	} {
+		var h Header
+		if err := json.Unmarshal([]byte(s), &h); err == nil {
+			t.Errorf("test %d, Unmarshal(%s) succeeded, want error", i, s)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+// A LinkResolver tracks the entries of an archive as they are read, in order
+// to resolve hard links (TypeLink) to the entries holding their data.
+//
+// A hard link entry has no data of its own; it refers to an earlier entry in
+// the archive by name. Extracting to a file system can simply create a link,
+// but other targets, such as object stores or in-memory file systems, must
+// instead duplicate the data of the earlier entry. A LinkResolver records
+// enough about every entry to find that data again, either by name or by its
+// offsets in the archive (see List).
+//
+// The zero value is ready to use.
+type LinkResolver struct {
+	entries map[string]TOCEntry
+}
+
+// Add records the entry e, which must be passed in the order that it appears
+// in the archive, and returns the entry holding its data.
+//
+// If e is a hard link, Add returns the entry of the file that it links to,
+// following links to links, and records that entry under the name of e.
+// It reports false if the target has not been seen or is not a file that can
+// hold data, such as a directory. For all other entries, Add returns e itself.
+//
+// The offsets of e are only needed by callers that wish to read the data
+// again by position; a TOCEntry holding just the Header is sufficient for
+// resolving by name.
+func (lr *LinkResolver) Add(e TOCEntry) (TOCEntry, bool) {
+	if e.Header.Typeflag == TypeXGlobalHeader {
+		return e, true // Global headers do not describe files
+	}
+	if lr.entries == nil {
+		lr.entries = make(map[string]TOCEntry)
+	}
+	name := entryKey(e.Header.Name)
+	if e.Header.Typeflag != TypeLink {
+		lr.entries[name] = e
+		return e, true
+	}
+	target, ok := lr.Lookup(e.Header.Linkname)
+	if !ok || target.Header.Typeflag == TypeDir || target.Header.Typeflag == TypeSymlink {
+		delete(lr.entries, name)
+		return TOCEntry{}, false
+	}
+	lr.entries[name] = target
+	return target, true
+}
+
+// Lookup returns the entry holding the data of the named file, as recorded
+// by the most recent call to Add for that name. Names are compared after
+// cleaning, so "./dir/file" and "dir/file" name the same file.
+func (lr *LinkResolver) Lookup(name string) (TOCEntry, bool) {
+	e, ok := lr.entries[entryKey(name)]
+	return e, ok
+}
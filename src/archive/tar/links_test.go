@@ -0,0 +1,65 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLinkResolver(t *testing.T) {
+	data := makeArchive(t,
+		testEntry{hdr: Header{Name: "file", Typeflag: TypeReg}, body: "first"},
+		testEntry{hdr: Header{Name: "dir/", Typeflag: TypeDir}},
+		testEntry{hdr: Header{Name: "link1", Typeflag: TypeLink, Linkname: "./file"}},
+		testEntry{hdr: Header{Name: "link2", Typeflag: TypeLink, Linkname: "link1"}},
+		testEntry{hdr: Header{Name: "file", Typeflag: TypeReg}, body: "second"},
+		testEntry{hdr: Header{Name: "link3", Typeflag: TypeLink, Linkname: "file"}},
+		testEntry{hdr: Header{Name: "dangling", Typeflag: TypeLink, Linkname: "missing"}},
+		testEntry{hdr: Header{Name: "dirlink", Typeflag: TypeLink, Linkname: "dir"}},
+	)
+	toc, err := List(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+
+	vectors := []struct {
+		target int // Index of the entry holding the data, or -1 if unresolved
+	}{{0}, {1}, {0}, {0}, {4}, {4}, {-1}, {-1}}
+	var lr LinkResolver
+	for i, v := range vectors {
+		got, ok := lr.Add(toc[i])
+		if v.target < 0 {
+			if ok {
+				t.Errorf("test %d, Add(%q) = %q, want unresolved", i, toc[i].Header.Name, got.Header.Name)
+			}
+			continue
+		}
+		want := toc[v.target]
+		if !ok || got != want {
+			t.Errorf("test %d, Add(%q) = (%v, %v), want entry at offset %d", i, toc[i].Header.Name, got.HeaderOffset, ok, want.HeaderOffset)
+		}
+	}
+
+	for i, v := range []struct {
+		name   string
+		target int
+	}{
+		{"file", 4},
+		{"/link1", 0},
+		{"link2", 0},
+		{"link3", 4},
+		{"dangling", -1},
+	} {
+		got, ok := lr.Lookup(v.name)
+		if v.target < 0 {
+			if ok {
+				t.Errorf("test %d, Lookup(%q) = %q, want not found", i, v.name, got.Header.Name)
+			}
+		} else if !ok || got != toc[v.target] {
+			t.Errorf("test %d, Lookup(%q) = (%v, %v), want entry at offset %d", i, v.name, got.HeaderOffset, ok, toc[v.target].HeaderOffset)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import "io"
+
+// A TOCEntry is an entry in the table of contents of an archive.
+type TOCEntry struct {
+	Header *Header
+
+	// HeaderOffset is the offset of the first header block of the entry,
+	// including any PAX or GNU meta headers that precede it.
+	HeaderOffset int64
+
+	// DataOffset is the offset of the data of the entry.
+	// For sparse files, this is the offset of the physical data,
+	// after any sparse map stored in the data section.
+	DataOffset int64
+}
+
+// List returns the table of contents of the archive read from r,
+// which contains an entry for each header returned by Reader.Next.
+// Offsets are relative to the position of r when List is called.
+//
+// List does not read the data of the entries if r is an io.Seeker,
+// so listing a large archive on disk only reads its headers.
+func List(r io.Reader) ([]TOCEntry, error) {
+	cr := &countReader{r: r}
+	var tr *Reader
+	if s, ok := r.(io.Seeker); ok {
+		if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+			tr = NewReader(&countReadSeeker{cr, s, pos})
+		}
+	}
+	if tr == nil {
+		tr = NewReader(cr)
+	}
+
+	var toc []TOCEntry
+	for {
+		off := cr.n + tr.curr.PhysicalRemaining() + tr.pad
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return toc, nil
+		}
+		if err != nil {
+			return toc, err
+		}
+		toc = append(toc, TOCEntry{Header: hdr, HeaderOffset: off, DataOffset: cr.n})
+	}
+}
+
+// countReader counts the number of bytes read from r.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countReadSeeker is a countReader that also supports seeking,
+// where start is the position of s when counting began.
+type countReadSeeker struct {
+	*countReader
+	s     io.Seeker
+	start int64
+}
+
+func (cr *countReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := cr.s.Seek(offset, whence)
+	if err == nil {
+		cr.n = pos - cr.start
+	}
+	return pos, err
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readOnly hides all methods of an io.Reader other than Read.
+type readOnly struct{ io.Reader }
+
+func TestList(t *testing.T) {
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	entries := []struct {
+		hdr  Header
+		data string
+	}{
+		{Header{Name: "small", Typeflag: TypeReg, Size: 5}, "hello"},
+		{Header{Name: "dir/", Typeflag: TypeDir}, ""},
+		{Header{Name: strings.Repeat("long/", 40) + "file", Typeflag: TypeReg, Size: 600}, strings.Repeat("x", 600)},
+		{Header{Name: "last", Typeflag: TypeReg, Size: 1}, "!"},
+	}
+	var wantOffsets [][2]int64
+	for _, e := range entries {
+		tw.Flush()
+		hdrOff := int64(b.Len())
+		if err := tw.WriteHeader(&e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Flush()
+		wantOffsets = append(wantOffsets, [2]int64{hdrOff, int64(b.Len())})
+		io.WriteString(tw, e.data)
+	}
+	tw.Close()
+
+	input := b.Bytes()
+	for _, r := range []io.Reader{bytes.NewReader(input), readOnly{bytes.NewReader(input)}} {
+		toc, err := List(r)
+		if err != nil {
+			t.Fatalf("%T: List() error: %v", r, err)
+		}
+		if len(toc) != len(entries) {
+			t.Fatalf("%T: List() returned %d entries, want %d", r, len(toc), len(entries))
+		}
+		for i, e := range toc {
+			if e.Header.Name != entries[i].hdr.Name {
+				t.Errorf("%T: entry %d, Name = %q, want %q", r, i, e.Header.Name, entries[i].hdr.Name)
+			}
+			if got := [2]int64{e.HeaderOffset, e.DataOffset}; got != wantOffsets[i] {
+				t.Errorf("%T: entry %d, offsets = %v, want %v", r, i, got, wantOffsets[i])
+			}
+			data := input[e.DataOffset : e.DataOffset+e.Header.Size]
+			if string(data) != entries[i].data {
+				t.Errorf("%T: entry %d, data at DataOffset = %q, want %q", r, i, data, entries[i].data)
+			}
+		}
+	}
+
+	// List reports the entries read before an error.
+	toc, err := List(bytes.NewReader(input[:wantOffsets[3][0]+100]))
+	if err == nil || len(toc) != 3 {
+		t.Errorf("List(truncated) = (%d entries, %v), want (3 entries, error)", len(toc), err)
+	}
+
+	// The headers match those returned by Reader.Next.
+	f, err := ioutil.ReadFile("testdata/sparse-formats.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	toc, err = List(bytes.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewReader(bytes.NewReader(f))
+	for i, e := range toc {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(e.Header, hdr) {
+			t.Errorf("entry %d, Header = %+v, want %+v", i, e.Header, hdr)
+		}
+	}
+}
+
+func TestWriterTOC(t *testing.T) {
+	var src bytes.Buffer
+	tw := NewWriter(&src)
+	tw.WriteHeader(&Header{Name: "copied", Typeflag: TypeReg, Size: 3, Xattrs: map[string]string{"user.a": "b"}})
+	tw.Write([]byte("abc"))
+	tw.Close()
+
+	for _, blocking := range []int{0, 20} {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		tw.BuildTOC = true
+		tw.BlockingFactor = blocking
+		tw.WriteHeader(&Header{Name: "small", Typeflag: TypeReg, Size: 5})
+		tw.Write([]byte("hello"))
+		tw.WriteHeader(&Header{Name: "dir/", Typeflag: TypeDir})
+		tw.WriteHeader(&Header{Name: strings.Repeat("long/", 40) + "file", Typeflag: TypeReg, Size: 600})
+		tw.Write([]byte(strings.Repeat("x", 600)))
+		tr := NewReader(bytes.NewReader(src.Bytes()))
+		if _, err := tr.Next(); err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		if _, err := tw.CopyFrom(tr); err != nil {
+			t.Fatalf("CopyFrom() error: %v", err)
+		}
+		tw.WriteHeader(&Header{Name: "last", Typeflag: TypeReg, Size: 1})
+		tw.Write([]byte("!"))
+		if err := tw.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+
+		want, err := List(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatalf("List() error: %v", err)
+		}
+		got := tw.TOC()
+		if len(got) != len(want) {
+			t.Fatalf("blocking factor %d, len(TOC()) = %d, want %d", blocking, len(got), len(want))
+		}
+		for i := range got {
+			g, w := got[i], want[i]
+			if g.Header.Name != w.Header.Name || g.Header.Size != w.Header.Size || g.HeaderOffset != w.HeaderOffset || g.DataOffset != w.DataOffset {
+				t.Errorf("blocking factor %d, TOC()[%d] = %q, %d, %d; want %q, %d, %d", blocking, i,
+					g.Header.Name, g.HeaderOffset, g.DataOffset, w.Header.Name, w.HeaderOffset, w.DataOffset)
+			}
+			if !reflect.DeepEqual(g.Header.Xattrs, w.Header.Xattrs) {
+				t.Errorf("blocking factor %d, TOC()[%d].Header.Xattrs = %v, want %v", blocking, i, g.Header.Xattrs, w.Header.Xattrs)
+			}
+		}
+	}
+
+	tw = NewWriter(ioutil.Discard)
+	tw.BuildTOC = true
+	tw.VolumeSize = 1 << 20
+	tw.NextVolume = func(int) (io.Writer, error) { return ioutil.Discard, nil }
+	if err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg}); err == nil {
+		t.Errorf("WriteHeader() with BuildTOC and volumes succeeded, want error")
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A ManifestEntry records the metadata of an entry of an archive,
+// as protected by a Manifest.
+type ManifestEntry struct {
+	Name     string
+	Typeflag byte  // TypeRegA is recorded as TypeReg
+	Mode     int64 // Permission and mode bits, as in Header.Mode
+	Size     int64 // Length of the data of the entry
+
+	// Digest is the name of the hash function followed by a colon and the
+	// digest in hexadecimal, such as "sha256:2cf24dba...". For links, the
+	// Linkname is hashed before the data, so that the target is protected.
+	// Digest is empty if the data of the entry is unknown.
+	Digest string
+}
+
+// A Manifest lists the entries of an archive, in order, with the digests of
+// their data, so that the archive can be verified with VerifyManifest.
+// It is produced by a Writer with ManifestHash set.
+//
+// The text encoding of a Manifest is stable, and suitable for signing.
+// It holds one line per entry, with the digest ("-" if empty), the Typeflag,
+// the mode in octal, the size and the name as a Go string literal,
+// separated by spaces.
+type Manifest struct {
+	Entries []ManifestEntry
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m *Manifest) MarshalText() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, e := range m.Entries {
+		if e.Typeflag <= ' ' || e.Typeflag > '~' {
+			return nil, fmt.Errorf("archive/tar: cannot encode type flag %q of %s in a manifest", e.Typeflag, e.Name)
+		}
+		digest := e.Digest
+		if digest == "" {
+			digest = "-"
+		} else if strings.ContainsAny(digest, " \n") {
+			return nil, fmt.Errorf("archive/tar: invalid digest %q of %s", digest, e.Name)
+		}
+		fmt.Fprintf(&buf, "%s %c %o %d %s\n", digest, e.Typeflag, e.Mode, e.Size, strconv.Quote(e.Name))
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (m *Manifest) UnmarshalText(b []byte) error {
+	var entries []ManifestEntry
+	for i, line := range strings.SplitAfter(string(b), "\n") {
+		if line == "" {
+			break
+		}
+		f := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 5)
+		if len(f) != 5 || len(f[1]) != 1 {
+			return fmt.Errorf("archive/tar: invalid manifest line %d", i+1)
+		}
+		e := ManifestEntry{Digest: f[0], Typeflag: f[1][0]}
+		if e.Digest == "-" {
+			e.Digest = ""
+		}
+		var err1, err2, err3 error
+		e.Mode, err1 = strconv.ParseInt(f[2], 8, 64)
+		e.Size, err2 = strconv.ParseInt(f[3], 10, 64)
+		e.Name, err3 = strconv.Unquote(f[4])
+		if err1 != nil || err2 != nil || err3 != nil || e.Size < 0 {
+			return fmt.Errorf("archive/tar: invalid manifest line %d", i+1)
+		}
+		entries = append(entries, e)
+	}
+	m.Entries = entries
+	return nil
+}
+
+// manifestEntry is an entry of a manifest whose digest is being computed.
+type manifestEntry struct {
+	ManifestEntry
+	h      hash.Hash // Nil if the digest is unknown
+	prefix string    // Prefix of the digest, such as "sha256:"
+}
+
+// newManifestEntry returns a manifestEntry for hdr computing its digest with
+// the hash function h, or without a digest if h is zero.
+func newManifestEntry(hdr *Header, h crypto.Hash) (*manifestEntry, error) {
+	e := &manifestEntry{ManifestEntry: ManifestEntry{Name: hdr.Name, Typeflag: hdr.Typeflag, Mode: hdr.Mode}}
+	if e.Typeflag == TypeRegA {
+		e.Typeflag = TypeReg
+	}
+	if h == 0 {
+		return e, nil
+	}
+	name, err := digestName(h)
+	if err != nil {
+		return nil, err
+	}
+	e.h, e.prefix = h.New(), name+":"
+	if hdr.Typeflag == TypeLink || hdr.Typeflag == TypeSymlink {
+		io.WriteString(e.h, hdr.Linkname)
+	}
+	return e, nil
+}
+
+// Write hashes and counts the data of the entry.
+func (e *manifestEntry) Write(b []byte) (int, error) {
+	if e.h != nil {
+		e.h.Write(b)
+	}
+	e.Size += int64(len(b))
+	return len(b), nil
+}
+
+// finish returns the ManifestEntry once all of the data has been written.
+func (e *manifestEntry) finish() ManifestEntry {
+	if e.h != nil {
+		e.Digest = fmt.Sprintf("%s%x", e.prefix, e.h.Sum(nil))
+	}
+	return e.ManifestEntry
+}
+
+// manifestEntry returns a manifestEntry for recording hdr in the manifest of
+// tw, or nil if ManifestHash is not set. It becomes the current entry of the
+// manifest once the header is written, and is recorded by Flush.
+func (tw *Writer) manifestEntry(hdr *Header) (*manifestEntry, error) {
+	if tw.ManifestHash == 0 || hdr.Typeflag == TypeXGlobalHeader {
+		return nil, nil
+	}
+	return newManifestEntry(hdr, tw.ManifestHash)
+}
+
+// finishManifestEntry records the current entry in the manifest of tw.
+func (tw *Writer) finishManifestEntry() {
+	if tw.manifest == nil {
+		tw.manifest = new(Manifest)
+	}
+	tw.manifest.Entries = append(tw.manifest.Entries, tw.mentry.finish())
+	tw.mentry = nil
+}
+
+// Manifest returns the manifest of the archive written so far if
+// ManifestHash is set, listing each entry written, including those copied
+// with CopyFrom, other than global PAX headers. It is complete once Close
+// has been called.
+func (tw *Writer) Manifest() *Manifest {
+	if tw.manifest == nil && tw.ManifestHash != 0 {
+		tw.manifest = new(Manifest)
+	}
+	return tw.manifest
+}
+
+// A ManifestStatus is the outcome of verifying an entry against a Manifest.
+type ManifestStatus int
+
+const (
+	// ManifestOK indicates that the entry matches the manifest.
+	ManifestOK ManifestStatus = iota
+
+	// ManifestMismatch indicates that the type, mode, size or data of the
+	// entry differ from those recorded in the manifest.
+	ManifestMismatch
+
+	// ManifestMissing indicates that the manifest lists an entry
+	// that is not in the archive.
+	ManifestMissing
+
+	// ManifestUnexpected indicates that the archive has an entry
+	// that is not listed in the manifest.
+	ManifestUnexpected
+)
+
+var manifestStatusNames = []string{"ok", "mismatch", "missing", "unexpected"}
+
+func (s ManifestStatus) String() string {
+	if s < 0 || int(s) >= len(manifestStatusNames) {
+		return "ManifestStatus(" + strconv.Itoa(int(s)) + ")"
+	}
+	return manifestStatusNames[s]
+}
+
+// A ManifestResult reports the outcome of verifying an entry.
+type ManifestResult struct {
+	Name   string
+	Status ManifestStatus
+	Want   *ManifestEntry // Entry in the manifest, or nil if ManifestUnexpected
+	Got    *ManifestEntry // Entry in the archive, or nil if ManifestMissing
+}
+
+// A ManifestReport is the result of VerifyManifest, with a ManifestResult
+// for each entry of the archive, in order, followed by one for each entry
+// of the manifest missing from the archive.
+type ManifestReport []ManifestResult
+
+// OK reports whether all of the entries of the report are ManifestOK.
+func (r ManifestReport) OK() bool {
+	for _, res := range r {
+		if res.Status != ManifestOK {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyManifest reads the archive from r and verifies each of its entries
+// against m. Entries are matched by name, in order if the name is repeated,
+// and their data is hashed with the hash function named by the digest in m,
+// which must be linked into the binary, as with Writer.Digests. Entries of
+// m without a digest are verified without their data. Global PAX headers
+// are not verified.
+//
+// A non-nil error is returned if the archive cannot be read, in which case
+// the report covers the entries read so far.
+func VerifyManifest(r io.Reader, m *Manifest) (ManifestReport, error) {
+	want := make(map[string][]int)
+	for i, e := range m.Entries {
+		want[e.Name] = append(want[e.Name], i)
+	}
+	found := make([]bool, len(m.Entries))
+
+	var report ManifestReport
+	tr := NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != ErrInsecurePath {
+			return report, err
+		}
+		if hdr.Typeflag == TypeXGlobalHeader {
+			continue
+		}
+
+		res := ManifestResult{Name: hdr.Name, Status: ManifestUnexpected}
+		var h crypto.Hash
+		if q := want[hdr.Name]; len(q) > 0 {
+			want[hdr.Name] = q[1:]
+			found[q[0]] = true
+			res.Want = &m.Entries[q[0]]
+			if h, err = manifestHash(res.Want.Digest); err != nil {
+				return report, err
+			}
+		}
+		e, err := newManifestEntry(hdr, h)
+		if err != nil {
+			return report, err
+		}
+		if _, err := io.Copy(e, tr); err != nil {
+			return report, err
+		}
+		got := e.finish()
+		res.Got = &got
+		if w := res.Want; w != nil {
+			res.Status = ManifestMismatch
+			if w.Typeflag == got.Typeflag && w.Mode == got.Mode && w.Size == got.Size && strings.EqualFold(w.Digest, got.Digest) {
+				res.Status = ManifestOK
+			}
+		}
+		report = append(report, res)
+	}
+	for i := range m.Entries {
+		if !found[i] {
+			report = append(report, ManifestResult{Name: m.Entries[i].Name, Status: ManifestMissing, Want: &m.Entries[i]})
+		}
+	}
+	return report, nil
+}
+
+// manifestHash returns the hash function named by digest,
+// or zero if digest is empty.
+func manifestHash(digest string) (crypto.Hash, error) {
+	if digest == "" {
+		return 0, nil
+	}
+	i := strings.IndexByte(digest, ':')
+	if i < 0 {
+		return 0, errors.New("archive/tar: invalid digest " + digest + " in manifest")
+	}
+	h, ok := digestAlgorithms[digest[:i]]
+	if !ok || !h.Available() {
+		return 0, errors.New("archive/tar: unsupported digest algorithm " + digest[:i] + " in manifest")
+	}
+	return h, nil
+}
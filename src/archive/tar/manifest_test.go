@@ -0,0 +1,209 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	var buf bytes.Buffer
+	tw := NewWriter(&buf)
+	tw.ManifestHash = crypto.SHA256
+	entries := []testEntry{
+		{hdr: Header{Name: "dir/", Typeflag: TypeDir, Mode: 0755}},
+		{hdr: Header{Name: "dir/file", Typeflag: TypeReg, Mode: 0644, Size: 5}, body: "hello"},
+		{hdr: Header{Name: "dir/read", Typeflag: TypeReg, Mode: 0600, Size: 4}, body: "data"},
+		{hdr: Header{Name: "sym", Typeflag: TypeSymlink, Linkname: "dir/file"}},
+		{hdr: Header{Name: "link", Typeflag: TypeLink, Linkname: "dir/file"}},
+	}
+	if err := tw.WriteGlobalPAXRecords(map[string]string{"comment": "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := tw.WriteHeader(&e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.hdr.Name == "dir/read" {
+			_, err := tw.ReadFrom(strings.NewReader(e.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else if _, err := io.WriteString(tw, e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := func(s string) string { return "sha256:" + hexSHA256(s) }
+	want := []ManifestEntry{
+		{"dir/", TypeDir, 0755, 0, sum("")},
+		{"dir/file", TypeReg, 0644, 5, sum("hello")},
+		{"dir/read", TypeReg, 0600, 4, sum("data")},
+		{"sym", TypeSymlink, 0, 0, sum("dir/file")},
+		{"link", TypeLink, 0, 0, sum("dir/file")},
+	}
+	m := tw.Manifest()
+	if !reflect.DeepEqual(m.Entries, want) {
+		t.Fatalf("Manifest() = %+v, want %+v", m.Entries, want)
+	}
+
+	text, err := m.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText() error: %v", err)
+	}
+	if line := "sha256:" + hexSHA256("hello") + " 0 644 5 \"dir/file\"\n"; !strings.Contains(string(text), line) {
+		t.Errorf("MarshalText() = %q, want line %q", text, line)
+	}
+	var m2 Manifest
+	if err := m2.UnmarshalText(text); err != nil {
+		t.Fatalf("UnmarshalText() error: %v", err)
+	}
+	if !reflect.DeepEqual(m2.Entries, want) {
+		t.Errorf("UnmarshalText() = %+v, want %+v", m2.Entries, want)
+	}
+
+	report, err := VerifyManifest(bytes.NewReader(buf.Bytes()), m)
+	if err != nil {
+		t.Fatalf("VerifyManifest() error: %v", err)
+	}
+	if len(report) != len(want) || !report.OK() {
+		t.Errorf("VerifyManifest() = %+v, want %d entries ok", report, len(want))
+	}
+
+	// Verify a modified archive.
+	src := makeArchive(t,
+		testEntry{hdr: Header{Name: "dir/", Typeflag: TypeDir, Mode: 0700}},
+		testEntry{hdr: Header{Name: "dir/file", Typeflag: TypeReg, Mode: 0644}, body: "HELLO"},
+		testEntry{hdr: Header{Name: "dir/read", Typeflag: TypeReg, Mode: 0600}, body: "data"},
+		testEntry{hdr: Header{Name: "sym", Typeflag: TypeSymlink, Linkname: "/etc/passwd"}},
+		testEntry{hdr: Header{Name: "extra", Typeflag: TypeReg, Mode: 0644}, body: "extra"},
+	)
+	report, err = VerifyManifest(bytes.NewReader(src), m)
+	if err != nil {
+		t.Fatalf("VerifyManifest() error: %v", err)
+	}
+	wantStatus := []struct {
+		name   string
+		status ManifestStatus
+	}{
+		{"dir/", ManifestMismatch},
+		{"dir/file", ManifestMismatch},
+		{"dir/read", ManifestOK},
+		{"sym", ManifestMismatch},
+		{"extra", ManifestUnexpected},
+		{"link", ManifestMissing},
+	}
+	if len(report) != len(wantStatus) {
+		t.Fatalf("VerifyManifest() = %+v, want %d results", report, len(wantStatus))
+	}
+	for i, v := range wantStatus {
+		if res := report[i]; res.Name != v.name || res.Status != v.status {
+			t.Errorf("test %d, got %q %v, want %q %v", i, res.Name, res.Status, v.name, v.status)
+		}
+	}
+	if report.OK() {
+		t.Errorf("OK() = true, want false")
+	}
+	if r := report[1]; r.Want.Digest == r.Got.Digest || r.Got.Size != 5 {
+		t.Errorf("got %+v for modified file, want different digest", r.Got)
+	}
+}
+
+func hexSHA256(s string) string {
+	h := crypto.SHA256.New()
+	io.WriteString(h, s)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func TestManifestCopyFrom(t *testing.T) {
+	src, err := ioutil.ReadFile("testdata/sparse-formats.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewReader(bytes.NewReader(src))
+	tw := NewWriter(ioutil.Discard)
+	tw.ManifestHash = crypto.SHA256
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.CopyFrom(tr); err != nil {
+			t.Fatalf("CopyFrom() error: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := tw.Manifest()
+	report, err := VerifyManifest(bytes.NewReader(src), m)
+	if err != nil {
+		t.Fatalf("VerifyManifest() error: %v", err)
+	}
+	var sparse int
+	for i, res := range report {
+		if res.Status != ManifestOK {
+			t.Errorf("test %d, %s: got %v, want ok (%+v, %+v)", i, res.Name, res.Status, res.Want, res.Got)
+		}
+		if res.Want.Digest == "" {
+			sparse++
+		}
+	}
+	if sparse == 0 || sparse == len(report) {
+		t.Errorf("got %d of %d entries without digests", sparse, len(report))
+	}
+}
+
+func TestManifestUnmarshalText(t *testing.T) {
+	vectors := []struct {
+		in string
+		ok bool
+	}{
+		{"", true},
+		{"- 5 755 0 \"dir\"\n", true},
+		{"sha256:00 0 644 0 \"a b\"\n- 2 777 0 \"c\"\n", true},
+		{"sha256:00 0 644 0 \"a\"", true},
+		{"sha256:00 0 644 0 a\n", false},
+		{"sha256:00 0 648 0 \"a\"\n", false},
+		{"sha256:00 0 644 -1 \"a\"\n", false},
+		{"sha256:00 00 644 0 \"a\"\n", false},
+		{"sha256:00 0 644\n", false},
+	}
+	for i, v := range vectors {
+		var m Manifest
+		err := m.UnmarshalText([]byte(v.in))
+		if (err == nil) != v.ok {
+			t.Errorf("test %d, UnmarshalText(%q) error: %v, want ok %v", i, v.in, err, v.ok)
+		}
+		if err != nil {
+			continue
+		}
+		if out, err := m.MarshalText(); err != nil || strings.TrimSuffix(string(out), "\n") != strings.TrimSuffix(v.in, "\n") {
+			t.Errorf("test %d, MarshalText() = %q, %v, want %q", i, out, err, v.in)
+		}
+	}
+
+	m := Manifest{Entries: []ManifestEntry{{Name: "a", Typeflag: '\n'}}}
+	if _, err := m.MarshalText(); err == nil {
+		t.Errorf("MarshalText() of invalid type flag succeeded")
+	}
+	if _, err := VerifyManifest(bytes.NewReader(makeArchive(t, testEntry{hdr: Header{Name: "a", Typeflag: TypeReg}})),
+		&Manifest{Entries: []ManifestEntry{{Name: "a", Typeflag: TypeReg, Digest: "crc32:00"}}}); err == nil {
+		t.Errorf("VerifyManifest() with unsupported algorithm succeeded")
+	}
+}
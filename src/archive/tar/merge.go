@@ -0,0 +1,150 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+)
+
+// A MergePolicy determines which entry Merge keeps when several entries
+// of the archives being merged have the same name.
+type MergePolicy int
+
+const (
+	// MergeFirstWins keeps the first entry with a given name.
+	MergeFirstWins MergePolicy = iota
+
+	// MergeLastWins keeps the last entry with a given name, as extracting
+	// the archives one after the other would.
+	MergeLastWins
+
+	// MergeErrorOnDuplicate causes Merge to fail if two entries other than
+	// directories have the same name. Of several entries for a directory,
+	// the first is kept.
+	MergeErrorOnDuplicate
+)
+
+// Merge writes to w a single archive holding the entries of the archives
+// read from rs, in order, keeping only one entry for each name, chosen
+// according to policy. Names are compared after cleaning them with
+// path.Clean, so that "dir" and "dir/" are the same. Global PAX headers
+// are always kept.
+//
+// As with Concat, the entries are copied with Writer.CopyFrom, preserving
+// their original encoding, and the archives are read sequentially, so that
+// they may be streams. The exception is MergeLastWins, for which every
+// reader must implement io.Seeker, as the names of the entries of all of
+// the archives are read first, and the archives are then read again from
+// their current offset to copy the entries.
+//
+// With MergeErrorOnDuplicate, the entries preceding the duplicate have been
+// written to w when Merge returns an error.
+func Merge(w io.Writer, policy MergePolicy, rs ...io.Reader) error {
+	var m merger
+	switch policy {
+	case MergeFirstWins, MergeErrorOnDuplicate:
+		m.seen = make(map[string]bool)
+	case MergeLastWins:
+		if err := m.findLast(rs); err != nil {
+			return err
+		}
+	default:
+		return errors.New("archive/tar: invalid MergePolicy")
+	}
+	m.policy = policy
+
+	tw := NewWriter(w)
+	for i, r := range rs {
+		tr := NewReader(r)
+		for j := 0; ; j++ {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil && err != ErrInsecurePath {
+				return err
+			}
+			keep, err := m.keep(hdr, mergePos{i, j})
+			if err != nil {
+				return err
+			}
+			if !keep {
+				continue
+			}
+			if _, err := tw.CopyFrom(tr); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// mergePos is the position of an entry in the archives passed to Merge.
+type mergePos struct {
+	archive, entry int
+}
+
+type merger struct {
+	policy MergePolicy
+	seen   map[string]bool     // Whether names already written are directories
+	last   map[string]mergePos // Position of the last entry with each name
+}
+
+// findLast reads the names of the entries of the archives from rs,
+// recording the position of the last entry with each name, and then seeks
+// each reader back to where it was.
+func (m *merger) findLast(rs []io.Reader) error {
+	m.last = make(map[string]mergePos)
+	for i, r := range rs {
+		s, ok := r.(io.Seeker)
+		if !ok {
+			return fmt.Errorf("archive/tar: MergeLastWins requires io.Seeker, archive %d is not", i)
+		}
+		start, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		tr := NewReader(r)
+		for j := 0; ; j++ {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil && err != ErrInsecurePath {
+				return err
+			}
+			if hdr.Typeflag != TypeXGlobalHeader {
+				m.last[path.Clean(hdr.Name)] = mergePos{i, j}
+			}
+		}
+		if _, err := s.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keep reports whether the entry with hdr at pos is to be written.
+func (m *merger) keep(hdr *Header, pos mergePos) (bool, error) {
+	if hdr.Typeflag == TypeXGlobalHeader {
+		return true, nil
+	}
+	name := path.Clean(hdr.Name)
+	if m.policy == MergeLastWins {
+		return m.last[name] == pos, nil
+	}
+	isDir, ok := m.seen[name]
+	if !ok {
+		m.seen[name] = hdr.Typeflag == TypeDir
+		return true, nil
+	}
+	if m.policy == MergeErrorOnDuplicate && !(isDir && hdr.Typeflag == TypeDir) {
+		return false, fmt.Errorf("archive/tar: duplicate entry %s in archive %d", hdr.Name, pos.archive)
+	}
+	return false, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMerge(t *testing.T) {
+	reg := func(name, body string) testEntry {
+		return testEntry{hdr: Header{Name: name, Typeflag: TypeReg, Mode: 0644}, body: body}
+	}
+	dir := func(name string) testEntry {
+		return testEntry{hdr: Header{Name: name, Typeflag: TypeDir, Mode: 0755}}
+	}
+	a := makeArchive(t, dir("dir/"), reg("dir/x", "a1"), reg("y", "a2"))
+	b := makeArchive(t, dir("dir"), reg("dir/x", "b1"), reg("z", "b2"))
+	c := makeArchive(t, reg("./y", "c1"), reg("y", "c2"))
+
+	vectors := []struct {
+		policy  MergePolicy
+		archs   [][]byte
+		want    []string // Name and body of each entry
+		wantErr string
+	}{{
+		policy: MergeFirstWins,
+		archs:  [][]byte{a, b, c},
+		want:   []string{"dir/", "dir/x:a1", "y:a2", "z:b2"},
+	}, {
+		policy: MergeLastWins,
+		archs:  [][]byte{a, b, c},
+		want:   []string{"dir", "dir/x:b1", "z:b2", "y:c2"},
+	}, {
+		policy:  MergeErrorOnDuplicate,
+		archs:   [][]byte{a, b},
+		wantErr: "duplicate entry dir/x in archive 1",
+	}, {
+		policy:  MergeErrorOnDuplicate,
+		archs:   [][]byte{c},
+		wantErr: "duplicate entry y in archive 0",
+	}, {
+		policy: MergeErrorOnDuplicate,
+		archs:  [][]byte{makeArchive(t, dir("dir/"), reg("x", "")), makeArchive(t, dir("dir"), reg("dir/x", ""))},
+		want:   []string{"dir/", "x:", "dir/x:"},
+	}, {
+		policy:  MergeErrorOnDuplicate,
+		archs:   [][]byte{makeArchive(t, reg("dir", "")), makeArchive(t, dir("dir/"))},
+		wantErr: "duplicate entry dir/ in archive 1",
+	}, {
+		policy:  MergePolicy(-1),
+		wantErr: "invalid MergePolicy",
+	}}
+
+	for i, v := range vectors {
+		var rs []io.Reader
+		for _, arch := range v.archs {
+			rs = append(rs, bytes.NewReader(arch))
+		}
+		var out bytes.Buffer
+		err := Merge(&out, v.policy, rs...)
+		if v.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), v.wantErr) {
+				t.Errorf("test %d, Merge() error: %v, want %q", i, err, v.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d, Merge() error: %v", i, err)
+			continue
+		}
+		var got []string
+		tr := NewReader(&out)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+			entry := hdr.Name
+			if hdr.Typeflag == TypeReg {
+				body, err := ioutil.ReadAll(tr)
+				if err != nil {
+					t.Fatalf("test %d, ReadAll() error: %v", i, err)
+				}
+				entry += ":" + string(body)
+			}
+			got = append(got, entry)
+		}
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("test %d, merged entries = %q, want %q", i, got, v.want)
+		}
+	}
+
+	// MergeLastWins needs to read the archives twice.
+	err := Merge(ioutil.Discard, MergeLastWins, bytes.NewReader(a), iotest.HalfReader(bytes.NewReader(b)))
+	if err == nil || !strings.Contains(err.Error(), "io.Seeker") {
+		t.Errorf("Merge(MergeLastWins) of a stream: got error %v, want error about io.Seeker", err)
+	}
+}
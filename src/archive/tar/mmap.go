@@ -0,0 +1,111 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"errors"
+	"os"
+)
+
+// A MappedFile is a tar archive file that is mapped into memory,
+// which allows entries to be read without copying them into
+// separate buffers. Its methods other than Close may be called
+// concurrently. Close must not be called concurrently with them,
+// nor while Readers returned by NewReader or slices returned by Data
+// are still in use, since it unmaps the memory they refer to.
+//
+// The file must not be truncated while it is mapped: accessing the pages
+// past its new end raises SIGBUS, which crashes the program.
+//
+// On platforms without memory mapping, the file is read into memory.
+type MappedFile struct {
+	data []byte
+	mmap bool // Whether data must be unmapped
+}
+
+// OpenMapped opens the named archive file and maps it into memory.
+// Large archives can only be mapped on 64-bit platforms.
+func OpenMapped(name string) (*MappedFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size < 0 || size != int64(int(size)) {
+		return nil, &os.PathError{Op: "mmap", Path: name, Err: errors.New("file too large")}
+	}
+	if size == 0 {
+		return &MappedFile{}, nil
+	}
+	data, mmap, err := mapFile(f, int(size))
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: name, Err: err}
+	}
+	return &MappedFile{data: data, mmap: mmap}, nil
+}
+
+// Len returns the size of the archive in bytes.
+func (m *MappedFile) Len() int {
+	return len(m.data)
+}
+
+// NewReader returns a Reader for the archive. Since the Reader can seek in
+// the mapped data, skipping the data of an entry does not touch its pages.
+// The Reader must not be used after m is closed.
+func (m *MappedFile) NewReader() *Reader {
+	return NewReader(bytes.NewReader(m.data))
+}
+
+// List returns the table of contents of the archive.
+func (m *MappedFile) List() ([]TOCEntry, error) {
+	return List(bytes.NewReader(m.data))
+}
+
+// Data returns the data of the entry e, which must have been returned by
+// m.List, as a slice of the mapped memory. The slice must not be modified,
+// and must not be used after m is closed.
+// It reports an error for sparse files, whose data is not stored contiguously.
+func (m *MappedFile) Data(e TOCEntry) ([]byte, error) {
+	hdr := e.Header
+	if isSparseHeader(hdr) {
+		return nil, errors.New("archive/tar: cannot map data of sparse file " + hdr.Name)
+	}
+	size := hdr.Size
+	if isHeaderOnlyType(hdr.Typeflag) {
+		size = 0
+	}
+	if e.DataOffset < 0 || size < 0 || e.DataOffset > int64(len(m.data)) || size > int64(len(m.data))-e.DataOffset {
+		return nil, ErrHeader
+	}
+	return m.data[e.DataOffset : e.DataOffset+size : e.DataOffset+size], nil
+}
+
+// Close unmaps the archive. Readers returned by NewReader and slices
+// returned by Data must not be used afterwards.
+func (m *MappedFile) Close() error {
+	data := m.data
+	m.data = nil
+	if m.mmap && data != nil {
+		return unmapFile(data)
+	}
+	return nil
+}
+
+// isSparseHeader reports whether hdr, as returned by Reader.Next,
+// describes a file stored in one of the sparse formats.
+func isSparseHeader(hdr *Header) bool {
+	if hdr.Typeflag == TypeGNUSparse {
+		return true
+	}
+	_, major := hdr.PAXRecords[paxGNUSparseMajor]
+	_, spmap := hdr.PAXRecords[paxGNUSparseMap]
+	return major || spmap
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMappedFile(t *testing.T) {
+	for _, file := range []string{"testdata/gnu.tar", "testdata/sparse-formats.tar", "testdata/hardlink.tar"} {
+		m, err := OpenMapped(file)
+		if err != nil {
+			t.Fatalf("OpenMapped(%q) error: %v", file, err)
+		}
+		toc, err := m.List()
+		if err != nil {
+			t.Fatalf("%s: List() error: %v", file, err)
+		}
+		tr := m.NewReader()
+		for i, e := range toc {
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatalf("%s: entry %d, Next() error: %v", file, i, err)
+			}
+			want, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("%s: entry %d, ReadAll() error: %v", file, i, err)
+			}
+			got, err := m.Data(e)
+			if sparse := isSparseHeader(hdr); sparse != (err != nil) {
+				t.Errorf("%s: entry %d, Data() error: %v, want error %v", file, i, err, sparse)
+			} else if err == nil && !bytes.Equal(got, want) {
+				t.Errorf("%s: entry %d, Data() = %q, want %q", file, i, got, want)
+			}
+		}
+		if err := m.Close(); err != nil {
+			t.Errorf("%s: Close() error: %v", file, err)
+		}
+	}
+
+	if _, err := OpenMapped("testdata/does-not-exist.tar"); err == nil {
+		t.Errorf("OpenMapped(missing file) succeeded")
+	}
+}
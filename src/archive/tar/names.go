@@ -0,0 +1,149 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// A NameResolver translates between the numeric IDs of users and groups and
+// their names, as recorded in the Uid, Gid, Uname and Gname fields of a
+// Header. Each method reports false if the ID or name is unknown.
+//
+// AddDir and AddFile use a NameResolver to name the owner of files (see
+// AddNames), and Extract uses one to find the IDs of the users and groups
+// named by entries (see ExtractNames). The methods are never called with
+// an empty name.
+type NameResolver interface {
+	UserName(uid int) (string, bool)
+	GroupName(gid int) (string, bool)
+	UserID(name string) (int, bool)
+	GroupID(name string) (int, bool)
+}
+
+// systemNames is the resolver returned by SystemNames.
+var systemNames = CacheNames(osNames{})
+
+// SystemNames returns a NameResolver that looks up the users and groups of
+// the system with the os/user package. Lookups are cached for the lifetime
+// of the process, so that renaming a user or group has no effect once it
+// has been looked up. This is the default resolver of Extract, and the one
+// FileInfoHeader uses to fill in Uname and Gname on Unix systems.
+func SystemNames() NameResolver {
+	return systemNames
+}
+
+// NumericNames returns a NameResolver that knows no names, like the
+// --numeric-owner option of GNU tar: files are archived with empty Uname
+// and Gname fields, and extracted using the Uid and Gid of their entries.
+func NumericNames() NameResolver {
+	return numericNames{}
+}
+
+// CacheNames returns a NameResolver that caches the results of r, including
+// failed lookups. It is safe for concurrent use if r is.
+func CacheNames(r NameResolver) NameResolver {
+	return &cachedNames{r: r}
+}
+
+// osNames resolves names with the os/user package.
+// The os/user functions may fail for any number of reasons
+// (not implemented on that platform, cgo not enabled, etc).
+type osNames struct{}
+
+func (osNames) UserName(uid int) (string, bool) {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", false
+	}
+	return u.Username, true
+}
+
+func (osNames) GroupName(gid int) (string, bool) {
+	g, err := user.LookupGroupId(strconv.Itoa(gid))
+	if err != nil {
+		return "", false
+	}
+	return g.Name, true
+}
+
+func (osNames) UserID(name string) (int, bool) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(u.Uid) // Fails for Windows SIDs
+	return id, err == nil
+}
+
+func (osNames) GroupID(name string) (int, bool) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(g.Gid)
+	return id, err == nil
+}
+
+type numericNames struct{}
+
+func (numericNames) UserName(int) (string, bool)  { return "", false }
+func (numericNames) GroupName(int) (string, bool) { return "", false }
+func (numericNames) UserID(string) (int, bool)    { return 0, false }
+func (numericNames) GroupID(string) (int, bool)   { return 0, false }
+
+type cachedNames struct {
+	r             NameResolver
+	users, groups sync.Map // map[int]cachedName
+	uids, gids    sync.Map // map[string]cachedID
+}
+
+type cachedName struct {
+	name string
+	ok   bool
+}
+
+type cachedID struct {
+	id int
+	ok bool
+}
+
+func (c *cachedNames) UserName(uid int) (string, bool) {
+	return c.name(&c.users, uid, c.r.UserName)
+}
+
+func (c *cachedNames) GroupName(gid int) (string, bool) {
+	return c.name(&c.groups, gid, c.r.GroupName)
+}
+
+func (c *cachedNames) UserID(name string) (int, bool) {
+	return c.id(&c.uids, name, c.r.UserID)
+}
+
+func (c *cachedNames) GroupID(name string) (int, bool) {
+	return c.id(&c.gids, name, c.r.GroupID)
+}
+
+func (c *cachedNames) name(m *sync.Map, id int, lookup func(int) (string, bool)) (string, bool) {
+	if v, ok := m.Load(id); ok {
+		v := v.(cachedName)
+		return v.name, v.ok
+	}
+	name, ok := lookup(id)
+	m.Store(id, cachedName{name, ok})
+	return name, ok
+}
+
+func (c *cachedNames) id(m *sync.Map, name string, lookup func(string) (int, bool)) (int, bool) {
+	if v, ok := m.Load(name); ok {
+		v := v.(cachedID)
+		return v.id, v.ok
+	}
+	id, ok := lookup(name)
+	m.Store(name, cachedID{id, ok})
+	return id, ok
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeNames is a NameResolver with a fixed set of users and groups,
+// which counts the lookups made.
+type fakeNames struct {
+	users, groups map[int]string
+	lookups       int
+}
+
+func (f *fakeNames) UserName(uid int) (string, bool) {
+	f.lookups++
+	name, ok := f.users[uid]
+	return name, ok
+}
+
+func (f *fakeNames) GroupName(gid int) (string, bool) {
+	f.lookups++
+	name, ok := f.groups[gid]
+	return name, ok
+}
+
+func (f *fakeNames) UserID(name string) (int, bool) {
+	f.lookups++
+	return findName(f.users, name)
+}
+
+func (f *fakeNames) GroupID(name string) (int, bool) {
+	f.lookups++
+	return findName(f.groups, name)
+}
+
+func findName(m map[int]string, name string) (int, bool) {
+	for id, n := range m {
+		if n == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func TestCacheNames(t *testing.T) {
+	f := &fakeNames{
+		users:  map[int]string{1000: "gopher"},
+		groups: map[int]string{100: "users"},
+	}
+	r := CacheNames(f)
+	for pass := 0; pass < 2; pass++ {
+		if name, ok := r.UserName(1000); name != "gopher" || !ok {
+			t.Errorf("pass %d, UserName(1000) = (%q, %v), want (%q, true)", pass, name, ok, "gopher")
+		}
+		if name, ok := r.UserName(0); name != "" || ok {
+			t.Errorf("pass %d, UserName(0) = (%q, %v), want (\"\", false)", pass, name, ok)
+		}
+		if name, ok := r.GroupName(100); name != "users" || !ok {
+			t.Errorf("pass %d, GroupName(100) = (%q, %v), want (%q, true)", pass, name, ok, "users")
+		}
+		if id, ok := r.UserID("gopher"); id != 1000 || !ok {
+			t.Errorf("pass %d, UserID(%q) = (%d, %v), want (1000, true)", pass, "gopher", id, ok)
+		}
+		if id, ok := r.GroupID("users"); id != 100 || !ok {
+			t.Errorf("pass %d, GroupID(%q) = (%d, %v), want (100, true)", pass, "users", id, ok)
+		}
+		if _, ok := r.GroupID("wheel"); ok {
+			t.Errorf("pass %d, GroupID(%q) succeeded, want failure", pass, "wheel")
+		}
+		if f.lookups != 6 {
+			t.Errorf("pass %d, made %d lookups, want 6", pass, f.lookups)
+		}
+	}
+}
+
+func TestExtractNames(t *testing.T) {
+	f := &fakeNames{
+		users:  map[int]string{1000: "gopher"},
+		groups: map[int]string{100: "users"},
+	}
+	vectors := []struct {
+		names NameResolver
+		name  string
+		group bool
+		id    int
+		ok    bool
+	}{
+		{f, "gopher", false, 1000, true},
+		{f, "gopher", true, 0, false},
+		{f, "users", true, 100, true},
+		{f, "", false, 0, false},
+		{NumericNames(), "gopher", false, 0, false},
+		{NumericNames(), "root", true, 0, false},
+	}
+	for i, v := range vectors {
+		x := &extractor{names: systemNames}
+		ExtractNames(v.names)(x)
+		if id, ok := x.lookupID(v.name, v.group); id != v.id || ok != v.ok {
+			t.Errorf("test %d, lookupID(%q, %v) = (%d, %v), want (%d, %v)", i, v.name, v.group, id, ok, v.id, v.ok)
+		}
+	}
+}
+
+func TestWriterAddNames(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" || runtime.GOOS == "nacl" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir, err := ioutil.TempDir("", "TestWriterAddNames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vectors := []struct {
+		names        NameResolver
+		uname, gname string
+	}{
+		{&fakeNames{users: map[int]string{os.Getuid(): "gopher"}}, "gopher", ""},
+		{&fakeNames{users: map[int]string{os.Getuid(): "gopher"}, groups: map[int]string{os.Getgid(): "staff"}}, "gopher", "staff"},
+		{NumericNames(), "", ""},
+	}
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		var filtered string
+		err := tw.AddFile("file", path, AddNames(v.names), AddFilter(func(_ string, hdr *Header) (bool, error) {
+			filtered = hdr.Uname
+			return true, nil
+		}))
+		if err != nil {
+			t.Fatalf("test %d, AddFile() error: %v", i, err)
+		}
+		tw.Close()
+		hdr, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if hdr.Uname != v.uname || hdr.Gname != v.gname {
+			t.Errorf("test %d, owner names = (%q, %q), want (%q, %q)", i, hdr.Uname, hdr.Gname, v.uname, v.gname)
+		}
+		if hdr.Uid != os.Getuid() || hdr.Gid != os.Getgid() {
+			t.Errorf("test %d, owner IDs = (%d, %d), want (%d, %d)", i, hdr.Uid, hdr.Gid, os.Getuid(), os.Getgid())
+		}
+		if filtered != v.uname {
+			t.Errorf("test %d, filter saw Uname %q, want %q", i, filtered, v.uname)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+// The numeric fields of a header, such as the mode, size and modification
+// time, are fixed-width fields holding either an octal number or, as a GNU
+// extension for values that do not fit, a base-256 number. The functions
+// below encode and decode such fields, for tools that read or write tar
+// headers, or other formats using the same encodings, without a Reader
+// or Writer.
+
+// ParseOctal decodes the octal number in the field b, which may be padded
+// with leading and trailing spaces and NULs. An empty field is zero.
+// It returns ErrHeader if b holds anything else or the value overflows.
+func ParseOctal(b []byte) (int64, error) {
+	var p parser
+	x := p.parseOctal(b)
+	return x, p.err
+}
+
+// ParseNumeric decodes the number in the field b, which is in base-256
+// if the high bit of its first byte is set, and in octal otherwise.
+// Base-256 numbers are big-endian two's complement numbers, so the result
+// may be negative. It returns ErrHeader if b is malformed or the value
+// overflows an int64.
+func ParseNumeric(b []byte) (int64, error) {
+	var p parser
+	x := p.parseNumeric(b)
+	return x, p.err
+}
+
+// FormatOctal encodes x in octal into the field b, right-aligned with
+// leading zeros and followed by a NUL if b is longer than one byte.
+// If x is negative or does not fit, b holds zero and FormatOctal returns
+// ErrFieldTooLong.
+func FormatOctal(b []byte, x int64) error {
+	var f formatter
+	f.formatOctal(b, x)
+	return f.err
+}
+
+// FormatBase256 encodes x in base-256 into the field b, as a big-endian
+// two's complement number whose first byte has its high bit set.
+// If x does not fit, b is unchanged and FormatBase256 returns
+// ErrFieldTooLong.
+func FormatBase256(b []byte, x int64) error {
+	if len(b) == 0 || !fitsInBase256(len(b), x) {
+		return ErrFieldTooLong
+	}
+	formatBase256(b, x)
+	return nil
+}
+
+// FormatNumeric encodes x into the field b in octal if it fits, as
+// Writer does, and in base-256 otherwise. If x fits in neither, b holds
+// zero and FormatNumeric returns ErrFieldTooLong.
+func FormatNumeric(b []byte, x int64) error {
+	var f formatter
+	f.formatNumeric(b, x)
+	return f.err
+}
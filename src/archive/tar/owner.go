@@ -0,0 +1,53 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import "fmt"
+
+// An Owner is the ownership of an entry, as recorded in its Header.
+type Owner struct {
+	Uid   int    // User ID of owner
+	Gid   int    // Group ID of owner
+	Uname string // User name of owner
+	Gname string // Group name of owner
+}
+
+// An IDMapping maps a range of user or group IDs, like a line of the
+// uid_map and gid_map files of a Linux user namespace: the Size IDs starting
+// at HostID correspond to those starting at ContainerID.
+type IDMapping struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// MapIDs returns a function for Writer.MapOwner that translates the IDs of
+// the files of the host into those of a user namespace, using uidMap and
+// gidMap. Entries whose user or group ID is not mapped are rejected. Since
+// names of the host need not be valid in the user namespace, the Uname and
+// Gname of mapped entries are cleared.
+func MapIDs(uidMap, gidMap []IDMapping) func(name string, o Owner) (Owner, error) {
+	return func(name string, o Owner) (Owner, error) {
+		uid, ok := mapID(uidMap, o.Uid)
+		if !ok {
+			return o, fmt.Errorf("archive/tar: user ID %d of %s is not mapped", o.Uid, name)
+		}
+		gid, ok := mapID(gidMap, o.Gid)
+		if !ok {
+			return o, fmt.Errorf("archive/tar: group ID %d of %s is not mapped", o.Gid, name)
+		}
+		return Owner{Uid: uid, Gid: gid}, nil
+	}
+}
+
+// mapID returns the ID in the container of the host ID id.
+func mapID(m []IDMapping, id int) (int, bool) {
+	for _, r := range m {
+		if id >= r.HostID && id-r.HostID < r.Size {
+			return r.ContainerID + id - r.HostID, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestMapIDs(t *testing.T) {
+	mapOwner := MapIDs(
+		[]IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}},
+		[]IDMapping{{ContainerID: 0, HostID: 1000, Size: 1}},
+	)
+	vectors := []struct {
+		in   Owner
+		want Owner
+		ok   bool
+	}{
+		{Owner{1000, 1000, "gopher", "gopher"}, Owner{0, 0, "", ""}, true},
+		{Owner{100000, 1000, "", ""}, Owner{1, 0, "", ""}, true},
+		{Owner{165535, 1000, "", ""}, Owner{65536, 0, "", ""}, true},
+		{Owner{165536, 1000, "", ""}, Owner{}, false},
+		{Owner{1000, 0, "", ""}, Owner{}, false},
+		{Owner{999, 1000, "", ""}, Owner{}, false},
+	}
+	for i, v := range vectors {
+		got, err := mapOwner("file", v.in)
+		if ok := err == nil; ok != v.ok || (ok && got != v.want) {
+			t.Errorf("test %d, MapIDs()(%+v) = (%+v, %v), want (%+v, ok=%v)", i, v.in, got, err, v.want, v.ok)
+		}
+	}
+}
+
+func TestWriterMapOwner(t *testing.T) {
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	tw.MapOwner = func(name string, o Owner) (Owner, error) {
+		if name == "bad" {
+			return o, io.ErrUnexpectedEOF
+		}
+		return Owner{Uid: o.Uid + 1, Gname: "wheel"}, nil
+	}
+	hdr := &Header{Name: "file", Uid: 41, Gid: 7, Uname: "gopher", Gname: "gopher"}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	if hdr.Uid != 41 || hdr.Uname != "gopher" {
+		t.Errorf("WriteHeader() modified its argument: %+v", hdr)
+	}
+	if err := tw.WriteHeader(&Header{Name: "bad"}); err != io.ErrUnexpectedEOF {
+		t.Errorf("WriteHeader() error: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	tw.Close()
+
+	got, err := NewReader(&b).Next()
+	if err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if got.Uid != 42 || got.Gid != 0 || got.Uname != "" || got.Gname != "wheel" {
+		t.Errorf("Next() ownership = %d, %d, %q, %q; want 42, 0, \"\", \"wheel\"", got.Uid, got.Gid, got.Uname, got.Gname)
+	}
+}
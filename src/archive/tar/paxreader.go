@@ -0,0 +1,140 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bufio"
+	"io"
+)
+
+// paxBufferSize is the size of the buffer used to parse PAX extended
+// headers, which is also the maximum length of the key of a record.
+const paxBufferSize = 4096
+
+// maxPAXPrealloc is the largest buffer allocated for the value of a PAX
+// record before its bytes are read, so that a bogus length cannot cause
+// a large allocation.
+const maxPAXPrealloc = 1 << 20
+
+// paxRecordReader reads the records of a PAX extended header one at
+// a time. Besides the values returned, it buffers at most paxBufferSize
+// bytes, so that the header need not be held in memory as a whole.
+type paxRecordReader struct {
+	br *bufio.Reader
+}
+
+// next returns the key and value of the next record, as parsed by
+// parsePAXRecord. It returns io.EOF at the end of the header, ErrHeader
+// if the record is malformed, and any error reading the record.
+func (pr *paxRecordReader) next() (k, v string, err error) {
+	// Parse the length of the record, which ends at the first space.
+	var n int64
+	var digits int
+	for {
+		c, err := pr.br.ReadByte()
+		if err != nil {
+			if err == io.EOF && digits > 0 {
+				err = ErrHeader
+			}
+			return "", "", err
+		}
+		if c == ' ' && digits > 0 {
+			break
+		}
+		if c < '0' || c > '9' || digits == 18 {
+			return "", "", ErrHeader
+		}
+		n = n*10 + int64(c-'0')
+		digits++
+	}
+	rem := n - int64(digits) - 1 // Length of the key, value and newline
+	if n < 5 || rem < 1 {
+		return "", "", ErrHeader
+	}
+
+	// The first equals separates the key from the value.
+	key, err := pr.br.ReadSlice('=')
+	if err != nil || int64(len(key)) >= rem {
+		return "", "", pr.errHeader(err)
+	}
+	k = paxKey(key[:len(key)-1])
+	if v, err = pr.readString(rem - int64(len(key)) - 1); err != nil {
+		return "", "", err
+	}
+	if c, err := pr.br.ReadByte(); err != nil || c != '\n' {
+		return "", "", pr.errHeader(err)
+	}
+	if !validPAXRecord(k, v) {
+		return "", "", ErrHeader
+	}
+	return k, v, nil
+}
+
+// paxKeys holds the keys of the records that this package interprets,
+// which paxKey returns rather than allocating a new string for each one.
+var paxKeys = func() map[string]string {
+	m := make(map[string]string)
+	for _, k := range []string{
+		paxPath, paxLinkpath, paxSize, paxUid, paxGid, paxUname, paxGname,
+		paxMtime, paxAtime, paxCtime, paxCharset, paxComment, paxHdrCharset,
+		paxRHTSELinux, paxMSWindowsFileAttr, paxMSWindowsRawSD, paxMSWindowsRawSDAlt,
+		paxGNUSparseNumBlocks, paxGNUSparseOffset, paxGNUSparseNumBytes,
+		paxGNUSparseMap, paxGNUSparseName, paxGNUSparseMajor, paxGNUSparseMinor,
+		paxGNUSparseSize, paxGNUSparseRealSize, paxLibarchiveCreationTime,
+		paxSchilyACLAccess, paxSchilyACLDefault,
+	} {
+		m[k] = k
+	}
+	return m
+}()
+
+// paxKey returns the key b as a string.
+func paxKey(b []byte) string {
+	if k, ok := paxKeys[string(b)]; ok {
+		return k // Does not allocate
+	}
+	return string(b)
+}
+
+// readString reads a string of n bytes. Longer values are read in chunks
+// of the size of the buffer, into a buffer that starts at no more than
+// maxPAXPrealloc bytes and doubles in size, up to n, as the bytes arrive.
+func (pr *paxRecordReader) readString(n int64) (string, error) {
+	if n <= int64(pr.br.Size()) {
+		b, err := pr.br.Peek(int(n))
+		if err != nil {
+			return "", pr.errHeader(err)
+		}
+		s := string(b)
+		pr.br.Discard(len(b))
+		return s, nil
+	}
+	buf := make([]byte, 0, min(n, maxPAXPrealloc))
+	for rem := n; rem > 0; {
+		b, err := pr.br.Peek(int(min(rem, int64(pr.br.Size()))))
+		if err != nil {
+			return "", pr.errHeader(err)
+		}
+		if len(buf)+len(b) > cap(buf) {
+			nb := make([]byte, len(buf), min(n, 2*int64(cap(buf))))
+			copy(nb, buf)
+			buf = nb
+		}
+		buf = append(buf, b...)
+		pr.br.Discard(len(b))
+		rem -= int64(len(b))
+	}
+	return string(buf), nil
+}
+
+// errHeader returns ErrHeader for a record that is malformed, because it
+// does not fit in the header or in the buffer, and err for other errors.
+func (pr *paxRecordReader) errHeader(err error) error {
+	switch err {
+	case nil, io.EOF, bufio.ErrBufferFull:
+		return ErrHeader
+	}
+	return err
+}
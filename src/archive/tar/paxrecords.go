@@ -0,0 +1,125 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Keys of PAX records defined by other implementations of tar, for use with
+// Header.PAXRecord and Header.SetPAXRecord. Keys ending in a period are the
+// prefix of a family of keys, such as "SCHILY.xattr.user.mime_type".
+//
+// Several of them are also accessible through fields of Header, which take
+// precedence over PAXRecords when writing.
+const (
+	PAXKeySchilyXattr      = paxSchilyXattr      // Extended attributes (Header.Xattrs)
+	PAXKeySchilyACLAccess  = paxSchilyACLAccess  // Access ACL (Header.AccessACL)
+	PAXKeySchilyACLDefault = paxSchilyACLDefault // Default ACL (Header.DefaultACL)
+	PAXKeySchilyFflags     = paxSchilyFflags     // File flags (Header.Flags)
+	PAXKeySchilyDev        = "SCHILY.dev"        // Device number of the file
+	PAXKeySchilyIno        = "SCHILY.ino"        // Inode number of the file
+	PAXKeySchilyNlink      = "SCHILY.nlink"      // Number of hard links to the file
+
+	PAXKeyLibarchiveXattr        = paxLibarchiveXattr        // Extended attributes, with base64 values
+	PAXKeyLibarchiveDigest       = paxLibarchiveDigest       // Digests of the data (Writer.Digests)
+	PAXKeyLibarchiveCreationTime = paxLibarchiveCreationTime // Creation time (Header.CreationTime)
+
+	PAXKeyRHTSELinux = paxRHTSELinux // SELinux context (Header.SELinuxContext)
+
+	PAXKeyMSWindowsFileAttr = paxMSWindowsFileAttr // Windows attributes (Header.WindowsAttributes)
+	PAXKeyMSWindowsRawSD    = paxMSWindowsRawSD    // Security descriptor (Header.WindowsSecurityDescriptor)
+)
+
+// PAXRecord returns the value of the PAX record with key k in h.PAXRecords,
+// and whether it is present.
+func (h *Header) PAXRecord(k string) (string, bool) {
+	v, ok := h.PAXRecords[k]
+	return v, ok
+}
+
+// SetPAXRecord sets the PAX record with key k to v in h.PAXRecords,
+// creating the map if needed.
+//
+// It returns a *HeaderError, leaving h unchanged, if the record is invalid,
+// because k is empty or contains '=' or NUL, or if k is reserved:
+// the records that hold the basic fields of Header, such as "path", "size"
+// and "mtime", and those describing GNU sparse files are derived from the
+// other fields of h when writing, and Writer.WriteHeader ignores them in
+// PAXRecords. Global headers (TypeXGlobalHeader) may set any valid record.
+func (h *Header) SetPAXRecord(k, v string) error {
+	if !validPAXRecord(k, v) {
+		return newHeaderError("PAXRecords", fmt.Sprintf("invalid PAX record: %q", k+" = "+v))
+	}
+	if h.Typeflag != TypeXGlobalHeader && isReservedPAXKey(k) {
+		return newHeaderError("PAXRecords", fmt.Sprintf("PAX record %q is reserved", k))
+	}
+	if h.PAXRecords == nil {
+		h.PAXRecords = make(map[string]string)
+	}
+	h.PAXRecords[k] = v
+	return nil
+}
+
+// DeletePAXRecord removes the PAX record with key k from h.PAXRecords,
+// if present.
+func (h *Header) DeletePAXRecord(k string) {
+	delete(h.PAXRecords, k)
+}
+
+// isReservedPAXKey reports whether Writer.WriteHeader ignores the PAX record
+// with key k in the PAXRecords of a Header other than a global header.
+func isReservedPAXKey(k string) bool {
+	return basicKeys[k] || strings.HasPrefix(k, paxGNUSparse)
+}
+
+// ParsePAXRecord parses the first record of s, which is in the format of
+// the data of a PAX extended header:
+//	"%d %s=%s\n" % (length, key, value)
+// where length is the length of the whole record, including itself.
+// It returns the key and value of the record, and the remainder of s.
+// ParsePAXRecord returns ErrHeader if the record is malformed.
+func ParsePAXRecord(s string) (k, v, rest string, err error) {
+	return parsePAXRecord(s)
+}
+
+// FormatPAXRecord formats a single PAX record as parsed by ParsePAXRecord.
+// It returns ErrHeader if k is empty or contains '=' or NUL, or if the
+// value of a "path", "linkpath", "uname" or "gname" record contains NUL.
+func FormatPAXRecord(k, v string) (string, error) {
+	return formatPAXRecord(k, v)
+}
+
+// ParsePAXRecords parses the data of a PAX extended header (TypeXHeader or
+// TypeXGlobalHeader) into a map of records, as Reader does.
+// When a key occurs several times, the last value takes precedence.
+// The GNU.sparse.offset and GNU.sparse.numbytes records of GNU sparse
+// format 0.0, which repeat, are combined into a single GNU.sparse.map
+// record. ParsePAXRecords returns ErrHeader if any record is malformed.
+func ParsePAXRecords(b []byte) (map[string]string, error) {
+	return parsePAX(bytes.NewReader(b), nil)
+}
+
+// FormatPAXRecords formats recs as the data of a PAX extended header,
+// with the records sorted by key as Writer does.
+// It returns ErrHeader if any record is invalid; see FormatPAXRecord.
+func FormatPAXRecords(recs map[string]string) ([]byte, error) {
+	keys := make([]string, 0, len(recs))
+	for k := range recs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b []byte
+	for _, k := range keys {
+		var err error
+		if b, err = appendPAXRecord(b, k, recs[k]); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderSetPAXRecord(t *testing.T) {
+	vectors := []struct {
+		typeflag byte
+		key, val string
+		ok       bool
+	}{
+		{TypeReg, "GOLANG.pkg.version", "1.10", true},
+		{TypeReg, PAXKeySchilyIno, "42", true},
+		{TypeReg, "comment", "hello", true},
+		{TypeReg, "", "empty", false},
+		{TypeReg, "a=b", "c", false},
+		{TypeReg, "VENDOR.nul\x00", "c", false},
+		{TypeReg, "path", "other", false},
+		{TypeReg, "path", "a\x00b", false},
+		{TypeReg, "size", "5", false},
+		{TypeReg, "mtime", "0", false},
+		{TypeReg, "GNU.sparse.map", "0,1", false},
+		{TypeXGlobalHeader, "mtime", "0", true},
+		{TypeXGlobalHeader, "a=b", "c", false},
+	}
+
+	for i, v := range vectors {
+		h := &Header{Name: "file", Typeflag: v.typeflag}
+		err := h.SetPAXRecord(v.key, v.val)
+		if ok := err == nil; ok != v.ok {
+			t.Errorf("test %d, SetPAXRecord(%q, %q) error: %v, want ok=%v", i, v.key, v.val, err, v.ok)
+			continue
+		}
+		if _, isHeaderError := err.(*HeaderError); err != nil && !isHeaderError {
+			t.Errorf("test %d, SetPAXRecord() error = %T, want *HeaderError", i, err)
+		}
+		got, ok := h.PAXRecord(v.key)
+		if ok != v.ok || (ok && got != v.val) {
+			t.Errorf("test %d, PAXRecord(%q) = %q, %v; want %q, %v", i, v.key, got, ok, v.val, v.ok)
+		}
+		h.DeletePAXRecord(v.key)
+		if _, ok := h.PAXRecord(v.key); ok {
+			t.Errorf("test %d, PAXRecord(%q) present after DeletePAXRecord", i, v.key)
+		}
+	}
+}
+
+func TestHeaderPAXRecordRoundTrip(t *testing.T) {
+	hdr := &Header{Name: "file", Typeflag: TypeReg}
+	if err := hdr.SetPAXRecord(PAXKeySchilyNlink, "2"); err != nil {
+		t.Fatalf("SetPAXRecord() error: %v", err)
+	}
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	tw.Close()
+	got, err := NewReader(&b).Next()
+	if err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if v, ok := got.PAXRecord(PAXKeySchilyNlink); v != "2" || !ok {
+		t.Errorf("PAXRecord(%q) = %q, %v; want %q, true", PAXKeySchilyNlink, v, ok, "2")
+	}
+}
+
+func TestHeaderVendorPAXRecords(t *testing.T) {
+	recs := map[string]string{
+		"MYAPP.version":     "2",
+		"MYAPP.build.id":    "a1b2c3",
+		"ACME.checksum.xyz": "0123",
+	}
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	if err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg, PAXRecords: recs}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	tw.Close()
+	got, err := NewReader(&b).Next()
+	if err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if got.Format != FormatPAX {
+		t.Errorf("Format = %v, want %v", got.Format, FormatPAX)
+	}
+	for k, want := range recs {
+		if v := got.PAXRecords[k]; v != want {
+			t.Errorf("PAXRecords[%q] = %q, want %q", k, v, want)
+		}
+	}
+}
+
+func TestPAXRecords(t *testing.T) {
+	recs := map[string]string{"path": "a/b", "mtime": "1500000000.5", "MYAPP.id": "x=y"}
+	b, err := FormatPAXRecords(recs)
+	if err != nil {
+		t.Fatalf("FormatPAXRecords() error: %v", err)
+	}
+	if want := "16 MYAPP.id=x=y\n22 mtime=1500000000.5\n12 path=a/b\n"; string(b) != want {
+		t.Errorf("FormatPAXRecords() = %q, want %q", b, want)
+	}
+	got, err := ParsePAXRecords(b)
+	if err != nil {
+		t.Fatalf("ParsePAXRecords() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, recs) {
+		t.Errorf("ParsePAXRecords() = %v, want %v", got, recs)
+	}
+
+	got, err = ParsePAXRecords([]byte("23 GNU.sparse.offset=0\n25 GNU.sparse.numbytes=5\n23 GNU.sparse.offset=9\n25 GNU.sparse.numbytes=1\n"))
+	if want := map[string]string{paxGNUSparseMap: "0,5,9,1"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePAXRecords() of sparse 0.0 records = %v, %v; want %v, nil", got, err, want)
+	}
+
+	if _, err := FormatPAXRecords(map[string]string{"a=b": "c"}); err != ErrHeader {
+		t.Errorf("FormatPAXRecords() of invalid record error = %v, want %v", err, ErrHeader)
+	}
+	for i, s := range []string{"6 k=v", "5 k=v\n", "6 kv\n\n", "x k=v\n"} {
+		if _, err := ParsePAXRecords([]byte(s)); err != ErrHeader {
+			t.Errorf("test %d, ParsePAXRecords(%q) error = %v, want %v", i, s, err, ErrHeader)
+		}
+	}
+}
@@ -5,9 +5,13 @@
 package tar
 
 import (
+	"bufio"
 	"bytes"
+	"crypto"
+	"encoding/base64"
 	"io"
 	"io/ioutil"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -17,10 +21,138 @@ import (
 // Reader.Next advances to the next file in the archive (including the first),
 // and then Reader can be treated as an io.Reader to access the file's data.
 type Reader struct {
+	// DisallowDuplicates causes Next to fail with ErrDuplicateName when
+	// an entry has the same name as a previous entry in the archive.
+	// Names are compared after cleaning with path.Clean and
+	// removing any leading slashes.
+	//
+	// Archives that contain the same name twice are a common means of
+	// smuggling content past tools that only inspect the first occurrence,
+	// while an extraction later overwrites it with the second.
+	DisallowDuplicates bool
+
+	// DisallowInsecurePaths causes Next to report ErrInsecurePath for
+	// entries whose Name is absolute, is empty, or escapes the current
+	// directory using "..", and for links whose Linkname does so.
+	// On Windows, names containing backslashes or volume names are also
+	// considered insecure.
+	//
+	// Next returns the Header together with ErrInsecurePath, so callers that
+	// wish to handle such entries themselves may ignore the error and
+	// continue to use the Reader.
+	//
+	// Setting the GODEBUG environment variable to include tarinsecurepath=0
+	// has the same effect as setting this field.
+	DisallowInsecurePaths bool
+
+	// DecodeName, if non-nil, converts the Name, Linkname, Uname and Gname
+	// of each entry to UTF-8 from the character set of the system that
+	// created the archive, such as Latin-1 or Shift-JIS.
+	//
+	// It is only called for values containing non-ASCII characters that
+	// were stored in the header block or in a GNU long name entry,
+	// which hold raw bytes in an unspecified encoding. PAX records are
+	// UTF-8 by definition and are not decoded, unless the archive sets
+	// the "hdrcharset" record to "BINARY".
+	//
+	// If DecodeName returns an error, Next returns that error.
+	DecodeName func(s string) (string, error)
+
+	// NormalizeNames causes Next to return the names of entries in a
+	// canonical form, so that callers need not account for the variations
+	// produced by different tools: backslashes, as written by some Windows
+	// tools, are converted to slashes, and names are cleaned as by
+	// path.Clean, which removes leading "./" elements and duplicate slashes.
+	// The names of directories always end with a slash. The Linkname of
+	// hard links, which names another entry, is normalized in the same way;
+	// the Linkname of symbolic links is left unchanged.
+	//
+	// Names that contain literal backslashes, which are valid in file names
+	// on Unix, are changed by this conversion.
+	NormalizeNames bool
+
+	// ApplyGlobalPAXRecords causes the records of global PAX headers
+	// (TypeXGlobalHeader) to be applied as defaults to all subsequent
+	// entries, as specified by POSIX. Records in an entry's own extended
+	// header take precedence, and an empty value removes the default.
+	//
+	// Next returns the global headers themselves regardless of this setting.
+	ApplyGlobalPAXRecords bool
+
+	// VerifyDigests causes Read to verify the data of each entry against
+	// the digests stored in its "LIBARCHIVE.digest.ALGORITHM" PAX records,
+	// which libarchive writes for the md5, sha1, sha256, sha384 and sha512
+	// algorithms. When the end of the data is reached and it does not match,
+	// Read returns a *DigestError instead of io.EOF.
+	//
+	// The hash functions are looked up using package crypto, so they must be
+	// linked into the binary, usually by importing the package that
+	// implements them (e.g., crypto/sha256). Records naming unavailable or
+	// unknown algorithms are ignored.
+	VerifyDigests bool
+
+	// Hashes lists hash functions with which to compute digests of the data
+	// of each entry while it is read, so that they can be retrieved with Sum
+	// once the data has been consumed. This avoids the need to wrap the
+	// Reader in an io.TeeReader for every entry.
+	//
+	// As with VerifyDigests, the hash functions must be linked into the
+	// binary; Next panics if one of them is unavailable.
+	Hashes []crypto.Hash
+
+	// TolerateTruncatedTrailer causes Next to report io.EOF rather than
+	// io.ErrUnexpectedEOF when the input ends within the two blocks of zeros
+	// that terminate an archive. Archives that end without a trailer, or
+	// without the padding of the last entry, are always accepted.
+	TolerateTruncatedTrailer bool
+
+	// AcceptQuirks enables workarounds for archives created by tar
+	// implementations that do not conform to the standards, such as those
+	// of AIX, HP-UX, Solaris and star. In particular, Next accepts:
+	//
+	//	* numeric fields that are terminated by garbage rather than by
+	//	a space or NUL,
+	//	* checksums computed with the checksum field filled with NULs
+	//	instead of spaces,
+	//	* extended headers with the Solaris 'X' type flag, which are decoded
+	//	as PAX extended headers, and
+	//	* access and change times stored in the prefix field by the xustar
+	//	and exustar formats of star.
+	AcceptQuirks bool
+
+	// HandleUnknownType, if non-nil, is called by Next for entries whose
+	// Typeflag is not one of the Type constants of this package, such as
+	// vendor extensions, instead of returning them as if they were regular
+	// files. The handler may read the data of the entry from r, and must not
+	// retain hdr or r after returning. Next then skips any unread data and
+	// advances to the following entry.
+	//
+	// If the handler returns an error, Next returns that error.
+	HandleUnknownType func(hdr *Header, r io.Reader) error
+
 	r    io.Reader
-	pad  int64      // Amount of padding (ignored) after current file entry
-	curr fileReader // Reader for current file entry
-	blk  block      // Buffer to use as temporary local storage
+	pad  int64         // Amount of padding (ignored) after current file entry
+	curr fileReader    // Reader for current file entry
+	reg  regFileReader // Storage for curr when reading a regular file
+	blk  block         // Buffer to use as temporary local storage
+
+	resynced bool        // Whether blk holds a header found by Resync
+	raw      rawRecorder // Raw header blocks of the current entry
+	end      int64       // Offset of the end of the current entry, or -1 if unknown
+
+	hashes []entryHash // Hashes of the data of the current entry; see Hashes
+	hashed bool        // Whether hashes holds the digests of all of the data
+
+	seen   map[string]bool   // Set of entry names seen so far; see DisallowDuplicates
+	global map[string]string // Records of all global headers read so far
+
+	pax *bufio.Reader // Buffer for parsing PAX extended headers
+
+	// Storage reused across entries, so that reading archives without
+	// extensions does not allocate for each entry.
+	hdrs         []Header    // Headers to be returned by Next
+	arena        stringArena // Storage for names read from header blocks
+	uname, gname string      // Last non-empty Uname and Gname read
 
 	// err is a persistent error.
 	// It is only the responsibility of every exported method of Reader to
@@ -40,21 +172,240 @@ func NewReader(r io.Reader) *Reader {
 	return &Reader{r: r, curr: &regFileReader{r, 0}}
 }
 
+// Reset discards the state of tr and makes it read from r, as if it were
+// returned by NewReader(r), while keeping its configuration fields and
+// reusing its internal buffers. This allows a single Reader to be used
+// for many archives.
+//
+// Since each entry starts on a block boundary, Reset can also be used to
+// resume reading in the middle of an archive: position r at the HeaderOffset
+// of an entry (see List) and Reset tr. State that depends on earlier entries,
+// such as the records of global headers and the names seen for
+// DisallowDuplicates, is not recovered in that case.
+func (tr *Reader) Reset(r io.Reader) {
+	tr.r = r
+	tr.pad = 0
+	tr.reg = regFileReader{r: r}
+	tr.curr = &tr.reg
+	for k := range tr.seen {
+		delete(tr.seen, k)
+	}
+	tr.global = nil
+	tr.resynced = false
+	tr.end = 0
+	tr.hashed = false
+	tr.err = nil
+}
+
+// Resync skips forward to the next block that holds a header with a valid
+// checksum, so that reading can continue with Next after it has reported
+// an error such as ErrHeader. This allows data recovery tools to salvage
+// the entries following a damaged region of an archive, provided that the
+// damage did not change the length of the archive.
+//
+// Resync discards the rest of the current entry and returns the number of
+// bytes skipped while searching. It returns io.EOF if no header was found
+// before the end of the input.
+func (tr *Reader) Resync() (int64, error) {
+	// The size of the current entry may be corrupt, so only skip to the
+	// next block boundary rather than past all of its data.
+	n := (tr.curr.PhysicalRemaining() + tr.pad) % blockSize
+	tr.reg = regFileReader{r: tr.r}
+	tr.curr = &tr.reg
+	tr.pad = 0
+	tr.resynced = false
+	tr.end = -1 // The offset of the next header is not known
+	tr.err = nil
+
+	if _, err := mustReadFull(tr.r, tr.blk[:n]); err != nil {
+		tr.err = err
+		return 0, err
+	}
+	skipped := n
+	for {
+		if _, err := io.ReadFull(tr.r, tr.blk[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF // A partial block cannot hold a header
+			}
+			tr.err = err
+			return skipped, err
+		}
+		if !bytes.Equal(tr.blk[:], zeroBlock[:]) && tr.blk.GetFormat() != FormatUnknown {
+			tr.resynced = true
+			return skipped, nil
+		}
+		skipped += blockSize
+	}
+}
+
 // Next advances to the next entry in the tar archive.
 // The Header.Size determines how many bytes can be read for the next file.
 // Any remaining data in the current file is automatically discarded.
 //
 // io.EOF is returned at the end of the input.
+//
+// If DisallowInsecurePaths is set and the entry has an insecure name,
+// Next returns both the Header and ErrInsecurePath.
+//
+// Each Header returned is distinct and may be retained and modified.
+// To avoid allocating for each entry, however, Headers are allocated
+// in batches of 16, and short strings read from header blocks, such as
+// Name, share chunks of 4 KiB. Retaining one Header, or one such string,
+// therefore keeps the memory of up to 16 Headers or 4 KiB of names alive.
 func (tr *Reader) Next() (*Header, error) {
 	if tr.err != nil {
 		return nil, tr.err
 	}
-	hdr, err := tr.next()
+	// Headers are allocated in batches. Each is returned once, so callers
+	// may retain and modify them as if they were allocated individually.
+	if len(tr.hdrs) == 0 {
+		tr.hdrs = make([]Header, 16)
+	}
+	hdr := &tr.hdrs[0]
+	if err := tr.NextInto(hdr); err != nil {
+		if err == ErrInsecurePath {
+			tr.hdrs = tr.hdrs[1:]
+			return hdr, err
+		}
+		*hdr = Header{} // Keep the Header for the next entry
+		return nil, err
+	}
+	tr.hdrs = tr.hdrs[1:]
+	return hdr, nil
+}
+
+// NextInto is like Next, but stores the next entry in hdr instead of
+// allocating a new Header. All fields of hdr are overwritten.
+//
+// The PAXRecords and Xattrs maps of hdr are cleared and reused for the
+// next entry, and strings are reused when they are unchanged from the
+// previous entry, so that reading an archive with NextInto and the same
+// Header does not allocate for each entry in the common case.
+// Strings share chunks of memory as described for Next.
+// Callers that retain an entry's maps must copy them before calling NextInto
+// again. For PAX entries, the maps may be empty rather than nil.
+//
+// If NextInto returns an error other than ErrInsecurePath,
+// the contents of hdr are unspecified.
+func (tr *Reader) NextInto(hdr *Header) error {
+	if tr.err != nil {
+		return tr.err
+	}
+	err := tr.next(hdr)
+	for err == nil && tr.HandleUnknownType != nil && !isKnownType(hdr.Typeflag) {
+		if err = tr.HandleUnknownType(hdr, struct{ io.Reader }{tr}); err == nil {
+			err = tr.next(hdr)
+		}
+	}
+	if err == nil && tr.DisallowDuplicates {
+		err = tr.checkDuplicate(hdr)
+	}
 	tr.err = err
-	return hdr, err
+	if err == nil && tr.insecurePathsDisallowed() && hdr.Typeflag != TypeXGlobalHeader && hdr.hasInsecurePath() {
+		err = ErrInsecurePath // Not sticky; the caller may continue
+	}
+	return err
+}
+
+// insecurePathsDisallowed reports whether Next should report ErrInsecurePath.
+func (tr *Reader) insecurePathsDisallowed() bool {
+	return tr.DisallowInsecurePaths || goDebugString("tarinsecurepath") == "0"
 }
 
-func (tr *Reader) next() (*Header, error) {
+// checkDuplicate reports ErrDuplicateName if hdr has the same name as
+// a previously returned entry.
+func (tr *Reader) checkDuplicate(hdr *Header) error {
+	if hdr.Typeflag == TypeXGlobalHeader {
+		return nil // Global headers do not describe files
+	}
+	name := entryKey(hdr.Name)
+	if tr.seen == nil {
+		tr.seen = make(map[string]bool)
+	}
+	if tr.seen[name] {
+		return ErrDuplicateName
+	}
+	tr.seen[name] = true
+	return nil
+}
+
+// entryKey returns the canonical form of name used for comparing entries.
+func entryKey(name string) string {
+	return strings.TrimLeft(path.Clean("/"+name), "/")
+}
+
+// normalizeName returns the canonical form of name; see NormalizeNames.
+// A trailing slash is kept, and added if dir is set.
+func normalizeName(name string, dir bool) string {
+	name = strings.Replace(name, `\`, "/", -1)
+	if name == "" {
+		return name
+	}
+	clean := path.Clean(name)
+	if !dir && !strings.HasSuffix(name, "/") || clean == "/" {
+		return clean
+	}
+	if strings.HasSuffix(name, "/") && name[:len(name)-1] == clean {
+		return name // Avoid allocating for names that are already canonical
+	}
+	return clean + "/"
+}
+
+// next skips the rest of the current entry and reads the header of the next,
+// recording the raw bytes of its header blocks for Writer.CopyFrom.
+func (tr *Reader) next(hdr *Header) error {
+	if err := tr.skipEntry(); err != nil {
+		return err
+	}
+	tr.raw = rawRecorder{r: tr.r, buf: tr.raw.buf[:0], off: -1}
+	if tr.resynced {
+		tr.raw.buf = append(tr.raw.buf, tr.blk[:]...)
+		tr.raw.size = blockSize
+	}
+	tr.r = &tr.raw
+	start := tr.end
+	err := tr.readNext(hdr)
+	tr.r = tr.raw.r
+	tr.reg.r = tr.r
+	if start >= 0 {
+		switch tr.end = start + tr.raw.size; {
+		case err == nil:
+			tr.end += tr.curr.PhysicalRemaining() + tr.pad
+		case err != io.EOF:
+			tr.end = -1
+		}
+	}
+	tr.raw.ok = err == nil
+	tr.raw.name = hdr.Name
+	tr.raw.hdr = hdr
+	tr.raw.n = tr.curr.PhysicalRemaining()
+	return err
+}
+
+// skipEntry discards the rest of the current entry and its padding.
+func (tr *Reader) skipEntry() error {
+	// Small remainders are read into blk, which avoids allocating.
+	if n := tr.curr.PhysicalRemaining(); n <= blockSize {
+		if _, err := mustReadFull(tr.r, tr.blk[:n]); err != nil {
+			return err
+		}
+	} else if err := discard(tr.r, n); err != nil {
+		return err
+	}
+	if _, err := tryReadFull(tr.r, tr.blk[:tr.pad]); err != nil {
+		return err
+	}
+	tr.pad = 0
+	tr.reg = regFileReader{r: tr.r}
+	tr.curr = &tr.reg
+	return nil
+}
+
+func (tr *Reader) readNext(hdr *Header) error {
+	// Recycle the maps of hdr, which are reset by readHeader.
+	paxBuf, xattrBuf := hdr.PAXRecords, hdr.Xattrs
+	tr.hashed = false
+
 	var paxHdrs map[string]string
 	var gnuLongName, gnuLongLink string
 
@@ -67,20 +418,19 @@ func (tr *Reader) next() (*Header, error) {
 loop:
 	for {
 		// Discard the remainder of the file and any padding.
-		if err := discard(tr.r, tr.curr.PhysicalRemaining()); err != nil {
-			return nil, err
-		}
-		if _, err := tryReadFull(tr.r, tr.blk[:tr.pad]); err != nil {
-			return nil, err
+		if err := tr.skipEntry(); err != nil {
+			return err
 		}
-		tr.pad = 0
 
-		hdr, rawHdr, err := tr.readHeader()
+		rawHdr, err := tr.readHeader(hdr)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if hdr.Typeflag == typeSolarisXHeader && tr.AcceptQuirks {
+			hdr.Typeflag = TypeXHeader
 		}
 		if err := tr.handleRegularFile(hdr); err != nil {
-			return nil, err
+			return err
 		}
 		format.mayOnlyBe(hdr.Format)
 
@@ -88,26 +438,35 @@ loop:
 		switch hdr.Typeflag {
 		case TypeXHeader, TypeXGlobalHeader:
 			format.mayOnlyBe(FormatPAX)
-			paxHdrs, err = parsePAX(tr)
+			if tr.pax == nil {
+				tr.pax = bufio.NewReaderSize(tr, paxBufferSize)
+			} else {
+				tr.pax.Reset(tr)
+			}
+			paxHdrs, err = parsePAX(tr.pax, clearMap(paxBuf))
 			if err != nil {
-				return nil, err
+				return err
 			}
+			paxBuf = nil
 			if hdr.Typeflag == TypeXGlobalHeader {
+				tr.updateGlobal(paxHdrs)
+				hdr.Xattrs = clearMap(xattrBuf)
 				mergePAX(hdr, paxHdrs)
-				return &Header{
+				*hdr = Header{
 					Name:       hdr.Name,
 					Typeflag:   hdr.Typeflag,
 					Xattrs:     hdr.Xattrs,
 					PAXRecords: hdr.PAXRecords,
 					Format:     format,
-				}, nil
+				}
+				return nil
 			}
 			continue loop // This is a meta header affecting the next header
 		case TypeGNULongName, TypeGNULongLink:
 			format.mayOnlyBe(FormatGNU)
 			realname, err := ioutil.ReadAll(tr)
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			var p parser
@@ -122,8 +481,14 @@ loop:
 			// The old GNU sparse format is handled here since it is technically
 			// just a regular file with additional attributes.
 
+			if tr.ApplyGlobalPAXRecords && len(tr.global) > 0 {
+				paxHdrs = tr.applyGlobal(paxHdrs)
+			}
+			if paxHdrs != nil {
+				hdr.Xattrs = clearMap(xattrBuf)
+			}
 			if err := mergePAX(hdr, paxHdrs); err != nil {
-				return nil, err
+				return err
 			}
 			if gnuLongName != "" {
 				hdr.Name = gnuLongName
@@ -131,6 +496,17 @@ loop:
 			if gnuLongLink != "" {
 				hdr.Linkname = gnuLongLink
 			}
+			if tr.DecodeName != nil {
+				if err := tr.decodeNames(hdr, paxHdrs, gnuLongName != "", gnuLongLink != ""); err != nil {
+					return err
+				}
+			}
+			if tr.NormalizeNames {
+				hdr.Name = normalizeName(hdr.Name, hdr.Typeflag == TypeDir)
+				if hdr.Typeflag == TypeLink {
+					hdr.Linkname = normalizeName(hdr.Linkname, false)
+				}
+			}
 			if hdr.Typeflag == TypeRegA && strings.HasSuffix(hdr.Name, "/") {
 				hdr.Typeflag = TypeDir // Legacy archives use trailing slash for directories
 			}
@@ -138,13 +514,19 @@ loop:
 			// The extended headers may have updated the size.
 			// Thus, setup the regFileReader again after merging PAX headers.
 			if err := tr.handleRegularFile(hdr); err != nil {
-				return nil, err
+				return err
 			}
 
 			// Sparse formats rely on being able to read from the logical data
 			// section; there must be a preceding call to handleRegularFile.
 			if err := tr.handleSparseFile(hdr, rawHdr); err != nil {
-				return nil, err
+				return err
+			}
+			if len(tr.Hashes) > 0 {
+				tr.curr = tr.newHashFileReader(tr.curr)
+			}
+			if tr.VerifyDigests {
+				tr.curr = newDigestFileReader(tr.curr, hdr)
 			}
 
 			// Set the final guess at the format.
@@ -152,11 +534,87 @@ loop:
 				format.mayOnlyBe(FormatUSTAR)
 			}
 			hdr.Format = format
-			return hdr, nil // This is a file, so stop
+			return nil // This is a file, so stop
 		}
 	}
 }
 
+// GlobalPAXRecords returns the records of all global PAX headers read so far.
+// Records in later global headers replace those of earlier ones, and records
+// with an empty value are removed. It returns nil if there are none.
+//
+// Archives created by git-archive, for example, store the commit ID in
+// the "comment" record of a global header.
+func (tr *Reader) GlobalPAXRecords() map[string]string {
+	if len(tr.global) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(tr.global))
+	for k, v := range tr.global {
+		m[k] = v
+	}
+	return m
+}
+
+// updateGlobal merges the records of a global header into tr.global.
+func (tr *Reader) updateGlobal(paxHdrs map[string]string) {
+	for k, v := range paxHdrs {
+		if v == "" {
+			delete(tr.global, k)
+			continue
+		}
+		if tr.global == nil {
+			tr.global = make(map[string]string)
+		}
+		tr.global[k] = v
+	}
+}
+
+// applyGlobal returns the records of the global headers overlaid with the
+// records of the entry's own extended header, paxHdrs.
+// An empty value in paxHdrs removes the global record of the same key.
+func (tr *Reader) applyGlobal(paxHdrs map[string]string) map[string]string {
+	m := make(map[string]string, len(tr.global)+len(paxHdrs))
+	for k, v := range tr.global {
+		m[k] = v
+	}
+	for k, v := range paxHdrs {
+		if v == "" {
+			delete(m, k)
+			continue
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// decodeNames converts the names in hdr to UTF-8 using tr.DecodeName.
+// The gnuLongName and gnuLongLink flags report whether the Name and Linkname
+// were taken from GNU long name entries, which take precedence over PAX records.
+func (tr *Reader) decodeNames(hdr *Header, paxHdrs map[string]string, gnuLongName, gnuLongLink bool) error {
+	binary := hdr.HdrCharset == hdrCharsetBinary
+	fields := []struct {
+		s     *string
+		isPAX bool
+	}{
+		{&hdr.Name, paxHdrs[paxPath] != "" && !gnuLongName},
+		{&hdr.Linkname, paxHdrs[paxLinkpath] != "" && !gnuLongLink},
+		{&hdr.Uname, paxHdrs[paxUname] != ""},
+		{&hdr.Gname, paxHdrs[paxGname] != ""},
+	}
+	for _, f := range fields {
+		if isASCII(*f.s) || (f.isPAX && !binary) {
+			continue
+		}
+		s, err := tr.DecodeName(*f.s)
+		if err != nil {
+			return err
+		}
+		*f.s = s
+	}
+	return nil
+}
+
 // handleRegularFile sets up the current file reader and padding such that it
 // can only read the following logical data section. It will properly handle
 // special headers that contain no data section.
@@ -170,7 +628,8 @@ func (tr *Reader) handleRegularFile(hdr *Header) error {
 	}
 
 	tr.pad = blockPadding(nb)
-	tr.curr = &regFileReader{r: tr.r, nb: nb}
+	tr.reg = regFileReader{r: tr.r, nb: nb}
+	tr.curr = &tr.reg
 	return nil
 }
 
@@ -270,8 +729,36 @@ func mergePAX(hdr *Header, paxHdrs map[string]string) (err error) {
 			hdr.ModTime, err = parsePAXTime(v)
 		case paxCtime:
 			hdr.ChangeTime, err = parsePAXTime(v)
+		case paxLibarchiveCreationTime:
+			hdr.CreationTime, err = parsePAXTime(v)
 		case paxSize:
 			hdr.Size, err = strconv.ParseInt(v, 10, 64)
+		case paxComment:
+			hdr.Comment = v
+		case paxCharset:
+			hdr.Charset = v
+		case paxHdrCharset:
+			hdr.HdrCharset = v
+		case paxRHTSELinux:
+			hdr.SELinuxContext = v
+		case paxMSWindowsFileAttr:
+			var n uint64
+			n, err = strconv.ParseUint(v, 10, 32)
+			hdr.WindowsAttributes = uint32(n)
+		case paxMSWindowsRawSD, paxMSWindowsRawSDAlt:
+			if k == paxMSWindowsRawSD || hdr.WindowsSecurityDescriptor == nil {
+				hdr.WindowsSecurityDescriptor, err = base64.StdEncoding.DecodeString(v)
+			}
+		case paxSchilyFflags:
+			// Flags of other systems remain available in PAXRecords.
+			hdr.Flags, _ = ParseFileFlags(v)
+		case paxSchilyACLAccess, paxSchilyACLDefault:
+			// Malformed ACLs are left in PAXRecords rather than rejected.
+			if acl, err := parseACL(v); err == nil && k == paxSchilyACLAccess {
+				hdr.AccessACL = acl
+			} else if err == nil {
+				hdr.DefaultACL = acl
+			}
 		default:
 			if strings.HasPrefix(k, paxSchilyXattr) {
 				if hdr.Xattrs == nil {
@@ -284,88 +771,134 @@ func mergePAX(hdr *Header, paxHdrs map[string]string) (err error) {
 			return ErrHeader
 		}
 	}
+	if hdr.SELinuxContext == "" {
+		hdr.SELinuxContext = selinuxXattr(paxHdrs)
+	}
 	hdr.PAXRecords = paxHdrs
 	return nil
 }
 
-// parsePAX parses PAX headers.
+// selinuxXattr returns the SELinux context stored in the security.selinux
+// extended attribute by star and bsdtar, if any.
+func selinuxXattr(paxHdrs map[string]string) string {
+	const name = "security.selinux"
+	v, ok := paxHdrs[paxSchilyXattr+name]
+	if !ok {
+		b, err := decodeLibarchiveXattr(paxHdrs[paxLibarchiveXattr+name])
+		if err != nil {
+			return ""
+		}
+		v = string(b)
+	}
+	return strings.TrimRight(v, "\x00") // The kernel includes a NUL terminator
+}
+
+// parsePAX parses PAX headers into paxHdrs, which is allocated if nil.
 // If an extended header (type 'x') is invalid, ErrHeader is returned
-func parsePAX(r io.Reader) (map[string]string, error) {
-	buf, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, err
+//
+// The records are read one at a time with a paxRecordReader, reading r
+// directly if it is a *bufio.Reader, so that the extended header is not
+// held in memory as a whole, besides the values of its records.
+func parsePAX(r io.Reader, paxHdrs map[string]string) (map[string]string, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(r, paxBufferSize)
 	}
-	sbuf := string(buf)
+	pr := paxRecordReader{br}
 
 	// For GNU PAX sparse format 0.0 support.
 	// This function transforms the sparse format 0.0 headers into format 0.1
 	// headers since 0.0 headers were not PAX compliant.
-	var sparseMap []string
+	var sparseMap strings.Builder
+	var sparseLen int
 
-	paxHdrs := make(map[string]string)
-	for len(sbuf) > 0 {
-		key, value, residual, err := parsePAXRecord(sbuf)
+	if paxHdrs == nil {
+		paxHdrs = make(map[string]string)
+	}
+	for {
+		key, value, err := pr.next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return nil, ErrHeader
+			return nil, err
 		}
-		sbuf = residual
 
 		switch key {
 		case paxGNUSparseOffset, paxGNUSparseNumBytes:
 			// Validate sparse header order and value.
-			if (len(sparseMap)%2 == 0 && key != paxGNUSparseOffset) ||
-				(len(sparseMap)%2 == 1 && key != paxGNUSparseNumBytes) ||
+			if (sparseLen%2 == 0 && key != paxGNUSparseOffset) ||
+				(sparseLen%2 == 1 && key != paxGNUSparseNumBytes) ||
 				strings.Contains(value, ",") {
 				return nil, ErrHeader
 			}
-			sparseMap = append(sparseMap, value)
+			if sparseLen > 0 {
+				sparseMap.WriteByte(',')
+			}
+			sparseMap.WriteString(value)
+			sparseLen++
 		default:
 			paxHdrs[key] = value
 		}
 	}
-	if len(sparseMap) > 0 {
-		paxHdrs[paxGNUSparseMap] = strings.Join(sparseMap, ",")
+	if sparseLen > 0 {
+		paxHdrs[paxGNUSparseMap] = sparseMap.String()
 	}
 	return paxHdrs, nil
 }
 
-// readHeader reads the next block header and assumes that the underlying reader
-// is already aligned to a block boundary. It returns the raw block of the
-// header in case further processing is required.
+// clearMap deletes all entries of m and returns it.
+func clearMap(m map[string]string) map[string]string {
+	for k := range m {
+		delete(m, k)
+	}
+	return m
+}
+
+// readHeader reads the next block header into hdr and assumes that the
+// underlying reader is already aligned to a block boundary. It returns the
+// raw block of the header in case further processing is required.
+// All fields of hdr are reset, but unchanged strings are reused.
 //
 // The err will be set to io.EOF only when one of the following occurs:
 //	* Exactly 0 bytes are read and EOF is hit.
 //	* Exactly 1 block of zeros is read and EOF is hit.
 //	* At least 2 blocks of zeros are read.
-func (tr *Reader) readHeader() (*Header, *block, error) {
+func (tr *Reader) readHeader(hdr *Header) (*block, error) {
 	// Two blocks of zero bytes marks the end of the archive.
-	if _, err := io.ReadFull(tr.r, tr.blk[:]); err != nil {
-		return nil, nil, err // EOF is okay here; exactly 0 bytes read
+	if tr.resynced {
+		tr.resynced = false // Use the header found by Resync
+	} else if n, err := io.ReadFull(tr.r, tr.blk[:]); err != nil {
+		return nil, tr.trailerError(n, err) // EOF is okay here; exactly 0 bytes read
 	}
 	if bytes.Equal(tr.blk[:], zeroBlock[:]) {
-		if _, err := io.ReadFull(tr.r, tr.blk[:]); err != nil {
-			return nil, nil, err // EOF is okay here; exactly 1 block of zeros read
+		if n, err := io.ReadFull(tr.r, tr.blk[:]); err != nil {
+			return nil, tr.trailerError(n, err) // EOF is okay here; exactly 1 block of zeros read
 		}
 		if bytes.Equal(tr.blk[:], zeroBlock[:]) {
-			return nil, nil, io.EOF // normal EOF; exactly 2 block of zeros read
+			return nil, io.EOF // normal EOF; exactly 2 block of zeros read
 		}
-		return nil, nil, ErrHeader // Zero block and then non-zero block
+		return nil, ErrHeader // Zero block and then non-zero block
 	}
 
 	// Verify the header matches a known format.
 	format := tr.blk.GetFormat()
+	if format == FormatUnknown && tr.AcceptQuirks {
+		format = tr.blk.getFormatQuirks()
+	}
 	if format == FormatUnknown {
-		return nil, nil, ErrHeader
+		return nil, ErrHeader
 	}
 
-	var p parser
-	hdr := new(Header)
+	p := parser{quirks: tr.AcceptQuirks, arena: &tr.arena}
+	prev := *hdr
+	*hdr = Header{}
 
 	// Unpack the V7 header.
 	v7 := tr.blk.V7()
 	hdr.Typeflag = v7.TypeFlag()[0]
-	hdr.Name = p.parseString(v7.Name())
-	hdr.Linkname = p.parseString(v7.LinkName())
+	hdr.Name = p.reuseString(v7.Name(), prev.Name)
+	hdr.Linkname = p.reuseString(v7.LinkName(), prev.Linkname)
 	hdr.Size = p.parseNumeric(v7.Size())
 	hdr.Mode = p.parseNumeric(v7.Mode())
 	hdr.Uid = int(p.parseNumeric(v7.UID()))
@@ -373,10 +906,18 @@ func (tr *Reader) readHeader() (*Header, *block, error) {
 	hdr.ModTime = time.Unix(p.parseNumeric(v7.ModTime()), 0)
 
 	// Unpack format specific fields.
-	if format > formatV7 {
+	if format > FormatV7 {
 		ustar := tr.blk.USTAR()
-		hdr.Uname = p.parseString(ustar.UserName())
-		hdr.Gname = p.parseString(ustar.GroupName())
+		// Most entries of an archive share their owner, so reuse the
+		// strings of the last entry with one.
+		hdr.Uname = p.reuseString(ustar.UserName(), tr.uname)
+		hdr.Gname = p.reuseString(ustar.GroupName(), tr.gname)
+		if hdr.Uname != "" {
+			tr.uname = hdr.Uname
+		}
+		if hdr.Gname != "" {
+			tr.gname = hdr.Gname
+		}
 		hdr.Devmajor = p.parseNumeric(ustar.DevMajor())
 		hdr.Devminor = p.parseNumeric(ustar.DevMinor())
 
@@ -386,6 +927,11 @@ func (tr *Reader) readHeader() (*Header, *block, error) {
 			hdr.Format = format
 			ustar := tr.blk.USTAR()
 			prefix = p.parseString(ustar.Prefix())
+			if star := tr.blk.STAR(); tr.AcceptQuirks && star.isXUSTAR() {
+				prefix = p.parseString(star.Prefix())
+				hdr.AccessTime = time.Unix(p.parseNumeric(star.AccessTime()), 0)
+				hdr.ChangeTime = time.Unix(p.parseNumeric(star.ChangeTime()), 0)
+			}
 
 			// For Format detection, check if block is properly formatted since
 			// the parser is more liberal than what USTAR actually permits.
@@ -398,19 +944,26 @@ func (tr *Reader) readHeader() (*Header, *block, error) {
 				nul(v7.ModTime()) && nul(ustar.DevMajor()) && nul(ustar.DevMinor())) {
 				hdr.Format = FormatUnknown // Numeric fields must end in NUL
 			}
-		case format.has(formatSTAR):
+		case format.has(FormatSTAR):
 			star := tr.blk.STAR()
 			prefix = p.parseString(star.Prefix())
-			hdr.AccessTime = time.Unix(p.parseNumeric(star.AccessTime()), 0)
-			hdr.ChangeTime = time.Unix(p.parseNumeric(star.ChangeTime()), 0)
+			if b := star.AccessTime(); !isBlankField(b) {
+				hdr.AccessTime = time.Unix(p.parseNumeric(b), 0)
+			}
+			if b := star.ChangeTime(); !isBlankField(b) {
+				hdr.ChangeTime = time.Unix(p.parseNumeric(b), 0)
+			}
 		case format.has(FormatGNU):
 			hdr.Format = format
 			var p2 parser
 			gnu := tr.blk.GNU()
-			if b := gnu.AccessTime(); b[0] != 0 {
+			// The old GNU format stores atime and ctime when they are known
+			// (e.g., for incremental dumps), and otherwise leaves the
+			// fields blank, which must not be mistaken for the Unix epoch.
+			if b := gnu.AccessTime(); !isBlankField(b) {
 				hdr.AccessTime = time.Unix(p2.parseNumeric(b), 0)
 			}
-			if b := gnu.ChangeTime(); b[0] != 0 {
+			if b := gnu.ChangeTime(); !isBlankField(b) {
 				hdr.ChangeTime = time.Unix(p2.parseNumeric(b), 0)
 			}
 
@@ -448,7 +1001,29 @@ func (tr *Reader) readHeader() (*Header, *block, error) {
 			hdr.Name = prefix + "/" + hdr.Name
 		}
 	}
-	return hdr, &tr.blk, p.err
+	return &tr.blk, p.err
+}
+
+// isBlankField reports whether the header field b is unset,
+// meaning that it only contains NULs and spaces.
+func isBlankField(b []byte) bool {
+	for _, c := range b {
+		if c != 0x00 && c != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// trailerError returns the error to report when reading a header block fails
+// with err after reading n bytes into tr.blk. If TolerateTruncatedTrailer is
+// set and those bytes are all zero, the archive is considered to have ended
+// within its trailer.
+func (tr *Reader) trailerError(n int, err error) error {
+	if err == io.ErrUnexpectedEOF && tr.TolerateTruncatedTrailer && bytes.Equal(tr.blk[:n], zeroBlock[:n]) {
+		return io.EOF
+	}
+	return err
 }
 
 // readOldGNUSparseMap reads the sparse map from the old GNU sparse format.
@@ -621,12 +1196,43 @@ func (tr *Reader) Read(b []byte) (int, error) {
 		return 0, tr.err
 	}
 	n, err := tr.curr.Read(b)
-	if err != nil && err != io.EOF {
+	if err != nil && err != io.EOF && !isDigestError(err) {
 		tr.err = err
 	}
 	return n, err
 }
 
+// WriteTo writes the remaining data of the current entry to w,
+// returning the number of bytes written. It implements io.WriterTo,
+// so io.Copy(w, tr) uses it to avoid copying through an intermediate buffer.
+//
+// If w implements io.ReaderFrom, the data of a regular file is passed to it
+// directly as a limited view of the underlying io.Reader. This allows writers
+// such as *net.TCPConn to use system calls like sendfile when the archive is
+// read from an *os.File.
+//
+// Holes in sparse files are written as NUL bytes.
+func (tr *Reader) WriteTo(w io.Writer) (int64, error) {
+	if _, ok := w.(io.Seeker); ok && isSparseReader(tr.curr) {
+		w = struct{ io.Writer }{w} // Do not skip holes with Seek
+	}
+	return tr.writeTo(w)
+}
+
+// isSparseReader reports whether fr reads a sparse file.
+func isSparseReader(fr fileReader) bool {
+	switch fr := fr.(type) {
+	case *sparseFileReader:
+		return true
+	case *digestFileReader:
+		return isSparseReader(fr.fileReader)
+	case *hashFileReader:
+		return isSparseReader(fr.fileReader)
+	default:
+		return false
+	}
+}
+
 // writeTo writes the content of the current file to w.
 // The bytes written matches the number of remaining bytes in the current file.
 //
@@ -642,12 +1248,49 @@ func (tr *Reader) writeTo(w io.Writer) (int64, error) {
 		return 0, tr.err
 	}
 	n, err := tr.curr.WriteTo(w)
-	if err != nil {
+	if err != nil && !isDigestError(err) {
 		tr.err = err
 	}
 	return n, err
 }
 
+// maxRawHeaders is the maximum size of the header blocks of an entry,
+// including any extended headers, that rawRecorder holds in memory.
+const maxRawHeaders = 1 << 20
+
+// rawRecorder is an io.Reader that records the bytes read from r.
+// Once more than maxRawHeaders bytes are read, it discards them and
+// records their offset in r instead, if r is an io.Seeker, so that huge
+// extended headers are not held in memory.
+type rawRecorder struct {
+	r    io.Reader
+	buf  []byte // Bytes read so far, unless size > maxRawHeaders
+	size int64  // Number of bytes read
+	off  int64  // Offset in r of the bytes read if size > maxRawHeaders, or -1
+	ok   bool   // Whether the recorded bytes are the headers of an entry
+	name string // Name of the entry
+	n    int64  // Physical size of the data of the entry
+
+	hdr *Header // Header of the entry, as returned by Next
+}
+
+func (rr *rawRecorder) Read(b []byte) (int, error) {
+	n, err := rr.r.Read(b)
+	rr.size += int64(n)
+	switch {
+	case rr.size <= maxRawHeaders:
+		rr.buf = append(rr.buf, b[:n]...)
+	case rr.size-int64(n) <= maxRawHeaders:
+		rr.buf = rr.buf[:0]
+		if sr, ok := rr.r.(io.Seeker); ok {
+			if pos, err := sr.Seek(0, io.SeekCurrent); err == nil {
+				rr.off = pos - rr.size
+			}
+		}
+	}
+	return n, err
+}
+
 // regFileReader is a fileReader for reading data from a regular file entry.
 type regFileReader struct {
 	r  io.Reader // Underlying Reader
@@ -673,6 +1316,17 @@ func (fr *regFileReader) Read(b []byte) (n int, err error) {
 }
 
 func (fr *regFileReader) WriteTo(w io.Writer) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		// Give w direct access to the underlying reader, since it may be
+		// able to transfer the data without copying it (e.g., with sendfile).
+		lr := &io.LimitedReader{R: fr.r, N: fr.nb}
+		n, err := rf.ReadFrom(lr)
+		fr.nb = lr.N
+		if err == nil && fr.nb > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
 	return io.Copy(w, struct{ io.Reader }{fr})
 }
 
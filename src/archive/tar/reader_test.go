@@ -14,10 +14,12 @@ import (
 	"math"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -274,6 +276,7 @@ func TestReader(t *testing.T) {
 			Name:     "file",
 			Uname:    strings.Repeat("long", 10),
 			ModTime:  time.Unix(0, 0),
+			Comment:  "Hello, 世界",
 			PAXRecords: map[string]string{
 				"GOLANG.pkg": "tar",
 				"comment":    "Hello, 世界",
@@ -361,7 +364,8 @@ func TestReader(t *testing.T) {
 				"SCHILY.xattr.user.key2":        "value2",
 				"SCHILY.xattr.security.selinux": "unconfined_u:object_r:default_t:s0\x00",
 			},
-			Format: FormatPAX,
+			SELinuxContext: "unconfined_u:object_r:default_t:s0",
+			Format:         FormatPAX,
 		}, {
 			Name:       "small2.txt",
 			Mode:       0644,
@@ -383,7 +387,8 @@ func TestReader(t *testing.T) {
 				"ctime": "1386065770.449252304",
 				"SCHILY.xattr.security.selinux": "unconfined_u:object_r:default_t:s0\x00",
 			},
-			Format: FormatPAX,
+			SELinuxContext: "unconfined_u:object_r:default_t:s0",
+			Format:         FormatPAX,
 		}},
 	}, {
 		// Matches the behavior of GNU, BSD, and STAR tar utilities.
@@ -978,6 +983,47 @@ func TestMergePAX(t *testing.T) {
 	}
 }
 
+func TestParsePAXLarge(t *testing.T) {
+	// A sparse map of a million entries is read in chunks.
+	var sb strings.Builder
+	for i := 0; i < 1e6; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.Itoa(i))
+	}
+	sparseMap := sb.String()
+	rec, err := formatPAXRecord(paxGNUSparseMap, sparseMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parsePAX(strings.NewReader("6 k=v\n"+rec+"6 z=w\n"), nil)
+	if err != nil {
+		t.Fatalf("parsePAX() error: %v", err)
+	}
+	if got[paxGNUSparseMap] != sparseMap || got["k"] != "v" || got["z"] != "w" || len(got) != 3 {
+		t.Errorf("parsePAX() of large record returned wrong records")
+	}
+
+	// Keys are limited by the size of the buffer.
+	key := strings.Repeat("k", 2*paxBufferSize)
+	rec, _ = formatPAXRecord(key, "v")
+	if _, err := parsePAX(strings.NewReader(rec), nil); err != ErrHeader {
+		t.Errorf("parsePAX() of record with long key error = %v, want %v", err, ErrHeader)
+	}
+
+	// A bogus length does not cause a large allocation.
+	if _, err := parsePAX(strings.NewReader("999999999999 k=v\n"), nil); err != ErrHeader {
+		t.Errorf("parsePAX() of truncated record error = %v, want %v", err, ErrHeader)
+	}
+
+	// Errors reading the header are reported as is.
+	r := io.MultiReader(strings.NewReader("6 k=v\n30 mti"), iotest.TimeoutReader(strings.NewReader("me")))
+	if _, err := parsePAX(r, nil); err != iotest.ErrTimeout {
+		t.Errorf("parsePAX() with failing reader error = %v, want %v", err, iotest.ErrTimeout)
+	}
+}
+
 func TestParsePAX(t *testing.T) {
 	vectors := []struct {
 		in   string
@@ -1009,7 +1055,7 @@ func TestParsePAX(t *testing.T) {
 
 	for i, v := range vectors {
 		r := strings.NewReader(v.in)
-		got, err := parsePAX(r)
+		got, err := parsePAX(r, nil)
 		if !reflect.DeepEqual(got, v.want) && !(len(got) == 0 && len(v.want) == 0) {
 			t.Errorf("test %d, parsePAX():\ngot  %v\nwant %v", i, got, v.want)
 		}
@@ -1608,3 +1654,840 @@ func TestFileReader(t *testing.T) {
 		}
 	}
 }
+
+func TestReaderDisallowDuplicates(t *testing.T) {
+	vectors := []struct {
+		names []string
+		want  error
+	}{
+		{[]string{"a", "b", "c/"}, nil},
+		{[]string{"a", "a"}, ErrDuplicateName},
+		{[]string{"a/b", "./a//b"}, ErrDuplicateName},
+		{[]string{"dir/", "dir"}, ErrDuplicateName},
+		{[]string{"/abs", "abs"}, ErrDuplicateName},
+		{[]string{"a", "aa"}, nil},
+	}
+
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		for _, name := range v.names {
+			hdr := &Header{Name: name, Typeflag: TypeReg}
+			if strings.HasSuffix(name, "/") {
+				hdr.Typeflag = TypeDir
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("test %d, Close() error: %v", i, err)
+		}
+
+		// Without the option set, all entries are returned.
+		tr := NewReader(bytes.NewReader(b.Bytes()))
+		for range v.names {
+			if _, err := tr.Next(); err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+		}
+
+		tr = NewReader(bytes.NewReader(b.Bytes()))
+		tr.DisallowDuplicates = true
+		var err error
+		for err == nil {
+			_, err = tr.Next()
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if err != v.want {
+			t.Errorf("test %d, Next() error: got %v, want %v", i, err, v.want)
+		}
+		if _, err2 := tr.Next(); err != nil && err2 != err {
+			t.Errorf("test %d, Next() error is not sticky: got %v, want %v", i, err2, err)
+		}
+	}
+}
+
+func TestReaderInsecurePaths(t *testing.T) {
+	vectors := []struct {
+		hdr      Header
+		insecure bool
+	}{
+		{Header{Name: "a/b/c", Typeflag: TypeReg}, false},
+		{Header{Name: "./a/../b", Typeflag: TypeReg}, false},
+		{Header{Name: "/etc/passwd", Typeflag: TypeReg}, true},
+		{Header{Name: "../escape", Typeflag: TypeReg}, true},
+		{Header{Name: "a/../../escape", Typeflag: TypeReg}, true},
+		{Header{Name: "link", Linkname: "a/b", Typeflag: TypeLink}, false},
+		{Header{Name: "link", Linkname: "../b", Typeflag: TypeLink}, true},
+		{Header{Name: "a/link", Linkname: "../b", Typeflag: TypeSymlink}, false},
+		{Header{Name: "a/link", Linkname: "../../b", Typeflag: TypeSymlink}, true},
+		{Header{Name: "a/link", Linkname: "/etc", Typeflag: TypeSymlink}, true},
+	}
+
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := tw.WriteHeader(&v.hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+
+		tr := NewReader(bytes.NewReader(b.Bytes()))
+		if _, err := tr.Next(); err != nil {
+			t.Errorf("test %d, Next() error: got %v, want nil", i, err)
+		}
+
+		tr = NewReader(bytes.NewReader(b.Bytes()))
+		tr.DisallowInsecurePaths = true
+		hdr, err := tr.Next()
+		if got := err == ErrInsecurePath; got != v.insecure {
+			t.Errorf("test %d, Next() error: got %v, want insecure=%v", i, err, v.insecure)
+		}
+		if hdr == nil || hdr.Name != v.hdr.Name {
+			t.Errorf("test %d, Next() did not return the header", i)
+		}
+		if _, err := tr.Next(); err != io.EOF {
+			t.Errorf("test %d, Next() error: got %v, want %v", i, err, io.EOF)
+		}
+	}
+}
+
+func TestReaderNormalizeNames(t *testing.T) {
+	vectors := []struct {
+		hdr      Header
+		name     string
+		linkname string
+	}{
+		{Header{Name: "a/b", Typeflag: TypeReg}, "a/b", ""},
+		{Header{Name: "./a//b", Typeflag: TypeReg}, "a/b", ""},
+		{Header{Name: `a\b\c`, Typeflag: TypeReg}, "a/b/c", ""},
+		{Header{Name: "./", Typeflag: TypeDir}, "./", ""},
+		{Header{Name: "./dir", Typeflag: TypeDir}, "dir/", ""},
+		{Header{Name: `dir\sub\`, Typeflag: TypeDir}, "dir/sub/", ""},
+		{Header{Name: "dir//", Typeflag: TypeDir}, "dir/", ""},
+		{Header{Name: "/abs/./file", Typeflag: TypeReg}, "/abs/file", ""},
+		{Header{Name: "a/../../b", Typeflag: TypeReg}, "../b", ""},
+		{Header{Name: "old/", Typeflag: TypeRegA}, "old/", ""},
+		{Header{Name: "link", Linkname: ".//a/b", Typeflag: TypeLink}, "link", "a/b"},
+		{Header{Name: "link", Linkname: ".//a/b", Typeflag: TypeSymlink}, "link", ".//a/b"},
+	}
+
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := tw.WriteHeader(&v.hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+
+		tr := NewReader(&b)
+		tr.NormalizeNames = true
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if hdr.Name != v.name || hdr.Linkname != v.linkname {
+			t.Errorf("test %d, Next() = {Name: %q, Linkname: %q}, want {%q, %q}", i, hdr.Name, hdr.Linkname, v.name, v.linkname)
+		}
+	}
+}
+
+func TestReaderNextInto(t *testing.T) {
+	// normalize replaces empty maps, which NextInto may leave behind
+	// when reusing a Header, with nil.
+	normalize := func(hdr Header) Header {
+		if len(hdr.PAXRecords) == 0 {
+			hdr.PAXRecords = nil
+		}
+		if len(hdr.Xattrs) == 0 {
+			hdr.Xattrs = nil
+		}
+		return hdr
+	}
+
+	files, err := filepath.Glob("testdata/*.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr1 := NewReader(bytes.NewReader(b))
+		tr2 := NewReader(bytes.NewReader(b))
+		var hdr2 Header
+		for i := 0; ; i++ {
+			hdr1, err1 := tr1.Next()
+			err2 := tr2.NextInto(&hdr2)
+			if err1 != err2 {
+				t.Errorf("%s: entry %d, NextInto() error: got %v, want %v", file, i, err2, err1)
+				break
+			}
+			if err1 != nil {
+				break
+			}
+			if got, want := normalize(hdr2), normalize(*hdr1); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: entry %d, NextInto():\ngot  %+v\nwant %+v", file, i, got, want)
+			}
+			if got := hdr1.PAXRecords; got != nil && len(hdr2.PAXRecords) > 0 {
+				hdr2.PAXRecords["reused"] = "" // Must not affect entries from Next
+				if _, ok := got["reused"]; ok {
+					t.Errorf("%s: entry %d, Next() and NextInto() share PAXRecords", file, i)
+				}
+			}
+		}
+	}
+
+	// Reading a USTAR archive with the same Header does not allocate
+	// once the header's strings have been seen.
+	var buf bytes.Buffer
+	tw := NewWriter(&buf)
+	for i := 0; i < 200; i++ {
+		hdr := &Header{Name: "file", Uname: "gopher", Gname: "gopher", Mode: 0644, Size: 1}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte("x"))
+	}
+	tw.Close()
+	var hdr Header
+	tr := NewReader(bytes.NewReader(buf.Bytes()))
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := tr.NextInto(&hdr); err != nil {
+			t.Fatalf("NextInto() error: %v", err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("NextInto() allocations: got %v, want 0", allocs)
+	}
+}
+
+func TestReaderNextAllocs(t *testing.T) {
+	// Reading a USTAR archive does not allocate for each entry, even if the
+	// entries have different names, and the Headers returned are retained.
+	const n = 2000
+	var buf bytes.Buffer
+	tw := NewWriter(&buf)
+	for i := 0; i < n; i++ {
+		hdr := &Header{Name: fmt.Sprintf("dir/file%d.txt", i), Uname: "gopher", Gname: "staff", Mode: 0644}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+
+	tr := NewReader(bytes.NewReader(buf.Bytes()))
+	allocs := testing.AllocsPerRun(n-1, func() {
+		if _, err := tr.Next(); err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("Next() allocations: got %v, want 0", allocs)
+	}
+
+	tr = NewReader(bytes.NewReader(buf.Bytes()))
+	var hdrs []*Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		hdrs = append(hdrs, hdr)
+	}
+	if len(hdrs) != n {
+		t.Fatalf("got %d entries, want %d", len(hdrs), n)
+	}
+	for i, hdr := range hdrs {
+		if want := fmt.Sprintf("dir/file%d.txt", i); hdr.Name != want || hdr.Uname != "gopher" || hdr.Gname != "staff" {
+			t.Errorf("entry %d, Header = {Name: %q, Uname: %q, Gname: %q}, want {%q, gopher, staff}",
+				i, hdr.Name, hdr.Uname, hdr.Gname, want)
+		}
+	}
+	hdrs[0].Name = "changed"
+	if hdrs[1].Name == "changed" {
+		t.Errorf("Headers returned by Next are shared")
+	}
+}
+
+func TestReaderDecodeName(t *testing.T) {
+	latin1 := func(s string) (string, error) {
+		r := make([]rune, len(s))
+		for i := 0; i < len(s); i++ {
+			r[i] = rune(s[i])
+		}
+		return string(r), nil
+	}
+	errDecode := errors.New("decode error")
+	failing := func(s string) (string, error) { return "", errDecode }
+
+	makePAX := func(hdr *Header) []byte {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Close()
+		return b.Bytes()
+	}
+	gnu, err := ioutil.ReadFile("testdata/gnu-not-utf8.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vectors := []struct {
+		input  []byte
+		decode func(string) (string, error)
+		want   string // Expected Name
+		err    error
+	}{{
+		input: gnu,
+		want:  "hi\x80\x81\x82\x83bye",
+	}, {
+		input:  gnu,
+		decode: latin1,
+		want:   "hi\u0080\u0081\u0082\u0083bye",
+	}, {
+		input:  gnu,
+		decode: failing,
+		err:    errDecode,
+	}, {
+		// PAX records are already UTF-8.
+		input:  makePAX(&Header{Name: "café", Typeflag: TypeReg, Format: FormatPAX}),
+		decode: failing,
+		want:   "café",
+	}, {
+		input: makePAX(&Header{Name: "caf\xe9", Typeflag: TypeReg, Format: FormatPAX,
+			PAXRecords: map[string]string{"hdrcharset": "BINARY"}}),
+		decode: latin1,
+		want:   "café",
+	}}
+
+	for i, v := range vectors {
+		tr := NewReader(bytes.NewReader(v.input))
+		tr.DecodeName = v.decode
+		hdr, err := tr.Next()
+		if err != v.err {
+			t.Errorf("test %d, Next() error: got %v, want %v", i, err, v.err)
+			continue
+		}
+		if err == nil && hdr.Name != v.want {
+			t.Errorf("test %d, Name: got %q, want %q", i, hdr.Name, v.want)
+		}
+	}
+}
+
+func TestReadGNUTimes(t *testing.T) {
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	if err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg, Format: FormatGNU}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	vectors := []struct {
+		atime, ctime string    // Raw contents of the atime and ctime fields
+		wantA, wantC time.Time // Expected AccessTime and ChangeTime
+	}{{
+		atime: "", ctime: "",
+	}, {
+		atime: "           ", ctime: "\x00\x00 ",
+	}, {
+		atime: "12574544610", ctime: "12574544620",
+		wantA: time.Unix(1441974664, 0), wantC: time.Unix(1441974672, 0),
+	}, {
+		atime: "00000000000", ctime: "",
+		wantA: time.Unix(0, 0),
+	}}
+
+	for i, v := range vectors {
+		var blk block
+		copy(blk[:], b.Bytes())
+		copy(blk.GNU().AccessTime(), append([]byte(v.atime), make([]byte, 12)...))
+		copy(blk.GNU().ChangeTime(), append([]byte(v.ctime), make([]byte, 12)...))
+		blk.SetFormat(FormatGNU)
+		input := append(blk[:], make([]byte, 2*blockSize)...)
+
+		hdr, err := NewReader(bytes.NewReader(input)).Next()
+		if err != nil {
+			t.Errorf("test %d, Next() error: %v", i, err)
+			continue
+		}
+		if !hdr.AccessTime.Equal(v.wantA) || hdr.AccessTime.IsZero() != v.wantA.IsZero() {
+			t.Errorf("test %d, AccessTime: got %v, want %v", i, hdr.AccessTime, v.wantA)
+		}
+		if !hdr.ChangeTime.Equal(v.wantC) || hdr.ChangeTime.IsZero() != v.wantC.IsZero() {
+			t.Errorf("test %d, ChangeTime: got %v, want %v", i, hdr.ChangeTime, v.wantC)
+		}
+	}
+}
+
+func TestReaderGlobalPAXRecords(t *testing.T) {
+	type entry struct {
+		name    string
+		modTime time.Time
+		global  map[string]string // Expected GlobalPAXRecords after Next
+	}
+	global1 := map[string]string{"path": "global1", "mtime": "1500000000.0"}
+	global2 := map[string]string{"mtime": "1500000000.0"}
+	vectors := []struct {
+		apply bool
+		want  []entry
+	}{{
+		apply: false,
+		want: []entry{
+			{"global1", time.Time{}, global1},
+			{"file1", time.Unix(0, 0), global1},
+			{"file2", time.Unix(0, 0), global1},
+			{"GlobalHead.0.0", time.Time{}, global2},
+			{"file3", time.Unix(0, 0), global2},
+			{"file4", time.Unix(1400000000, 0), global2},
+		},
+	}, {
+		// This is the result required by the PAX specification.
+		apply: true,
+		want: []entry{
+			{"global1", time.Time{}, global1},
+			{"global1", time.Unix(1500000000, 0), global1},
+			{"file2", time.Unix(1500000000, 0), global1},
+			{"GlobalHead.0.0", time.Time{}, global2},
+			{"file3", time.Unix(1500000000, 0), global2},
+			{"file4", time.Unix(1400000000, 0), global2},
+		},
+	}}
+
+	for i, v := range vectors {
+		f, err := os.Open("testdata/pax-global-records.tar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := NewReader(f)
+		tr.ApplyGlobalPAXRecords = v.apply
+		if got := tr.GlobalPAXRecords(); got != nil {
+			t.Errorf("test %d, initial GlobalPAXRecords() = %v, want nil", i, got)
+		}
+		for j, want := range v.want {
+			hdr, err := tr.Next()
+			if err != nil {
+				t.Fatalf("test %d, entry %d, Next() error: %v", i, j, err)
+			}
+			if hdr.Name != want.name || !hdr.ModTime.Equal(want.modTime) {
+				t.Errorf("test %d, entry %d, got (%q, %v), want (%q, %v)",
+					i, j, hdr.Name, hdr.ModTime, want.name, want.modTime)
+			}
+			if got := tr.GlobalPAXRecords(); !reflect.DeepEqual(got, want.global) {
+				t.Errorf("test %d, entry %d, GlobalPAXRecords() = %v, want %v", i, j, got, want.global)
+			}
+		}
+		if _, err := tr.Next(); err != io.EOF {
+			t.Errorf("test %d, final Next() error: got %v, want io.EOF", i, err)
+		}
+		f.Close()
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	f, err := ioutil.ReadFile("testdata/gnu.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := ioutil.ReadFile("testdata/pax-global-records.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := NewReader(bytes.NewReader(g))
+	tr.DisallowDuplicates = true
+	for {
+		if _, err := tr.Next(); err != nil {
+			break
+		}
+	}
+	if tr.GlobalPAXRecords() == nil {
+		t.Fatal("GlobalPAXRecords() = nil, want records")
+	}
+
+	// Reading the same archive twice does not report duplicates.
+	for i := 0; i < 2; i++ {
+		tr.Reset(bytes.NewReader(f))
+		if !tr.DisallowDuplicates {
+			t.Errorf("test %d, Reset cleared DisallowDuplicates", i)
+		}
+		if got := tr.GlobalPAXRecords(); got != nil {
+			t.Errorf("test %d, GlobalPAXRecords() = %v, want nil", i, got)
+		}
+		var names []string
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+			names = append(names, hdr.Name)
+		}
+		if want := []string{"small.txt", "small2.txt"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("test %d, names = %v, want %v", i, names, want)
+		}
+	}
+
+	// Resume reading at the second entry.
+	toc, err := List(bytes.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Reset(io.NewSectionReader(bytes.NewReader(f), toc[1].HeaderOffset, int64(len(f))))
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "small2.txt" {
+		t.Fatalf("Next() after resume = (%v, %v), want small2.txt", hdr, err)
+	}
+	if b, err := ioutil.ReadAll(tr); err != nil || int64(len(b)) != hdr.Size {
+		t.Errorf("ReadAll() after resume = (%d bytes, %v), want %d bytes", len(b), err, hdr.Size)
+	}
+}
+
+// readFromRecorder records the io.Reader passed to ReadFrom.
+type readFromRecorder struct {
+	bytes.Buffer
+	src io.Reader
+}
+
+func (w *readFromRecorder) ReadFrom(r io.Reader) (int64, error) {
+	w.src = r
+	return w.Buffer.ReadFrom(r)
+}
+
+func TestReaderWriteTo(t *testing.T) {
+	const data = "Hello, world!\n"
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg, Size: int64(len(data))})
+	io.WriteString(tw, data)
+	tw.Close()
+
+	// Regular files are passed to io.ReaderFrom as a limited reader.
+	br := bytes.NewReader(b.Bytes())
+	tr := NewReader(br)
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	var w readFromRecorder
+	if n, err := io.Copy(&w, tr); err != nil || n != int64(len(data)) || w.String() != data {
+		t.Errorf("io.Copy() = (%d, %v), data %q, want (%d, nil), data %q", n, err, w.String(), len(data), data)
+	}
+	if lr, ok := w.src.(*io.LimitedReader); !ok || lr.R != io.Reader(br) {
+		t.Errorf("ReadFrom called with %#v, want *io.LimitedReader of the archive", w.src)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("Next() error: got %v, want io.EOF", err)
+	}
+
+	// Truncated data is reported.
+	tr = NewReader(bytes.NewReader(b.Bytes()[:blockSize+5]))
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.WriteTo(new(bytes.Buffer)); err != io.ErrUnexpectedEOF {
+		t.Errorf("WriteTo(truncated) error: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// Holes in sparse files are written out, even if w can seek.
+	f, err := os.Open("testdata/sparse-formats.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr = NewReader(f)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempFile("", "tar-writeto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	tmp.Write(bytes.Repeat([]byte("x"), int(hdr.Size)))
+	tmp.Seek(0, io.SeekStart)
+	if n, err := tr.WriteTo(tmp); err != nil || n != hdr.Size {
+		t.Fatalf("WriteTo() = (%d, %v), want (%d, nil)", n, err, hdr.Size)
+	}
+	got, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.IndexByte(got, 'x') >= 0 {
+		t.Errorf("WriteTo() did not overwrite holes in sparse file")
+	}
+}
+
+func TestReaderResync(t *testing.T) {
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	var offsets []int
+	for _, name := range []string{"first", "second", "third"} {
+		tw.Flush()
+		offsets = append(offsets, b.Len())
+		data := strings.Repeat(name, 200)
+		if err := tw.WriteHeader(&Header{Name: name, Typeflag: TypeReg, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, data)
+	}
+	tw.Close()
+
+	// Damage the header of the second entry.
+	input := b.Bytes()
+	input[offsets[1]] ^= 0xff
+
+	tr := NewReader(bytes.NewReader(input))
+	if hdr, err := tr.Next(); err != nil || hdr.Name != "first" {
+		t.Fatalf("Next() = (%v, %v), want first", hdr, err)
+	}
+	if _, err := tr.Next(); err != ErrHeader {
+		t.Fatalf("Next() error: got %v, want %v", err, ErrHeader)
+	}
+	if _, err := tr.Next(); err != ErrHeader {
+		t.Fatalf("Next() error is not sticky: got %v, want %v", err, ErrHeader)
+	}
+	n, err := tr.Resync()
+	if want := int64(offsets[2] - offsets[1] - blockSize); err != nil || n != want {
+		t.Errorf("Resync() = (%d, %v), want (%d, nil)", n, err, want)
+	}
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "third" {
+		t.Fatalf("Next() after Resync = (%v, %v), want third", hdr, err)
+	}
+	if data, err := ioutil.ReadAll(tr); err != nil || string(data) != strings.Repeat("third", 200) {
+		t.Errorf("ReadAll() after Resync = (%q, %v)", data, err)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("Next() error: got %v, want io.EOF", err)
+	}
+
+	// Resync in the middle of an entry skips to the next block boundary.
+	tr = NewReader(bytes.NewReader(input[offsets[2]:]))
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	io.CopyN(ioutil.Discard, tr, 10)
+	if n, err := tr.Resync(); err != io.EOF {
+		t.Errorf("Resync() at end = (%d, %v), want io.EOF", n, err)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("Next() after failed Resync error: got %v, want io.EOF", err)
+	}
+}
+
+func TestReaderTruncatedTrailer(t *testing.T) {
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg, Size: 5})
+	io.WriteString(tw, "hello")
+	tw.Close()
+	input := b.Bytes()
+
+	vectors := []struct {
+		size       int  // Length of the input
+		garbage    bool // Whether the last byte is non-zero
+		tolerate   bool // Value of TolerateTruncatedTrailer
+		err1, err2 error
+	}{
+		{blockSize + 5, false, false, nil, io.EOF},   // No padding or trailer
+		{blockSize + 100, false, false, nil, io.EOF}, // Partial padding
+		{2 * blockSize, false, false, nil, io.EOF},   // No trailer
+		{2*blockSize + 100, false, false, nil, io.ErrUnexpectedEOF},
+		{2*blockSize + 100, false, true, nil, io.EOF},
+		{2*blockSize + 100, true, true, nil, io.ErrUnexpectedEOF},
+		{3*blockSize + 100, false, false, nil, io.ErrUnexpectedEOF},
+		{3*blockSize + 100, false, true, nil, io.EOF},
+		{4 * blockSize, false, false, nil, io.EOF},
+		{blockSize + 3, false, true, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF}, // Truncated data
+	}
+	for i, v := range vectors {
+		in := append([]byte(nil), input[:v.size]...)
+		if v.garbage {
+			in[len(in)-1] = 1
+		}
+		tr := NewReader(bytes.NewReader(in))
+		tr.TolerateTruncatedTrailer = v.tolerate
+		if _, err := tr.Next(); err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if _, err := ioutil.ReadAll(tr); err != v.err1 {
+			t.Errorf("test %d, ReadAll() error: got %v, want %v", i, err, v.err1)
+		}
+		if _, err := tr.Next(); err != v.err2 {
+			t.Errorf("test %d, Next() error: got %v, want %v", i, err, v.err2)
+		}
+	}
+}
+
+func TestReaderQuirks(t *testing.T) {
+	makeInput := func(hdr *Header) []byte {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Close()
+		return b.Bytes()
+	}
+	// fixChecksum updates the checksum of the header block at b.
+	fixChecksum := func(b []byte, format Format) {
+		var blk block
+		copy(blk[:], b)
+		blk.SetFormat(format)
+		copy(b, blk[:])
+	}
+	longName := strings.Repeat("long", 30)
+
+	vectors := []struct {
+		label  string
+		input  []byte
+		modify func(b []byte)
+		want   Header // Fields checked are Name, Mode, Typeflag and AccessTime
+	}{{
+		label: "garbage after numeric field",
+		input: makeInput(&Header{Name: "file", Mode: 0644, Typeflag: TypeReg, Format: FormatUSTAR}),
+		modify: func(b []byte) {
+			copy(b[100:108], "644 X\x00\x00\x00")
+			fixChecksum(b, FormatUSTAR)
+		},
+		want: Header{Name: "file", Mode: 0644, Typeflag: TypeReg},
+	}, {
+		label: "checksum computed with NUL checksum field",
+		input: makeInput(&Header{Name: "file", Mode: 0644, Typeflag: TypeReg, Format: FormatUSTAR}),
+		modify: func(b []byte) {
+			var blk block
+			copy(blk[:], b)
+			chksum, _ := blk.ComputeChecksum()
+			var f formatter
+			f.formatOctal(b[148:155], chksum-8*' ')
+		},
+		want: Header{Name: "file", Mode: 0644, Typeflag: TypeReg},
+	}, {
+		label: "Solaris extended header",
+		input: makeInput(&Header{Name: longName, Mode: 0644, Typeflag: TypeReg, Format: FormatPAX}),
+		modify: func(b []byte) {
+			b[156] = 'X'
+			fixChecksum(b, FormatUSTAR)
+		},
+		want: Header{Name: longName, Mode: 0644, Typeflag: TypeReg},
+	}, {
+		label: "star xustar times",
+		input: makeInput(&Header{Name: "file", Mode: 0644, Typeflag: TypeReg, Format: FormatUSTAR}),
+		modify: func(b []byte) {
+			copy(b[476:488], "12574544610 ")
+			copy(b[488:500], "12574544620 ")
+			fixChecksum(b, FormatUSTAR)
+		},
+		want: Header{Name: "file", Mode: 0644, Typeflag: TypeReg, AccessTime: time.Unix(1441974664, 0)},
+	}}
+
+	for _, v := range vectors {
+		v.modify(v.input)
+
+		tr := NewReader(bytes.NewReader(v.input))
+		hdr, err := tr.Next()
+		if err == nil && hdr.Name == v.want.Name && hdr.Typeflag == v.want.Typeflag && hdr.AccessTime.Equal(v.want.AccessTime) {
+			t.Errorf("%s: quirk handled without AcceptQuirks", v.label)
+		}
+
+		tr = NewReader(bytes.NewReader(v.input))
+		tr.AcceptQuirks = true
+		hdr, err = tr.Next()
+		if err != nil {
+			t.Errorf("%s: Next() error: %v", v.label, err)
+			continue
+		}
+		if hdr.Name != v.want.Name || hdr.Mode != v.want.Mode || hdr.Typeflag != v.want.Typeflag || !hdr.AccessTime.Equal(v.want.AccessTime) {
+			t.Errorf("%s: Next() = %+v, want %+v", v.label, hdr, v.want)
+		}
+	}
+}
+
+func TestReaderHandleUnknownType(t *testing.T) {
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	entries := []struct {
+		hdr  Header
+		data string
+	}{
+		{Header{Name: "file1", Typeflag: TypeReg, Size: 5}, "hello"},
+		{Header{Name: "vendor", Typeflag: 'Z', Size: 6}, "custom"},
+		{Header{Name: "dumpdir", Typeflag: 'D', Size: 7}, "Nfile\x00\x00"},
+		{Header{Name: "file2", Typeflag: TypeReg, Size: 5}, "world"},
+	}
+	for _, e := range entries {
+		if err := tw.WriteHeader(&e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, e.data)
+	}
+	tw.Close()
+
+	// Without a handler, unknown entries are returned by Next.
+	tr := NewReader(bytes.NewReader(b.Bytes()))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	if want := []string{"file1", "vendor", "dumpdir", "file2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names without handler = %v, want %v", names, want)
+	}
+
+	// With a handler, they are passed to it along with their data.
+	tr = NewReader(bytes.NewReader(b.Bytes()))
+	var handled []string
+	tr.HandleUnknownType = func(hdr *Header, r io.Reader) error {
+		data := make([]byte, 3) // Only read part of the data
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		handled = append(handled, fmt.Sprintf("%c:%s:%s", hdr.Typeflag, hdr.Name, data))
+		return nil
+	}
+	names = nil
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if data, _ := ioutil.ReadAll(tr); int64(len(data)) != hdr.Size {
+			t.Errorf("%s: read %d bytes, want %d", hdr.Name, len(data), hdr.Size)
+		}
+	}
+	if want := []string{"file1", "file2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names with handler = %v, want %v", names, want)
+	}
+	if want := []string{"Z:vendor:cus", "D:dumpdir:Nfi"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled = %v, want %v", handled, want)
+	}
+
+	// Errors from the handler are returned by Next.
+	errFail := errors.New("unsupported")
+	tr = NewReader(bytes.NewReader(b.Bytes()))
+	tr.HandleUnknownType = func(*Header, io.Reader) error { return errFail }
+	tr.Next()
+	if _, err := tr.Next(); err != errFail {
+		t.Errorf("Next() error: got %v, want %v", err, errFail)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// recordWriter is an io.Writer that writes to w in records of a fixed size.
+type recordWriter struct {
+	w   io.Writer
+	buf []byte // Record being filled
+	n   int    // Number of bytes in buf
+}
+
+func newRecordWriter(w io.Writer, size int64) *recordWriter {
+	return &recordWriter{w: w, buf: make([]byte, size)}
+}
+
+func (rw *recordWriter) Write(b []byte) (int, error) {
+	var n int
+	for len(b) > 0 {
+		m := copy(rw.buf[rw.n:], b)
+		rw.n += m
+		b = b[m:]
+		if rw.n == len(rw.buf) {
+			if _, err := rw.w.Write(rw.buf); err != nil {
+				return n, err
+			}
+			rw.n = 0
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// flush pads the current record with zeros and writes it, if it is not empty.
+func (rw *recordWriter) flush() error {
+	if rw.n == 0 {
+		return nil
+	}
+	for i := rw.n; i < len(rw.buf); i++ {
+		rw.buf[i] = 0
+	}
+	rw.n = 0
+	_, err := rw.w.Write(rw.buf)
+	return err
+}
+
+// SkipPadding reads the rest of the input once Next has returned io.EOF at
+// the end of the archive, and returns the blocking factor of the archive:
+// the number of 512-byte blocks per record, as implied by the zeros that
+// pad the archive to a whole number of records.
+//
+// The padding only shows that the records are larger than it, and that they
+// divide the size of the archive, so the result is a best guess. Of the
+// blocking factors consistent with both, SkipPadding reports the largest
+// one up to 20, which is the default of GNU tar, bsdtar and star, and
+// otherwise the smallest one. If the archive is not padded, the blocking
+// factor is reported as 1.
+//
+// The padding must consist of zero bytes; otherwise SkipPadding returns
+// ErrHeader. It returns a blocking factor of 0 if it cannot be determined,
+// such as when the size of the input is not a multiple of 512 bytes, or
+// after a call to Resync.
+func (tr *Reader) SkipPadding() (int, error) {
+	if tr.err != io.EOF {
+		if tr.err == nil {
+			return 0, errors.New("archive/tar: SkipPadding before the end of the archive")
+		}
+		return 0, tr.err
+	}
+	var pad int64
+	for {
+		n, err := tr.r.Read(tr.blk[:])
+		if !bytes.Equal(tr.blk[:n], zeroBlock[:n]) {
+			return 0, ErrHeader
+		}
+		pad += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	total := tr.end + pad
+	if tr.end < 0 || total%blockSize != 0 {
+		return 0, nil
+	}
+	if pad == 0 {
+		return 1, nil
+	}
+	// The padding is shorter than a record, and the archive is made of
+	// whole records.
+	var guess int64
+	for b := pad/blockSize + 1; b <= total/blockSize; b++ {
+		if total%(b*blockSize) == 0 {
+			if guess == 0 || b <= 20 {
+				guess = b
+			}
+			if b >= 20 {
+				break
+			}
+		}
+	}
+	return int(guess), nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// writeSizes records the sizes of the writes to it.
+type writeSizes struct {
+	bytes.Buffer
+	sizes []int
+}
+
+func (w *writeSizes) Write(b []byte) (int, error) {
+	w.sizes = append(w.sizes, len(b))
+	return w.Buffer.Write(b)
+}
+
+func TestWriterBlockingFactor(t *testing.T) {
+	for _, bf := range []int{1, 4, 20} {
+		var w writeSizes
+		tw := NewWriter(&w)
+		tw.BlockingFactor = bf
+		for i, data := range []string{"hello", strings.Repeat("x", 5000), ""} {
+			hdr := &Header{Name: strings.Repeat("a", i+1), Typeflag: TypeReg, Size: int64(len(data))}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatalf("WriteHeader() error: %v", err)
+			}
+			io.WriteString(tw, data)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+		for _, n := range w.sizes {
+			if n != bf*blockSize {
+				t.Errorf("blocking factor %d, got write of %d bytes", bf, n)
+				break
+			}
+		}
+
+		tr := NewReader(&w)
+		for {
+			if _, err := tr.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("blocking factor %d, Next() error: %v", bf, err)
+			}
+		}
+		// Other blocking factors cannot be told apart from the padding.
+		if bf != 1 && bf != 20 {
+			continue
+		}
+		if got, err := tr.SkipPadding(); got != bf || err != nil {
+			t.Errorf("SkipPadding() = (%d, %v), want (%d, nil)", got, err, bf)
+		}
+	}
+
+	var volumes []*bytes.Buffer
+	tw := NewWriter(ioutil.Discard)
+	tw.BlockingFactor = 4
+	tw.VolumeSize = 6 * blockSize
+	tw.NextVolume = func(int) (io.Writer, error) { return nil, nil }
+	if err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg}); err == nil {
+		t.Errorf("WriteHeader() with VolumeSize not a multiple of the record size succeeded")
+	}
+	tw.VolumeSize = 8 * blockSize
+	tw.NextVolume = func(int) (io.Writer, error) {
+		volumes = append(volumes, new(bytes.Buffer))
+		return volumes[len(volumes)-1], nil
+	}
+	if err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg, Size: 10000}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	tw.Write(make([]byte, 10000))
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	for i, v := range volumes {
+		if v.Len()%(4*blockSize) != 0 {
+			t.Errorf("volume %d has size %d, not a multiple of the record size", i+2, v.Len())
+		}
+	}
+}
+
+func TestReaderSkipPadding(t *testing.T) {
+	vectors := []struct {
+		file    string
+		want    int
+		wantErr bool
+	}{
+		{"testdata/pax.tar", 20, false},
+		{"testdata/gnu.tar", 1, false},
+	}
+	for i, v := range vectors {
+		f, err := os.Open(v.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		tr := NewReader(f)
+		if _, err := tr.SkipPadding(); err == nil {
+			t.Errorf("test %d, SkipPadding() before end of archive succeeded", i)
+		}
+		for {
+			if _, err := tr.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+		}
+		if got, err := tr.SkipPadding(); got != v.want || err != nil {
+			t.Errorf("test %d, SkipPadding() = (%d, %v), want (%d, nil)", i, got, err, v.want)
+		}
+	}
+
+	data := makeArchive(t, testEntry{hdr: Header{Name: "file", Typeflag: TypeReg}, body: "hello"})
+	tr := NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader("garbage")))
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		}
+	}
+	if _, err := tr.SkipPadding(); err != ErrHeader {
+		t.Errorf("SkipPadding() with garbage error: got %v, want %v", err, ErrHeader)
+	}
+}
+
+func TestWriterTrailerBlocks(t *testing.T) {
+	vectors := []struct {
+		trailer, bf int
+		wantSize    int
+	}{
+		{0, 0, 4 * blockSize},
+		{1, 0, 3 * blockSize},
+		{5, 0, 7 * blockSize},
+		{-1, 0, 2 * blockSize},
+		{1, 20, 20 * blockSize},
+		{-1, 1, 2 * blockSize},
+	}
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		tw.TrailerBlocks, tw.BlockingFactor = v.trailer, v.bf
+		if err := tw.WriteHeader(&Header{Name: "file", Size: 5}); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		io.WriteString(tw, "hello")
+		if err := tw.Close(); err != nil {
+			t.Fatalf("test %d, Close() error: %v", i, err)
+		}
+		if b.Len() != v.wantSize {
+			t.Errorf("test %d, archive size = %d, want %d", i, b.Len(), v.wantSize)
+		}
+
+		tr := NewReader(&b)
+		if hdr, err := tr.Next(); err != nil || hdr.Name != "file" {
+			t.Errorf("test %d, Next() = (%v, %v), want file", i, hdr, err)
+		}
+		if _, err := tr.Next(); err != io.EOF {
+			t.Errorf("test %d, final Next() error: got %v, want %v", i, err, io.EOF)
+		}
+	}
+}
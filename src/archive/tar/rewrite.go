@@ -0,0 +1,95 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"errors"
+	"io"
+)
+
+// SkipEntry is used as a return value from a RewriteFunc to indicate that
+// the entry is to be dropped from the archive. It is not returned as an
+// error by Rewrite.
+var SkipEntry = errors.New("skip this entry")
+
+// A RewriteFunc is called by Rewrite for each entry of the source archive,
+// with its Header and a Reader for its data.
+//
+// It may modify hdr in place, and returns the Reader for the data to write,
+// which is r to keep the data of the entry, or another Reader to replace it,
+// in which case hdr.Size must be set to its length. A RewriteFunc that
+// reads from r must return a replacement, such as a bytes.Reader holding
+// what it read. Returning SkipEntry drops the entry, and returning any other
+// error stops Rewrite.
+type RewriteFunc func(hdr *Header, r io.Reader) (io.Reader, error)
+
+// Rewrite reads the archive from r and writes a new archive to w, calling fn
+// for each entry to modify, replace or drop it.
+//
+// Entries for which fn changes neither the Header nor the data are copied
+// with Writer.CopyFrom, preserving their original encoding, including
+// extension records and details that the Header does not represent. Other
+// entries are written with Writer.WriteHeader, keeping the Format and
+// PAXRecords of the Header unless fn changes them; fn should clear the
+// Format if its changes cannot be represented in the original format.
+// Modified GNU sparse files are written as regular files.
+func Rewrite(w io.Writer, r io.Reader, fn RewriteFunc) error {
+	tr := NewReader(r)
+	tw := NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != ErrInsecurePath {
+			return err
+		}
+
+		orig := hdr.clone()
+		data := &rewriteReader{r: tr}
+		nr, err := fn(hdr, data)
+		if err == SkipEntry {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if nr == io.Reader(data) {
+			if data.read {
+				return errors.New("archive/tar: RewriteFunc read the data without replacing it")
+			}
+			if CompareHeaders(orig, hdr) == nil {
+				if _, err := tw.CopyFrom(tr); err != nil {
+					return err
+				}
+				continue
+			}
+			nr = tr
+		}
+
+		if hdr.Typeflag == TypeGNUSparse {
+			hdr.Typeflag = TypeReg
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, nr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// rewriteReader is the Reader passed to a RewriteFunc,
+// which records whether it has been read from.
+type rewriteReader struct {
+	r    io.Reader
+	read bool
+}
+
+func (rr *rewriteReader) Read(b []byte) (int, error) {
+	rr.read = true
+	return rr.r.Read(b)
+}
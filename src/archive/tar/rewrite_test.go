@@ -0,0 +1,127 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	src := makeArchive(t,
+		testEntry{hdr: Header{Name: "keep", Typeflag: TypeReg, Mode: 0644, PAXRecords: map[string]string{"MYAPP.id": "1"}}, body: "keep"},
+		testEntry{hdr: Header{Name: "rename", Typeflag: TypeReg, Mode: 0644, PAXRecords: map[string]string{"MYAPP.id": "2"}}, body: "rename"},
+		testEntry{hdr: Header{Name: "replace", Typeflag: TypeReg, Mode: 0644}, body: "old"},
+		testEntry{hdr: Header{Name: "drop", Typeflag: TypeReg, Mode: 0644}, body: "drop"},
+	)
+
+	var buf bytes.Buffer
+	if err := Rewrite(&buf, bytes.NewReader(src), func(hdr *Header, r io.Reader) (io.Reader, error) {
+		return r, nil
+	}); err != nil {
+		t.Fatalf("Rewrite() error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), src) {
+		t.Errorf("Rewrite() without changes modified the archive")
+	}
+
+	buf.Reset()
+	err := Rewrite(&buf, bytes.NewReader(src), func(hdr *Header, r io.Reader) (io.Reader, error) {
+		switch hdr.Name {
+		case "rename":
+			hdr.Name = "renamed"
+		case "replace":
+			hdr.Size = 3
+			return strings.NewReader("new"), nil
+		case "drop":
+			return nil, SkipEntry
+		}
+		return r, nil
+	})
+	if err != nil {
+		t.Fatalf("Rewrite() error: %v", err)
+	}
+	want := []struct {
+		name, data, id string
+	}{
+		{"keep", "keep", "1"},
+		{"renamed", "rename", "2"},
+		{"replace", "new", ""},
+	}
+	tr := NewReader(&buf)
+	for i, v := range want {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("test %d, ReadAll() error: %v", i, err)
+		}
+		if hdr.Name != v.name || string(data) != v.data || hdr.PAXRecords["MYAPP.id"] != v.id {
+			t.Errorf("test %d, got %q with data %q and id %q, want %q with data %q and id %q",
+				i, hdr.Name, data, hdr.PAXRecords["MYAPP.id"], v.name, v.data, v.id)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("Next() at end error: %v, want io.EOF", err)
+	}
+
+	errStop := errors.New("stop")
+	if err := Rewrite(ioutil.Discard, bytes.NewReader(src), func(hdr *Header, r io.Reader) (io.Reader, error) {
+		return nil, errStop
+	}); err != errStop {
+		t.Errorf("Rewrite() error: %v, want %v", err, errStop)
+	}
+	if err := Rewrite(ioutil.Discard, bytes.NewReader(src), func(hdr *Header, r io.Reader) (io.Reader, error) {
+		ioutil.ReadAll(r)
+		return r, nil
+	}); err == nil {
+		t.Errorf("Rewrite() of read data succeeded")
+	}
+}
+
+func TestRewriteSparse(t *testing.T) {
+	src, err := ioutil.ReadFile("testdata/sparse-formats.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Rewrite(&buf, bytes.NewReader(src), func(hdr *Header, r io.Reader) (io.Reader, error) {
+		hdr.Mode = 0600
+		return r, nil
+	}); err != nil {
+		t.Fatalf("Rewrite() error: %v", err)
+	}
+
+	want, got := NewReader(bytes.NewReader(src)), NewReader(&buf)
+	for i := 0; ; i++ {
+		wantHdr, err := want.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotHdr, err := got.Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		wantData, _ := ioutil.ReadAll(want)
+		gotData, err := ioutil.ReadAll(got)
+		if err != nil {
+			t.Fatalf("test %d, ReadAll() error: %v", i, err)
+		}
+		if gotHdr.Name != wantHdr.Name || os.FileMode(gotHdr.Mode) != 0600 || !bytes.Equal(gotData, wantData) {
+			t.Errorf("test %d, got %q with mode %v, want %q with mode 0600 and the same data",
+				i, gotHdr.Name, os.FileMode(gotHdr.Mode), wantHdr.Name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func init() {
+	sysSecurityDescriptor = fileSecurityDescriptor
+	sysSetSecurityDescriptor = setSecurityDescriptor
+}
+
+var (
+	modadvapi32          = syscall.NewLazyDLL("advapi32.dll")
+	procGetFileSecurityW = modadvapi32.NewProc("GetFileSecurityW")
+	procSetFileSecurityW = modadvapi32.NewProc("SetFileSecurityW")
+)
+
+// Security descriptor constants, from the Windows SDK.
+const (
+	ownerSecurityInformation = 0x1
+	groupSecurityInformation = 0x2
+	daclSecurityInformation  = 0x4
+	saclSecurityInformation  = 0x8
+
+	seDACLPresent  = 0x0004
+	seSACLPresent  = 0x0010
+	seSelfRelative = 0x8000
+
+	errorInsufficientBuffer syscall.Errno = 122
+	errorInvalidOwner       syscall.Errno = 1307
+	errorPrivilegeNotHeld   syscall.Errno = 1314
+)
+
+var errSecurityDescriptor = errors.New("archive/tar: invalid security descriptor")
+
+// fileSecurityDescriptor returns the self-relative security descriptor of
+// the file at path, in the form stored in MSWINDOWS.rawsecuritydescriptor
+// PAX records.
+func fileSecurityDescriptor(path string) ([]byte, error) {
+	info := uint32(ownerSecurityInformation | groupSecurityInformation | daclSecurityInformation | saclSecurityInformation)
+	sd, err := getFileSecurity(path, info)
+	if err == errorPrivilegeNotHeld {
+		// Reading the SACL requires the SeSecurityPrivilege privilege.
+		sd, err = getFileSecurity(path, info&^saclSecurityInformation)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "getfilesecurity", Path: path, Err: err}
+	}
+	return sd, nil
+}
+
+func getFileSecurity(path string, info uint32) ([]byte, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 256)
+	for {
+		var n uint32
+		r, _, e := procGetFileSecurityW.Call(uintptr(unsafe.Pointer(p)), uintptr(info),
+			uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), uintptr(unsafe.Pointer(&n)))
+		switch {
+		case r != 0:
+			return b[:n], nil
+		case e == errorInsufficientBuffer && int(n) > len(b):
+			b = make([]byte, n)
+		default:
+			return nil, e
+		}
+	}
+}
+
+// setSecurityDescriptor sets the parts of the self-relative security
+// descriptor sd that it holds on the file at p. If the process is not
+// privileged to set the owner or SACL, only the group and DACL are set.
+func setSecurityDescriptor(p string, sd []byte) error {
+	info, err := securityInformation(sd)
+	if err != nil {
+		return &os.PathError{Op: "setfilesecurity", Path: p, Err: err}
+	}
+	err = setFileSecurity(p, info, sd)
+	if err == errorPrivilegeNotHeld || err == errorInvalidOwner {
+		err = setFileSecurity(p, info&^(ownerSecurityInformation|saclSecurityInformation), sd)
+	}
+	if err != nil {
+		return &os.PathError{Op: "setfilesecurity", Path: p, Err: err}
+	}
+	return nil
+}
+
+func setFileSecurity(p string, info uint32, sd []byte) error {
+	if info == 0 {
+		return nil
+	}
+	pp, err := syscall.UTF16PtrFromString(p)
+	if err != nil {
+		return err
+	}
+	r, _, e := procSetFileSecurityW.Call(uintptr(unsafe.Pointer(pp)), uintptr(info), uintptr(unsafe.Pointer(&sd[0])))
+	if r == 0 {
+		return e
+	}
+	return nil
+}
+
+// securityInformation returns the SECURITY_INFORMATION flags for the parts
+// that the self-relative security descriptor sd holds.
+func securityInformation(sd []byte) (uint32, error) {
+	// The header of a SECURITY_DESCRIPTOR_RELATIVE is the revision, a
+	// reserved byte, the control flags, and the offsets of the owner,
+	// group, SACL and DACL.
+	if len(sd) < 20 || sd[0] != 1 {
+		return 0, errSecurityDescriptor
+	}
+	control := binary.LittleEndian.Uint16(sd[2:])
+	if control&seSelfRelative == 0 {
+		return 0, errSecurityDescriptor
+	}
+	var info uint32
+	if binary.LittleEndian.Uint32(sd[4:]) != 0 {
+		info |= ownerSecurityInformation
+	}
+	if binary.LittleEndian.Uint32(sd[8:]) != 0 {
+		info |= groupSecurityInformation
+	}
+	if control&seSACLPresent != 0 {
+		info |= saclSecurityInformation
+	}
+	if control&seDACLPresent != 0 {
+		info |= daclSecurityInformation
+	}
+	return info, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"os"
+	"syscall"
+)
+
+// maxSendfile is the largest amount of data to transfer in a single call to
+// sendfile, which Linux limits to slightly less than 2 GiB.
+const maxSendfile = 1 << 30
+
+// sendFile copies up to n bytes from src to dst with sendfile, if both are
+// regular files. It returns the number of bytes copied, which is zero if
+// sendfile cannot be used, in which case the caller copies the data itself.
+func sendFile(dst, src *os.File, n int64) (int64, error) {
+	if !isRegularFile(dst) || !isRegularFile(src) {
+		return 0, nil
+	}
+	dstFd, srcFd := int(dst.Fd()), int(src.Fd())
+	var written int64
+	for written < n {
+		chunk := n - written
+		if chunk > maxSendfile {
+			chunk = maxSendfile
+		}
+		m, err := syscall.Sendfile(dstFd, srcFd, nil, int(chunk))
+		if m > 0 {
+			written += int64(m)
+		}
+		switch {
+		case err == syscall.EINTR || err == syscall.EAGAIN:
+			continue
+		case err == syscall.EINVAL || err == syscall.ENOSYS:
+			if written == 0 {
+				return 0, nil // Not supported for these files
+			}
+			fallthrough
+		case err != nil:
+			return written, &os.SyscallError{Syscall: "sendfile", Err: err}
+		case m == 0:
+			return written, nil // End of src
+		}
+	}
+	return written, nil
+}
+
+func isRegularFile(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,15 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package tar
+
+import "os"
+
+// sendFile reports that no data was copied, so that the caller copies
+// the data itself.
+func sendFile(dst, src *os.File, n int64) (int64, error) {
+	return 0, nil
+}
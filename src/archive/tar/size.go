@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import "errors"
+
+// ArchiveSize returns the exact size in bytes of the archive that a new
+// Writer, configured like tw, would produce by writing each of hdrs followed
+// by Header.Size bytes of data, and then calling Close.
+// No data is read or written, and tw itself is not modified, so this may be
+// used to send a Content-Length before streaming an archive, or to size a
+// buffer for it.
+//
+// The options of tw that change the headers, such as Deterministic,
+// StrictUSTAR, GNUTimes, TimePolicy and MapOwner, as well as BlockingFactor,
+// TrailerBlocks and Digests, are taken into account. ArchiveSize fails if
+// tw splits archives into volumes, or if the size of an entry is unknown
+// (-1).
+func (tw *Writer) ArchiveSize(hdrs []*Header) (int64, error) {
+	if tw.NextVolume != nil {
+		return 0, errors.New("archive/tar: cannot compute the size of an archive split into volumes")
+	}
+	if tw.BlockingFactor < 0 {
+		return 0, errors.New("archive/tar: negative BlockingFactor")
+	}
+	var cw countWriter
+	sw := NewWriter(&cw)
+	sw.Deterministic = tw.Deterministic
+	sw.ClampTime = tw.ClampTime
+	sw.StrictUSTAR = tw.StrictUSTAR
+	sw.GNUTimes = tw.GNUTimes
+	sw.TimePolicy = tw.TimePolicy
+	sw.TrailerBlocks = tw.TrailerBlocks
+	sw.MapOwner = tw.MapOwner
+
+	for _, hdr := range hdrs {
+		if hdr.Size == -1 {
+			return 0, newHeaderError("Size", "unknown size of "+hdr.Name)
+		}
+		if len(tw.Digests) > 0 && (hdr.Typeflag == TypeReg || hdr.Typeflag == TypeRegA) {
+			// The placeholder digests have the length of the real ones.
+			e, err := tw.newDigestEntry(hdr)
+			if err != nil {
+				return 0, err
+			}
+			hdr = &e.hdr
+		}
+		if err := sw.WriteHeader(hdr); err != nil {
+			return 0, err
+		}
+
+		// Account for the data without writing it.
+		cw.n += sw.reg.nb
+		sw.reg.nb = 0
+	}
+	if err := sw.Close(); err != nil {
+		return 0, err
+	}
+
+	// The last record is padded with zeros to its full size.
+	n := cw.n
+	if recordSize := int64(tw.BlockingFactor) * blockSize; recordSize > 0 {
+		n = (n + recordSize - 1) / recordSize * recordSize
+	}
+	return n, nil
+}
+
+// countWriter is an io.Writer that only counts the bytes written to it.
+type countWriter struct {
+	n int64
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	cw.n += int64(len(b))
+	return len(b), nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterArchiveSize(t *testing.T) {
+	hdrs := []*Header{
+		{Name: "dir/", Typeflag: TypeDir, Mode: 0755},
+		{Name: "dir/file", Typeflag: TypeReg, Size: 1000, ModTime: time.Unix(0, 5e8), Uname: "gopher"},
+		{Name: "dir/" + strings.Repeat("long", 50), Typeflag: TypeReg, Size: 3},
+		{Name: "dir/link", Typeflag: TypeSymlink, Linkname: "file"},
+		{Name: "dir/xattr", Typeflag: TypeReg, Size: 512, Xattrs: map[string]string{"user.a": "b"}},
+		{Name: "dir/empty", Typeflag: TypeReg},
+	}
+	vectors := []struct {
+		configure func(*Writer)
+		hdrs      []*Header
+	}{{
+		configure: func(*Writer) {},
+	}, {
+		configure: func(*Writer) {},
+		hdrs:      []*Header{},
+	}, {
+		configure: func(tw *Writer) { tw.BlockingFactor = 20 },
+	}, {
+		configure: func(tw *Writer) { tw.TrailerBlocks = -1; tw.Deterministic = true },
+	}, {
+		configure: func(tw *Writer) { tw.Digests = []crypto.Hash{crypto.SHA256} },
+	}, {
+		configure: func(tw *Writer) {
+			tw.MapOwner = func(string, Owner) (Owner, error) {
+				return Owner{Uname: strings.Repeat("u", 40)}, nil
+			}
+		},
+	}}
+
+	for i, v := range vectors {
+		if v.hdrs == nil {
+			v.hdrs = hdrs
+		}
+		// Digests require an io.WriteSeeker.
+		f, err := ioutil.TempFile("", "tar-size")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		tw := NewWriter(f)
+		v.configure(tw)
+		got, err := tw.ArchiveSize(v.hdrs)
+		if err != nil {
+			t.Fatalf("test %d, ArchiveSize() error: %v", i, err)
+		}
+		if n, _ := f.Seek(0, io.SeekEnd); n != 0 {
+			t.Errorf("test %d, ArchiveSize() wrote %d bytes", i, n)
+		}
+
+		for _, hdr := range v.hdrs {
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+			}
+			if _, err := tw.Write(bytes.Repeat([]byte{'x'}, int(hdr.Size))); err != nil {
+				t.Fatalf("test %d, Write() error: %v", i, err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("test %d, Close() error: %v", i, err)
+		}
+		if want, _ := f.Seek(0, io.SeekEnd); got != want {
+			t.Errorf("test %d, ArchiveSize() = %d, want %d", i, got, want)
+		}
+	}
+
+	tw := NewWriter(&bytes.Buffer{})
+	if _, err := tw.ArchiveSize([]*Header{{Name: "file", Typeflag: TypeReg, Size: -1}}); err == nil {
+		t.Errorf("ArchiveSize() with unknown size succeeded, want error")
+	}
+	if _, err := tw.ArchiveSize([]*Header{{Name: "file/", Typeflag: TypeReg}}); err == nil {
+		t.Errorf("ArchiveSize() with invalid header succeeded, want error")
+	}
+}
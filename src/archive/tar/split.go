@@ -0,0 +1,210 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+)
+
+// splitRecord is a record of the metadata written by Disassemble, which is
+// a sequence of JSON objects, one per line. Each record holds either raw
+// bytes of the archive, or a reference to the data of a file.
+type splitRecord struct {
+	Raw []byte `json:"raw,omitempty"`
+
+	// Name, Size and CRC64 describe the data of a regular file, of Size bytes
+	// as stored in the archive, with the given CRC-64 (ISO polynomial).
+	// For sparse files, Fragments lists the offset and length of each
+	// fragment of the file that is stored, in order.
+	Name      string     `json:"name,omitempty"`
+	Size      int64      `json:"size,omitempty"`
+	CRC64     uint64     `json:"crc64,omitempty"`
+	Fragments [][2]int64 `json:"fragments,omitempty"`
+}
+
+var crc64Table = crc64.MakeTable(crc64.ISO)
+
+// splitReader records the bytes read from r by a Reader, except for the
+// data of regular files, which is only hashed.
+type splitReader struct {
+	r    io.Reader
+	raw  bytes.Buffer
+	data bool // Whether the data of a regular file is being read
+	crc  hash.Hash64
+	n    int64 // Bytes of data read
+}
+
+func (sr *splitReader) Read(b []byte) (int, error) {
+	n, err := sr.r.Read(b)
+	if sr.data {
+		sr.crc.Write(b[:n])
+		sr.n += int64(n)
+	} else {
+		sr.raw.Write(b[:n])
+	}
+	return n, err
+}
+
+// Disassemble reads the archive from r, and writes to meta everything needed
+// to reproduce it byte for byte once the data of its regular files has been
+// extracted: the raw bytes of its headers, padding and trailer, the data of
+// entries other than regular files, and a reference to each regular file
+// with the size and a checksum of its data. The archive can then be
+// reassembled from the metadata and the files with Assemble, which allows
+// storing the files rather than the archive, while keeping the digest of
+// the archive stable, as container registries require.
+//
+// If fn is not nil, it is called for each entry with its Header and
+// a Reader for its data, for example to extract it. Data that fn does not
+// read is skipped. If fn returns an error, Disassemble stops and returns it.
+func Disassemble(r io.Reader, meta io.Writer, fn func(hdr *Header, r io.Reader) error) error {
+	sr := &splitReader{r: r, crc: crc64.New(crc64Table)}
+	tr := NewReader(sr)
+	enc := json.NewEncoder(meta)
+	flushRaw := func() error {
+		if sr.raw.Len() == 0 {
+			return nil
+		}
+		err := enc.Encode(&splitRecord{Raw: sr.raw.Bytes()})
+		sr.raw.Reset()
+		return err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != ErrInsecurePath {
+			return err
+		}
+
+		var rec *splitRecord
+		if hdr.Typeflag == TypeReg || hdr.Typeflag == TypeRegA || hdr.Typeflag == TypeGNUSparse {
+			if err := flushRaw(); err != nil {
+				return err
+			}
+			rec = &splitRecord{Name: hdr.Name}
+			if sp, ok := tr.curr.(*sparseFileReader); ok {
+				sph := append(sparseHoles(nil), sp.sp...) // Inverted in place
+				for _, f := range invertSparseEntries(sph, hdr.Size) {
+					rec.Fragments = append(rec.Fragments, [2]int64{f.Offset, f.Length})
+				}
+			}
+			sr.data, sr.n = true, 0
+			sr.crc.Reset()
+		}
+		if fn != nil {
+			if err := fn(hdr, tr); err != nil {
+				return err
+			}
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return err
+		}
+		if rec != nil {
+			sr.data = false
+			rec.Size, rec.CRC64 = sr.n, sr.crc.Sum64()
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Keep anything following the trailer, such as the padding of the
+	// last record.
+	if _, err := io.Copy(&sr.raw, r); err != nil {
+		return err
+	}
+	return flushRaw()
+}
+
+// Assemble writes to w the archive described by the metadata read from meta,
+// as written by Disassemble, reading the data of each regular file from the
+// file returned by open for its name in the archive. Files without data are
+// not opened. If the data of a file differs from that of the original
+// archive, Assemble returns an error.
+//
+// When an archive contains several regular files with the same name,
+// only the last one can usually be extracted, so the others cannot be
+// reassembled.
+func Assemble(w io.Writer, meta io.Reader, open func(name string) (io.ReadCloser, error)) error {
+	dec := json.NewDecoder(meta)
+	for {
+		var rec splitRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if rec.Name == "" {
+			if _, err := w.Write(rec.Raw); err != nil {
+				return err
+			}
+			continue
+		}
+		if rec.Size == 0 {
+			continue
+		}
+		if err := assembleFile(w, &rec, open); err != nil {
+			return err
+		}
+	}
+}
+
+// assembleFile writes the data of the file described by rec to w.
+func assembleFile(w io.Writer, rec *splitRecord, open func(name string) (io.ReadCloser, error)) error {
+	f, err := open(rec.Name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	frags := rec.Fragments
+	if frags == nil {
+		frags = [][2]int64{{0, rec.Size}}
+	}
+	crc := crc64.New(crc64Table)
+	dst := io.MultiWriter(w, crc)
+	var pos, n int64
+	for _, frag := range frags {
+		off, length := frag[0], frag[1]
+		if off < pos || length < 0 {
+			return errors.New("archive/tar: invalid fragment in metadata of " + rec.Name)
+		}
+		if _, err := io.CopyN(ioutil.Discard, f, off-pos); err != nil {
+			return assembleError(rec.Name, err)
+		}
+		if _, err := io.CopyN(dst, f, length); err != nil {
+			return assembleError(rec.Name, err)
+		}
+		pos, n = off+length, n+length
+	}
+	longer := false
+	if rec.Fragments == nil { // Sparse files may end with a hole
+		var b [1]byte
+		_, err := io.ReadFull(f, b[:])
+		longer = err != io.EOF
+	}
+	if n != rec.Size || crc.Sum64() != rec.CRC64 || longer {
+		return fmt.Errorf("archive/tar: data of %s differs from the original archive", rec.Name)
+	}
+	return nil
+}
+
+// assembleError converts an unexpected end of the data of a file to an error.
+func assembleError(name string, err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("archive/tar: data of %s is shorter than in the original archive", name)
+	}
+	return err
+}
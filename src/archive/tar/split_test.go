@@ -0,0 +1,119 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// extractedFiles maps names to the data of regular files, as extracted.
+type extractedFiles map[string][]byte
+
+func (m extractedFiles) extract(hdr *Header, r io.Reader) error {
+	if hdr.Typeflag != TypeReg && hdr.Typeflag != TypeRegA && hdr.Typeflag != TypeGNUSparse {
+		return nil
+	}
+	data, err := ioutil.ReadAll(r)
+	m[hdr.Name] = data
+	return err
+}
+
+func (m extractedFiles) open(name string) (io.ReadCloser, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestDisassemble(t *testing.T) {
+	vectors := []string{
+		"testdata/gnu.tar",
+		"testdata/sparse-formats.tar",
+		"testdata/star.tar",
+		"testdata/v7.tar",
+		"testdata/pax.tar",
+		"testdata/pax-records.tar",
+		"testdata/pax-global-records.tar",
+		"testdata/hardlink.tar",
+		"testdata/xattrs.tar",
+		"testdata/ustar.tar",
+	}
+	for i, v := range vectors {
+		src, err := ioutil.ReadFile(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Check that trailing data is preserved.
+		src = append(src, "trailing garbage"...)
+
+		var meta bytes.Buffer
+		files := make(extractedFiles)
+		if err := Disassemble(bytes.NewReader(src), &meta, files.extract); err != nil {
+			t.Errorf("test %d, Disassemble(%s) error: %v", i, v, err)
+			continue
+		}
+		var got bytes.Buffer
+		if err := Assemble(&got, bytes.NewReader(meta.Bytes()), files.open); err != nil {
+			t.Errorf("test %d, Assemble(%s) error: %v", i, v, err)
+			continue
+		}
+		if !bytes.Equal(got.Bytes(), src) {
+			t.Errorf("test %d, Assemble(%s) differs from the original archive", i, v)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	src := makeArchive(t,
+		testEntry{hdr: Header{Name: "a", Typeflag: TypeReg, Mode: 0644}, body: "hello"},
+		testEntry{hdr: Header{Name: "empty", Typeflag: TypeReg, Mode: 0644}},
+		testEntry{hdr: Header{Name: "b", Typeflag: TypeReg, Mode: 0644}, body: strings.Repeat("b", 1000)},
+	)
+	var meta bytes.Buffer
+	files := make(extractedFiles)
+	if err := Disassemble(bytes.NewReader(src), &meta, files.extract); err != nil {
+		t.Fatalf("Disassemble() error: %v", err)
+	}
+	if bytes.Contains(meta.Bytes(), []byte("bbbb")) {
+		t.Errorf("metadata holds the data of regular files")
+	}
+
+	vectors := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"a", []byte("HELLO"), "differs"},
+		{"a", []byte("hell"), "shorter"},
+		{"a", []byte("hello, world"), "differs"},
+		{"b", nil, "not exist"},
+	}
+	for i, v := range vectors {
+		m := make(extractedFiles)
+		for k, d := range files {
+			m[k] = d
+		}
+		if v.data != nil {
+			m[v.name] = v.data
+		} else {
+			delete(m, v.name)
+		}
+		delete(m, "empty") // Not needed, as it has no data
+		err := Assemble(ioutil.Discard, bytes.NewReader(meta.Bytes()), m.open)
+		if err == nil || !strings.Contains(err.Error(), v.want) {
+			t.Errorf("test %d, Assemble() error: %v, want %q", i, err, v.want)
+		}
+	}
+
+	if err := Assemble(ioutil.Discard, strings.NewReader("{invalid"), files.open); err == nil {
+		t.Errorf("Assemble() of invalid metadata succeeded")
+	}
+}
@@ -18,3 +18,7 @@ func statAtime(st *syscall.Stat_t) time.Time {
 func statCtime(st *syscall.Stat_t) time.Time {
 	return time.Unix(st.Ctim.Unix())
 }
+
+func statBtime(st *syscall.Stat_t) time.Time {
+	return time.Time{} // Not recorded in Stat_t
+}
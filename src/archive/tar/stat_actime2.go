@@ -18,3 +18,10 @@ func statAtime(st *syscall.Stat_t) time.Time {
 func statCtime(st *syscall.Stat_t) time.Time {
 	return time.Unix(st.Ctimespec.Unix())
 }
+
+func statBtime(st *syscall.Stat_t) time.Time {
+	if st.Birthtimespec.Sec <= 0 {
+		return time.Time{} // Not supported by the file system
+	}
+	return time.Unix(st.Birthtimespec.Unix())
+}
@@ -0,0 +1,19 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package tar
+
+import "syscall"
+
+// statFlags returns the file flags of st that have a name, since the
+// flags of other systems only partly agree with those of FreeBSD.
+func statFlags(st *syscall.Stat_t) FileFlags {
+	var known FileFlags
+	for _, fn := range fileFlagNames {
+		known |= fn.flag
+	}
+	return FileFlags(st.Flags) & known
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,amd64 linux,arm64
+
+package tar
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func init() {
+	sysBtime = statxBtime
+}
+
+// Constants for statx, which package syscall lacks.
+const (
+	atFDCWD        = -0x64 // AT_FDCWD
+	statxBtimeMask = 0x800 // STATX_BTIME
+)
+
+// sysStatx is the number of the statx system call (added in Linux 4.11).
+var sysStatx = func() uintptr {
+	if runtime.GOARCH == "arm64" {
+		return 291
+	}
+	return 332
+}()
+
+// statxTimestamp is struct statx_timestamp from <linux/stat.h>.
+type statxTimestamp struct {
+	sec  int64
+	nsec uint32
+	_    int32
+}
+
+// statxT is struct statx from <linux/stat.h>, up to the timestamps.
+type statxT struct {
+	mask       uint32
+	blksize    uint32
+	attributes uint64
+	nlink      uint32
+	uid        uint32
+	gid        uint32
+	mode       uint16
+	_          uint16
+	ino        uint64
+	size       uint64
+	blocks     uint64
+	attrMask   uint64
+	atime      statxTimestamp
+	btime      statxTimestamp
+	ctime      statxTimestamp
+	mtime      statxTimestamp
+	_          [16]uint64 // Device numbers and fields of later kernels
+}
+
+// statxBtime returns the creation time of the file at p, without following
+// symbolic links. It returns the zero time if the kernel lacks statx, as
+// before Linux 4.11, or if the file system does not record the time.
+func statxBtime(p string) time.Time {
+	pp, err := syscall.BytePtrFromString(p)
+	if err != nil {
+		return time.Time{}
+	}
+	var stx statxT
+	fd := atFDCWD
+	_, _, errno := syscall.Syscall6(sysStatx, uintptr(fd), uintptr(unsafe.Pointer(pp)),
+		atSymlinkNofollow, statxBtimeMask, uintptr(unsafe.Pointer(&stx)), 0)
+	if errno != 0 || stx.mask&statxBtimeMask == 0 {
+		return time.Time{}
+	}
+	return time.Unix(stx.btime.sec, int64(stx.btime.nsec))
+}
@@ -8,20 +8,24 @@ package tar
 
 import (
 	"os"
-	"os/user"
 	"runtime"
 	"strconv"
-	"sync"
 	"syscall"
 )
 
 func init() {
 	sysStat = statUnix
+	sysFileID = fileIDUnix
+	sysStatExtended = statExtendedUnix
 }
 
-// userMap and groupMap caches UID and GID lookups for performance reasons.
-// The downside is that renaming uname or gname by the OS never takes effect.
-var userMap, groupMap sync.Map // map[int]string
+func fileIDUnix(fi os.FileInfo) (fileID, bool) {
+	sys, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{uint64(sys.Dev), uint64(sys.Ino)}, sys.Nlink > 1
+}
 
 func statUnix(fi os.FileInfo, h *Header) error {
 	sys, ok := fi.Sys().(*syscall.Stat_t)
@@ -32,23 +36,13 @@ func statUnix(fi os.FileInfo, h *Header) error {
 	h.Gid = int(sys.Gid)
 
 	// Best effort at populating Uname and Gname.
-	// The os/user functions may fail for any number of reasons
-	// (not implemented on that platform, cgo not enabled, etc).
-	if u, ok := userMap.Load(h.Uid); ok {
-		h.Uname = u.(string)
-	} else if u, err := user.LookupId(strconv.Itoa(h.Uid)); err == nil {
-		h.Uname = u.Username
-		userMap.Store(h.Uid, h.Uname)
-	}
-	if g, ok := groupMap.Load(h.Gid); ok {
-		h.Gname = g.(string)
-	} else if g, err := user.LookupGroupId(strconv.Itoa(h.Gid)); err == nil {
-		h.Gname = g.Name
-		groupMap.Store(h.Gid, h.Gname)
-	}
+	// The lookups are cached by systemNames for performance reasons.
+	h.Uname, _ = systemNames.UserName(h.Uid)
+	h.Gname, _ = systemNames.GroupName(h.Gid)
 
 	h.AccessTime = statAtime(sys)
 	h.ChangeTime = statCtime(sys)
+	h.CreationTime = statBtime(sys)
 
 	// Best effort at populating Devmajor and Devminor.
 	if h.Typeflag == TypeChar || h.Typeflag == TypeBlock {
@@ -94,3 +88,17 @@ func statUnix(fi os.FileInfo, h *Header) error {
 	}
 	return nil
 }
+
+func statExtendedUnix(fi os.FileInfo, h *Header) {
+	sys, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	h.Flags = statFlags(sys)
+	if h.PAXRecords == nil {
+		h.PAXRecords = make(map[string]string)
+	}
+	h.PAXRecords[PAXKeySchilyDev] = strconv.FormatUint(uint64(sys.Dev), 10)
+	h.PAXRecords[PAXKeySchilyIno] = strconv.FormatUint(uint64(sys.Ino), 10)
+	h.PAXRecords[PAXKeySchilyNlink] = strconv.FormatUint(uint64(sys.Nlink), 10)
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func init() {
+	sysStat = statWindows
+	sysStatExtended = statExtendedWindows
+}
+
+func statWindows(fi os.FileInfo, h *Header) error {
+	sys, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return nil
+	}
+	h.CreationTime = time.Unix(0, sys.CreationTime.Nanoseconds())
+	return nil
+}
+
+func statExtendedWindows(fi os.FileInfo, h *Header) {
+	if sys, ok := fi.Sys().(*syscall.Win32FileAttributeData); ok {
+		h.WindowsAttributes = sys.FileAttributes
+	}
+}
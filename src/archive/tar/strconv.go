@@ -6,10 +6,11 @@ package tar
 
 import (
 	"bytes"
-	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
+	"unsafe"
 )
 
 // hasNUL reports whether the NUL character exists within s.
@@ -43,7 +44,9 @@ func toASCII(s string) string {
 }
 
 type parser struct {
-	err error // Last error seen
+	err    error        // Last error seen
+	quirks bool         // Ignore garbage after the digits of octal fields
+	arena  *stringArena // Storage for parsed strings, if non-nil
 }
 
 type formatter struct {
@@ -52,11 +55,47 @@ type formatter struct {
 
 // parseString parses bytes as a NUL-terminated C-style string.
 // If a NUL byte is not found then the whole slice is returned as a string.
-func (*parser) parseString(b []byte) string {
+func (p *parser) parseString(b []byte) string {
 	if i := bytes.IndexByte(b, 0); i >= 0 {
-		return string(b[:i])
+		b = b[:i]
 	}
-	return string(b)
+	return p.arena.string(b)
+}
+
+// reuseString is like parseString, but returns s without allocating
+// if it is equal to the parsed string.
+func (p *parser) reuseString(b []byte, s string) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	if string(b) == s {
+		return s
+	}
+	return p.arena.string(b)
+}
+
+// stringArenaSize is the size of the chunks of memory of a stringArena.
+const stringArenaSize = 4096
+
+// A stringArena allocates short strings in shared chunks of memory, so
+// that parsing the names of many entries does not allocate for each one.
+// The bytes of a chunk are never modified once they back a string.
+type stringArena struct {
+	buf []byte
+}
+
+// string returns b as a string. A nil *stringArena allocates each string.
+func (a *stringArena) string(b []byte) string {
+	if a == nil || len(b) == 0 || len(b) > stringArenaSize/8 {
+		return string(b)
+	}
+	if cap(a.buf)-len(a.buf) < len(b) {
+		a.buf = make([]byte, 0, stringArenaSize)
+	}
+	n := len(a.buf)
+	a.buf = append(a.buf, b...)
+	sb := a.buf[n:]
+	return *(*string)(unsafe.Pointer(&sb))
 }
 
 // formatString copies s into b, NUL-terminating if possible.
@@ -143,11 +182,7 @@ func (f *formatter) formatNumeric(b []byte, x int64) {
 	}
 
 	if fitsInBase256(len(b), x) {
-		for i := len(b) - 1; i >= 0; i-- {
-			b[i] = byte(x)
-			x >>= 8
-		}
-		b[0] |= 0x80 // Highest bit indicates binary format
+		formatBase256(b, x)
 		return
 	}
 
@@ -155,20 +190,44 @@ func (f *formatter) formatNumeric(b []byte, x int64) {
 	f.err = ErrFieldTooLong
 }
 
+// formatBase256 encodes x into b using base-256 (binary) encoding.
+// The caller must check that x fits using fitsInBase256.
+func formatBase256(b []byte, x int64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(x)
+		x >>= 8
+	}
+	b[0] |= 0x80 // Highest bit indicates binary format
+}
+
 func (p *parser) parseOctal(b []byte) int64 {
 	// Because unused fields are filled with NULs, we need
 	// to skip leading NULs. Fields may also be padded with
 	// spaces or NULs.
 	// So we remove leading and trailing NULs and spaces to
-	// be sure.
-	b = bytes.Trim(b, " \x00")
+	// be sure. This is done by hand, since bytes.Trim allocates.
+	for len(b) > 0 && (b[0] == ' ' || b[0] == 0) {
+		b = b[1:]
+	}
+	for len(b) > 0 && (b[len(b)-1] == ' ' || b[len(b)-1] == 0) {
+		b = b[:len(b)-1]
+	}
 
-	if len(b) == 0 {
-		return 0
+	// Parse the digits directly, rather than using strconv.ParseUint,
+	// to avoid allocating a string for every numeric field.
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
 	}
-	x, perr := strconv.ParseUint(p.parseString(b), 8, 64)
-	if perr != nil {
-		p.err = ErrHeader
+	var x uint64
+	for _, c := range b {
+		if (c < '0' || c > '7') && p.quirks {
+			break // Some producers do not terminate fields properly
+		}
+		if c < '0' || c > '7' || x > math.MaxUint64>>3 {
+			p.err = ErrHeader
+			return 0
+		}
+		x = x<<3 | uint64(c-'0')
 	}
 	return int64(x)
 }
@@ -179,12 +238,18 @@ func (f *formatter) formatOctal(b []byte, x int64) {
 		f.err = ErrFieldTooLong
 	}
 
-	s := strconv.FormatInt(x, 8)
-	// Add leading zeros, but leave room for a NUL.
-	if n := len(b) - len(s) - 1; n > 0 {
-		s = strings.Repeat("0", n) + s
+	// Write the digits right-aligned with leading zeros, leaving room
+	// for a NUL if possible, directly into b to avoid allocating a string.
+	i := len(b)
+	if i > 1 {
+		i--
+		b[i] = 0
+	}
+	for i > 0 {
+		i--
+		b[i] = '0' + byte(x&7)
+		x >>= 3
 	}
-	f.formatString(b, s)
 }
 
 // fitsInOctal reports whether the integer x fits in a field n-bytes long
@@ -215,16 +280,21 @@ func parsePAXTime(s string) (time.Time, error) {
 		return time.Unix(secs, 0), nil // No sub-second values
 	}
 
-	// Parse the nanoseconds.
-	if strings.Trim(sn, "0123456789") != "" {
-		return time.Time{}, ErrHeader
+	// Parse the nanoseconds, right padding or truncating them to nine digits.
+	// The digits are parsed directly to avoid allocating a padded string.
+	var nsecs int64
+	for i := 0; i < len(sn); i++ {
+		c := sn[i]
+		if c < '0' || c > '9' {
+			return time.Time{}, ErrHeader
+		}
+		if i < maxNanoSecondDigits {
+			nsecs = nsecs*10 + int64(c-'0')
+		}
 	}
-	if len(sn) < maxNanoSecondDigits {
-		sn += strings.Repeat("0", maxNanoSecondDigits-len(sn)) // Right pad
-	} else {
-		sn = sn[:maxNanoSecondDigits] // Right truncate
+	for i := len(sn); i < maxNanoSecondDigits; i++ {
+		nsecs *= 10
 	}
-	nsecs, _ := strconv.ParseInt(sn, 10, 64) // Must succeed
 	if len(ss) > 0 && ss[0] == '-' {
 		return time.Unix(secs, -1*nsecs), nil // Negative correction
 	}
@@ -234,19 +304,38 @@ func parsePAXTime(s string) (time.Time, error) {
 // formatPAXTime converts ts into a time of the form %d.%d as described in the
 // PAX specification. This function is capable of negative timestamps.
 func formatPAXTime(ts time.Time) (s string) {
+	var buf [32]byte
+	return string(appendPAXTime(buf[:0], ts))
+}
+
+// appendPAXTime is like formatPAXTime, but appends the time to b,
+// so that callers may format times without allocating.
+func appendPAXTime(b []byte, ts time.Time) []byte {
 	secs, nsecs := ts.Unix(), ts.Nanosecond()
 	if nsecs == 0 {
-		return strconv.FormatInt(secs, 10)
+		return strconv.AppendInt(b, secs, 10)
 	}
 
 	// If seconds is negative, then perform correction.
-	sign := ""
 	if secs < 0 {
-		sign = "-"             // Remember sign
+		b = append(b, '-')     // Remember sign
 		secs = -(secs + 1)     // Add a second to secs
 		nsecs = -(nsecs - 1E9) // Take that second away from nsecs
 	}
-	return strings.TrimRight(fmt.Sprintf("%s%d.%09d", sign, secs, nsecs), "0")
+	b = strconv.AppendInt(b, secs, 10)
+	b = append(b, '.')
+
+	// Append the nine digits of nsecs without their trailing zeros.
+	var digits [9]byte
+	for i := len(digits) - 1; i >= 0; i-- {
+		digits[i] = '0' + byte(nsecs%10)
+		nsecs /= 10
+	}
+	n := len(digits)
+	for digits[n-1] == '0' {
+		n--
+	}
+	return append(b, digits[:n]...)
 }
 
 // parsePAXRecord parses the input PAX record string into a key-value pair.
@@ -287,21 +376,40 @@ func parsePAXRecord(s string) (k, v, r string, err error) {
 // formatPAXRecord formats a single PAX record, prefixing it with the
 // appropriate length.
 func formatPAXRecord(k, v string) (string, error) {
+	b, err := appendPAXRecord(nil, k, v)
+	return string(b), err
+}
+
+// appendPAXRecord is like formatPAXRecord, but appends the record to b.
+func appendPAXRecord(b []byte, k, v string) ([]byte, error) {
 	if !validPAXRecord(k, v) {
-		return "", ErrHeader
+		return b, ErrHeader
 	}
 
 	const padding = 3 // Extra padding for ' ', '=', and '\n'
 	size := len(k) + len(v) + padding
-	size += len(strconv.Itoa(size))
-	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	n := decimalLen(size)
+	size += n
 
 	// Final adjustment if adding size field increased the record size.
-	if len(record) != size {
-		size = len(record)
-		record = strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	if decimalLen(size) != n {
+		size++
+	}
+	b = strconv.AppendInt(b, int64(size), 10)
+	b = append(b, ' ')
+	b = append(b, k...)
+	b = append(b, '=')
+	b = append(b, v...)
+	return append(b, '\n'), nil
+}
+
+// decimalLen returns the number of decimal digits of the positive integer n.
+func decimalLen(n int) int {
+	i := 1
+	for ; n >= 10; n /= 10 {
+		i++
 	}
-	return record, nil
+	return i
 }
 
 // validPAXRecord reports whether the key-value pair is valid where each
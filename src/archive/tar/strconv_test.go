@@ -104,6 +104,14 @@ func TestParseNumeric(t *testing.T) {
 	}
 }
 
+func TestParseNumericAllocs(t *testing.T) {
+	b := []byte("\x00 0000660\x00 ")
+	var p parser
+	if n := testing.AllocsPerRun(100, func() { p.parseNumeric(b) }); n > 0 {
+		t.Errorf("parseNumeric(%q) allocations = %v, want 0", b, n)
+	}
+}
+
 func TestFormatNumeric(t *testing.T) {
 	vectors := []struct {
 		in   int64
@@ -174,6 +182,45 @@ func TestFormatNumeric(t *testing.T) {
 	}
 }
 
+func TestNumericCodecs(t *testing.T) {
+	b := make([]byte, 8)
+	if err := FormatOctal(b, 0644); err != nil || string(b) != "0000644\x00" {
+		t.Errorf("FormatOctal(0644) = %q, %v; want %q, nil", b, err, "0000644\x00")
+	}
+	if x, err := ParseOctal(b); x != 0644 || err != nil {
+		t.Errorf("ParseOctal(%q) = %o, %v; want 644, nil", b, x, err)
+	}
+	if x, err := ParseOctal([]byte(" 755 \x00")); x != 0755 || err != nil {
+		t.Errorf("ParseOctal() of padded field = %o, %v; want 755, nil", x, err)
+	}
+	if _, err := ParseOctal([]byte("0009\x00")); err != ErrHeader {
+		t.Errorf("ParseOctal() of invalid field error = %v, want %v", err, ErrHeader)
+	}
+	if err := FormatOctal(b, -1); err != ErrFieldTooLong || string(b) != "0000000\x00" {
+		t.Errorf("FormatOctal(-1) = %q, %v; want %q, %v", b, err, "0000000\x00", ErrFieldTooLong)
+	}
+
+	if err := FormatBase256(b, 0644); err != nil || string(b) != "\x80\x00\x00\x00\x00\x00\x01\xa4" {
+		t.Errorf("FormatBase256(0644) = %q, %v", b, err)
+	}
+	if x, err := ParseNumeric(b); x != 0644 || err != nil {
+		t.Errorf("ParseNumeric(%q) = %o, %v; want 644, nil", b, x, err)
+	}
+	if err := FormatBase256(b[:1], 1<<8); err != ErrFieldTooLong {
+		t.Errorf("FormatBase256(1<<8) error = %v, want %v", err, ErrFieldTooLong)
+	}
+
+	if err := FormatNumeric(b, -2); err != nil {
+		t.Errorf("FormatNumeric(-2) error: %v", err)
+	}
+	if x, err := ParseNumeric(b); x != -2 || err != nil {
+		t.Errorf("ParseNumeric(%q) = %d, %v; want -2, nil", b, x, err)
+	}
+	if _, err := ParseNumeric([]byte("\x80\x80\x00\x00\x00\x00\x00\x00\x00")); err != ErrHeader {
+		t.Errorf("ParseNumeric() of overflowing field error = %v, want %v", err, ErrHeader)
+	}
+}
+
 func TestFitsInOctal(t *testing.T) {
 	vectors := []struct {
 		input int64
@@ -339,6 +386,27 @@ func TestFormatPAXTime(t *testing.T) {
 	}
 }
 
+func TestPAXTimeAllocs(t *testing.T) {
+	ts := time.Unix(-1350244992, -23960100)
+	var buf [32]byte
+	if n := testing.AllocsPerRun(100, func() { appendPAXTime(buf[:0], ts) }); n > 0 {
+		t.Errorf("appendPAXTime() allocations = %v, want 0", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { parsePAXTime("-1350244992.0239601") }); n > 0 {
+		t.Errorf("parsePAXTime() allocations = %v, want 0", n)
+	}
+}
+
+func TestPAXKeyAllocs(t *testing.T) {
+	b := []byte(paxMtime)
+	if n := testing.AllocsPerRun(100, func() { paxKey(b) }); n > 0 {
+		t.Errorf("paxKey(%q) allocations = %v, want 0", b, n)
+	}
+	if got := paxKey([]byte("user.key")); got != "user.key" {
+		t.Errorf("paxKey(%q) = %q, want %q", "user.key", got, "user.key")
+	}
+}
+
 func TestParsePAXRecord(t *testing.T) {
 	medName := strings.Repeat("CD", 50)
 	longName := strings.Repeat("AB", 100)
@@ -0,0 +1,80 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"io"
+	"sync"
+)
+
+// A SyncWriter writes entries to a Writer on behalf of several goroutines.
+// Each entry is submitted as a whole, with its header and data, so that
+// the entries written concurrently are not interleaved. Entries appear in
+// the archive in the order in which they are submitted.
+//
+// A SyncWriter is safe for concurrent use, unlike a Writer. The Writer
+// must not be used directly while the SyncWriter is in use, other than
+// through Do.
+type SyncWriter struct {
+	mu  sync.Mutex
+	tw  *Writer
+	err error // Persistent error from an incomplete entry
+}
+
+// NewSyncWriter returns a SyncWriter that writes entries to tw.
+func NewSyncWriter(tw *Writer) *SyncWriter {
+	return &SyncWriter{tw: tw}
+}
+
+// WriteEntry writes an entry with the Header hdr and the data read from r
+// until EOF, which must be exactly hdr.Size bytes long unless the size is
+// unknown (see Header.Size). The entry is finished before WriteEntry
+// returns, and other goroutines wait for it to be written in full before
+// writing their own entries. The data is read while holding the lock, so r
+// should not block for long, such as by having been read in advance.
+//
+// If hdr is not valid, WriteEntry returns an error without writing anything,
+// and other entries can still be written. Once an entry has been partially
+// written, because reading r or writing it failed, or r does not hold the
+// expected amount of data, the archive is corrupt, and the error is
+// returned by all subsequent calls.
+func (sw *SyncWriter) WriteEntry(hdr *Header, r io.Reader) error {
+	return sw.Do(func(tw *Writer) error {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if r != nil {
+			if _, err := io.Copy(tw, r); err != nil {
+				sw.err = err
+				return err
+			}
+		}
+		if err := tw.FinishEntry(); err != nil {
+			sw.err = err
+			return err
+		}
+		return nil
+	})
+}
+
+// Do calls fn with the Writer while holding the lock, so that fn can use
+// other methods of the Writer, such as CopyFrom or AddFile, without
+// interference from other goroutines. Any entry fn writes should be complete
+// when it returns. Do returns the error returned by fn.
+func (sw *SyncWriter) Do(fn func(tw *Writer) error) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.err != nil {
+		return sw.err
+	}
+	return fn(sw.tw)
+}
+
+// Close closes the Writer once the entries submitted before it are written,
+// as by Writer.Close. Entries submitted afterwards fail with
+// ErrWriteAfterClose.
+func (sw *SyncWriter) Close() error {
+	return sw.Do(func(tw *Writer) error { return tw.Close() })
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSyncWriter(t *testing.T) {
+	const workers, entries = 8, 50
+	var b bytes.Buffer
+	sw := NewSyncWriter(NewWriter(&b))
+	var wg sync.WaitGroup
+	errc := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < entries; i++ {
+				name := fmt.Sprintf("w%d/%d", w, i)
+				data := strings.Repeat(name, 100*i)
+				hdr := &Header{Name: name, Typeflag: TypeReg, Mode: 0644, Size: int64(len(data))}
+				// Read in small pieces to give other goroutines a chance to interfere.
+				if err := sw.WriteEntry(hdr, iotest.OneByteReader(strings.NewReader(data))); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Fatalf("WriteEntry() error: %v", err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	tr := NewReader(&b)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("ReadAll() error: %v", err)
+		}
+		if strings.Replace(string(data), hdr.Name, "", -1) != "" {
+			t.Errorf("data of %s is interleaved with other entries", hdr.Name)
+		}
+		seen[hdr.Name] = true
+	}
+	if len(seen) != workers*entries {
+		t.Errorf("archive holds %d entries, want %d", len(seen), workers*entries)
+	}
+
+	if err := sw.WriteEntry(&Header{Name: "late", Typeflag: TypeReg}, nil); err != ErrWriteAfterClose {
+		t.Errorf("WriteEntry() after Close error: %v, want %v", err, ErrWriteAfterClose)
+	}
+}
+
+func TestSyncWriterErrors(t *testing.T) {
+	sw := NewSyncWriter(NewWriter(ioutil.Discard))
+
+	// An invalid header does not prevent writing further entries.
+	if err := sw.WriteEntry(&Header{Name: "bad", Typeflag: TypeReg, Size: -2}, nil); err == nil {
+		t.Errorf("WriteEntry() with invalid Header succeeded")
+	}
+	if err := sw.WriteEntry(&Header{Name: "ok", Typeflag: TypeReg, Size: 2}, strings.NewReader("ok")); err != nil {
+		t.Errorf("WriteEntry() after invalid Header error: %v", err)
+	}
+
+	// A partially written entry is a persistent error.
+	if err := sw.WriteEntry(&Header{Name: "short", Typeflag: TypeReg, Size: 10}, strings.NewReader("short")); err == nil {
+		t.Errorf("WriteEntry() with short data succeeded")
+	}
+	errRead := errors.New("read error")
+	if err := sw.Do(func(*Writer) error { return errRead }); err == nil || err == errRead {
+		t.Errorf("Do() after partial entry error: %v, want persistent error", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
+	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
@@ -640,6 +641,18 @@ func TestHeaderAllowedFormats(t *testing.T) {
 		header:  &Header{Xattrs: map[string]string{"foo": ""}},
 		paxHdrs: map[string]string{paxSchilyXattr + "foo": ""},
 		formats: FormatPAX,
+	}, {
+		header:  &Header{Comment: "Hello, 世界"},
+		paxHdrs: map[string]string{paxComment: "Hello, 世界"},
+		formats: FormatPAX,
+	}, {
+		header:  &Header{Charset: "ISO-IR 10646 2000 UTF-8", Format: FormatGNU},
+		paxHdrs: map[string]string{paxCharset: "ISO-IR 10646 2000 UTF-8"},
+		formats: FormatUnknown,
+	}, {
+		header:  &Header{HdrCharset: "BINARY", Comment: "new", PAXRecords: map[string]string{"comment": "old"}},
+		paxHdrs: map[string]string{paxHdrCharset: "BINARY", paxComment: "new"},
+		formats: FormatPAX,
 	}, {
 		header:  &Header{ModTime: time.Unix(0, 0)},
 		formats: FormatUSTAR | FormatPAX | FormatGNU,
@@ -760,6 +773,250 @@ func TestHeaderAllowedFormats(t *testing.T) {
 	}
 }
 
+func TestHeaderFormatReport(t *testing.T) {
+	type constraint struct {
+		field   string
+		exclude Format
+	}
+	vectors := []struct {
+		header      *Header
+		allowed     Format
+		format      Format
+		constraints []constraint
+	}{{
+		header:  &Header{Name: "file", ModTime: time.Unix(0, 5e8), AccessTime: time.Unix(1, 0)},
+		allowed: FormatUSTAR | FormatPAX | FormatGNU,
+		format:  FormatUSTAR,
+	}, {
+		header:      &Header{Name: strings.Repeat("a", 200)},
+		allowed:     FormatPAX | FormatGNU,
+		format:      FormatPAX,
+		constraints: []constraint{{"Name", FormatUSTAR}},
+	}, {
+		header:      &Header{Name: "file", Uid: 1 << 21, Uname: strings.Repeat("u", 40)},
+		allowed:     FormatPAX,
+		format:      FormatPAX,
+		constraints: []constraint{{"Uname", FormatGNU}, {"Uname", FormatUSTAR}, {"Uid", FormatUSTAR}},
+	}, {
+		header:      &Header{Name: "file", Xattrs: map[string]string{"user.a": "b"}},
+		allowed:     FormatPAX,
+		format:      FormatPAX,
+		constraints: []constraint{{"Xattrs", FormatUSTAR | FormatGNU}},
+	}, {
+		header:      &Header{Name: "file", Mode: -1, Format: FormatUSTAR},
+		allowed:     FormatUnknown,
+		format:      FormatUnknown,
+		constraints: []constraint{{"Mode", FormatUSTAR}, {"Mode", FormatPAX}, {"Format", FormatPAX | FormatGNU}},
+	}}
+
+	for i, v := range vectors {
+		rep := v.header.FormatReport()
+		var got []constraint
+		for _, c := range rep.Constraints {
+			if c.Reason == "" {
+				t.Errorf("test %d, constraint on %s has no reason", i, c.Field)
+			}
+			got = append(got, constraint{c.Field, c.Exclude})
+		}
+		if rep.Allowed != v.allowed || rep.Format != v.format || !reflect.DeepEqual(got, v.constraints) {
+			t.Errorf("test %d, FormatReport() = %v, %v, %v; want %v, %v, %v",
+				i, rep.Allowed, rep.Format, got, v.allowed, v.format, v.constraints)
+		}
+		if (rep.Err != nil) != (v.allowed == FormatUnknown) {
+			t.Errorf("test %d, FormatReport() error: %v", i, rep.Err)
+		}
+	}
+}
+
+func TestHeaderValidate(t *testing.T) {
+	vectors := []struct {
+		header *Header
+		format Format
+		want   []FieldError // Reasons are not compared
+		ok     bool
+	}{{
+		header: &Header{Name: "file", Size: 5},
+		format: FormatUSTAR,
+		ok:     true,
+	}, {
+		header: &Header{Name: strings.Repeat("a", 101), Uid: 1 << 21},
+		format: FormatUSTAR,
+		want:   []FieldError{{Field: "Uid", Value: 1 << 21}},
+	}, {
+		header: &Header{Name: strings.Repeat("a", 101)},
+		format: FormatV7,
+		want:   []FieldError{{Field: "Name", Value: strings.Repeat("a", 101)}},
+	}, {
+		header: &Header{Name: "file", Uname: "gopher"},
+		format: FormatV7,
+		want:   []FieldError{{Field: "Uname", Value: "gopher"}},
+	}, {
+		header: &Header{Name: "file", Xattrs: map[string]string{"user.a": "b"}},
+		format: FormatGNU,
+		want:   []FieldError{{Field: "Xattrs", Value: map[string]string{"user.a": "b"}}},
+	}, {
+		header: &Header{Name: "file", Xattrs: map[string]string{"user.a": "b"}},
+		format: FormatPAX,
+		ok:     true,
+	}, {
+		header: &Header{Name: "file", Typeflag: TypeReg, Size: -2},
+		format: FormatPAX,
+		want:   []FieldError{{Field: "Size", Value: int64(-2)}},
+	}, {
+		header: &Header{Name: "file"},
+		format: FormatUSTAR | FormatPAX,
+	}}
+
+	for i, v := range vectors {
+		err := v.header.Validate(v.format)
+		if ok := err == nil; ok != v.ok {
+			t.Errorf("test %d, Validate(%v) error: %v, want ok=%v", i, v.format, err, v.ok)
+			continue
+		}
+		if v.want == nil {
+			continue
+		}
+		he, ok := err.(*HeaderError)
+		if !ok {
+			t.Errorf("test %d, Validate(%v) error = %v, want *HeaderError", i, v.format, err)
+			continue
+		}
+		var got []FieldError
+		for _, fe := range he.Fields {
+			if fe.Reason == "" {
+				t.Errorf("test %d, error on %s has no reason", i, fe.Field)
+			}
+			fe.Reason = ""
+			got = append(got, fe)
+		}
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("test %d, Validate(%v) fields = %v, want %v", i, v.format, got, v.want)
+		}
+	}
+}
+
+func TestHeaderCreationTime(t *testing.T) {
+	btime := time.Unix(1500000000, 123456789)
+	vectors := []struct {
+		format Format
+		want   time.Time
+	}{
+		{FormatPAX, btime},
+		{FormatUnknown, time.Time{}},
+		{FormatGNU, time.Time{}},
+	}
+	for i, v := range vectors {
+		hdr := &Header{Name: "file", Typeflag: TypeReg, ModTime: time.Unix(1500000000, 0), CreationTime: btime, Format: v.format}
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if !got.CreationTime.Equal(v.want) {
+			t.Errorf("test %d, CreationTime = %v, want %v", i, got.CreationTime, v.want)
+		}
+		if want := formatPAXTime(v.want); !v.want.IsZero() && got.PAXRecords[PAXKeyLibarchiveCreationTime] != want {
+			t.Errorf("test %d, PAXRecords[%q] = %q, want %q", i, PAXKeyLibarchiveCreationTime, got.PAXRecords[PAXKeyLibarchiveCreationTime], want)
+		}
+	}
+
+	if f := (&Header{Name: "file", CreationTime: btime, Format: FormatUSTAR}).FormatReport().Format; f != FormatUSTAR {
+		t.Errorf("FormatReport().Format = %v, want USTAR, since CreationTime is ignored", f)
+	}
+}
+
+func TestHeaderKind(t *testing.T) {
+	vectors := []struct {
+		header *Header
+		name   string
+		kind   string // Name of the method reporting true, if any
+	}{
+		{&Header{Typeflag: TypeReg, Name: "file"}, "reg", "IsRegular"},
+		{&Header{Typeflag: TypeRegA, Name: "file"}, "rega", "IsRegular"},
+		{&Header{Typeflag: TypeRegA, Name: "dir/"}, "rega", "IsDir"},
+		{&Header{Typeflag: TypeReg, Name: "dir/"}, "reg", "IsRegular"},
+		{&Header{Typeflag: TypeCont, Name: "file"}, "cont", "IsRegular"},
+		{&Header{Typeflag: TypeGNUSparse, Name: "file"}, "gnusparse", "IsRegular"},
+		{&Header{Typeflag: TypeDir, Name: "dir"}, "dir", "IsDir"},
+		{&Header{Typeflag: TypeSymlink, Name: "link"}, "symlink", "IsSymlink"},
+		{&Header{Typeflag: TypeLink, Name: "link"}, "link", "IsHardlink"},
+		{&Header{Typeflag: TypeChar, Name: "tty"}, "char", "IsDevice"},
+		{&Header{Typeflag: TypeBlock, Name: "sda"}, "block", "IsDevice"},
+		{&Header{Typeflag: TypeFifo, Name: "pipe"}, "fifo", "IsFIFO"},
+		{&Header{Typeflag: TypeXGlobalHeader, Name: "global"}, "xglobalheader", ""},
+		{&Header{Typeflag: 'A', Name: "solaris"}, "'A'", ""},
+		{&Header{Typeflag: 0xff, Name: "binary"}, "'\\u00ff'", ""},
+	}
+
+	for i, v := range vectors {
+		if got := TypeName(v.header.Typeflag); got != v.name {
+			t.Errorf("test %d, TypeName(%q) = %s, want %s", i, v.header.Typeflag, got, v.name)
+		}
+		got := map[string]bool{
+			"IsRegular":  v.header.IsRegular(),
+			"IsDir":      v.header.IsDir(),
+			"IsSymlink":  v.header.IsSymlink(),
+			"IsHardlink": v.header.IsHardlink(),
+			"IsDevice":   v.header.IsDevice(),
+			"IsFIFO":     v.header.IsFIFO(),
+		}
+		for kind, ok := range got {
+			if ok != (kind == v.kind) {
+				t.Errorf("test %d, %s() = %v, want %v", i, kind, ok, !ok)
+			}
+		}
+	}
+}
+
+func TestHeaderError(t *testing.T) {
+	vectors := []struct {
+		header *Header
+		fields []string
+	}{{
+		header: &Header{Name: "file/", Typeflag: TypeReg, Size: -2},
+		fields: []string{"Name", "Size"},
+	}, {
+		header: &Header{Name: "file", Typeflag: TypeXHeader},
+		fields: []string{"Typeflag"},
+	}, {
+		header: &Header{Name: "file", Xattrs: map[string]string{"user.a": "b"}, Format: FormatUSTAR},
+		fields: []string{"Format", "Xattrs"},
+	}, {
+		header: &Header{Name: strings.Repeat("a", 200), Format: FormatUSTAR, AccessACL: []ACLEntry{{Tag: ACLUser, ID: -1}}},
+		fields: []string{"AccessACL", "Format", "Name"},
+	}, {
+		header: &Header{Name: "file", Xattrs: map[string]string{"a\x00": ""}, PAXRecords: map[string]string{"a=b": ""}},
+		fields: []string{"Xattrs", "PAXRecords"},
+	}}
+
+	for i, v := range vectors {
+		err := NewWriter(ioutil.Discard).WriteHeader(v.header)
+		he, ok := err.(*HeaderError)
+		if !ok {
+			t.Errorf("test %d, WriteHeader() error = %v, want *HeaderError", i, err)
+			continue
+		}
+		var got []string
+		for _, f := range he.Fields {
+			if f.Reason == "" {
+				t.Errorf("test %d, error on %s has no reason", i, f.Field)
+			}
+			got = append(got, f.Field)
+		}
+		if !reflect.DeepEqual(got, v.fields) {
+			t.Errorf("test %d, HeaderError fields = %v, want %v (%v)", i, got, v.fields, err)
+		}
+		if rep := v.header.FormatReport(); !reflect.DeepEqual(rep.Err, err) {
+			t.Errorf("test %d, FormatReport().Err = %v, want %v", i, rep.Err, err)
+		}
+	}
+}
+
 func Benchmark(b *testing.B) {
 	type file struct {
 		hdr  *Header
@@ -852,3 +1109,117 @@ func Benchmark(b *testing.B) {
 	})
 
 }
+
+func TestHeaderWindowsMetadata(t *testing.T) {
+	sd := []byte{1, 0, 4, 0x80, 0, 0, 0, 0}
+	vectors := []struct {
+		hdr       Header
+		wantAttrs uint32
+		wantSD    []byte
+	}{{
+		hdr:       Header{WindowsAttributes: 0x2 | 0x4, WindowsSecurityDescriptor: sd},
+		wantAttrs: 0x6,
+		wantSD:    sd,
+	}, {
+		hdr:       Header{PAXRecords: map[string]string{"MSWINDOWS.fileattr": "1", "MSWINDOWS.rawsd": "AQAEgAAAAAA="}},
+		wantAttrs: 0x1,
+		wantSD:    sd,
+	}, {
+		hdr: Header{PAXRecords: map[string]string{
+			"MSWINDOWS.rawsecuritydescriptor": "AQAEgAAAAAA=",
+			"MSWINDOWS.rawsd":                 "AAAA",
+		}},
+		wantSD: sd,
+	}}
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		v.hdr.Name, v.hdr.Typeflag = "file", TypeReg
+		if err := tw.WriteHeader(&v.hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if got.WindowsAttributes != v.wantAttrs || !bytes.Equal(got.WindowsSecurityDescriptor, v.wantSD) {
+			t.Errorf("test %d, Next() = %#x, %x; want %#x, %x",
+				i, got.WindowsAttributes, got.WindowsSecurityDescriptor, v.wantAttrs, v.wantSD)
+		}
+	}
+
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	tw.WriteHeader(&Header{Name: "bad", Typeflag: TypeReg, PAXRecords: map[string]string{"MSWINDOWS.fileattr": "-1"}})
+	tw.Close()
+	if _, err := NewReader(&b).Next(); err != ErrHeader {
+		t.Errorf("Next() with invalid attributes: got %v, want %v", err, ErrHeader)
+	}
+}
+
+// computeChecksumBytes is the byte-at-a-time reference implementation of
+// block.ComputeChecksum.
+func computeChecksumBytes(b *block) (unsigned, signed int64) {
+	for i, c := range b {
+		if 148 <= i && i < 156 {
+			c = ' ' // Treat the checksum field itself as all spaces.
+		}
+		unsigned += int64(c)
+		signed += int64(int8(c))
+	}
+	return unsigned, signed
+}
+
+func TestComputeChecksum(t *testing.T) {
+	var blks []block
+	var ones block
+	for i := range ones {
+		ones[i] = 0xff
+	}
+	blks = append(blks, block{}, ones)
+	rnd := rand.New(rand.NewSource(0))
+	for i := 0; i < 100; i++ {
+		var blk block
+		rnd.Read(blk[:])
+		blks = append(blks, blk)
+	}
+	files, err := filepath.Glob("testdata/*.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var blk block
+		copy(blk[:], b)
+		blks = append(blks, blk)
+	}
+
+	for i, blk := range blks {
+		gotU, gotS := blk.ComputeChecksum()
+		wantU, wantS := computeChecksumBytes(&blk)
+		if gotU != wantU || gotS != wantS {
+			t.Errorf("test %d, ComputeChecksum() = %d, %d; want %d, %d", i, gotU, gotS, wantU, wantS)
+		}
+	}
+}
+
+func BenchmarkComputeChecksum(b *testing.B) {
+	var blk block
+	rand.New(rand.NewSource(0)).Read(blk[:])
+	b.Run("SWAR", func(b *testing.B) {
+		b.SetBytes(blockSize)
+		for i := 0; i < b.N; i++ {
+			blk.ComputeChecksum()
+		}
+	})
+	b.Run("Bytes", func(b *testing.B) {
+		b.SetBytes(blockSize)
+		for i := 0; i < b.N; i++ {
+			computeChecksumBytes(&blk)
+		}
+	})
+}
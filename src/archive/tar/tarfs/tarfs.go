@@ -0,0 +1,400 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tarfs implements an in-memory tree of files that can be populated
+// programmatically or loaded from a tar archive, and written to one.
+//
+// It is meant for tests, and for tools that assemble archives from generated
+// content. Unlike a tar.Writer, an FS may be modified in any order, and
+// writes a deterministic archive regardless of that order.
+package tarfs
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An FS is an in-memory tree of files, each described by a tar.Header.
+// The zero value is not usable; use New or Load. An FS is not safe for
+// concurrent modification.
+//
+// Names are slash-separated paths relative to the root of the tree, such as
+// "dir/file.txt", where "." names the root directory. Methods return an
+// *os.PathError whose Err is os.ErrInvalid for names that are not in this
+// form, such as "/abs", "../up", "a//b" or "dir/".
+//
+// Adding a file creates its missing parent directories with mode 0755.
+// Like tar.FS, a file is replaced by a directory that its name implies, and
+// a directory is replaced, along with its contents, by a file of the same
+// name, so that the last of several entries with the same name wins.
+type FS struct {
+	files map[string]*file
+}
+
+// file is a file or directory in an FS.
+type file struct {
+	hdr      tar.Header      // Name is canonical, with a trailing slash for directories
+	data     []byte          // Contents of a regular file
+	children map[string]bool // Base names of the contents of a directory
+}
+
+func (f *file) isDir() bool { return f.hdr.Typeflag == tar.TypeDir }
+
+// New returns an FS holding only the root directory.
+func New() *FS {
+	fsys := &FS{files: make(map[string]*file)}
+	fsys.files["."] = &file{
+		hdr:      tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755},
+		children: make(map[string]bool),
+	}
+	return fsys
+}
+
+// Load reads the archive in r into a new FS. Sparse files are loaded as
+// regular files, and PAX global headers are ignored. Entries with insecure
+// names (see tar.ErrInsecurePath) are reported as errors.
+func Load(r io.Reader) (*FS, error) {
+	fsys := New()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeGNUSparse:
+			hdr.Typeflag = tar.TypeReg
+		}
+		var data []byte
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			if data, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		}
+		if err := fsys.Add(hdr, data); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Add adds a file described by hdr, with the given contents if it is a
+// regular file. Its name is hdr.Name, which is cleaned first, so that
+// "./dir/" names "dir". The Size of the stored header is set to len(data).
+//
+// The Linkname of a hard link must name a regular file in fsys,
+// while that of a symbolic link is not checked.
+//
+// Add retains data, which must not be modified afterwards.
+func (fsys *FS) Add(hdr *tar.Header, data []byte) error {
+	name := path.Clean(hdr.Name)
+	if !isLocalName(name) || name == "." {
+		return &os.PathError{Op: "add", Path: hdr.Name, Err: tar.ErrInsecurePath}
+	}
+	f := &file{hdr: *hdr}
+	f.hdr.Name = name
+	f.hdr.Size = 0
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		f.data = data
+		f.hdr.Size = int64(len(data))
+	case tar.TypeDir:
+		f.hdr.Name += "/"
+		f.children = make(map[string]bool)
+	case tar.TypeLink:
+		target := path.Clean(hdr.Linkname)
+		if t, ok := fsys.files[target]; !ok || (t.hdr.Typeflag != tar.TypeReg && t.hdr.Typeflag != tar.TypeRegA) {
+			return &os.PathError{Op: "link", Path: hdr.Linkname, Err: errors.New("not a regular file")}
+		}
+		f.hdr.Linkname = target
+	case tar.TypeXHeader, tar.TypeXGlobalHeader, tar.TypeGNULongName, tar.TypeGNULongLink, tar.TypeGNUSparse:
+		return &os.PathError{Op: "add", Path: hdr.Name, Err: errors.New("unsupported type " + tar.TypeName(hdr.Typeflag))}
+	}
+	if len(data) > 0 && f.data == nil {
+		return &os.PathError{Op: "add", Path: hdr.Name, Err: errors.New("contents for a file that is not regular")}
+	}
+
+	if old, ok := fsys.files[name]; ok {
+		if old.isDir() && f.isDir() {
+			f.children = old.children // Keep the contents of the directory
+		} else {
+			fsys.remove(name)
+		}
+	}
+	fsys.mkdirAll(path.Dir(name)).children[path.Base(name)] = true
+	fsys.files[name] = f
+	return nil
+}
+
+// mkdirAll returns the directory named name,
+// creating it and its parents if they do not exist.
+func (fsys *FS) mkdirAll(name string) *file {
+	if f, ok := fsys.files[name]; ok && f.isDir() {
+		return f
+	} else if ok {
+		fsys.remove(name) // A file is replaced by an implied directory
+	}
+	f := &file{
+		hdr:      tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0755},
+		children: make(map[string]bool),
+	}
+	fsys.mkdirAll(path.Dir(name)).children[path.Base(name)] = true
+	fsys.files[name] = f
+	return f
+}
+
+// remove removes the named file and its contents.
+func (fsys *FS) remove(name string) {
+	for c := range fsys.files[name].children {
+		fsys.remove(path.Join(name, c))
+	}
+	delete(fsys.files, name)
+	if parent, ok := fsys.files[path.Dir(name)]; ok {
+		delete(parent.children, path.Base(name))
+	}
+}
+
+// WriteFile adds a regular file with the given contents and permissions.
+// WriteFile retains data, which must not be modified afterwards.
+func (fsys *FS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	if err := checkName("writefile", name); err != nil {
+		return err
+	}
+	return fsys.Add(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: int64(perm.Perm())}, data)
+}
+
+// MkdirAll adds a directory with the given permissions, along with any
+// missing parents. An existing directory keeps its contents and permissions.
+func (fsys *FS) MkdirAll(name string, perm os.FileMode) error {
+	if err := checkName("mkdir", name); err != nil {
+		return err
+	}
+	if f, ok := fsys.files[name]; ok && f.isDir() {
+		return nil
+	}
+	return fsys.Add(&tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: int64(perm.Perm())}, nil)
+}
+
+// Symlink adds a symbolic link named newname pointing to oldname.
+func (fsys *FS) Symlink(oldname, newname string) error {
+	if err := checkName("symlink", newname); err != nil {
+		return err
+	}
+	return fsys.Add(&tar.Header{Typeflag: tar.TypeSymlink, Name: newname, Linkname: oldname, Mode: 0777}, nil)
+}
+
+// Link adds a hard link named newname to the regular file oldname.
+func (fsys *FS) Link(oldname, newname string) error {
+	if err := checkName("link", oldname); err != nil {
+		return err
+	}
+	if err := checkName("link", newname); err != nil {
+		return err
+	}
+	return fsys.Add(&tar.Header{Typeflag: tar.TypeLink, Name: newname, Linkname: oldname}, nil)
+}
+
+// RemoveAll removes the named file, or directory and its contents.
+// It returns nil if name does not exist.
+func (fsys *FS) RemoveAll(name string) error {
+	if err := checkName("remove", name); err != nil {
+		return err
+	}
+	if name == "." {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrInvalid}
+	}
+	if _, ok := fsys.files[name]; ok {
+		fsys.remove(name)
+	}
+	return nil
+}
+
+// lookup returns the named file.
+func (fsys *FS) lookup(op, name string) (*file, error) {
+	if err := checkName(op, name); err != nil {
+		return nil, err
+	}
+	f, ok := fsys.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return f, nil
+}
+
+// Stat returns an os.FileInfo describing the named file.
+// The Sys method of the os.FileInfo returns a copy of its *tar.Header.
+func (fsys *FS) Stat(name string) (os.FileInfo, error) {
+	f, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.stat(), nil
+}
+
+func (f *file) stat() os.FileInfo {
+	hdr := f.hdr // Copy, so that the caller cannot modify f
+	return hdr.FileInfo()
+}
+
+// ReadDir returns the contents of the named directory, sorted by name.
+func (fsys *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	f, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	fis := make([]os.FileInfo, 0, len(f.children))
+	for _, c := range f.sortedChildren() {
+		fis = append(fis, fsys.files[path.Join(name, c)].stat())
+	}
+	return fis, nil
+}
+
+func (f *file) sortedChildren() []string {
+	names := make([]string, 0, len(f.children))
+	for c := range f.children {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadFile returns a copy of the contents of the named regular file,
+// or of the file that a hard link links to.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.hdr.Typeflag == tar.TypeLink {
+		if f = fsys.files[f.hdr.Linkname]; f == nil {
+			return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+		}
+	}
+	if f.isDir() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+// Walk walks the file tree rooted at root in lexical order, calling fn for
+// each file or directory in the tree, including root, with the same
+// semantics as filepath.Walk, except that names are slash-separated.
+func (fsys *FS) Walk(root string, fn filepath.WalkFunc) error {
+	f, err := fsys.lookup("walk", root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fsys.walk(root, f, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (fsys *FS) walk(name string, f *file, fn filepath.WalkFunc) error {
+	if err := fn(name, f.stat(), nil); err != nil {
+		if err == filepath.SkipDir && f.isDir() {
+			return nil
+		}
+		return err
+	}
+	for _, c := range f.sortedChildren() {
+		cname := path.Join(name, c)
+		cf := fsys.files[cname]
+		if err := fsys.walk(cname, cf, fn); err != nil {
+			if err == filepath.SkipDir && !cf.isDir() {
+				return nil // Skip the rest of this directory
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the files of fsys to w as a tar archive, in lexical
+// order, except that hard links follow all other files, so that they
+// always follow the files that they link to. The root directory is not
+// written.
+//
+// The headers are written as they were added, so their Format must allow
+// any later changes to them, and is best left unspecified.
+func (fsys *FS) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	tw := tar.NewWriter(cw)
+	var links []*file
+	err := fsys.Walk(".", func(name string, fi os.FileInfo, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		f := fsys.files[name]
+		if f.hdr.Typeflag == tar.TypeLink {
+			links = append(links, f)
+			return nil
+		}
+		return writeFile(tw, f)
+	})
+	for _, f := range links {
+		if err != nil {
+			break
+		}
+		if _, ok := fsys.files[f.hdr.Linkname]; !ok {
+			err = &os.PathError{Op: "write", Path: f.hdr.Name, Err: errors.New("hard link to missing file")}
+			break
+		}
+		err = writeFile(tw, f)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	return cw.n, err
+}
+
+func writeFile(tw *tar.Writer, f *file) error {
+	hdr := f.hdr
+	if err := tw.WriteHeader(&hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(f.data)
+	return err
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// checkName reports an error if name is not a canonical name in an FS.
+func checkName(op, name string) error {
+	if name != "." && (!isLocalName(name) || path.Clean(name) != name) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrInvalid}
+	}
+	return nil
+}
+
+// isLocalName reports whether the cleaned name does not leave the root.
+func isLocalName(name string) bool {
+	return name != "" && !path.IsAbs(name) && name != ".." && !strings.HasPrefix(name, "../")
+}
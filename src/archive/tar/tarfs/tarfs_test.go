@@ -0,0 +1,169 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFS(t *testing.T) {
+	fsys := New()
+	for i, err := range []error{
+		fsys.WriteFile("a/b/file", []byte("hello"), 0644),
+		fsys.MkdirAll("dir", 0700),
+		fsys.Link("a/b/file", "dir/link"),
+		fsys.Symlink("a", "sym"),
+		fsys.WriteFile("dup", []byte("old"), 0644),
+		fsys.WriteFile("dup", []byte("new"), 0600),
+		fsys.WriteFile("gone/file", nil, 0644),
+		fsys.RemoveAll("gone"),
+	} {
+		if err != nil {
+			t.Fatalf("test %d, error: %v", i, err)
+		}
+	}
+
+	for i, name := range []string{"/abs", "../up", "a//b", "dir/", "./a"} {
+		if err := fsys.WriteFile(name, nil, 0644); err == nil || err.(*os.PathError).Err != os.ErrInvalid {
+			t.Errorf("test %d, WriteFile(%q) error: %v, want os.ErrInvalid", i, name, err)
+		}
+	}
+	if err := fsys.Link("sym", "badlink"); err == nil {
+		t.Errorf("Link() to a symbolic link succeeded")
+	}
+	if err := fsys.Add(&tar.Header{Typeflag: tar.TypeDir, Name: "d"}, []byte("x")); err == nil {
+		t.Errorf("Add() of a directory with contents succeeded")
+	}
+
+	var buf bytes.Buffer
+	if _, err := fsys.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error: %v", err)
+	}
+	want := []string{"a/", "a/b/", "a/b/file", "dir/", "dup", "sym", "dir/link"}
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("WriteTo() names = %q, want %q", names, want)
+	}
+
+	fsys2, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	for i, fs := range []*FS{fsys, fsys2} {
+		fis, err := fs.ReadDir(".")
+		if err != nil {
+			t.Fatalf("test %d, ReadDir() error: %v", i, err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if want := []string{"a", "dir", "dup", "sym"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("test %d, ReadDir() = %q, want %q", i, names, want)
+		}
+		for _, v := range []struct {
+			name, want string
+		}{
+			{"a/b/file", "hello"},
+			{"dir/link", "hello"},
+			{"dup", "new"},
+		} {
+			if got, err := fs.ReadFile(v.name); err != nil || string(got) != v.want {
+				t.Errorf("test %d, ReadFile(%q) = %q, %v; want %q", i, v.name, got, err, v.want)
+			}
+		}
+		if fi, err := fs.Stat("dir"); err != nil || fi.Mode() != os.ModeDir|0700 {
+			t.Errorf("test %d, Stat(dir) = %v, %v; want mode %v", i, fi, err, os.ModeDir|0700)
+		}
+		if fi, err := fs.Stat("sym"); err != nil || fi.Sys().(*tar.Header).Linkname != "a" {
+			t.Errorf("test %d, Stat(sym) = %v, %v; want link to a", i, fi, err)
+		}
+		if _, err := fs.Stat("gone"); !os.IsNotExist(err) {
+			t.Errorf("test %d, Stat(gone) error: %v, want not exist", i, err)
+		}
+	}
+}
+
+func TestFSReplace(t *testing.T) {
+	fsys := New()
+	fsys.WriteFile("a", []byte("file"), 0644)
+	fsys.WriteFile("a/b", []byte("nested"), 0644) // Replaces the file with a directory
+	if fi, err := fsys.Stat("a"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat(a) = %v, %v; want directory", fi, err)
+	}
+	fsys.WriteFile("a", []byte("file"), 0644) // Replaces the directory and its contents
+	if _, err := fsys.Stat("a/b"); !os.IsNotExist(err) {
+		t.Errorf("Stat(a/b) error: %v, want not exist", err)
+	}
+	var paths []string
+	fsys.Walk(".", func(name string, fi os.FileInfo, err error) error {
+		paths = append(paths, name)
+		return err
+	})
+	if want := []string{".", "a"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Walk() = %q, want %q", paths, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f, err := os.Open("../testdata/sparse-formats.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fsys, err := Load(f)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	got, err := fsys.ReadFile("sparse-gnu")
+	if err != nil || len(got) != 200 {
+		t.Fatalf("ReadFile(sparse-gnu) = %d bytes, %v; want 200 bytes", len(got), err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := fsys.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error: %v", err)
+	}
+	fsys2, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load() of written archive error: %v", err)
+	}
+	got2, err := fsys2.ReadFile("sparse-gnu")
+	if err != nil || !bytes.Equal(got, got2) {
+		t.Errorf("ReadFile(sparse-gnu) after round trip differs, error: %v", err)
+	}
+
+	if _, err := Load(bytes.NewReader(makeInsecure(t))); err == nil {
+		t.Errorf("Load() of insecure name succeeded")
+	}
+}
+
+func makeInsecure(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../evil"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,266 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tarhttp provides random access to remote files over HTTP,
+// so that single entries can be read from large remote archives without
+// downloading them in full, for example with tar.NewFS:
+//
+//	ra, err := tarhttp.NewReaderAt(nil, "https://example.com/image.tar")
+//	if err != nil {
+//		return err
+//	}
+//	fsys, err := tar.NewFS(ra, ra.Size())
+//	...
+//	data, err := fsys.ReadFile("etc/os-release")
+package tarhttp
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBlockSize is the default size of the blocks read by a ReaderAt.
+const DefaultBlockSize = 64 << 10
+
+// DefaultCacheBlocks is the default number of blocks cached by a ReaderAt.
+const DefaultCacheBlocks = 256
+
+// A ReaderAt is an io.ReaderAt for a remote file, which reads the file with
+// HTTP range requests in blocks of BlockSize bytes, and caches the blocks
+// most recently read. It is safe for concurrent use.
+//
+// If the server reports an entity tag or a modification time for the file,
+// it is sent as a precondition with each request, so that reads fail
+// rather than mix the contents of different versions of the file.
+//
+// The fields of a ReaderAt must not be changed after the first call to ReadAt.
+type ReaderAt struct {
+	// BlockSize is the size of the blocks in which the file is read.
+	// If zero, DefaultBlockSize is used.
+	BlockSize int64
+
+	// CacheBlocks is the number of blocks to cache. The blocks least
+	// recently read are evicted first. If zero, DefaultCacheBlocks is used,
+	// and if negative, blocks are not cached.
+	CacheBlocks int
+
+	// Prefetch is the number of blocks following those requested by ReadAt
+	// to read in the same request, and cache, which speeds up sequential
+	// reads, such as those of the data of large entries.
+	Prefetch int
+
+	client *http.Client
+	url    string
+	size   int64
+	etag   string // Strong entity tag of the file, if any
+	mtime  string // Last-Modified time of the file, if any
+
+	mu     sync.Mutex
+	blocks map[int64]*list.Element // Cached blocks, by index
+	lru    list.List               // Cached *block values, most recent first
+}
+
+// block is a cached block of the file.
+type block struct {
+	i    int64
+	data []byte
+}
+
+// NewReaderAt returns a ReaderAt for the file at url, whose size it obtains
+// with a first range request. It returns an error if the server does not
+// support range requests. If client is nil, http.DefaultClient is used.
+func NewReaderAt(client *http.Client, url string) (*ReaderAt, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ra := &ReaderAt{client: client, url: url}
+	resp, err := ra.get("bytes=0-0")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, _, ra.size, err = parseContentRange(resp.Header.Get("Content-Range"))
+	case http.StatusRequestedRangeNotSatisfiable: // Empty file
+		if resp.Header.Get("Content-Range") != "bytes */0" {
+			err = errors.New("tarhttp: invalid Content-Range in response for " + url)
+		}
+	case http.StatusOK:
+		// Some servers, including net/http, ignore ranges of empty files.
+		if resp.ContentLength != 0 {
+			err = errors.New("tarhttp: server does not support range requests for " + url)
+		}
+	default:
+		err = fmt.Errorf("tarhttp: unexpected status %q for %s", resp.Status, url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if etag := resp.Header.Get("ETag"); !strings.HasPrefix(etag, "W/") {
+		ra.etag = etag
+	}
+	ra.mtime = resp.Header.Get("Last-Modified")
+	return ra, nil
+}
+
+// Size returns the size of the file.
+func (ra *ReaderAt) Size() int64 { return ra.size }
+
+// ReadAt implements io.ReaderAt.
+func (ra *ReaderAt) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("tarhttp: negative offset")
+	}
+	bs := ra.blockSize()
+	for n < len(b) {
+		pos := off + int64(n)
+		if pos >= ra.size {
+			return n, io.EOF
+		}
+		i := pos / bs
+		data, ok := ra.cached(i)
+		if !ok {
+			last := (pos + int64(len(b)-n) - 1) / bs
+			if data, err = ra.fetch(i, last+int64(ra.Prefetch)); err != nil {
+				return n, err
+			}
+		}
+		n += copy(b[n:], data[pos-i*bs:])
+	}
+	return n, nil
+}
+
+func (ra *ReaderAt) blockSize() int64 {
+	if ra.BlockSize > 0 {
+		return ra.BlockSize
+	}
+	return DefaultBlockSize
+}
+
+// cached returns the block i if it is cached.
+func (ra *ReaderAt) cached(i int64) ([]byte, bool) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	e, ok := ra.blocks[i]
+	if !ok {
+		return nil, false
+	}
+	ra.lru.MoveToFront(e)
+	return e.Value.(*block).data, true
+}
+
+// fetch reads the blocks first to last, caches them and returns their data.
+func (ra *ReaderAt) fetch(first, last int64) ([]byte, error) {
+	bs := ra.blockSize()
+	start := first * bs
+	end := (last + 1) * bs
+	if end > ra.size {
+		end = ra.size
+	}
+	resp, err := ra.get(fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusPreconditionFailed:
+		return nil, errors.New("tarhttp: " + ra.url + " changed while being read")
+	default:
+		return nil, fmt.Errorf("tarhttp: unexpected status %q for %s", resp.Status, ra.url)
+	}
+	gotStart, gotEnd, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	if gotStart != start || gotEnd != end-1 || size != ra.size {
+		return nil, errors.New("tarhttp: " + ra.url + " changed while being read")
+	}
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+
+	for i := first; (i-first)*bs < int64(len(data)); i++ {
+		d := data[(i-first)*bs:]
+		if int64(len(d)) > bs {
+			d = d[:bs:bs]
+		}
+		ra.store(i, d)
+	}
+	return data, nil
+}
+
+// store caches data as the block i.
+func (ra *ReaderAt) store(i int64, data []byte) {
+	max := ra.CacheBlocks
+	if max == 0 {
+		max = DefaultCacheBlocks
+	}
+	if max < 0 {
+		return
+	}
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if ra.blocks == nil {
+		ra.blocks = make(map[int64]*list.Element)
+	}
+	if e, ok := ra.blocks[i]; ok {
+		ra.lru.MoveToFront(e)
+		return
+	}
+	ra.blocks[i] = ra.lru.PushFront(&block{i, data})
+	for ra.lru.Len() > max {
+		e := ra.lru.Back()
+		ra.lru.Remove(e)
+		delete(ra.blocks, e.Value.(*block).i)
+	}
+}
+
+// get sends a GET request for the given range of the file.
+func (ra *ReaderAt) get(rng string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", ra.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", rng)
+	if ra.etag != "" {
+		req.Header.Set("If-Match", ra.etag)
+	} else if ra.mtime != "" {
+		req.Header.Set("If-Unmodified-Since", ra.mtime)
+	}
+	return ra.client.Do(req)
+}
+
+// parseContentRange parses a Content-Range header of the form
+// "bytes start-end/size".
+func parseContentRange(s string) (start, end, size int64, err error) {
+	err = errors.New("tarhttp: invalid Content-Range " + strconv.Quote(s))
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, 0, err
+	}
+	s = s[len("bytes "):]
+	i := strings.IndexByte(s, '-')
+	j := strings.IndexByte(s, '/')
+	if i < 0 || j < i {
+		return 0, 0, 0, err
+	}
+	var err1, err2, err3 error
+	start, err1 = strconv.ParseInt(s[:i], 10, 64)
+	end, err2 = strconv.ParseInt(s[i+1:j], 10, 64)
+	size, err3 = strconv.ParseInt(s[j+1:], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || start < 0 || end < start || size <= end {
+		return 0, 0, 0, err
+	}
+	return start, end, size, nil
+}
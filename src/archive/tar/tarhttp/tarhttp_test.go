@@ -0,0 +1,232 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tarhttp
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server serves the content of a file with range requests,
+// counting the requests.
+type server struct {
+	data     []byte
+	etag     string
+	requests int32
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&s.requests, 1)
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+	}
+	http.ServeContent(w, r, "file", time.Unix(1500000000, 0), bytes.NewReader(s.data))
+}
+
+func TestReaderAt(t *testing.T) {
+	data := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(data)
+	s := &server{data: data, etag: `"v1"`}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ra, err := NewReaderAt(nil, ts.URL)
+	if err != nil {
+		t.Fatalf("NewReaderAt() error: %v", err)
+	}
+	ra.BlockSize = 1000
+	ra.CacheBlocks = 4
+	if ra.Size() != int64(len(data)) {
+		t.Fatalf("Size() = %d, want %d", ra.Size(), len(data))
+	}
+
+	vectors := []struct {
+		off, n   int
+		requests int32 // Number of requests made, or -1 if not checked
+	}{
+		{0, 10, 1},
+		{500, 400, 0},      // Cached
+		{900, 200, 1},      // Second block fetched
+		{1500, 100, 0},     // Cached
+		{2500, 3000, 1},    // Blocks 2 to 5 fetched at once
+		{0, 10, 1},         // Evicted
+		{9990, 10, 1},      // Last block
+		{0, len(data), -1}, // Everything
+		{4321, 1234, -1},   // Unaligned
+		{9999, 1, -1},      // Last byte
+		{1000, 1000, -1},   // Exactly one block
+		{0, 0, 0},          // Empty read
+		{20000, 0, 0},      // Empty read past the end
+		{7777, 2223, -1},   // Up to the end, unaligned
+		{999, 2, -1},       // Across a block boundary
+	}
+	for i, v := range vectors {
+		before := atomic.LoadInt32(&s.requests)
+		b := make([]byte, v.n)
+		n, err := ra.ReadAt(b, int64(v.off))
+		if err != nil || n != v.n {
+			t.Errorf("test %d, ReadAt() = %d, %v, want %d", i, n, err, v.n)
+			continue
+		}
+		if v.n > 0 && !bytes.Equal(b, data[v.off:v.off+v.n]) {
+			t.Errorf("test %d, ReadAt() returned wrong data", i)
+		}
+		if got := atomic.LoadInt32(&s.requests) - before; v.requests >= 0 && got != v.requests {
+			t.Errorf("test %d, made %d requests, want %d", i, got, v.requests)
+		}
+	}
+
+	b := make([]byte, 100)
+	if n, err := ra.ReadAt(b, 9950); n != 50 || err != io.EOF {
+		t.Errorf("ReadAt() past end = %d, %v, want 50, io.EOF", n, err)
+	}
+	if _, err := ra.ReadAt(b, -1); err == nil {
+		t.Errorf("ReadAt() at negative offset succeeded")
+	}
+
+	// Reads fail once the file changes.
+	s.etag = `"v2"`
+	if _, err := ra.ReadAt(b, 3000); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("ReadAt() of changed file error: %v, want changed", err)
+	}
+}
+
+func TestReaderAtPrefetch(t *testing.T) {
+	s := &server{data: bytes.Repeat([]byte("0123456789"), 1000)}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ra, err := NewReaderAt(ts.Client(), ts.URL)
+	if err != nil {
+		t.Fatalf("NewReaderAt() error: %v", err)
+	}
+	ra.BlockSize = 100
+	ra.Prefetch = 9
+	atomic.StoreInt32(&s.requests, 0)
+	data, err := ioutil.ReadAll(io.NewSectionReader(ra, 0, ra.Size()))
+	if err != nil || !bytes.Equal(data, s.data) {
+		t.Fatalf("ReadAll() = %d bytes, %v, want %d bytes", len(data), err, len(s.data))
+	}
+	if got := atomic.LoadInt32(&s.requests); got > 10 {
+		t.Errorf("made %d requests, want at most 10", got)
+	}
+
+	ra, err = NewReaderAt(ts.Client(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra.BlockSize = 100
+	ra.CacheBlocks = -1
+	atomic.StoreInt32(&s.requests, 0)
+	b := make([]byte, 50)
+	for i := 0; i < 3; i++ {
+		if _, err := ra.ReadAt(b, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&s.requests); got != 3 {
+		t.Errorf("made %d requests without cache, want 3", got)
+	}
+}
+
+func TestReaderAtFS(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	big := bytes.Repeat([]byte("x"), 1<<20)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"big1", big},
+		{"dir/small", []byte("small")},
+		{"big2", big},
+		{"last", []byte("last")},
+	}
+	for _, f := range files {
+		tw.WriteHeader(&tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.data))})
+		tw.Write(f.data)
+	}
+	tw.Close()
+
+	s := &server{data: buf.Bytes()}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	ra, err := NewReaderAt(nil, ts.URL)
+	if err != nil {
+		t.Fatalf("NewReaderAt() error: %v", err)
+	}
+	ra.BlockSize = 4096
+	fsys, err := tar.NewFS(ra, ra.Size())
+	if err != nil {
+		t.Fatalf("NewFS() error: %v", err)
+	}
+	for _, name := range []string{"dir/small", "last"} {
+		data, err := fsys.ReadFile(name)
+		if err != nil || string(data) != name[strings.LastIndex(name, "/")+1:] {
+			t.Errorf("ReadFile(%q) = %q, %v", name, data, err)
+		}
+	}
+	if got := atomic.LoadInt32(&s.requests); got > 10 {
+		t.Errorf("made %d requests, want at most 10", got)
+	}
+}
+
+func TestNewReaderAt(t *testing.T) {
+	noRange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "no range support")
+	}))
+	defer noRange.Close()
+	if _, err := NewReaderAt(nil, noRange.URL); err == nil {
+		t.Errorf("NewReaderAt() without range support succeeded")
+	}
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	if _, err := NewReaderAt(nil, notFound.URL); err == nil {
+		t.Errorf("NewReaderAt() of missing file succeeded")
+	}
+
+	empty := httptest.NewServer(&server{})
+	defer empty.Close()
+	ra, err := NewReaderAt(nil, empty.URL)
+	if err != nil {
+		t.Fatalf("NewReaderAt() of empty file error: %v", err)
+	}
+	if n, err := ra.ReadAt(make([]byte, 1), 0); ra.Size() != 0 || n != 0 || err != io.EOF {
+		t.Errorf("ReadAt() of empty file = %d, %v with size %d", n, err, ra.Size())
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	vectors := []struct {
+		in               string
+		start, end, size int64
+		ok               bool
+	}{
+		{"bytes 0-0/1", 0, 0, 1, true},
+		{"bytes 100-199/1000", 100, 199, 1000, true},
+		{"bytes */1000", 0, 0, 0, false},
+		{"bytes 100-99/1000", 0, 0, 0, false},
+		{"bytes 0-1000/1000", 0, 0, 0, false},
+		{"items 0-0/1", 0, 0, 0, false},
+		{"bytes 0-0", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+	}
+	for i, v := range vectors {
+		start, end, size, err := parseContentRange(v.in)
+		if (err == nil) != v.ok || start != v.start || end != v.end || size != v.size {
+			t.Errorf("test %d, parseContentRange(%q) = %d, %d, %d, %v", i, v.in, start, end, size, err)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tartest provides utilities for constructing and comparing
+// tar archives in tests.
+//
+// A Builder assembles an archive from a list of entries:
+//
+//	data := new(tartest.Builder).
+//		Entry("dir/").
+//		Entry("dir/file", tartest.WithMode(0600), tartest.WithContent([]byte("hello"))).
+//		Entry("link", tartest.WithSymlink("dir/file")).
+//		Build()
+//
+// Compare and Golden compare archives entry by entry, reporting which
+// fields of which entries differ rather than that the bytes differ.
+package tartest
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A Builder assembles a tar archive. The zero value is an empty Builder
+// ready to use.
+type Builder struct {
+	entries []entry
+}
+
+// entry is an entry added to a Builder.
+type entry struct {
+	hdr  tar.Header
+	data []byte
+}
+
+// An EntryOption configures an entry added with Builder.Entry.
+type EntryOption func(*entry)
+
+// WithMode sets the Mode of the entry.
+func WithMode(mode int64) EntryOption {
+	return func(e *entry) { e.hdr.Mode = mode }
+}
+
+// WithContent sets the contents of the entry,
+// and its Size to len(b).
+func WithContent(b []byte) EntryOption {
+	return func(e *entry) {
+		e.data = b
+		e.hdr.Size = int64(len(b))
+	}
+}
+
+// WithType sets the Typeflag of the entry.
+func WithType(flag byte) EntryOption {
+	return func(e *entry) { e.hdr.Typeflag = flag }
+}
+
+// WithSymlink makes the entry a symbolic link to target.
+func WithSymlink(target string) EntryOption {
+	return func(e *entry) {
+		e.hdr.Typeflag = tar.TypeSymlink
+		e.hdr.Linkname = target
+		e.hdr.Mode = 0777
+	}
+}
+
+// WithHardlink makes the entry a hard link to target.
+func WithHardlink(target string) EntryOption {
+	return func(e *entry) {
+		e.hdr.Typeflag = tar.TypeLink
+		e.hdr.Linkname = target
+	}
+}
+
+// WithModTime sets the ModTime of the entry.
+func WithModTime(t time.Time) EntryOption {
+	return func(e *entry) { e.hdr.ModTime = t }
+}
+
+// WithOwner sets the Uid and Gid of the entry.
+func WithOwner(uid, gid int) EntryOption {
+	return func(e *entry) { e.hdr.Uid, e.hdr.Gid = uid, gid }
+}
+
+// WithFormat sets the Format of the entry.
+func WithFormat(f tar.Format) EntryOption {
+	return func(e *entry) { e.hdr.Format = f }
+}
+
+// WithPAXRecords adds the given PAX records to the entry.
+func WithPAXRecords(recs map[string]string) EntryOption {
+	return func(e *entry) {
+		if e.hdr.PAXRecords == nil {
+			e.hdr.PAXRecords = make(map[string]string)
+		}
+		for k, v := range recs {
+			e.hdr.PAXRecords[k] = v
+		}
+	}
+}
+
+// WithHeader calls fn to modify the Header of the entry arbitrarily,
+// after the options preceding it have been applied.
+func WithHeader(fn func(*tar.Header)) EntryOption {
+	return func(e *entry) { fn(&e.hdr) }
+}
+
+// Entry adds an entry with the given name and options, and returns b.
+//
+// By default, the entry is an empty regular file with mode 0644, or a
+// directory with mode 0755 if name ends in a slash. Its ModTime is the Unix
+// epoch, so that the archive does not depend on when it is built.
+func (b *Builder) Entry(name string, opts ...EntryOption) *Builder {
+	e := entry{hdr: tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		ModTime:  time.Unix(0, 0),
+	}}
+	if strings.HasSuffix(name, "/") {
+		e.hdr.Typeflag = tar.TypeDir
+		e.hdr.Mode = 0755
+	}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	b.entries = append(b.entries, e)
+	return b
+}
+
+// WriteTo writes the archive to w.
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	tw := tar.NewWriter(cw)
+	for _, e := range b.entries {
+		hdr := e.hdr
+		if err := tw.WriteHeader(&hdr); err != nil {
+			return cw.n, err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return cw.n, err
+		}
+	}
+	err := tw.Close()
+	return cw.n, err
+}
+
+// Build returns the archive. It panics if an entry cannot be written,
+// such as when its Size does not match its contents or its Format cannot
+// represent it; use WriteTo to handle such errors.
+func (b *Builder) Build() []byte {
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		panic("tartest: " + err.Error())
+	}
+	return buf.Bytes()
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Compare compares the archives got and want entry by entry, using
+// tar.CompareHeaders with opts for the headers, and returns a description
+// of each difference, or nil if they are equal. PAX global headers are
+// compared like other entries.
+func Compare(got, want []byte, opts ...tar.CompareOption) ([]string, error) {
+	ge, err := readEntries(got)
+	if err != nil {
+		return nil, fmt.Errorf("tartest: reading got: %v", err)
+	}
+	we, err := readEntries(want)
+	if err != nil {
+		return nil, fmt.Errorf("tartest: reading want: %v", err)
+	}
+	var diffs []string
+	for i := 0; i < len(ge) || i < len(we); i++ {
+		switch {
+		case i >= len(we):
+			diffs = append(diffs, fmt.Sprintf("entry %d %q: unexpected", i, ge[i].hdr.Name))
+			continue
+		case i >= len(ge):
+			diffs = append(diffs, fmt.Sprintf("entry %d %q: missing", i, we[i].hdr.Name))
+			continue
+		}
+		g, w := ge[i], we[i]
+		for _, d := range tar.CompareHeaders(&g.hdr, &w.hdr, opts...) {
+			diffs = append(diffs, fmt.Sprintf("entry %d %q: %s = %v, want %v", i, w.hdr.Name, d.Field, d.A, d.B))
+		}
+		if !bytes.Equal(g.data, w.data) {
+			diffs = append(diffs, fmt.Sprintf("entry %d %q: contents = %s, want %s", i, w.hdr.Name, summarize(g.data), summarize(w.data)))
+		}
+	}
+	return diffs, nil
+}
+
+// readEntries reads the entries of the archive in b.
+func readEntries(b []byte) ([]entry, error) {
+	var entries []entry
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{*hdr, data})
+	}
+}
+
+// summarize returns a short description of the contents b.
+func summarize(b []byte) string {
+	const max = 32
+	if len(b) <= max {
+		return fmt.Sprintf("%q", b)
+	}
+	return fmt.Sprintf("%q... (%d bytes)", b[:max], len(b))
+}
+
+// Golden compares the archive got with the golden archive stored in the
+// named file, and reports each difference with t.Errorf. To create or
+// update a golden file, write the archive to it with ioutil.WriteFile.
+func Golden(t testing.TB, got []byte, file string, opts ...tar.CompareOption) {
+	t.Helper()
+	want, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("tartest: %v", err)
+	}
+	diffs, err := Compare(got, want, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diffs {
+		t.Errorf("%s: %s", file, d)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tartest
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuilder(t *testing.T) {
+	data := new(Builder).
+		Entry("dir/").
+		Entry("dir/file", WithMode(0600), WithContent([]byte("hello")), WithOwner(1, 2)).
+		Entry("link", WithSymlink("dir/file")).
+		Entry("hard", WithHardlink("dir/file"), WithHeader(func(h *tar.Header) { h.Uname = "root" })).
+		Build()
+
+	want := []tar.Header{
+		{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755},
+		{Typeflag: tar.TypeReg, Name: "dir/file", Mode: 0600, Size: 5, Uid: 1, Gid: 2},
+		{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "dir/file", Mode: 0777},
+		{Typeflag: tar.TypeLink, Name: "hard", Linkname: "dir/file", Mode: 0644, Uname: "root"},
+	}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("got %d entries, want %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if i >= len(want) {
+			continue
+		}
+		want[i].ModTime = time.Unix(0, 0)
+		if diffs := tar.CompareHeaders(hdr, &want[i], tar.CompareIgnore("Format")); diffs != nil {
+			t.Errorf("test %d, header differs: %+v", i, diffs)
+		}
+	}
+
+	if _, err := new(Builder).Entry("file", WithType(tar.TypeDir), WithContent([]byte("x"))).WriteTo(ioutil.Discard); err == nil {
+		t.Errorf("WriteTo() of directory with contents succeeded")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := func() *Builder {
+		return new(Builder).Entry("a", WithContent([]byte("data"))).Entry("b/")
+	}
+	vectors := []struct {
+		got   *Builder
+		opts  []tar.CompareOption
+		diffs []string
+	}{{
+		got: base(),
+	}, {
+		got: new(Builder).Entry("a", WithContent([]byte("DATA"))).Entry("b/"),
+		diffs: []string{
+			`entry 0 "a": contents = "DATA", want "data"`,
+		},
+	}, {
+		got: new(Builder).Entry("a", WithContent([]byte("data")), WithMode(0600)).Entry("b/").Entry("c"),
+		diffs: []string{
+			`entry 0 "a": Mode = 384, want 420`,
+			`entry 2 "c": unexpected`,
+		},
+	}, {
+		got:  new(Builder).Entry("a", WithContent([]byte("data")), WithMode(0600)),
+		opts: []tar.CompareOption{tar.CompareIgnore("Mode")},
+		diffs: []string{
+			`entry 1 "b/": missing`,
+		},
+	}}
+
+	want := base().Build()
+	for i, v := range vectors {
+		diffs, err := Compare(v.got.Build(), want, v.opts...)
+		if err != nil {
+			t.Errorf("test %d, Compare() error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(diffs, v.diffs) {
+			t.Errorf("test %d, Compare() = %q, want %q", i, diffs, v.diffs)
+		}
+	}
+
+	if _, err := Compare([]byte("garbage"), want); err == nil {
+		t.Errorf("Compare() of invalid archive succeeded")
+	}
+}
+
+// recorder is a testing.TB that records errors.
+type recorder struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recorder) Helper() {}
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tartest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "golden.tar")
+	if err := ioutil.WriteFile(file, new(Builder).Entry("a").Build(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var r recorder
+	Golden(&r, new(Builder).Entry("a").Build(), file)
+	if r.errs != nil {
+		t.Errorf("Golden() of equal archive reported %q", r.errs)
+	}
+	Golden(&r, new(Builder).Entry("b").Build(), file)
+	if len(r.errs) != 1 {
+		t.Errorf("Golden() of different archive reported %q, want 1 error", r.errs)
+	}
+}
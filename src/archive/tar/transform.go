@@ -0,0 +1,114 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"io"
+	"strings"
+)
+
+// A TransformRule renames an entry, returning its new name, or the empty
+// string to drop it. It is applied to names as they are in the archive,
+// with any trailing slash of directories.
+//
+// Any function of a name can serve as a rule. The sed-like expressions of
+// tar --transform correspond to the replacement methods of a regular
+// expression re from package regexp:
+//
+//	TransformRule(func(name string) string { return re.ReplaceAllString(name, repl) })
+type TransformRule func(name string) string
+
+// TransformStripPrefix returns a TransformRule that removes prefix from the
+// names that start with it, leaving the others unchanged.
+// Names equal to prefix become empty, so their entries are dropped.
+func TransformStripPrefix(prefix string) TransformRule {
+	return func(name string) string { return strings.TrimPrefix(name, prefix) }
+}
+
+// TransformAddPrefix returns a TransformRule that adds prefix to all names,
+// such as "pkg-1.0/" to place the entries in a directory.
+func TransformAddPrefix(prefix string) TransformRule {
+	return func(name string) string { return prefix + name }
+}
+
+// A Transform renames the entries of an archive while copying it,
+// in the manner of tar --transform.
+type Transform struct {
+	// Rules are applied in order to the Name of each entry, and to the
+	// Linkname of hard links, which names another entry of the archive,
+	// so that hard links keep referring to their renamed targets.
+	Rules []TransformRule
+
+	// Symlinks causes the Rules to be applied to the Linkname of symbolic
+	// links as well. The target of a symbolic link is a path relative to
+	// the directory of the link or an absolute path, rather than the name
+	// of an entry, so it is left unchanged by default.
+	Symlinks bool
+}
+
+// Rename returns name transformed by the rules of t, or the empty string
+// if an entry with that name is to be dropped.
+func (t *Transform) Rename(name string) string {
+	for _, rule := range t.Rules {
+		if name == "" {
+			break
+		}
+		name = rule(name)
+	}
+	return name
+}
+
+// Copy reads the archive from r and writes it to w with its entries renamed,
+// using Rewrite. Entries whose name becomes empty are dropped, as are hard
+// links whose target becomes empty, as it is no longer in the archive.
+//
+// Entries that are not renamed are copied without being encoded again.
+// For the others, the "path" and "linkpath" records in PAXRecords are
+// updated with the new names, and the Format is cleared if the new names
+// cannot be represented in the original format.
+func (t *Transform) Copy(w io.Writer, r io.Reader) error {
+	return Rewrite(w, r, func(hdr *Header, r io.Reader) (io.Reader, error) {
+		if hdr.Typeflag == TypeXGlobalHeader {
+			return r, nil
+		}
+		if !t.renameHeader(hdr) {
+			return nil, SkipEntry
+		}
+		return r, nil
+	})
+}
+
+// renameHeader renames hdr in place, and reports whether it is to be kept.
+func (t *Transform) renameHeader(hdr *Header) bool {
+	name, link := t.Rename(hdr.Name), hdr.Linkname
+	if hdr.Typeflag == TypeLink || hdr.Typeflag == TypeSymlink && t.Symlinks {
+		link = t.Rename(link)
+	}
+	if name == "" || link == "" && hdr.Typeflag == TypeLink {
+		return false
+	}
+	if name == hdr.Name && link == hdr.Linkname {
+		return true
+	}
+
+	hdr.Name, hdr.Linkname = name, link
+	if hdr.PAXRecords != nil {
+		recs := make(map[string]string, len(hdr.PAXRecords))
+		for k, v := range hdr.PAXRecords {
+			recs[k] = v
+		}
+		if _, ok := recs[paxPath]; ok {
+			recs[paxPath] = name
+		}
+		if _, ok := recs[paxLinkpath]; ok {
+			recs[paxLinkpath] = link
+		}
+		hdr.PAXRecords = recs
+	}
+	if _, _, err := hdr.allowedFormats(); err != nil {
+		hdr.Format = FormatUnknown
+	}
+	return true
+}
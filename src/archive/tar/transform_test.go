@@ -0,0 +1,121 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTransformRename(t *testing.T) {
+	txt := regexp.MustCompile(`\.txt$`)
+	tf := &Transform{Rules: []TransformRule{
+		TransformStripPrefix("src/"),
+		TransformRule(func(name string) string { return txt.ReplaceAllString(name, ".md") }),
+		TransformAddPrefix("pkg/"),
+	}}
+	vectors := []struct {
+		in, want string
+	}{
+		{"src/a.txt", "pkg/a.md"},
+		{"src/dir/", "pkg/dir/"},
+		{"src/", ""},
+		{"other.txt", "pkg/other.md"},
+		{"srcfile", "pkg/srcfile"},
+	}
+	for i, v := range vectors {
+		if got := tf.Rename(v.in); got != v.want {
+			t.Errorf("test %d, Rename(%q) = %q, want %q", i, v.in, got, v.want)
+		}
+	}
+}
+
+func TestTransformCopy(t *testing.T) {
+	long := strings.Repeat("d", 90) + "/" + strings.Repeat("f", 90)
+	src := makeArchive(t,
+		testEntry{hdr: Header{Name: "src/", Typeflag: TypeDir, Mode: 0755}},
+		testEntry{hdr: Header{Name: "src/file", Typeflag: TypeReg, Mode: 0644}, body: "data"},
+		testEntry{hdr: Header{Name: "src/hardlink", Typeflag: TypeLink, Linkname: "src/file"}},
+		testEntry{hdr: Header{Name: "src/symlink", Typeflag: TypeSymlink, Linkname: "src/file"}},
+		testEntry{hdr: Header{Name: "src/" + long, Typeflag: TypeReg, Mode: 0644, Format: FormatPAX}, body: "long"},
+		testEntry{hdr: Header{Name: "src/ustar", Typeflag: TypeReg, Mode: 0644, Format: FormatUSTAR}},
+		testEntry{hdr: Header{Name: "dropped/link", Typeflag: TypeLink, Linkname: "src/"}},
+		testEntry{hdr: Header{Name: "README", Typeflag: TypeReg, Mode: 0644}, body: "readme"},
+	)
+
+	vectors := []struct {
+		tf   *Transform
+		want []string // Name, Linkname and data of each entry
+	}{{
+		tf: &Transform{},
+		want: []string{
+			"src/", "src/file:data", "src/hardlink->src/file", "src/symlink->src/file",
+			"src/" + long + ":long", "src/ustar:", "dropped/link->src/", "README:readme",
+		},
+	}, {
+		tf: &Transform{Rules: []TransformRule{TransformStripPrefix("src/")}},
+		want: []string{
+			"file:data", "hardlink->file", "symlink->src/file",
+			long + ":long", "ustar:", "README:readme",
+		},
+	}, {
+		tf: &Transform{Rules: []TransformRule{TransformAddPrefix(strings.Repeat("p", 100) + "/")}, Symlinks: true},
+		want: []string{
+			strings.Repeat("p", 100) + "/src/",
+			strings.Repeat("p", 100) + "/src/file:data",
+			strings.Repeat("p", 100) + "/src/hardlink->" + strings.Repeat("p", 100) + "/src/file",
+			strings.Repeat("p", 100) + "/src/symlink->" + strings.Repeat("p", 100) + "/src/file",
+			strings.Repeat("p", 100) + "/src/" + long + ":long",
+			strings.Repeat("p", 100) + "/src/ustar:",
+			strings.Repeat("p", 100) + "/dropped/link->" + strings.Repeat("p", 100) + "/src/",
+			strings.Repeat("p", 100) + "/README:readme",
+		},
+	}}
+
+	for i, v := range vectors {
+		var b bytes.Buffer
+		if err := v.tf.Copy(&b, bytes.NewReader(src)); err != nil {
+			t.Errorf("test %d, Copy() error: %v", i, err)
+			continue
+		}
+		if len(v.tf.Rules) == 0 && !bytes.Equal(b.Bytes(), src) {
+			t.Errorf("test %d, Copy() without rules modified the archive", i)
+		}
+
+		var got []string
+		tr := NewReader(&b)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("test %d, Next() error: %v", i, err)
+			}
+			if p, ok := hdr.PAXRecords[paxPath]; ok && p != hdr.Name {
+				t.Errorf("test %d, PAX path record %q differs from Name %q", i, p, hdr.Name)
+			}
+			entry := hdr.Name
+			switch hdr.Typeflag {
+			case TypeReg:
+				data, err := ioutil.ReadAll(tr)
+				if err != nil {
+					t.Fatalf("test %d, ReadAll() error: %v", i, err)
+				}
+				entry += ":" + string(data)
+			case TypeLink, TypeSymlink:
+				entry += "->" + hdr.Linkname
+			}
+			got = append(got, entry)
+		}
+		if strings.Join(got, "\n") != strings.Join(v.want, "\n") {
+			t.Errorf("test %d, transformed entries:\ngot  %q\nwant %q", i, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"errors"
+	"io"
+)
+
+// volumeWriter is an io.Writer that splits an archive into volumes.
+//
+// The headers of each entry are buffered, so that they can be moved to the
+// next volume as a whole if they do not fit in the current one, while the
+// data is split wherever the current volume is full.
+type volumeWriter struct {
+	w      io.Writer // Current volume
+	n      int64     // Number of bytes written to the current volume
+	volume int       // Number of the current volume, starting at 1
+	size   int64     // Maximum size of a volume
+	next   func(volume int) (io.Writer, error)
+
+	recordSize int64 // Size of records in which volumes are written, if any
+
+	inHeaders bool   // Whether writes are buffered in hdrs
+	hdrs      []byte // Header blocks of the next entry
+
+	name string // Name of the entry whose data is being written
+	left int64  // Number of bytes of data remaining for the entry
+	done int64  // Number of bytes of data written for the entry
+	blk  block  // Storage for continuation headers
+}
+
+// beginHeaders prepares tw to write the headers of an entry.
+func (tw *Writer) beginHeaders() error {
+	if !tw.started {
+		if err := tw.startOutput(); err != nil {
+			return err
+		}
+		tw.started = true
+	}
+	if tw.vol != nil {
+		tw.vol.inHeaders = true
+	}
+	return nil
+}
+
+// startOutput sets up the writers for records and volumes, if configured.
+func (tw *Writer) startOutput() error {
+	recordSize := int64(tw.BlockingFactor) * blockSize
+	if tw.BlockingFactor < 0 {
+		return errors.New("archive/tar: negative BlockingFactor")
+	}
+	if tw.NextVolume != nil {
+		switch {
+		case tw.VolumeSize%blockSize != 0 || tw.VolumeSize < 2*blockSize:
+			return errors.New("archive/tar: VolumeSize must be a multiple of 512 and at least 1024")
+		case recordSize > 0 && tw.VolumeSize%recordSize != 0:
+			return errors.New("archive/tar: VolumeSize must be a multiple of the record size")
+		}
+	}
+	if recordSize > 0 {
+		tw.w = newRecordWriter(tw.w, recordSize)
+	}
+	if tw.NextVolume != nil {
+		tw.vol = &volumeWriter{w: tw.w, volume: 1, size: tw.VolumeSize, next: tw.NextVolume, recordSize: recordSize}
+		tw.w = tw.vol
+	}
+	if tw.BuildTOC {
+		if tw.vol != nil {
+			return errors.New("archive/tar: BuildTOC when writing volumes")
+		}
+		tw.off = &offsetWriter{w: tw.w}
+		tw.w = tw.off
+	}
+	return nil
+}
+
+// flushRecord writes the last record of the archive, if it is being
+// written in records.
+func (tw *Writer) flushRecord() error {
+	w := tw.dst()
+	if tw.vol != nil {
+		w = tw.vol.w
+	}
+	if rw, ok := w.(*recordWriter); ok {
+		return rw.flush()
+	}
+	return nil
+}
+
+// endHeaders writes the buffered headers of the named entry,
+// which is followed by size bytes of data.
+func (vw *volumeWriter) endHeaders(name string, size int64) error {
+	if int64(len(vw.hdrs)) > vw.size {
+		return errors.New("archive/tar: headers of entry larger than VolumeSize")
+	}
+	if int64(len(vw.hdrs)) > vw.size-vw.n {
+		if err := vw.nextVolume(); err != nil {
+			return err
+		}
+	}
+	vw.inHeaders = false
+	_, err := vw.write(vw.hdrs)
+	vw.hdrs = vw.hdrs[:0]
+	vw.name, vw.left, vw.done = name, size, 0
+	return err
+}
+
+func (vw *volumeWriter) Write(b []byte) (int, error) {
+	if vw.inHeaders {
+		vw.hdrs = append(vw.hdrs, b...)
+		return len(b), nil
+	}
+	var n int
+	for len(b) > 0 {
+		if vw.n == vw.size {
+			if err := vw.nextVolume(); err != nil {
+				return n, err
+			}
+			if err := vw.writeContinuation(); err != nil {
+				return n, err
+			}
+		}
+		m := len(b)
+		if int64(m) > vw.size-vw.n {
+			m = int(vw.size - vw.n)
+		}
+		nn, err := vw.write(b[:m])
+		n += nn
+		vw.done += int64(nn)
+		vw.left -= int64(nn)
+		if err != nil {
+			return n, err
+		}
+		b = b[m:]
+	}
+	return n, nil
+}
+
+// write writes b to the current volume.
+func (vw *volumeWriter) write(b []byte) (int, error) {
+	n, err := vw.w.Write(b)
+	vw.n += int64(n)
+	return n, err
+}
+
+// nextVolume switches to the next volume.
+func (vw *volumeWriter) nextVolume() error {
+	if rw, ok := vw.w.(*recordWriter); ok {
+		if err := rw.flush(); err != nil {
+			return err
+		}
+	}
+	w, err := vw.next(vw.volume + 1)
+	if err != nil {
+		return err
+	}
+	if vw.recordSize > 0 {
+		w = newRecordWriter(w, vw.recordSize)
+	}
+	vw.w, vw.n = w, 0
+	vw.volume++
+	return nil
+}
+
+// writeContinuation writes the GNU header that starts a volume
+// in the middle of the data of an entry.
+func (vw *volumeWriter) writeContinuation() error {
+	left := vw.left
+	if left < 0 {
+		left = 0 // Only padding remains
+	}
+	name := toASCII(vw.name)
+	if len(name) > nameSize {
+		name = name[:nameSize]
+	}
+
+	var f formatter
+	vw.blk.Reset()
+	gnu := vw.blk.GNU()
+	gnu.V7().TypeFlag()[0] = typeGNUMultiVolume
+	f.formatString(gnu.V7().Name(), name)
+	f.formatOctal(gnu.V7().Mode(), 0)
+	f.formatOctal(gnu.V7().UID(), 0)
+	f.formatOctal(gnu.V7().GID(), 0)
+	f.formatOctal(gnu.V7().ModTime(), 0)
+	f.formatNumeric(gnu.V7().Size(), left)
+	f.formatNumeric(gnu.Offset(), vw.done)
+	vw.blk.SetFormat(FormatGNU)
+	if f.err != nil {
+		return f.err
+	}
+	_, err := vw.write(vw.blk[:])
+	return err
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestWriterVolumes(t *testing.T) {
+	files := []struct {
+		name string
+		data string
+	}{
+		{"small", "hello"},
+		{"big", strings.Repeat("0123456789", 500)},
+		{strings.Repeat("long/", 30) + "name", strings.Repeat("x", 1000)},
+		{"dir/", ""},
+	}
+
+	const volumeSize = 4 * blockSize
+	volumes := []*bytes.Buffer{new(bytes.Buffer)}
+	tw := NewWriter(volumes[0])
+	tw.VolumeSize = volumeSize
+	tw.NextVolume = func(n int) (io.Writer, error) {
+		if n != len(volumes)+1 {
+			t.Errorf("NextVolume(%d), want %d", n, len(volumes)+1)
+		}
+		volumes = append(volumes, new(bytes.Buffer))
+		return volumes[n-1], nil
+	}
+	for _, f := range files {
+		hdr := &Header{Name: f.name, Typeflag: TypeReg, Size: int64(len(f.data))}
+		if strings.HasSuffix(f.name, "/") {
+			hdr.Typeflag = TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader(%q) error: %v", f.name, err)
+		}
+		if _, err := io.WriteString(tw, f.data); err != nil {
+			t.Fatalf("Write(%q) error: %v", f.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	// Reassemble the files from the volumes.
+	data := make(map[string]string)
+	for _, f := range files {
+		data[f.name] = f.data
+	}
+	got := make(map[string]string)
+	var order []string
+	for i, v := range volumes {
+		raw := append([]byte(nil), v.Bytes()...)
+		if v.Len() > volumeSize || v.Len()%blockSize != 0 {
+			t.Errorf("volume %d has size %d", i+1, v.Len())
+		}
+		tr := NewReader(v)
+		for j := 0; ; j++ {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("volume %d, Next() error: %v", i+1, err)
+			}
+			if hdr.Typeflag == typeGNUMultiVolume {
+				if j != 0 {
+					t.Errorf("volume %d, continuation header is entry %d", i+1, j)
+				}
+				var blk block
+				copy(blk[:], raw)
+				var p parser
+				off := len(got[hdr.Name])
+				if gotOff := p.parseNumeric(blk.GNU().Offset()); gotOff != int64(off) {
+					t.Errorf("volume %d, continuation offset = %d, want %d", i+1, gotOff, off)
+				}
+				if want := int64(len(data[hdr.Name]) - off); hdr.Size != want {
+					t.Errorf("volume %d, continuation size = %d, want %d", i+1, hdr.Size, want)
+				}
+			} else {
+				order = append(order, hdr.Name)
+			}
+			b, err := ioutil.ReadAll(tr)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				t.Fatalf("volume %d, ReadAll() error: %v", i+1, err)
+			}
+			got[hdr.Name] += string(b)
+			if err == io.ErrUnexpectedEOF {
+				break // Continued in the next volume
+			}
+		}
+	}
+	if len(volumes) < 4 {
+		t.Errorf("got %d volumes, want at least 4", len(volumes))
+	}
+	if len(order) != len(files) {
+		t.Errorf("got entries %q, want %d entries", order, len(files))
+	}
+	for _, f := range files {
+		if got[f.name] != f.data {
+			t.Errorf("data of %q: got %d bytes, want %d", f.name, len(got[f.name]), len(f.data))
+		}
+	}
+
+	tw = NewWriter(ioutil.Discard)
+	tw.VolumeSize = 1000
+	tw.NextVolume = func(int) (io.Writer, error) { return ioutil.Discard, nil }
+	if err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg}); err == nil {
+		t.Errorf("WriteHeader() with invalid VolumeSize succeeded")
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// Whiteouts are the entries by which the layers of Docker and OCI container
+// images, which are tar archives applied on top of each other, record the
+// deletion of files present in the layers below. A whiteout is an empty
+// regular file named after the deleted file with WhiteoutPrefix prepended to
+// its base name. An opaque whiteout, named WhiteoutOpaqueDir, marks its
+// directory as replacing rather than being merged with the directories of
+// the same name in the layers below.
+//
+// See https://github.com/opencontainers/image-spec/blob/master/layer.md.
+const (
+	WhiteoutPrefix    = ".wh."
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+// WhiteoutHeader returns the Header of the whiteout for the file name,
+// recording its deletion. The entry has no data.
+func WhiteoutHeader(name string) (*Header, error) {
+	dir, file := path.Split(strings.TrimSuffix(name, "/"))
+	if file == "" || file == "." || file == ".." || strings.HasPrefix(file, WhiteoutPrefix) {
+		return nil, errors.New("archive/tar: invalid name for whiteout: " + name)
+	}
+	return &Header{Name: dir + WhiteoutPrefix + file, Typeflag: TypeReg, Mode: 0644}, nil
+}
+
+// OpaqueWhiteoutHeader returns the Header of the opaque whiteout for the
+// directory dir, which hides the contents of the directories of the same name
+// in the layers below. An empty dir refers to the root of the layer.
+// The entry has no data.
+func OpaqueWhiteoutHeader(dir string) *Header {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return &Header{Name: dir + WhiteoutOpaqueDir, Typeflag: TypeReg, Mode: 0644}
+}
+
+// ParseWhiteout reports whether name is the name of a whiteout, and returns
+// the name of the file it deletes. For an opaque whiteout, it returns the
+// name of the directory it applies to, which is "." for the root of the
+// layer, and sets opaque. Other names starting with two WhiteoutPrefixes
+// are reserved for use by overlay file systems, and not reported as
+// whiteouts.
+func ParseWhiteout(name string) (target string, opaque, ok bool) {
+	dir, file := path.Split(name)
+	switch {
+	case file == WhiteoutOpaqueDir:
+		return path.Clean(dir), true, true
+	case strings.HasPrefix(file, WhiteoutPrefix+WhiteoutPrefix):
+		return "", false, false
+	case strings.HasPrefix(file, WhiteoutPrefix) && len(file) > len(WhiteoutPrefix):
+		return dir + file[len(WhiteoutPrefix):], false, true
+	}
+	return "", false, false
+}
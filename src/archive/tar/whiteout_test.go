@@ -0,0 +1,80 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"path"
+	"testing"
+)
+
+func TestParseWhiteout(t *testing.T) {
+	vectors := []struct {
+		in     string
+		target string
+		opaque bool
+		ok     bool
+	}{
+		{"a/.wh.b", "a/b", false, true},
+		{".wh.file", "file", false, true},
+		{"./a/.wh..hidden", "./a/.hidden", false, true},
+		{"a/.wh..wh..opq", "a", true, true},
+		{"a/b/.wh..wh..opq", "a/b", true, true},
+		{".wh..wh..opq", ".", true, true},
+		{"a/.wh..wh.plnk", "", false, false},
+		{"a/.wh.", "", false, false},
+		{"a/b.wh.c", "", false, false},
+		{".wh.dir/file", "", false, false},
+		{"file", "", false, false},
+	}
+	for i, v := range vectors {
+		target, opaque, ok := ParseWhiteout(v.in)
+		if target != v.target || opaque != v.opaque || ok != v.ok {
+			t.Errorf("test %d, ParseWhiteout(%q) = (%q, %v, %v), want (%q, %v, %v)",
+				i, v.in, target, opaque, ok, v.target, v.opaque, v.ok)
+		}
+	}
+}
+
+func TestWhiteoutHeader(t *testing.T) {
+	vectors := []struct {
+		in   string
+		want string // Empty if invalid
+	}{
+		{"file", ".wh.file"},
+		{"a/b/c", "a/b/.wh.c"},
+		{"a/dir/", "a/.wh.dir"},
+		{"", ""},
+		{"/", ""},
+		{"a/..", ""},
+		{"a/.wh.b", ""},
+	}
+	for i, v := range vectors {
+		hdr, err := WhiteoutHeader(v.in)
+		if v.want == "" {
+			if err == nil {
+				t.Errorf("test %d, WhiteoutHeader(%q) succeeded, want error", i, v.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d, WhiteoutHeader(%q) error: %v", i, v.in, err)
+			continue
+		}
+		if hdr.Name != v.want || hdr.Typeflag != TypeReg || hdr.Size != 0 {
+			t.Errorf("test %d, WhiteoutHeader(%q) = %+v, want empty file %q", i, v.in, hdr, v.want)
+		}
+		if target, _, ok := ParseWhiteout(hdr.Name); !ok || target+"/" != v.in && target != v.in {
+			t.Errorf("test %d, ParseWhiteout(%q) = %q, %v; want %q", i, hdr.Name, target, ok, v.in)
+		}
+	}
+
+	for _, dir := range []string{"", "a", "a/b/"} {
+		hdr := OpaqueWhiteoutHeader(dir)
+		want := path.Clean(dir)
+		if target, opaque, ok := ParseWhiteout(hdr.Name); !ok || !opaque || target != want {
+			t.Errorf("ParseWhiteout(OpaqueWhiteoutHeader(%q).Name) = (%q, %v, %v), want (%q, true, true)", dir, target, opaque, ok, want)
+		}
+	}
+}
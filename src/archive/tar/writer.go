@@ -6,10 +6,14 @@ package tar
 
 import (
 	"bytes"
+	"crypto"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,21 +22,217 @@ import (
 // Write.WriteHeader begins a new file with the provided Header,
 // and then Writer can be treated as an io.Writer to supply that file's data.
 type Writer struct {
+	// Deterministic causes WriteHeader to normalize the metadata of each
+	// entry, so that archiving the same files always produces the same bytes
+	// regardless of who creates the archive, or when. This is needed by build
+	// systems that rely on reproducible outputs.
+	//
+	// In particular, the Uid and Gid are set to zero, the Uname and Gname are
+	// cleared, the AccessTime, ChangeTime and CreationTime are discarded, and
+	// the ModTime is truncated to whole seconds, with a zero ModTime stored as
+	// the Unix epoch. As a result, the format chosen for an entry depends only on its
+	// name, size, mode, type and extended attributes. PAX records are always
+	// written in sorted order.
+	Deterministic bool
+
+	// ClampTime, if not zero, is the latest time that WriteHeader records in
+	// an archive: ModTime, AccessTime, ChangeTime and CreationTime values
+	// after ClampTime are replaced by it. Together with Deterministic, this implements the
+	// SOURCE_DATE_EPOCH convention for reproducible builds, under which files
+	// created during a build appear to be no newer than the sources.
+	// See SourceDateEpoch.
+	ClampTime time.Time
+
+	// StrictUSTAR restricts WriteHeader to the USTAR format, so that the
+	// archive can be read by busybox tar and other minimal extractors that
+	// support neither PAX nor GNU extensions. The ModTime is truncated to
+	// whole seconds, subject to TimePolicy, and the AccessTime, ChangeTime and CreationTime are
+	// discarded. Headers that USTAR cannot encode, such as those with a name
+	// longer than its limits, are rejected with a *HeaderError explaining
+	// why, as are headers whose Format is set to anything other than
+	// FormatUSTAR.
+	StrictUSTAR bool
+
+	// GNUTimes causes WriteHeader to keep the AccessTime and ChangeTime of
+	// headers whose Format is unspecified, and to store them in the atime
+	// and ctime fields of the GNU header, as GNU tar does for incremental
+	// archives, rather than discarding them. The times are rounded to the
+	// nearest second, and such headers are written in the GNU format. If a
+	// header cannot be encoded in the GNU format, such as because it has
+	// extended attributes, the PAX format is used instead.
+	GNUTimes bool
+
+	// TimePolicy specifies how WriteHeader handles a ModTime, AccessTime or
+	// ChangeTime that the format of a header cannot represent exactly,
+	// when its Format is set and does not include PAX: USTAR, V7 and STAR
+	// store whole seconds from 1970 to 2242, and GNU stores whole seconds.
+	// By default, times are truncated to whole seconds, and headers with
+	// times out of range are rejected with a *HeaderError.
+	//
+	// Headers whose Format is unspecified are not affected, since the
+	// format chosen for them can represent their times.
+	TimePolicy TimePolicy
+
+	// BuildTOC causes the Writer to record the header and offsets of each
+	// entry as it is written, in the form returned by List, so that an index
+	// allowing random access can be shipped alongside the archive without
+	// reading it again. The table of contents is returned by TOC.
+	// BuildTOC is not supported when writing volumes.
+	BuildTOC bool
+
+	// VolumeSize and NextVolume, if set, split the archive into volumes of
+	// at most VolumeSize bytes, in the manner of the --multi-volume option of
+	// GNU tar, for tapes and removable media. The io.Writer passed to
+	// NewWriter receives the first volume. When a volume is full, the Writer
+	// calls NextVolume with the number of the next volume, starting at 2,
+	// to obtain the io.Writer for it. The Writer does not close volumes,
+	// so NextVolume may need to close the previous one.
+	//
+	// Headers are never split between volumes. When the data of an entry
+	// is split, the next volume starts with a GNU continuation header that
+	// records the name of the entry, the size of the remaining data and its
+	// offset within the file, from which GNU tar can resume extraction.
+	// Only the last volume ends with a trailer.
+	//
+	// VolumeSize must be a multiple of 512 and at least 1024.
+	VolumeSize int64
+	NextVolume func(volume int) (io.Writer, error)
+
+	// BlockingFactor, if positive, is the number of 512-byte blocks in each
+	// record of the archive. The archive is then written to the underlying
+	// io.Writer in whole records, as required by tape drives, and Close pads
+	// the last record with zeros. GNU tar uses a blocking factor of 20 by
+	// default, so that the size of its archives is a multiple of 10240 bytes.
+	// When splitting the archive into volumes, VolumeSize must be a multiple
+	// of the record size, and each volume is padded separately.
+	BlockingFactor int
+
+	// TrailerBlocks, if not zero, is the number of 512-byte zero blocks that
+	// Close writes to mark the end of the archive, instead of the two that
+	// POSIX requires. Many readers stop at the first zero block, so one block
+	// suffices to minimize the output, such as when streaming HTTP responses.
+	// If TrailerBlocks is negative, no trailer is written at all, so that the
+	// output can be concatenated with other archives. Padding the archive to
+	// a whole number of records, as some tape drives and legacy readers
+	// require, is controlled by BlockingFactor.
+	TrailerBlocks int
+
+	// Digests lists hash functions with which to compute digests of the data
+	// of each regular file, to be recorded as LIBARCHIVE.digest.<algorithm>
+	// PAX records (e.g., "LIBARCHIVE.digest.sha256"), which Reader verifies
+	// and libarchive understands. Supported hash functions are MD5, SHA-1,
+	// SHA-256, SHA-384 and SHA-512, whose implementations must be linked
+	// into the binary, for example by importing crypto/sha256.
+	//
+	// Since the records precede the data in the archive, they are written
+	// with placeholders, over which the digests are recorded once all of the
+	// data of the entry has been written, as for entries of unknown size.
+	// This requires the underlying io.Writer to be an io.WriteSeeker, unless
+	// the digests are already recorded in the Header.PAXRecords passed to
+	// WriteHeader, in which case they are verified instead, and Flush
+	// returns a *DigestError if they do not match the data.
+	// Since ReadFrom must hash the data, it does not use sendfile.
+	Digests []crypto.Hash
+
+	// MapOwner, if set, is called by WriteHeader with the name and ownership
+	// of each entry, and the ownership it returns is written in its place.
+	// This allows tools running without privileges to record ownership
+	// different from that of the files they archive, for example by mapping
+	// every file to root, or by shifting IDs as a user namespace would
+	// (see MapIDs). If MapOwner returns an error, WriteHeader returns it
+	// without writing the entry. MapOwner is applied before Deterministic.
+	MapOwner func(name string, o Owner) (Owner, error)
+
+	// ManifestHash, if non-zero, causes the Writer to record the name,
+	// type, mode and size of each entry, with the digest of its data computed
+	// with ManifestHash, in a Manifest returned by Manifest. The manifest
+	// can then be signed and shipped alongside the archive, to be checked
+	// with VerifyManifest. The supported hash functions are those of Digests.
+	//
+	// Since ReadFrom must hash the data, it does not use sendfile.
+	// For GNU sparse files copied with CopyFrom, no digest is recorded.
+	ManifestHash crypto.Hash
+
+	// UpgradeSparse causes CopyFrom to re-encode sparse files stored in the
+	// old GNU format (TypeGNUSparse) or in versions 0.0 and 0.1 of the GNU
+	// sparse PAX format as version 1.0 of the latter, which GNU tar writes by
+	// default in the PAX format, so that consumers of the archive need only
+	// understand a single representation of sparse files. The headers of
+	// such entries are encoded again in the PAX format, with the sparse map
+	// stored at the start of their data, while the data itself is copied
+	// as it is stored.
+	UpgradeSparse bool
+
+	// OnStartEntry, OnWrite and OnFinishEntry, if set, are called to report
+	// the progress of writing the archive, for example to a user interface.
+	// OnStartEntry is called with the Header passed to WriteHeader once it
+	// has been accepted, OnWrite with the number of bytes of data accepted by
+	// each call to Write, and OnFinishEntry with the same Header once all of
+	// the data and padding of the entry have been written, which happens
+	// in Flush, FinishEntry, the next WriteHeader or Close.
+	// They are not called for entries copied by CopyFrom.
+	OnStartEntry  func(hdr *Header)
+	OnWrite       func(n int64)
+	OnFinishEntry func(hdr *Header)
+
 	w    io.Writer
 	pad  int64      // Amount of padding to write after current file entry
 	curr fileWriter // Writer for current file entry
 	hdr  Header     // Shallow copy of Header that is safe for mutations
 	blk  block      // Buffer to use as temporary local storage
 
+	// Storage reused across entries, to reduce allocations.
+	reg     regFileWriter    // Storage for curr
+	fmtr    formatter        // Formatter of the current header
+	paxKeys sort.StringSlice // Sorted keys of PAX records
+	paxBuf  []byte           // Data of PAX extended headers and GNU long names
+
+	started bool          // Whether startOutput has been called
+	vol     *volumeWriter // Underlying writer when splitting into volumes
+	unsized *unsizedEntry // Current entry if its size is not yet known
+	digest  *digestEntry  // Current entry if its digests are computed
+	entry   *Header       // Current entry, to be passed to OnFinishEntry
+	name    string        // Name of the current entry, if inEntry
+	inEntry bool          // Whether an entry is to be finished by Flush
+	last    string        // Name of the last finished entry
+
+	links map[fileID]string // Names of added files with several hard links
+
+	toc []TOCEntry    // Table of contents, if BuildTOC is set
+	off *offsetWriter // Counter of the bytes written, if BuildTOC is set
+
+	manifest *Manifest      // Manifest, if ManifestHash is set
+	mentry   *manifestEntry // Current entry of the manifest, if any
+
 	// err is a persistent error.
 	// It is only the responsibility of every exported method of Writer to
 	// ensure that this error is sticky.
 	err error
 }
 
+// A TimePolicy specifies how a Writer handles times that the format of
+// a header cannot represent exactly; see Writer.TimePolicy.
+type TimePolicy int
+
+const (
+	// TimeTruncate truncates times to whole seconds, and rejects headers
+	// with times outside the range of their format. This is the default.
+	TimeTruncate TimePolicy = iota
+
+	// TimeReject rejects headers with times that their format cannot
+	// represent exactly, including times with fractional seconds.
+	TimeReject
+
+	// TimeClamp truncates times to whole seconds, and replaces times
+	// outside the range of the format with the nearest time within it.
+	TimeClamp
+)
+
 // NewWriter creates a new Writer writing to w.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w: w, curr: &regFileWriter{w, 0}}
+	tw := &Writer{w: w}
+	tw.setCurr(0)
+	return tw
 }
 
 type fileWriter interface {
@@ -51,13 +251,129 @@ func (tw *Writer) Flush() error {
 	if tw.err != nil {
 		return tw.err
 	}
+	if tw.unsized != nil {
+		if tw.err = tw.finishUnsized(); tw.err != nil {
+			return tw.err
+		}
+	}
 	if nb := tw.curr.LogicalRemaining(); nb > 0 {
 		return fmt.Errorf("archive/tar: missed writing %d bytes", nb)
 	}
-	if _, tw.err = tw.w.Write(zeroBlock[:tw.pad]); tw.err != nil {
-		return tw.err
+	if tw.digest != nil {
+		if tw.err = tw.finishDigest(); tw.err != nil {
+			return tw.err
+		}
+	}
+	if tw.pad > 0 { // Avoid empty writes, which tape drives may record
+		if _, tw.err = tw.w.Write(zeroBlock[:tw.pad]); tw.err != nil {
+			return tw.err
+		}
+		tw.pad = 0
+	}
+	if tw.inEntry {
+		tw.inEntry, tw.last = false, tw.name
+	}
+	if tw.mentry != nil {
+		tw.finishManifestEntry()
 	}
-	tw.pad = 0
+	if hdr := tw.entry; hdr != nil {
+		tw.entry = nil
+		tw.OnFinishEntry(hdr)
+	}
+	return nil
+}
+
+// WriteGlobalPAXRecords writes a global PAX header holding recs, which apply
+// to all entries that follow it in the archive. Like git-archive, it names
+// the entry "pax_global_header", so that readers unaware of global headers
+// extract it as a regular file rather than silently dropping it.
+//
+// Records such as "comment" are ignored by readers, and can be used to embed
+// metadata about the archive as a whole, such as the commit it was made from.
+// It is equivalent to calling WriteHeader with a Header of type
+// TypeXGlobalHeader.
+func (tw *Writer) WriteGlobalPAXRecords(recs map[string]string) error {
+	return tw.WriteHeader(&Header{Name: "pax_global_header", Typeflag: TypeXGlobalHeader, PAXRecords: recs})
+}
+
+// FinishEntry completes the current entry. For an entry whose size was
+// unknown when its header was written, it seeks back to the header to record
+// the size of the data written, and then returns to the end of the archive.
+// Otherwise, it is equivalent to Flush.
+//
+// FinishEntry is called implicitly by Flush, WriteHeader and Close.
+func (tw *Writer) FinishEntry() error {
+	return tw.Flush()
+}
+
+// unsizedEntry is the state of an entry whose size is not yet known.
+type unsizedEntry struct {
+	ws      io.WriteSeeker
+	off     int64 // Offset of the header block holding the size
+	blk     block // Copy of that header block
+	numeric bool  // Whether the size may be stored in base-256
+	max     int64 // Maximum size of the data
+}
+
+// These are the amounts of data that may be written for an entry of
+// unknown size. In base-256, the size is limited by the type of Header.Size.
+// In octal, it is limited by the 12-byte size field, which holds 11 digits.
+const (
+	maxUnsizedData      = 1<<63 - 1
+	maxUnsizedOctalData = 1<<33 - 1
+)
+
+// startUnsized prepares to write the data of an entry of unknown size,
+// after its headers have been written to ws, using the GNU format if gnu is set.
+func (tw *Writer) startUnsized(ws io.WriteSeeker, gnu bool) error {
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	max := int64(maxUnsizedOctalData)
+	if gnu {
+		max = maxUnsizedData
+	}
+	tw.unsized = &unsizedEntry{ws: ws, off: end - blockSize, blk: tw.blk, numeric: gnu, max: max}
+	tw.setCurr(max)
+	return nil
+}
+
+// finishUnsized records the size of the current entry in its header.
+func (tw *Writer) finishUnsized() error {
+	u := tw.unsized
+	tw.unsized = nil
+	size := u.max - tw.curr.LogicalRemaining()
+	if tw.off != nil {
+		tw.toc[len(tw.toc)-1].Header.Size = size
+	}
+
+	var f formatter
+	if u.numeric {
+		f.formatNumeric(u.blk.V7().Size(), size)
+	} else {
+		f.formatOctal(u.blk.V7().Size(), size)
+	}
+	if f.err != nil {
+		return f.err
+	}
+	u.blk.setChecksum()
+
+	end, err := u.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := u.ws.Seek(u.off, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := u.ws.Write(u.blk[:]); err != nil {
+		return err
+	}
+	if _, err := u.ws.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+	tw.setCurr(0)
+	tw.pad = blockPadding(size)
 	return nil
 }
 
@@ -65,37 +381,266 @@ func (tw *Writer) Flush() error {
 // The Header.Size determines how many bytes can be written for the next file.
 // If the current file is not fully written, then this returns an error.
 // This implicitly flushes any padding necessary before writing the header.
+//
+// If hdr cannot be encoded, the error is a *HeaderError listing all of the
+// fields at fault, such as a name too long for the requested Format.
+//
+// If the size of a regular file is not known in advance, and the underlying
+// io.Writer is an io.WriteSeeker, Header.Size may be -1. Data may then be
+// written up to the maximum size that the format can record, and FinishEntry
+// records its size in the header once done. This allows streaming generated
+// content without buffering it. The size of such entries is limited to
+// 8 GiB minus one byte, unless the Format is set to FormatGNU; Write returns
+// ErrWriteTooLong beyond that. Writing in records or volumes is not supported.
+//
+// If Digests is set, the digests in the header of a regular file are only
+// recorded once all of its data has been written; see Digests.
 func (tw *Writer) WriteHeader(hdr *Header) error {
 	if err := tw.Flush(); err != nil {
 		return err
 	}
+	orig := hdr
+	if tw.MapOwner != nil && hdr.Typeflag != TypeXGlobalHeader {
+		o, err := tw.MapOwner(hdr.Name, Owner{hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname})
+		if err != nil {
+			return err
+		}
+		h := *hdr
+		h.Uid, h.Gid, h.Uname, h.Gname = o.Uid, o.Gid, o.Uname, o.Gname
+		hdr = &h
+	}
+	if tw.StrictUSTAR && hdr.Format != FormatUnknown && hdr.Format != FormatUSTAR {
+		return newHeaderError("Format", "StrictUSTAR does not allow Format "+hdr.Format.String())
+	}
+	mentry, err := tw.manifestEntry(hdr)
+	if err != nil {
+		return err
+	}
+	if len(tw.Digests) > 0 && (hdr.Typeflag == TypeReg || hdr.Typeflag == TypeRegA) {
+		err = tw.startDigest(hdr)
+	} else {
+		err = tw.writeHeader(hdr)
+	}
+	if err != nil {
+		return err
+	}
+	tw.mentry = mentry
+	tw.name, tw.inEntry = orig.Name, true
+	if tw.OnStartEntry != nil {
+		tw.OnStartEntry(orig)
+	}
+	if tw.OnFinishEntry != nil {
+		tw.entry = orig
+	}
+	return nil
+}
+
+// writeHeader writes hdr and prepares to accept the file's contents.
+func (tw *Writer) writeHeader(hdr *Header) error {
 	tw.hdr = *hdr // Shallow copy of Header
 
-	// Round ModTime and ignore AccessTime and ChangeTime unless
-	// the format is explicitly chosen.
-	// This ensures nominal usage of WriteHeader (without specifying the format)
-	// does not always result in the PAX format being chosen, which
-	// causes a 1KiB increase to every header.
-	if tw.hdr.Format == FormatUnknown {
-		tw.hdr.ModTime = tw.hdr.ModTime.Round(time.Second)
-		tw.hdr.AccessTime = time.Time{}
-		tw.hdr.ChangeTime = time.Time{}
+	unsized := hdr.Size == -1
+	if unsized {
+		if hdr.Typeflag != TypeReg && hdr.Typeflag != TypeRegA {
+			return newHeaderError("Size", "unknown size on non-regular file")
+		}
+		tw.hdr.Size = 0
+	}
+	if err := tw.adjustHeader(&tw.hdr); err != nil {
+		return err
 	}
 
 	allowedFormats, paxHdrs, err := tw.hdr.allowedFormats()
+	if allowedFormats != FormatUnknown {
+		if err := tw.beginHeaders(); err != nil {
+			return err
+		}
+	}
+	ws, ok := tw.dst().(io.WriteSeeker)
+	if unsized && !ok {
+		return newHeaderError("Size", "unknown size requires an io.WriteSeeker")
+	}
+	var entry TOCEntry
+	if tw.off != nil {
+		entry.Header = new(Header)
+		*entry.Header = tw.hdr
+		entry.HeaderOffset = tw.off.n
+	}
 	switch {
 	case allowedFormats.has(FormatUSTAR):
 		tw.err = tw.writeUSTARHeader(&tw.hdr)
-		return tw.err
 	case allowedFormats.has(FormatPAX):
 		tw.err = tw.writePAXHeader(&tw.hdr, paxHdrs)
-		return tw.err
 	case allowedFormats.has(FormatGNU):
 		tw.err = tw.writeGNUHeader(&tw.hdr)
-		return tw.err
+	case allowedFormats.has(FormatV7):
+		tw.err = tw.writeV7Header(&tw.hdr)
+	case allowedFormats.has(FormatSTAR):
+		tw.err = tw.writeSTARHeader(&tw.hdr)
 	default:
 		return err // Non-fatal error
 	}
+	if tw.err == nil && tw.vol != nil {
+		tw.err = tw.vol.endHeaders(hdr.Name, tw.curr.PhysicalRemaining())
+	}
+	if tw.err == nil && unsized {
+		gnu := !allowedFormats.has(FormatUSTAR|FormatPAX) && allowedFormats.has(FormatGNU)
+		tw.err = tw.startUnsized(ws, gnu)
+	}
+	if tw.err == nil && tw.off != nil {
+		entry.DataOffset = tw.off.n
+		tw.toc = append(tw.toc, entry)
+	}
+	return tw.err
+}
+
+// TOC returns the table of contents of the archive written so far if
+// BuildTOC is set, with an entry for each header written, including those
+// copied with CopyFrom. It is complete once Close has been called.
+// Offsets are relative to the start of the output of tw.
+func (tw *Writer) TOC() []TOCEntry {
+	return tw.toc
+}
+
+// dst returns the io.Writer that tw writes to, without counting offsets.
+func (tw *Writer) dst() io.Writer {
+	if tw.off != nil {
+		return tw.off.w
+	}
+	return tw.w
+}
+
+// offsetWriter counts the bytes written to w.
+type offsetWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (ow *offsetWriter) Write(b []byte) (int, error) {
+	n, err := ow.w.Write(b)
+	ow.n += int64(n)
+	return n, err
+}
+
+// adjustHeader applies the metadata policies of tw to h before it is written.
+func (tw *Writer) adjustHeader(h *Header) error {
+	if tw.Deterministic && h.Typeflag != TypeXGlobalHeader {
+		h.normalize()
+	}
+	if !tw.ClampTime.IsZero() {
+		h.clampTimes(tw.ClampTime)
+	}
+	if tw.StrictUSTAR {
+		h.AccessTime, h.ChangeTime = time.Time{}, time.Time{}
+		h.Format = FormatUSTAR
+	}
+	if tw.GNUTimes && h.Format == FormatUnknown && !(h.AccessTime.IsZero() && h.ChangeTime.IsZero()) {
+		h.ModTime = h.ModTime.Round(time.Second)
+		h.AccessTime = h.AccessTime.Round(time.Second)
+		h.ChangeTime = h.ChangeTime.Round(time.Second)
+		h.Format = FormatGNU
+		if _, _, err := h.allowedFormats(); err != nil {
+			h.Format = FormatPAX
+		}
+	}
+	if err := tw.applyTimePolicy(h); err != nil {
+		return err
+	}
+
+	// Round ModTime and ignore AccessTime and ChangeTime unless
+	// the format is explicitly chosen.
+	h.defaultTimes()
+	return nil
+}
+
+// applyTimePolicy adjusts the times of h as specified by tw.TimePolicy,
+// if the format of h is set and does not include PAX.
+func (tw *Writer) applyTimePolicy(h *Header) error {
+	if h.Format == FormatUnknown || h.Format.has(FormatPAX) || h.Typeflag == TypeXGlobalHeader {
+		return nil
+	}
+	limited := !h.Format.has(FormatGNU) // Only GNU stores times in base-256
+	min, max := time.Unix(0, 0), time.Unix(1<<33-1, 0)
+	for _, t := range [...]struct {
+		name string
+		ts   *time.Time
+	}{
+		{"ModTime", &h.ModTime},
+		{"AccessTime", &h.AccessTime},
+		{"ChangeTime", &h.ChangeTime},
+	} {
+		ts := *t.ts
+		if ts.IsZero() {
+			continue
+		}
+		switch tw.TimePolicy {
+		case TimeReject:
+			if ts.Nanosecond() != 0 {
+				return newHeaderError(t.name, fmt.Sprintf("%v cannot encode %s=%v exactly", h.Format, t.name, ts))
+			}
+		case TimeClamp:
+			if limited && ts.Before(min) {
+				ts = min
+			} else if limited && ts.After(max) {
+				ts = max
+			}
+		}
+		*t.ts = ts.Truncate(time.Second)
+	}
+	return nil
+}
+
+// normalize removes the metadata of h that depends on the user and
+// time that an archive was created; see Writer.Deterministic.
+func (h *Header) normalize() {
+	h.Uid, h.Gid = 0, 0
+	h.Uname, h.Gname = "", ""
+	h.AccessTime, h.ChangeTime, h.CreationTime = time.Time{}, time.Time{}, time.Time{}
+	if h.ModTime.IsZero() {
+		h.ModTime = time.Unix(0, 0)
+	} else {
+		h.ModTime = h.ModTime.Truncate(time.Second)
+	}
+}
+
+// clampTimes replaces the times of h that are after t by t.
+func (h *Header) clampTimes(t time.Time) {
+	for _, ts := range []*time.Time{&h.ModTime, &h.AccessTime, &h.ChangeTime, &h.CreationTime} {
+		if ts.After(t) {
+			*ts = t
+		}
+	}
+}
+
+// SourceDateEpoch returns the time given by the SOURCE_DATE_EPOCH environment
+// variable, as a number of seconds since the Unix epoch, for use as
+// Writer.ClampTime. It returns the zero Time if the variable is not set.
+//
+// See https://reproducible-builds.org/specs/source-date-epoch/.
+func SourceDateEpoch() (time.Time, error) {
+	s := os.Getenv("SOURCE_DATE_EPOCH")
+	if s == "" {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || sec < 0 {
+		return time.Time{}, fmt.Errorf("archive/tar: invalid SOURCE_DATE_EPOCH: %q", s)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+func (tw *Writer) writeV7Header(hdr *Header) error {
+	f := tw.formatter()
+	blk := tw.templateV7Plus(hdr, f.formatString, f.formatOctal)
+	copy(blk[magicOffset:], zeroBlock[:]) // V7 has no fields past the link name
+	if hdr.Typeflag == TypeDir {
+		blk.V7().TypeFlag()[0] = TypeRegA // Identified by the trailing slash
+	}
+	blk.SetFormat(FormatV7)
+	if f.err != nil {
+		return f.err // Should never happen since header is validated
+	}
+	return tw.writeRawHeader(blk, hdr.Size, hdr.Typeflag)
 }
 
 func (tw *Writer) writeUSTARHeader(hdr *Header) error {
@@ -106,7 +651,7 @@ func (tw *Writer) writeUSTARHeader(hdr *Header) error {
 	}
 
 	// Pack the main header.
-	var f formatter
+	f := tw.formatter()
 	blk := tw.templateV7Plus(hdr, f.formatString, f.formatOctal)
 	f.formatString(blk.USTAR().Prefix(), namePrefix)
 	blk.SetFormat(FormatUSTAR)
@@ -116,6 +661,30 @@ func (tw *Writer) writeUSTARHeader(hdr *Header) error {
 	return tw.writeRawHeader(blk, hdr.Size, hdr.Typeflag)
 }
 
+func (tw *Writer) writeSTARHeader(hdr *Header) error {
+	var namePrefix string
+	if prefix, suffix, ok := splitPrefixPath(hdr.Name, starPrefixSize); ok {
+		namePrefix, hdr.Name = prefix, suffix
+	}
+
+	// Pack the main header.
+	f := tw.formatter()
+	blk := tw.templateV7Plus(hdr, f.formatString, f.formatOctal)
+	star := blk.STAR()
+	f.formatString(star.Prefix(), namePrefix)
+	if !hdr.AccessTime.IsZero() {
+		f.formatOctal(star.AccessTime(), hdr.AccessTime.Unix())
+	}
+	if !hdr.ChangeTime.IsZero() {
+		f.formatOctal(star.ChangeTime(), hdr.ChangeTime.Unix())
+	}
+	blk.SetFormat(FormatSTAR)
+	if f.err != nil {
+		return f.err // Should never happen since header is validated
+	}
+	return tw.writeRawHeader(blk, hdr.Size, hdr.Typeflag)
+}
+
 func (tw *Writer) writePAXHeader(hdr *Header, paxHdrs map[string]string) error {
 	realName, realSize := hdr.Name, hdr.Size
 
@@ -159,21 +728,22 @@ func (tw *Writer) writePAXHeader(hdr *Header, paxHdrs map[string]string) error {
 	isGlobal := hdr.Typeflag == TypeXGlobalHeader
 	if len(paxHdrs) > 0 || isGlobal {
 		// Sort keys for deterministic ordering.
-		var keys []string
+		keys := tw.paxKeys[:0]
 		for k := range paxHdrs {
 			keys = append(keys, k)
 		}
-		sort.Strings(keys)
+		tw.paxKeys = keys
+		sort.Sort(&tw.paxKeys) // Does not allocate, unlike sort.Strings
 
 		// Write each record to a buffer.
-		var buf bytes.Buffer
+		buf := tw.paxBuf[:0]
 		for _, k := range keys {
-			rec, err := formatPAXRecord(k, paxHdrs[k])
-			if err != nil {
+			var err error
+			if buf, err = appendPAXRecord(buf, k, paxHdrs[k]); err != nil {
 				return err
 			}
-			buf.WriteString(rec)
 		}
+		tw.paxBuf = buf
 
 		// Write the extended header file.
 		var name string
@@ -189,14 +759,13 @@ func (tw *Writer) writePAXHeader(hdr *Header, paxHdrs map[string]string) error {
 			name = path.Join(dir, "PaxHeaders.0", file)
 			flag = TypeXHeader
 		}
-		data := buf.String()
-		if err := tw.writeRawFile(name, data, flag, FormatPAX); err != nil || isGlobal {
+		if err := tw.writeRawFile(name, buf, flag, FormatPAX); err != nil || isGlobal {
 			return err // Global headers return here
 		}
 	}
 
 	// Pack the main header.
-	var f formatter // Ignore errors since they are expected
+	f := tw.formatter() // Ignore errors since they are expected
 	fmtStr := func(b []byte, s string) { f.formatString(b, toASCII(s)) }
 	blk := tw.templateV7Plus(hdr, fmtStr, f.formatOctal)
 	blk.SetFormat(FormatPAX)
@@ -223,20 +792,20 @@ func (tw *Writer) writeGNUHeader(hdr *Header) error {
 	// Use long-link files if Name or Linkname exceeds the field size.
 	const longName = "././@LongLink"
 	if len(hdr.Name) > nameSize {
-		data := hdr.Name + "\x00"
-		if err := tw.writeRawFile(longName, data, TypeGNULongName, FormatGNU); err != nil {
+		tw.paxBuf = append(append(tw.paxBuf[:0], hdr.Name...), 0)
+		if err := tw.writeRawFile(longName, tw.paxBuf, TypeGNULongName, FormatGNU); err != nil {
 			return err
 		}
 	}
 	if len(hdr.Linkname) > nameSize {
-		data := hdr.Linkname + "\x00"
-		if err := tw.writeRawFile(longName, data, TypeGNULongLink, FormatGNU); err != nil {
+		tw.paxBuf = append(append(tw.paxBuf[:0], hdr.Linkname...), 0)
+		if err := tw.writeRawFile(longName, tw.paxBuf, TypeGNULongLink, FormatGNU); err != nil {
 			return err
 		}
 	}
 
 	// Pack the main header.
-	var f formatter // Ignore errors since they are expected
+	f := tw.formatter() // Ignore errors since they are expected
 	var spd sparseDatas
 	var spb []byte
 	blk := tw.templateV7Plus(hdr, f.formatString, f.formatNumeric)
@@ -305,6 +874,14 @@ type (
 	numberFormatter func([]byte, int64)
 )
 
+// formatter returns the formatter of tw, reset for a new header.
+// It is stored in tw so that passing its methods to templateV7Plus
+// does not allocate.
+func (tw *Writer) formatter() *formatter {
+	tw.fmtr = formatter{}
+	return &tw.fmtr
+}
+
 // templateV7Plus fills out the V7 fields of a block using values from hdr.
 // It also fills out fields (uname, gname, devmajor, devminor) that are
 // shared in the USTAR, PAX, and GNU formats using the provided formatters.
@@ -341,7 +918,7 @@ func (tw *Writer) templateV7Plus(hdr *Header, fmtStr stringFormatter, fmtNum num
 // writeRawFile writes a minimal file with the given name and flag type.
 // It uses format to encode the header format and will write data as the body.
 // It uses default values for all of the other fields (as BSD and GNU tar does).
-func (tw *Writer) writeRawFile(name, data string, flag byte, format Format) error {
+func (tw *Writer) writeRawFile(name string, data []byte, flag byte, format Format) error {
 	tw.blk.Reset()
 
 	// Best effort for the filename.
@@ -349,7 +926,9 @@ func (tw *Writer) writeRawFile(name, data string, flag byte, format Format) erro
 	if len(name) > nameSize {
 		name = name[:nameSize]
 	}
-	name = strings.TrimRight(name, "/")
+	for len(name) > 0 && name[len(name)-1] == '/' {
+		name = name[:len(name)-1] // Like strings.TrimRight, without allocating
+	}
 
 	var f formatter
 	v7 := tw.blk.V7()
@@ -369,7 +948,7 @@ func (tw *Writer) writeRawFile(name, data string, flag byte, format Format) erro
 	if err := tw.writeRawHeader(&tw.blk, int64(len(data)), flag); err != nil {
 		return err
 	}
-	_, err := io.WriteString(tw, data)
+	_, err := tw.curr.Write(data)
 	return err
 }
 
@@ -386,14 +965,25 @@ func (tw *Writer) writeRawHeader(blk *block, size int64, flag byte) error {
 	if isHeaderOnlyType(flag) {
 		size = 0
 	}
-	tw.curr = &regFileWriter{tw.w, size}
+	tw.setCurr(size)
 	tw.pad = blockPadding(size)
 	return nil
 }
 
+// setCurr prepares to write size bytes of data for the current entry.
+func (tw *Writer) setCurr(size int64) {
+	tw.reg = regFileWriter{tw.w, size}
+	tw.curr = &tw.reg
+}
+
 // splitUSTARPath splits a path according to USTAR prefix and suffix rules.
 // If the path is not splittable, then it will return ("", "", false).
 func splitUSTARPath(name string) (prefix, suffix string, ok bool) {
+	return splitPrefixPath(name, prefixSize)
+}
+
+// splitPrefixPath is like splitUSTARPath, for a prefix field of the given size.
+func splitPrefixPath(name string, prefixSize int) (prefix, suffix string, ok bool) {
 	length := len(name)
 	if length <= nameSize || !isASCII(name) {
 		return "", "", false
@@ -427,27 +1017,242 @@ func (tw *Writer) Write(b []byte) (int, error) {
 	if err != nil && err != ErrWriteTooLong {
 		tw.err = err
 	}
+	if tw.mentry != nil {
+		tw.mentry.Write(b[:n])
+	}
+	if tw.digest != nil {
+		tw.digest.Write(b[:n])
+	}
+	if n > 0 && tw.OnWrite != nil {
+		tw.OnWrite(int64(n))
+	}
 	return n, err
 }
 
-// readFrom populates the content of the current file by reading from r.
-// The bytes read must match the number of remaining bytes in the current file.
-//
-// If the current file is sparse and r is an io.ReadSeeker,
-// then readFrom uses Seek to skip past holes defined in Header.SparseHoles,
-// assuming that skipped regions are all NULs.
-// This always reads the last byte to ensure r is the right size.
+// ReadFrom populates the content of the current file by reading from r
+// until EOF, and returns the number of bytes written. It implements
+// io.ReaderFrom, so io.Copy uses it when copying to the Writer.
+// ReadFrom returns the error ErrWriteTooLong if r holds more than the
+// remaining Header.Size bytes.
 //
-// TODO(dsnet): Re-export this when adding sparse file support.
-// See https://golang.org/issue/22735
-func (tw *Writer) readFrom(r io.Reader) (int64, error) {
+// On Linux, if the archive is written directly to a regular *os.File and r
+// is also a regular *os.File, the data is copied by the kernel with
+// sendfile, without passing through user space.
+func (tw *Writer) ReadFrom(r io.Reader) (int64, error) {
 	if tw.err != nil {
 		return 0, tw.err
 	}
+	if tw.mentry != nil {
+		r = io.TeeReader(r, tw.mentry)
+	}
+	if tw.digest != nil {
+		r = io.TeeReader(r, tw.digest)
+	}
 	n, err := tw.curr.ReadFrom(r)
 	if err != nil && err != ErrWriteTooLong {
 		tw.err = err
 	}
+	if n > 0 && tw.OnWrite != nil {
+		tw.OnWrite(n)
+	}
+	return n, err
+}
+
+// CopyFrom copies the current entry of tr, which must have just been returned
+// by tr.Next without any of its data having been read, to the archive.
+// It returns the number of bytes written.
+//
+// Unlike WriteHeader followed by io.Copy, CopyFrom copies the raw bytes of
+// the entry as stored in the source archive, including its header blocks,
+// any PAX or GNU extension headers preceding them, the data of sparse files
+// and the padding, without decoding and encoding them again. This preserves
+// details of the original encoding that the Header does not represent,
+// such as the precise format of numeric fields, and avoids the cost of
+// processing the data. As a consequence, changes made to the Header returned
+// by Next are ignored, and Reader options such as VerifyDigests and Hashes
+// do not apply to the copied data. Header blocks larger than 1 MiB in total,
+// as for large extended attributes, are not held in memory but read again
+// from the source, so CopyFrom of such an entry requires tr to read from
+// an io.Seeker.
+//
+// After CopyFrom, tr is positioned at the end of the entry, and an error
+// reading from it is returned to subsequent calls on both tr and tw.
+func (tw *Writer) CopyFrom(tr *Reader) (int64, error) {
+	if err := tw.Flush(); err != nil {
+		return 0, err
+	}
+	if tr.err != nil {
+		return 0, tr.err
+	}
+	if !tr.raw.ok || tr.curr.PhysicalRemaining() != tr.raw.n {
+		return 0, errors.New("archive/tar: CopyFrom of entry that is partially read or not returned by Next")
+	}
+	mentry, err := tw.manifestEntry(tr.raw.hdr)
+	if err != nil {
+		return 0, err
+	}
+	sparse := tr.curr.LogicalRemaining() != tr.raw.n
+	var hdrs, spb []byte // Headers replacing the raw ones, if any
+	if sp, ok := tr.curr.(*sparseFileReader); ok && tw.UpgradeSparse && tr.raw.hdr.PAXRecords[paxGNUSparseMajor] != "1" {
+		if hdrs, spb, err = sparse1Headers(tr.raw.hdr, sp.sp); err != nil {
+			return 0, err
+		}
+	}
+	if hdrs == nil && tr.raw.size > maxRawHeaders && tr.raw.off < 0 {
+		return 0, errors.New("archive/tar: CopyFrom of entry with headers too large to record from a source that cannot seek")
+	}
+	tr.raw.ok = false
+
+	if err := tw.beginHeaders(); err != nil {
+		return 0, err
+	}
+	var entry TOCEntry
+	if tw.off != nil {
+		entry = TOCEntry{Header: tr.raw.hdr.clone(), HeaderOffset: tw.off.n}
+	}
+	var n int64
+	if hdrs != nil {
+		var nn int
+		nn, err = tw.w.Write(hdrs)
+		n = int64(nn)
+	} else {
+		n, err = tw.copyRaw(tr)
+	}
+	if err == nil && tw.vol != nil {
+		err = tw.vol.endHeaders(tr.raw.name, int64(len(spb))+tr.raw.n)
+	}
+	if err != nil {
+		tw.err = err
+		return n, err
+	}
+	if tw.off != nil {
+		entry.DataOffset = tw.off.n
+		tw.toc = append(tw.toc, entry)
+	}
+
+	// Copy the data and padding, recording any error from tr separately.
+	size, pad := tr.raw.n, tr.pad
+	tr.reg = regFileReader{r: tr.r}
+	tr.curr = &tr.reg
+	tr.pad = 0
+	er := &errReader{r: tr.r}
+	var src io.Reader = io.LimitReader(er, size)
+	if spb != nil {
+		src = io.MultiReader(bytes.NewReader(spb), src)
+		size += int64(len(spb))
+	}
+	if mentry != nil {
+		if sparse {
+			mentry.h, mentry.Size = nil, tr.raw.hdr.Size
+		} else {
+			src = io.TeeReader(src, mentry)
+		}
+		tw.mentry = mentry
+	}
+	m, err := io.Copy(tw.w, src)
+	n += m
+	if err == nil && m < size {
+		er.err = io.ErrUnexpectedEOF
+	}
+	if er.err != nil {
+		tr.err, tw.err = er.err, er.err
+		return n, er.err
+	}
+	if err != nil {
+		tw.err = err
+		return n, err
+	}
+	nn, err := tryReadFull(tr.r, tr.blk[:pad])
+	if err != nil && err != io.EOF {
+		tr.err, tw.err = err, err
+		return n, err
+	}
+	copy(tr.blk[nn:pad], zeroBlock[:]) // Complete truncated padding
+	nn, err = tw.w.Write(tr.blk[:pad])
+	n += int64(nn)
+	if err != nil {
+		tw.err = err
+	}
+	return n, err
+}
+
+// copyRaw writes the header blocks recorded by tr.raw, reading them again
+// from tr if they were too large to be held in memory, which leaves tr
+// positioned at the start of the data. An error reading from tr is
+// returned to subsequent calls on both tr and tw.
+func (tw *Writer) copyRaw(tr *Reader) (int64, error) {
+	if tr.raw.size <= maxRawHeaders {
+		n, err := tw.w.Write(tr.raw.buf)
+		return int64(n), err
+	}
+	_, err := tr.r.(io.Seeker).Seek(tr.raw.off, io.SeekStart)
+	if err != nil {
+		tr.err, tw.err = err, err
+		return 0, err
+	}
+	er := &errReader{r: tr.r}
+	n, err := io.Copy(tw.w, io.LimitReader(er, tr.raw.size))
+	if err == nil && n < tr.raw.size {
+		er.err = io.ErrUnexpectedEOF
+	}
+	if er.err != nil {
+		tr.err, tw.err = er.err, er.err
+		return n, er.err
+	}
+	return n, err
+}
+
+// sparse1Headers returns the header blocks and the sparse map with which
+// to store the sparse file described by hdr and its holes sph in version 1.0
+// of the GNU sparse PAX format. The sparse map is padded to a whole block.
+func sparse1Headers(hdr *Header, sph sparseHoles) (hdrs, spb []byte, err error) {
+	spd := invertSparseEntries(append(sparseHoles(nil), sph...), hdr.Size)
+	var size int64
+	spb = append(strconv.AppendInt(spb, int64(len(spd)), 10), '\n')
+	for _, s := range spd {
+		size += s.Length
+		spb = append(strconv.AppendInt(spb, s.Offset, 10), '\n')
+		spb = append(strconv.AppendInt(spb, s.Length, 10), '\n')
+	}
+	spb = append(spb, zeroBlock[:blockPadding(int64(len(spb)))]...)
+	size += int64(len(spb))
+
+	h := hdr.clone()
+	dir, file := path.Split(hdr.Name)
+	h.Name, h.Size = path.Join(dir, "GNUSparseFile.0", file), size
+	h.Typeflag, h.Format = TypeReg, FormatPAX
+	_, paxHdrs, err := h.allowedFormats()
+	if err != nil {
+		return nil, nil, err
+	}
+	if paxHdrs == nil {
+		paxHdrs = make(map[string]string)
+	}
+	paxHdrs[paxGNUSparseMajor] = "1"
+	paxHdrs[paxGNUSparseMinor] = "0"
+	paxHdrs[paxGNUSparseName] = hdr.Name
+	paxHdrs[paxGNUSparseRealSize] = strconv.FormatInt(hdr.Size, 10)
+	paxHdrs[paxSize] = strconv.FormatInt(size, 10)
+	delete(paxHdrs, paxPath) // Recorded by paxGNUSparseName
+
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).writePAXHeader(h, paxHdrs); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), spb, nil
+}
+
+// errReader is an io.Reader that records the first error of r other than io.EOF.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (er *errReader) Read(b []byte) (int, error) {
+	n, err := er.r.Read(b)
+	if err != nil && err != io.EOF && er.err == nil {
+		er.err = err
+	}
 	return n, err
 }
 
@@ -462,11 +1267,24 @@ func (tw *Writer) Close() error {
 		return tw.err
 	}
 
-	// Trailer: two zero blocks.
+	// Trailer: two zero blocks, unless configured otherwise.
+	trailer := 2
+	if tw.TrailerBlocks != 0 {
+		trailer = tw.TrailerBlocks
+	}
 	err := tw.Flush()
-	for i := 0; i < 2 && err == nil; i++ {
+	if err == nil {
+		err = tw.beginHeaders()
+	}
+	for i := 0; i < trailer && err == nil; i++ {
 		_, err = tw.w.Write(zeroBlock[:])
 	}
+	if err == nil && tw.vol != nil {
+		err = tw.vol.endHeaders("", 0)
+	}
+	if err == nil {
+		err = tw.flushRecord()
+	}
 
 	// Ensure all future actions are invalid.
 	tw.err = ErrWriteAfterClose
@@ -499,7 +1317,29 @@ func (fw *regFileWriter) Write(b []byte) (n int, err error) {
 }
 
 func (fw *regFileWriter) ReadFrom(r io.Reader) (int64, error) {
-	return io.Copy(struct{ io.Writer }{fw}, r)
+	var n int64
+	w := fw.w
+	ow, counted := w.(*offsetWriter)
+	if counted {
+		w = ow.w
+	}
+	if dst, ok := w.(*os.File); ok {
+		if src, ok := r.(*os.File); ok {
+			var err error
+			n, err = sendFile(dst, src, fw.nb)
+			fw.nb -= n
+			if counted {
+				ow.n += n
+			}
+			if err != nil {
+				return n, err
+			}
+			// Copy any remaining data as usual, which also detects
+			// an overlong source.
+		}
+	}
+	m, err := io.Copy(struct{ io.Writer }{fw}, r)
+	return n + m, err
 }
 
 func (fw regFileWriter) LogicalRemaining() int64 {
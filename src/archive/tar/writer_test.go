@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -223,14 +224,14 @@ func TestWriter(t *testing.T) {
 				Typeflag: TypeReg,
 				Name:     "bad-null.txt",
 				Xattrs:   map[string]string{"null\x00null\x00": "fizzbuzz"},
-			}, headerError{}},
+			}, &HeaderError{}},
 		},
 	}, {
 		tests: []testFnc{
 			testHeader{Header{
 				Typeflag: TypeReg,
 				Name:     "null\x00.txt",
-			}, headerError{}},
+			}, &HeaderError{}},
 		},
 	}, {
 		file: "testdata/pax-records.tar",
@@ -464,8 +465,8 @@ func TestWriter(t *testing.T) {
 	}}
 
 	equalError := func(x, y error) bool {
-		_, ok1 := x.(headerError)
-		_, ok2 := y.(headerError)
+		_, ok1 := x.(*HeaderError)
+		_, ok2 := y.(*HeaderError)
 		if ok1 || ok2 {
 			return ok1 && ok2
 		}
@@ -491,7 +492,7 @@ func TestWriter(t *testing.T) {
 					}
 				case testReadFrom:
 					f := &testFile{ops: tf.ops}
-					got, err := tw.readFrom(f)
+					got, err := tw.ReadFrom(f)
 					if _, ok := err.(testError); ok {
 						t.Errorf("test %d, ReadFrom(): %v", i, err)
 					} else if got != tf.wantCnt || !equalError(err, tf.wantErr) {
@@ -1300,3 +1301,865 @@ func TestFileWriter(t *testing.T) {
 		}
 	}
 }
+
+func TestWriterCopyFrom(t *testing.T) {
+	files := []string{
+		"testdata/gnu.tar",
+		"testdata/gnu-long-nul.tar",
+		"testdata/gnu-multi-hdrs.tar",
+		"testdata/pax.tar",
+		"testdata/pax-global-records.tar",
+		"testdata/pax-records.tar",
+		"testdata/sparse-formats.tar",
+		"testdata/star.tar",
+		"testdata/ustar.tar",
+		"testdata/v7.tar",
+		"testdata/xattrs.tar",
+	}
+	for _, file := range files {
+		want, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		tr, tw := NewReader(bytes.NewReader(want)), NewWriter(&b)
+		for {
+			if _, err := tr.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: Next() error: %v", file, err)
+			}
+			if _, err := tw.CopyFrom(tr); err != nil {
+				t.Fatalf("%s: CopyFrom() error: %v", file, err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("%s: Close() error: %v", file, err)
+		}
+
+		// The archives differ only in the trailer and the padding after it.
+		got := b.Bytes()
+		n := len(got) - 2*blockSize
+		if n > len(want) || !bytes.Equal(got[:n], want[:n]) {
+			t.Errorf("%s: copied archive differs from original", file)
+		}
+	}
+
+	// The data of the entry must not have been read.
+	data := makeArchive(t, testEntry{hdr: Header{Name: "file", Typeflag: TypeReg}, body: "hello"})
+	tr, tw := NewReader(bytes.NewReader(data)), NewWriter(ioutil.Discard)
+	if _, err := tw.CopyFrom(tr); err == nil {
+		t.Errorf("CopyFrom() before Next succeeded")
+	}
+	if _, err := tr.Next(); err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	io.CopyN(ioutil.Discard, tr, 1)
+	if _, err := tw.CopyFrom(tr); err == nil {
+		t.Errorf("CopyFrom() of partially read entry succeeded")
+	}
+
+	// Headers too large to be held in memory are read again from the source.
+	hdr := Header{Name: "big", Typeflag: TypeReg, PAXRecords: map[string]string{
+		"SCHILY.xattr.user.big": strings.Repeat("x", 2*maxRawHeaders),
+	}}
+	data = makeArchive(t, testEntry{hdr: hdr, body: "hello"})
+	for i, r := range []io.Reader{bytes.NewReader(data), struct{ io.Reader }{bytes.NewReader(data)}} {
+		seekable := i == 0
+		var b bytes.Buffer
+		tr, tw := NewReader(r), NewWriter(&b)
+		if _, err := tr.Next(); err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if len(tr.raw.buf) > maxRawHeaders {
+			t.Errorf("test %d, recorded %d bytes of headers, want at most %d", i, len(tr.raw.buf), maxRawHeaders)
+		}
+		if _, err := tw.CopyFrom(tr); (err == nil) != seekable {
+			t.Fatalf("test %d, CopyFrom() error: %v, want error %v", i, err, !seekable)
+		}
+		if !seekable {
+			continue
+		}
+		if _, err := tr.Next(); err != io.EOF {
+			t.Errorf("test %d, Next() error: got %v, want io.EOF", i, err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("test %d, Close() error: %v", i, err)
+		}
+		if !bytes.Equal(b.Bytes(), data) {
+			t.Errorf("test %d, copied archive differs from original", i)
+		}
+	}
+}
+
+func TestWriterUpgradeSparse(t *testing.T) {
+	files := []string{
+		"testdata/sparse-formats.tar",
+		"testdata/gnu-sparse-big.tar",
+		"testdata/pax-sparse-big.tar",
+		"testdata/gnu.tar",
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		tr, tw := NewReader(bytes.NewReader(src)), NewWriter(&b)
+		tw.UpgradeSparse = true
+		for {
+			if _, err := tr.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: Next() error: %v", file, err)
+			}
+			if _, err := tw.CopyFrom(tr); err != nil {
+				t.Fatalf("%s: CopyFrom() error: %v", file, err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("%s: Close() error: %v", file, err)
+		}
+
+		tr, tr2 := NewReader(bytes.NewReader(src)), NewReader(&b)
+		for {
+			want, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: Next() error: %v", file, err)
+			}
+			got, err := tr2.Next()
+			if err != nil {
+				t.Fatalf("%s: Next() of copy error: %v", file, err)
+			}
+			sp, sparse := tr.curr.(*sparseFileReader)
+			if got.Name != want.Name || got.Size != want.Size || !got.ModTime.Equal(want.ModTime) {
+				t.Errorf("%s: got entry %q of size %d, want %q of size %d", file, got.Name, got.Size, want.Name, want.Size)
+			}
+			if !sparse {
+				continue
+			}
+			if got.Typeflag != TypeReg || got.PAXRecords[paxGNUSparseMajor] != "1" || got.PAXRecords[paxGNUSparseMinor] != "0" {
+				t.Errorf("%s: %s has type %q and sparse version %s.%s, want 1.0", file, got.Name,
+					got.Typeflag, got.PAXRecords[paxGNUSparseMajor], got.PAXRecords[paxGNUSparseMinor])
+			}
+			sp2, ok := tr2.curr.(*sparseFileReader)
+			if !ok || !equalSparseEntries(sp2.sp, sp.sp) {
+				t.Errorf("%s: holes of %s differ from the original", file, got.Name)
+				continue
+			}
+			if want.Size < 1<<20 {
+				data, _ := ioutil.ReadAll(tr)
+				data2, err := ioutil.ReadAll(tr2)
+				if err != nil || !bytes.Equal(data, data2) {
+					t.Errorf("%s: data of %s differs from the original: %v", file, got.Name, err)
+				}
+			}
+		}
+	}
+}
+
+func TestWriterDeterministic(t *testing.T) {
+	write := func(hdrs ...Header) []byte {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		tw.Deterministic = true
+		for _, hdr := range hdrs {
+			if err := tw.WriteHeader(&hdr); err != nil {
+				t.Fatalf("WriteHeader() error: %v", err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+		return b.Bytes()
+	}
+
+	vectors := []struct {
+		a, b Header
+	}{{
+		Header{Name: "file", Mode: 0644, Uid: 1000, Gid: 1000, Uname: "alice", Gname: "staff"},
+		Header{Name: "file", Mode: 0644, Uid: 0, Gid: 0},
+	}, {
+		Header{Name: "file", ModTime: time.Unix(1500000000, 999999999)},
+		Header{Name: "file", ModTime: time.Unix(1500000000, 0)},
+	}, {
+		Header{Name: "file", ModTime: time.Unix(1500000000, 0), AccessTime: time.Unix(1600000000, 0), Format: FormatPAX},
+		Header{Name: "file", ModTime: time.Unix(1500000000, 0), ChangeTime: time.Unix(1700000000, 0), Format: FormatPAX},
+	}, {
+		Header{Name: "file"},
+		Header{Name: "file", ModTime: time.Unix(0, 0)},
+	}, {
+		Header{Name: "file", Uid: 1 << 30, PAXRecords: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}},
+		Header{Name: "file", PAXRecords: map[string]string{"d": "4", "c": "3", "b": "2", "a": "1"}},
+	}}
+	for i, v := range vectors {
+		v.a.Typeflag, v.b.Typeflag = TypeReg, TypeReg
+		if a, b := write(v.a), write(v.b); !bytes.Equal(a, b) {
+			t.Errorf("test %d, archives differ", i)
+		}
+	}
+}
+
+func TestWriterClampTime(t *testing.T) {
+	clamp := time.Unix(1500000000, 0)
+	vectors := []struct {
+		in, want time.Time
+	}{
+		{time.Unix(1400000000, 0), time.Unix(1400000000, 0)},
+		{time.Unix(1500000000, 0), time.Unix(1500000000, 0)},
+		{time.Unix(1600000000, 0), clamp},
+	}
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		tw.ClampTime = clamp
+		hdr := &Header{Name: "file", Typeflag: TypeReg, ModTime: v.in, AccessTime: v.in, ChangeTime: v.in, Format: FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if !got.ModTime.Equal(v.want) || !got.AccessTime.Equal(v.want) || !got.ChangeTime.Equal(v.want) {
+			t.Errorf("test %d, times = %v, %v, %v; want %v", i, got.ModTime, got.AccessTime, got.ChangeTime, v.want)
+		}
+	}
+}
+
+func TestWriterStrictUSTAR(t *testing.T) {
+	now := time.Unix(1500000000, 5e8)
+	vectors := []struct {
+		header *Header
+		fields []string // Fields of the HeaderError, if any
+	}{{
+		header: &Header{Name: "file", Typeflag: TypeReg, ModTime: now, AccessTime: now, ChangeTime: now},
+	}, {
+		header: &Header{Name: strings.Repeat("d/", 60) + "file", Typeflag: TypeReg, ModTime: now},
+	}, {
+		header: &Header{Name: strings.Repeat("a", 101), Typeflag: TypeReg, ModTime: now},
+		fields: []string{"Format", "Name"},
+	}, {
+		header: &Header{Name: "file", Typeflag: TypeReg, Uname: strings.Repeat("u", 33)},
+		fields: []string{"Format", "Uname"},
+	}, {
+		header: &Header{Name: "file", Typeflag: TypeReg, Xattrs: map[string]string{"user.a": "b"}},
+		fields: []string{"Format", "Xattrs"},
+	}, {
+		header: &Header{Name: "file", Typeflag: TypeReg, Format: FormatPAX},
+		fields: []string{"Format"},
+	}}
+
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		tw.StrictUSTAR = true
+		err := tw.WriteHeader(v.header)
+		if v.fields != nil {
+			var got []string
+			if he, ok := err.(*HeaderError); ok {
+				for _, f := range he.Fields {
+					got = append(got, f.Field)
+				}
+			}
+			if !reflect.DeepEqual(got, v.fields) {
+				t.Errorf("test %d, WriteHeader() error = %v, want fields %v", i, err, v.fields)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if got.Format != FormatUSTAR {
+			t.Errorf("test %d, Format = %v, want USTAR", i, got.Format)
+		}
+		if want := v.header.ModTime.Truncate(time.Second); !got.ModTime.Equal(want) || !got.AccessTime.IsZero() || !got.ChangeTime.IsZero() {
+			t.Errorf("test %d, times = %v, %v, %v; want %v", i, got.ModTime, got.AccessTime, got.ChangeTime, want)
+		}
+		if got.Name != v.header.Name {
+			t.Errorf("test %d, Name = %q, want %q", i, got.Name, v.header.Name)
+		}
+	}
+}
+
+func TestWriterGNUTimes(t *testing.T) {
+	mtime, atime, ctime := time.Unix(1500000000, 0), time.Unix(1500000100, 4e8), time.Unix(1500000200, 6e8)
+	vectors := []struct {
+		header *Header
+		format Format
+		atime  time.Time
+		ctime  time.Time
+	}{{
+		header: &Header{Name: "file", Typeflag: TypeReg, ModTime: mtime, AccessTime: atime, ChangeTime: ctime},
+		format: FormatGNU,
+		atime:  time.Unix(1500000100, 0),
+		ctime:  time.Unix(1500000201, 0),
+	}, {
+		header: &Header{Name: "file", Typeflag: TypeReg, ModTime: mtime, AccessTime: atime, Xattrs: map[string]string{"user.a": "b"}},
+		format: FormatPAX,
+		atime:  time.Unix(1500000100, 0),
+	}, {
+		header: &Header{Name: "file", Typeflag: TypeReg, ModTime: mtime},
+		format: FormatUSTAR,
+	}, {
+		header: &Header{Name: "file", Typeflag: TypeReg, ModTime: mtime, AccessTime: atime, Format: FormatPAX},
+		format: FormatPAX,
+		atime:  atime,
+	}}
+
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		tw.GNUTimes = true
+		if err := tw.WriteHeader(v.header); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if got.Format != v.format {
+			t.Errorf("test %d, Format = %v, want %v", i, got.Format, v.format)
+		}
+		if !got.ModTime.Equal(mtime) || !got.AccessTime.Equal(v.atime) || !got.ChangeTime.Equal(v.ctime) {
+			t.Errorf("test %d, times = %v, %v, %v; want %v, %v, %v", i,
+				got.ModTime, got.AccessTime, got.ChangeTime, mtime, v.atime, v.ctime)
+		}
+	}
+}
+
+func TestWriterTimePolicy(t *testing.T) {
+	frac, old, late := time.Unix(1500000000, 5e8), time.Unix(-100, 0), time.Unix(1<<34, 0)
+	vectors := []struct {
+		policy TimePolicy
+		format Format
+		mtime  time.Time
+		want   time.Time // Zero if WriteHeader fails
+	}{
+		{TimeTruncate, FormatUSTAR, frac, time.Unix(1500000000, 0)},
+		{TimeTruncate, FormatUSTAR, old, time.Time{}},
+		{TimeTruncate, FormatGNU, old, old},
+		{TimeTruncate, FormatPAX, frac, frac},
+		{TimeTruncate, FormatUnknown, old, old},
+		{TimeReject, FormatUSTAR, frac, time.Time{}},
+		{TimeReject, FormatGNU, frac, time.Time{}},
+		{TimeReject, FormatUSTAR, time.Unix(1500000000, 0), time.Unix(1500000000, 0)},
+		{TimeReject, FormatPAX, frac, frac},
+		{TimeClamp, FormatUSTAR, old, time.Unix(0, 0)},
+		{TimeClamp, FormatUSTAR, late, time.Unix(1<<33-1, 0)},
+		{TimeClamp, FormatUSTAR, frac, time.Unix(1500000000, 0)},
+		{TimeClamp, FormatGNU, late, late},
+	}
+
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		tw.TimePolicy = v.policy
+		err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg, ModTime: v.mtime, Format: v.format})
+		if v.want.IsZero() {
+			if _, ok := err.(*HeaderError); !ok {
+				t.Errorf("test %d, WriteHeader() error = %v, want *HeaderError", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+
+		hdr, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if !hdr.ModTime.Equal(v.want) {
+			t.Errorf("test %d, ModTime = %v, want %v", i, hdr.ModTime, v.want)
+		}
+	}
+}
+
+func TestWriterBinaryNames(t *testing.T) {
+	vectors := []struct {
+		header     *Header
+		hdrCharset string
+	}{{
+		header:     &Header{Name: "caf\xe9", Typeflag: TypeReg},
+		hdrCharset: "BINARY",
+	}, {
+		header:     &Header{Name: "link", Linkname: "caf\xe9", Typeflag: TypeSymlink},
+		hdrCharset: "BINARY",
+	}, {
+		header:     &Header{Name: "file", Typeflag: TypeReg, Uname: "\xff\xfe"},
+		hdrCharset: "BINARY",
+	}, {
+		header: &Header{Name: "café", Typeflag: TypeReg},
+	}, {
+		header:     &Header{Name: "caf\xe9", Typeflag: TypeReg, HdrCharset: "ISO-IR 8859 1 1998"},
+		hdrCharset: "ISO-IR 8859 1 1998",
+	}}
+
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		if err := tw.WriteHeader(v.header); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if got.HdrCharset != v.hdrCharset {
+			t.Errorf("test %d, HdrCharset = %q, want %q", i, got.HdrCharset, v.hdrCharset)
+		}
+		if got.Name != v.header.Name || got.Linkname != v.header.Linkname || got.Uname != v.header.Uname {
+			t.Errorf("test %d, names = %q, %q, %q; want %q, %q, %q", i,
+				got.Name, got.Linkname, got.Uname, v.header.Name, v.header.Linkname, v.header.Uname)
+		}
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	defer os.Setenv("SOURCE_DATE_EPOCH", os.Getenv("SOURCE_DATE_EPOCH"))
+	vectors := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"1500000000", time.Unix(1500000000, 0), false},
+		{"0", time.Unix(0, 0), false},
+		{"-1", time.Time{}, true},
+		{"1.5", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+	for i, v := range vectors {
+		os.Setenv("SOURCE_DATE_EPOCH", v.in)
+		got, err := SourceDateEpoch()
+		if !got.Equal(v.want) || (err != nil) != v.wantErr {
+			t.Errorf("test %d, SourceDateEpoch() = (%v, %v), want (%v, error %v)", i, got, err, v.want, v.wantErr)
+		}
+	}
+}
+
+func TestWriterUnknownSize(t *testing.T) {
+	f, err := ioutil.TempFile("", "tar-unsized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	vectors := []struct {
+		name   string
+		format Format
+		data   string
+	}{
+		{"empty", FormatUnknown, ""},
+		{"small", FormatUnknown, "hello"},
+		{"block", FormatUSTAR, strings.Repeat("x", blockSize)},
+		{"large", FormatGNU, strings.Repeat("y", 3*blockSize+7)},
+		{strings.Repeat("long/", 30) + "name", FormatPAX, "pax"},
+	}
+	tw := NewWriter(f)
+	for i, v := range vectors {
+		if err := tw.WriteHeader(&Header{Name: v.name, Typeflag: TypeReg, Size: -1, Format: v.format}); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		for j := 0; j < len(v.data); j += 100 {
+			k := j + 100
+			if k > len(v.data) {
+				k = len(v.data)
+			}
+			if _, err := io.WriteString(tw, v.data[j:k]); err != nil {
+				t.Fatalf("test %d, Write() error: %v", i, err)
+			}
+		}
+		if i%2 == 0 {
+			if err := tw.FinishEntry(); err != nil {
+				t.Fatalf("test %d, FinishEntry() error: %v", i, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewReader(f)
+	for i, v := range vectors {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		got, err := ioutil.ReadAll(tr)
+		if err != nil || hdr.Name != v.name || hdr.Size != int64(len(v.data)) || string(got) != v.data {
+			t.Errorf("test %d, got %q with size %d and %d bytes, %v; want %q with %d bytes",
+				i, hdr.Name, hdr.Size, len(got), err, v.name, len(v.data))
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("Next() error: got %v, want %v", err, io.EOF)
+	}
+
+	// The data of a non-GNU entry is limited by its octal size field.
+	for i, v := range []struct {
+		format Format
+		want   int64
+	}{
+		{FormatUSTAR, 1<<33 - 1},
+		{FormatPAX, 1<<33 - 1},
+		{FormatGNU, 1<<63 - 1},
+	} {
+		tw = NewWriter(f)
+		if err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg, Size: -1, Format: v.format}); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		if got := tw.curr.LogicalRemaining(); got != v.want {
+			t.Errorf("test %d, LogicalRemaining() = %d, want %d", i, got, v.want)
+		}
+	}
+
+	var b bytes.Buffer
+	tw = NewWriter(&b)
+	if err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg, Size: -1}); err == nil {
+		t.Errorf("WriteHeader() to bytes.Buffer succeeded, want error")
+	}
+	tw = NewWriter(f)
+	if err := tw.WriteHeader(&Header{Name: "dir/", Typeflag: TypeDir, Size: -1}); err == nil {
+		t.Errorf("WriteHeader() of directory succeeded, want error")
+	}
+}
+
+func TestWriteGlobalPAXRecords(t *testing.T) {
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	tw.Deterministic = true
+	recs := map[string]string{"comment": "0123456789abcdef", "uname": "builder"}
+	if err := tw.WriteGlobalPAXRecords(recs); err != nil {
+		t.Fatalf("WriteGlobalPAXRecords() error: %v", err)
+	}
+	if err := tw.WriteHeader(&Header{Name: "file", Typeflag: TypeReg}); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	tr := NewReader(bytes.NewReader(b.Bytes()))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if hdr.Typeflag != TypeXGlobalHeader || hdr.Name != "pax_global_header" || !reflect.DeepEqual(hdr.PAXRecords, recs) {
+		t.Errorf("Next() = %q of type %q with %v, want global header with %v", hdr.Name, hdr.Typeflag, hdr.PAXRecords, recs)
+	}
+
+	tr = NewReader(bytes.NewReader(b.Bytes()))
+	tr.ApplyGlobalPAXRecords = true
+	if hdr, err = tr.Next(); err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if hdr.Typeflag != TypeXGlobalHeader {
+		t.Fatalf("Next() = %q of type %q, want global header", hdr.Name, hdr.Typeflag)
+	}
+	if hdr, err = tr.Next(); err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if hdr.Name != "file" || hdr.Uname != "builder" {
+		t.Errorf("Next() = %q owned by %q, want %q owned by %q", hdr.Name, hdr.Uname, "file", "builder")
+	}
+}
+
+func TestWriterGNULongNames(t *testing.T) {
+	name := strings.Repeat("longname/", 15) + "file"
+	link := strings.Repeat("linkname/", 15) + "target"
+	vectors := []struct {
+		hdr   Header
+		flags string // Typeflags of the blocks holding headers
+	}{
+		{Header{Name: "short", Linkname: "target", Typeflag: TypeSymlink}, "2"},
+		{Header{Name: name, Linkname: "target", Typeflag: TypeSymlink}, "L2"},
+		{Header{Name: "short", Linkname: link, Typeflag: TypeSymlink}, "K2"},
+		{Header{Name: name, Linkname: link, Typeflag: TypeLink}, "LK1"},
+		{Header{Name: strings.Repeat("a", nameSize), Typeflag: TypeReg}, "0"},
+	}
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		v.hdr.Format = FormatGNU
+		if err := tw.WriteHeader(&v.hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("test %d, Close() error: %v", i, err)
+		}
+
+		// Walk the header blocks, skipping the data of LongName and LongLink.
+		var flags string
+		data := b.Bytes()
+		for len(data) >= blockSize {
+			var blk block
+			copy(blk[:], data)
+			if blk == zeroBlock {
+				break
+			}
+			if f := blk.GetFormat(); f != FormatGNU {
+				t.Errorf("test %d, block of type %q has format %v, want GNU", i, blk.V7().TypeFlag()[0], f)
+			}
+			var p parser
+			size := p.parseNumeric(blk.V7().Size())
+			flags += string(blk.V7().TypeFlag()[0])
+			data = data[blockSize+size+blockPadding(size):]
+		}
+		if flags != v.flags {
+			t.Errorf("test %d, got header types %q, want %q", i, flags, v.flags)
+		}
+
+		hdr, err := NewReader(&b).Next()
+		if err != nil || hdr.Name != v.hdr.Name || hdr.Linkname != v.hdr.Linkname {
+			t.Errorf("test %d, Next() = %v, %v; want %q -> %q", i, hdr, err, v.hdr.Name, v.hdr.Linkname)
+		}
+	}
+}
+
+func TestWriterV7(t *testing.T) {
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	hdrs := []Header{
+		{Name: "file", Typeflag: TypeReg, Mode: 0644, Uid: 10, Size: 5},
+		{Name: "dir/", Typeflag: TypeDir, Mode: 0755},
+		{Name: "dir/link", Typeflag: TypeLink, Linkname: "file"},
+		{Name: "sym", Typeflag: TypeSymlink, Linkname: strings.Repeat("a", nameSize)},
+	}
+	for i, hdr := range hdrs {
+		hdr.ModTime = time.Unix(1500000000, 0)
+		hdr.Format = FormatV7
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("hello"))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	data := b.Bytes()
+	for i, want := range hdrs {
+		var blk block
+		copy(blk[:], data)
+		if f := blk.GetFormat(); f != FormatV7 {
+			t.Errorf("test %d, GetFormat() = %v, want V7", i, f)
+		}
+		if !bytes.Equal(blk[magicOffset:], zeroBlock[magicOffset:]) {
+			t.Errorf("test %d, header has data past the V7 fields", i)
+		}
+		got, err := NewReader(bytes.NewReader(data)).Next()
+		data = data[blockSize+want.Size+blockPadding(want.Size):]
+		want.ModTime = time.Unix(1500000000, 0)
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("test %d, Next():\ngot  %+v\nwant %+v", i, *got, want)
+		}
+	}
+
+	invalid := []Header{
+		{Name: strings.Repeat("a", nameSize+1), Typeflag: TypeReg},
+		{Name: "file", Typeflag: TypeReg, Uname: "root"},
+		{Name: "file", Typeflag: TypeReg, Uid: 1 << 21},
+		{Name: "file", Typeflag: TypeReg, AccessTime: time.Unix(1, 0)},
+		{Name: "dev", Typeflag: TypeChar, Devmajor: 1},
+		{Name: "dir", Typeflag: TypeDir},
+		{Name: "fïle", Typeflag: TypeReg},
+	}
+	for i, hdr := range invalid {
+		hdr.Format = FormatV7
+		if err := NewWriter(ioutil.Discard).WriteHeader(&hdr); err == nil {
+			t.Errorf("test %d, WriteHeader() succeeded, want error", i)
+		}
+	}
+}
+
+func TestWriterSTAR(t *testing.T) {
+	longName := strings.Repeat("p", 140) + "/file" // Fits the prefix of USTAR, but not STAR
+	vectors := []struct {
+		hdr Header
+		ok  bool
+	}{
+		{Header{Name: "file", Typeflag: TypeReg, Uname: "user", Size: 5}, true},
+		{Header{Name: "times", Typeflag: TypeReg, AccessTime: time.Unix(1600000000, 0), ChangeTime: time.Unix(1700000000, 0)}, true},
+		{Header{Name: strings.Repeat("p/", 60) + "file", Typeflag: TypeReg}, true},
+		{Header{Name: "dev", Typeflag: TypeChar, Devmajor: 1, Devminor: 2}, true},
+		{Header{Name: longName, Typeflag: TypeReg}, false},
+		{Header{Name: "file", Typeflag: TypeReg, Uid: 1 << 21}, false},
+		{Header{Name: "file", Typeflag: TypeReg, Xattrs: map[string]string{"user.a": "b"}}, false},
+	}
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		v.hdr.ModTime = time.Unix(1500000000, 0)
+		v.hdr.Format = FormatSTAR
+		err := tw.WriteHeader(&v.hdr)
+		if (err == nil) != v.ok {
+			t.Errorf("test %d, WriteHeader() error: %v, want success %v", i, err, v.ok)
+			continue
+		}
+		if !v.ok {
+			continue
+		}
+		io.WriteString(tw, strings.Repeat("x", int(v.hdr.Size)))
+		if err := tw.Close(); err != nil {
+			t.Fatalf("test %d, Close() error: %v", i, err)
+		}
+
+		var blk block
+		copy(blk[:], b.Bytes())
+		if f := blk.GetFormat(); f != FormatSTAR {
+			t.Errorf("test %d, GetFormat() = %v, want STAR", i, f)
+		}
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		want := v.hdr
+		want.Format = FormatUnknown
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("test %d, Next():\ngot  %+v\nwant %+v", i, *got, want)
+		}
+	}
+}
+
+func TestWriterProgress(t *testing.T) {
+	var events []string
+	tw := NewWriter(ioutil.Discard)
+	tw.OnStartEntry = func(hdr *Header) { events = append(events, "start "+hdr.Name) }
+	tw.OnWrite = func(n int64) { events = append(events, fmt.Sprintf("write %d", n)) }
+	tw.OnFinishEntry = func(hdr *Header) { events = append(events, "finish "+hdr.Name) }
+
+	if err := tw.WriteHeader(&Header{Name: "a", Size: 5}); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(tw, "abc")
+	io.WriteString(tw, "def") // Too long, so only 2 bytes are written
+	if err := tw.WriteHeader(&Header{Name: "dir/", Typeflag: TypeDir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&Header{Name: "b", Format: FormatUSTAR, Uname: strings.Repeat("u", 40)}); err == nil {
+		t.Fatal("WriteHeader() with invalid header succeeded, want error")
+	}
+	tw.Flush()
+	if err := tw.WriteHeader(&Header{Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	want := []string{"start a", "write 3", "write 2", "finish a", "start dir/", "finish dir/", "start c", "finish c"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events:\ngot  %q\nwant %q", events, want)
+	}
+}
+
+func TestWriterReadFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := strings.Repeat("Hello, world!\n", 1000)
+	src, err := os.Create(path.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	io.WriteString(src, data)
+	dst, err := os.Create(path.Join(dir, "dst.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	tw := NewWriter(dst)
+	for i, size := range []int64{int64(len(data)), 100, int64(len(data)) + 1} {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.WriteHeader(&Header{Name: fmt.Sprint(i), Size: size}); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		n, err := io.Copy(tw, src)
+		if want := min(size, int64(len(data))); n != want {
+			t.Errorf("test %d, io.Copy() = %d, want %d", i, n, want)
+		}
+		if size < int64(len(data)) && err != ErrWriteTooLong {
+			t.Errorf("test %d, io.Copy() error: got %v, want %v", i, err, ErrWriteTooLong)
+		} else if size >= int64(len(data)) && err != nil {
+			t.Errorf("test %d, io.Copy() error: %v", i, err)
+		}
+		if size > int64(len(data)) {
+			io.WriteString(tw, "!")
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	if _, err := dst.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewReader(dst)
+	for i, want := range []string{data, data[:100], data + "!"} {
+		if _, err := tr.Next(); err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if got, err := ioutil.ReadAll(tr); err != nil || string(got) != want {
+			t.Errorf("test %d, ReadAll() = (%d bytes, %v), want %d bytes", i, len(got), err, len(want))
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("final Next() error: got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestWriterAllocs(t *testing.T) {
+	vectors := []struct {
+		hdr  Header
+		want float64
+	}{
+		{Header{Name: "file", Mode: 0644, Size: 5, ModTime: time.Unix(1e9, 0)}, 0},
+		{Header{Name: "dir/", Typeflag: TypeDir, Uname: "gopher", Gname: "gopher", Format: FormatGNU}, 0},
+		{Header{Name: "file", Size: 5, PAXRecords: map[string]string{"comment": "hello"}}, 3}, // Records and name of the extended header
+	}
+	data := []byte("hello")
+	for i, v := range vectors {
+		tw := NewWriter(ioutil.Discard)
+		got := testing.AllocsPerRun(100, func() {
+			if err := tw.WriteHeader(&v.hdr); err != nil {
+				t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+			}
+			tw.Write(data)
+		})
+		if got > v.want {
+			t.Errorf("test %d, allocations per entry = %v, want at most %v", i, got, v.want)
+		}
+	}
+}
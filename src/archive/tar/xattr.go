@@ -0,0 +1,151 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// An Xattr is an extended attribute of a file.
+type Xattr struct {
+	// Name is the full name of the attribute, including its namespace,
+	// such as "user.mime_type" or "security.capability".
+	Name string
+
+	// Value is the value of the attribute, which may hold arbitrary bytes.
+	Value []byte
+}
+
+// Namespace returns the namespace of the attribute on Linux, which is one of
+// "user", "security", "trusted" or "system", or the empty string if the name
+// has none of these prefixes, as on other systems.
+func (x Xattr) Namespace() string {
+	i := strings.IndexByte(x.Name, '.')
+	if i < 0 {
+		return ""
+	}
+	switch ns := x.Name[:i]; ns {
+	case "user", "security", "trusted", "system":
+		return ns
+	}
+	return ""
+}
+
+// ExtendedAttrs returns the extended attributes of the file, sorted by name.
+//
+// They are taken from the Xattrs field and from the "SCHILY.xattr." PAX
+// records written by GNU tar, star and this package. The base64-encoded
+// "LIBARCHIVE.xattr." records written by bsdtar are also decoded; if both
+// kinds of records hold an attribute, the former take precedence.
+func (h *Header) ExtendedAttrs() []Xattr {
+	m := make(map[string][]byte)
+	for k, v := range h.PAXRecords {
+		if !strings.HasPrefix(k, paxLibarchiveXattr) {
+			continue
+		}
+		name, ok := unescapeXattrName(k[len(paxLibarchiveXattr):])
+		b, err := decodeLibarchiveXattr(v)
+		if ok && err == nil {
+			m[name] = b
+		}
+	}
+	for k, v := range h.PAXRecords {
+		if strings.HasPrefix(k, paxSchilyXattr) {
+			m[k[len(paxSchilyXattr):]] = []byte(v)
+		}
+	}
+	for k, v := range h.Xattrs {
+		m[k] = []byte(v)
+	}
+
+	xs := make([]Xattr, 0, len(m))
+	for k, v := range m {
+		xs = append(xs, Xattr{k, v})
+	}
+	sort.Slice(xs, func(i, j int) bool { return xs[i].Name < xs[j].Name })
+	return xs
+}
+
+// SetExtendedAttr sets the named extended attribute of the file to value,
+// replacing any previous value. It is stored as a "SCHILY.xattr." PAX record,
+// which requires the PAX format.
+func (h *Header) SetExtendedAttr(name string, value []byte) error {
+	if name == "" || hasNUL(name) || strings.IndexByte(name, '=') >= 0 {
+		return errors.New("archive/tar: invalid extended attribute name: " + name)
+	}
+	h.RemoveExtendedAttr(name)
+	if h.PAXRecords == nil {
+		h.PAXRecords = make(map[string]string)
+	}
+	h.PAXRecords[paxSchilyXattr+name] = string(value)
+	if h.Xattrs != nil {
+		h.Xattrs[name] = string(value)
+	}
+	return nil
+}
+
+// RemoveExtendedAttr removes the named extended attribute of the file,
+// from both the Xattrs field and PAXRecords.
+func (h *Header) RemoveExtendedAttr(name string) {
+	delete(h.Xattrs, name)
+	delete(h.PAXRecords, paxSchilyXattr+name)
+	for k := range h.PAXRecords {
+		if strings.HasPrefix(k, paxLibarchiveXattr) {
+			if n, ok := unescapeXattrName(k[len(paxLibarchiveXattr):]); ok && n == name {
+				delete(h.PAXRecords, k)
+			}
+		}
+	}
+}
+
+// decodeLibarchiveXattr decodes the value of a "LIBARCHIVE.xattr." record,
+// with or without padding.
+func decodeLibarchiveXattr(v string) ([]byte, error) {
+	if len(v)%4 != 0 {
+		return base64.RawStdEncoding.DecodeString(v)
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+// unescapeXattrName decodes the %XX escapes that libarchive uses in the
+// names of extended attributes, and reports whether s was valid.
+func unescapeXattrName(s string) (string, bool) {
+	if strings.IndexByte(s, '%') < 0 {
+		return s, s != ""
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", false
+		}
+		hi, ok1 := unhex(s[i+1])
+		lo, ok2 := unhex(s[i+2])
+		if !ok1 || !ok2 {
+			return "", false
+		}
+		b = append(b, hi<<4|lo)
+		i += 2
+	}
+	return string(b), len(b) > 0
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
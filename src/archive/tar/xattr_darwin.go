@@ -0,0 +1,118 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// xattrNoFollow is the XATTR_NOFOLLOW option of the xattr system calls.
+const xattrNoFollow = 0x1
+
+// setXattr sets the extended attribute name of p to value.
+// On macOS, this includes the resource fork, com.apple.ResourceFork.
+func setXattr(p, name string, value []byte) error {
+	pp, err := syscall.BytePtrFromString(p)
+	if err != nil {
+		return err
+	}
+	np, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	var vp unsafe.Pointer
+	if len(value) > 0 {
+		vp = unsafe.Pointer(&value[0])
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETXATTR, uintptr(unsafe.Pointer(pp)), uintptr(unsafe.Pointer(np)),
+		uintptr(vp), uintptr(len(value)), 0, xattrNoFollow)
+	if errno != 0 {
+		return &os.PathError{Op: "setxattr", Path: p, Err: errno}
+	}
+	return nil
+}
+
+// listXattrs returns the extended attributes of p, without following
+// symbolic links. It returns none if the file system does not support them.
+// The resource fork and Finder information of the file are returned as the
+// com.apple.ResourceFork and com.apple.FinderInfo attributes.
+func listXattrs(p string) ([]Xattr, error) {
+	names, err := xattrCall(syscall.SYS_LISTXATTR, p, "")
+	if err == syscall.ENOTSUP {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: p, Err: err}
+	}
+	var xs []Xattr
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := xattrCall(syscall.SYS_GETXATTR, p, string(name))
+		if err == syscall.ENOATTR {
+			continue // Removed concurrently
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: p, Err: err}
+		}
+		xs = append(xs, Xattr{string(name), value})
+	}
+	return xs, nil
+}
+
+// xattrCall calls listxattr, or getxattr for the named attribute, without
+// following symbolic links. It asks for the size of the result first, since
+// a resource fork may be much larger than other attributes.
+func xattrCall(trap uintptr, p, name string) ([]byte, error) {
+	pp, err := syscall.BytePtrFromString(p)
+	if err != nil {
+		return nil, err
+	}
+	var np *byte
+	if trap == syscall.SYS_GETXATTR {
+		if np, err = syscall.BytePtrFromString(name); err != nil {
+			return nil, err
+		}
+	}
+	call := func(buf []byte) (int, syscall.Errno) {
+		var bp unsafe.Pointer
+		if len(buf) > 0 {
+			bp = unsafe.Pointer(&buf[0])
+		}
+		var r uintptr
+		var errno syscall.Errno
+		if np == nil {
+			r, _, errno = syscall.Syscall6(trap, uintptr(unsafe.Pointer(pp)),
+				uintptr(bp), uintptr(len(buf)), xattrNoFollow, 0, 0)
+		} else {
+			r, _, errno = syscall.Syscall6(trap, uintptr(unsafe.Pointer(pp)), uintptr(unsafe.Pointer(np)),
+				uintptr(bp), uintptr(len(buf)), 0, xattrNoFollow)
+		}
+		return int(r), errno
+	}
+	for {
+		n, errno := call(nil)
+		if errno != 0 {
+			return nil, errno
+		}
+		buf := make([]byte, n)
+		if n == 0 {
+			return buf, nil
+		}
+		n, errno = call(buf)
+		switch errno {
+		case 0:
+			return buf[:n], nil
+		case syscall.ERANGE:
+			continue // Grown concurrently
+		default:
+			return nil, errno
+		}
+	}
+}
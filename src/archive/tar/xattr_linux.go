@@ -0,0 +1,82 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// setXattr sets the extended attribute name of p to value.
+func setXattr(p, name string, value []byte) error {
+	if err := syscall.Setxattr(p, name, value, 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: p, Err: err}
+	}
+	return nil
+}
+
+// listXattrs returns the extended attributes of p, without following
+// symbolic links. It returns none if the file system does not support them.
+func listXattrs(p string) ([]Xattr, error) {
+	names, err := xattrCall(syscall.SYS_LLISTXATTR, p, "")
+	if err == syscall.ENOTSUP {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "llistxattr", Path: p, Err: err}
+	}
+	var xs []Xattr
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := xattrCall(syscall.SYS_LGETXATTR, p, string(name))
+		if err == syscall.ENODATA {
+			continue // Removed concurrently
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "lgetxattr", Path: p, Err: err}
+		}
+		xs = append(xs, Xattr{string(name), value})
+	}
+	return xs, nil
+}
+
+// xattrCall calls llistxattr, or lgetxattr for the named attribute,
+// growing the buffer for the result as needed.
+func xattrCall(trap uintptr, p, name string) ([]byte, error) {
+	pp, err := syscall.BytePtrFromString(p)
+	if err != nil {
+		return nil, err
+	}
+	var np *byte
+	if trap == syscall.SYS_LGETXATTR {
+		if np, err = syscall.BytePtrFromString(name); err != nil {
+			return nil, err
+		}
+	}
+	buf := make([]byte, 256)
+	for {
+		var r uintptr
+		var errno syscall.Errno
+		if np == nil {
+			r, _, errno = syscall.Syscall(trap, uintptr(unsafe.Pointer(pp)),
+				uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+		} else {
+			r, _, errno = syscall.Syscall6(trap, uintptr(unsafe.Pointer(pp)), uintptr(unsafe.Pointer(np)),
+				uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+		}
+		switch errno {
+		case 0:
+			return buf[:r], nil
+		case syscall.ERANGE:
+			buf = make([]byte, 2*len(buf)) // Grown concurrently or too small
+		default:
+			return nil, errno
+		}
+	}
+}
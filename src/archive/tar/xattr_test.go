@@ -0,0 +1,135 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestExtendedAttrs(t *testing.T) {
+	vectors := []struct {
+		hdr  Header
+		want []Xattr
+	}{{
+		hdr:  Header{},
+		want: []Xattr{},
+	}, {
+		hdr: Header{PAXRecords: map[string]string{
+			"SCHILY.xattr.user.b":            "\x00\xff",
+			"LIBARCHIVE.xattr.user.a":        "aGVsbG8=",
+			"LIBARCHIVE.xattr.user.b":        "aWdub3JlZA==",
+			"LIBARCHIVE.xattr.user.%3Dc%25":  "d29ybGQ",
+			"LIBARCHIVE.xattr.user.bad%zz":   "aGVsbG8=",
+			"LIBARCHIVE.xattr.user.badvalue": "!!!",
+			"comment":                        "ignored",
+		}},
+		want: []Xattr{
+			{"user.=c%", []byte("world")},
+			{"user.a", []byte("hello")},
+			{"user.b", []byte("\x00\xff")},
+		},
+	}, {
+		hdr: Header{
+			Xattrs:     map[string]string{"security.selinux": "new"},
+			PAXRecords: map[string]string{"SCHILY.xattr.security.selinux": "old"},
+		},
+		want: []Xattr{{"security.selinux", []byte("new")}},
+	}}
+	for i, v := range vectors {
+		if got := v.hdr.ExtendedAttrs(); !reflect.DeepEqual(got, v.want) {
+			t.Errorf("test %d, ExtendedAttrs() = %q, want %q", i, got, v.want)
+		}
+	}
+
+	namespaces := map[string]string{
+		"user.a": "user", "security.capability": "security", "trusted.x": "trusted",
+		"system.posix_acl_access": "system", "com.apple.quarantine": "", "user": "",
+	}
+	for name, want := range namespaces {
+		if got := (Xattr{Name: name}).Namespace(); got != want {
+			t.Errorf("Namespace(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSetExtendedAttr(t *testing.T) {
+	hdr := &Header{
+		Name:       "file",
+		Typeflag:   TypeReg,
+		Xattrs:     map[string]string{"user.old": "1"},
+		PAXRecords: map[string]string{"LIBARCHIVE.xattr.user.bin": "b2xk"},
+	}
+	if err := hdr.SetExtendedAttr("user.bin", []byte{0, 1, 0xfe, 0xff}); err != nil {
+		t.Fatalf("SetExtendedAttr() error: %v", err)
+	}
+	hdr.RemoveExtendedAttr("user.old")
+	for _, name := range []string{"", "a=b", "a\x00b"} {
+		if err := hdr.SetExtendedAttr(name, nil); err == nil {
+			t.Errorf("SetExtendedAttr(%q) succeeded, want error", name)
+		}
+	}
+	want := []Xattr{{"user.bin", []byte{0, 1, 0xfe, 0xff}}}
+	if got := hdr.ExtendedAttrs(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ExtendedAttrs() = %q, want %q", got, want)
+	}
+
+	var b bytes.Buffer
+	tw := NewWriter(&b)
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatalf("WriteHeader() error: %v", err)
+	}
+	tw.Close()
+	got, err := NewReader(&b).Next()
+	if err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if xs := got.ExtendedAttrs(); !reflect.DeepEqual(xs, want) {
+		t.Errorf("ExtendedAttrs() after round trip = %q, want %q", xs, want)
+	}
+}
+
+func TestSELinuxContext(t *testing.T) {
+	const ctx = "system_u:object_r:bin_t:s0"
+	vectors := []struct {
+		hdr  Header
+		want string
+	}{{
+		hdr:  Header{SELinuxContext: ctx},
+		want: ctx,
+	}, {
+		hdr:  Header{PAXRecords: map[string]string{"SCHILY.xattr.security.selinux": ctx + "\x00"}},
+		want: ctx,
+	}, {
+		hdr:  Header{PAXRecords: map[string]string{"LIBARCHIVE.xattr.security.selinux": "c3lzdGVtX3U6b2JqZWN0X3I6YmluX3Q6czAA"}},
+		want: ctx,
+	}, {
+		hdr: Header{
+			SELinuxContext: ctx,
+			PAXRecords:     map[string]string{"SCHILY.xattr.security.selinux": "other"},
+		},
+		want: ctx,
+	}}
+	for i, v := range vectors {
+		var b bytes.Buffer
+		tw := NewWriter(&b)
+		v.hdr.Name, v.hdr.Typeflag = "file", TypeReg
+		if err := tw.WriteHeader(&v.hdr); err != nil {
+			t.Fatalf("test %d, WriteHeader() error: %v", i, err)
+		}
+		tw.Close()
+		got, err := NewReader(&b).Next()
+		if err != nil {
+			t.Fatalf("test %d, Next() error: %v", i, err)
+		}
+		if got.SELinuxContext != v.want {
+			t.Errorf("test %d, SELinuxContext = %q, want %q", i, got.SELinuxContext, v.want)
+		}
+		if v.hdr.SELinuxContext != "" && got.PAXRecords[paxRHTSELinux] != v.want {
+			t.Errorf("test %d, PAXRecords[%q] = %q, want %q", i, paxRHTSELinux, got.PAXRecords[paxRHTSELinux], v.want)
+		}
+	}
+}
@@ -227,7 +227,11 @@ var pkgDeps = map[string][]string{
 	"go/types":                  {"L4", "GOPARSER", "container/heap", "go/constant"},
 
 	// One of a kind.
-	"archive/tar":              {"L4", "OS", "syscall", "os/user"},
+	"archive/tar":              {"L4", "OS", "syscall", "os/user", "encoding/json"},
+	"archive/tar/estargz":      {"L4", "OS", "archive/tar", "compress/gzip", "crypto/sha256", "encoding/hex", "encoding/json"},
+	"archive/tar/tarfs":        {"L4", "OS", "archive/tar"},
+	"archive/tar/tarhttp":      {"L4", "NET", "container/list", "net/http"},
+	"archive/tar/tartest":      {"L4", "OS", "archive/tar", "testing"},
 	"archive/zip":              {"L4", "OS", "compress/flate"},
 	"container/heap":           {"sort"},
 	"compress/bzip2":           {"L4"},